@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/codecrafters-io/redis-starter-go/server"
 	"github.com/codecrafters-io/redis-starter-go/utils"
@@ -14,9 +17,35 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	serverManager := server.NewServerManager(args)
-	server := serverManager.SpawnServer()
-	server.LoadRDBToCache()
-	server.Init()
-	server.Listen()
+	cfg, err := server.ConfigFromArgs(args)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := srv.Start(context.Background()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	go handleSignals(srv)
+	if err := srv.Wait(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// SIGINT and SIGTERM shut the server down the way SHUTDOWN does, the server keeps running if the shutdown fails
+func handleSignals(srv *server.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range signals {
+		fmt.Printf("Received %s scheduling shutdown...\n", sig)
+		if err := srv.Shutdown(); err != nil {
+			fmt.Printf("%s received but errors trying to shut down the server: %s\n", sig, err)
+		}
+	}
 }
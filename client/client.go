@@ -0,0 +1,103 @@
+/*
+Package client talks to the server with the RESP encoder and decoder of the server package.
+
+	c := client.New(client.Options{Addr: "127.0.0.1:6379"})
+	defer c.Close()
+	err := c.Set("greeting", "hello")
+	value, err := c.Get("greeting")
+
+A Client is safe for concurrent use, its commands run on connections taken from a Pool.
+An error reply of the server is returned as an Error, a null reply as ErrNil.
+*/
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
+
+const (
+	DEFAULT_POOL_SIZE    = 10
+	DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+	DEFAULT_READ_TIMEOUT = 3 * time.Second
+)
+
+var (
+	ErrNil       = errors.New("redis: nil")
+	ErrClosed    = errors.New("redis: client is closed")
+	ErrNoCommand = errors.New("redis: no command given")
+)
+
+// Error is an error reply of the server, such as "ERR syntax error"
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+type Options struct {
+	Addr        string // host:port of the server
+	Username    string // user authenticated with Password, the default user if empty
+	Password    string // sent with AUTH when a connection is opened, if set
+	Protocol    int    // RESP3 switches the connections to RESP3 with HELLO, RESP2 if 0
+	ClientName  string // name given to each connection with CLIENT SETNAME, if set
+	DB          int    // database each connection selects with SELECT, database 0 if 0
+	PoolSize    int    // largest number of open connections, DEFAULT_POOL_SIZE if 0
+	DialTimeout time.Duration
+	ReadTimeout time.Duration // how long a reply is waited for, blocking commands wait longer
+}
+
+type Client struct {
+	opts Options
+	pool *Pool
+}
+
+func New(opts Options) *Client {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DEFAULT_POOL_SIZE
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if opts.ReadTimeout <= 0 {
+		opts.ReadTimeout = DEFAULT_READ_TIMEOUT
+	}
+	c := &Client{opts: opts}
+	c.pool = NewPool(opts.PoolSize, func() (*Conn, error) {
+		return dial(opts)
+	})
+	return c
+}
+
+// Returns the pool the commands take their connection from
+func (c *Client) Pool() *Pool {
+	return c.pool
+}
+
+// Runs fn on a connection of the pool
+func (c *Client) withConn(fn func(conn *Conn) error) error {
+	conn, err := c.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer c.pool.Put(conn)
+	return fn(conn)
+}
+
+// Sends any command and returns its reply
+func (c *Client) Do(args ...string) (server.RespValue, error) {
+	var v server.RespValue
+	err := c.withConn(func(conn *Conn) error {
+		var err error
+		v, err = conn.Do(args...)
+		return err
+	})
+	return v, err
+}
+
+// Closes the connections, the commands waiting for a reply return an error
+func (c *Client) Close() error {
+	return c.pool.Close()
+}
@@ -0,0 +1,569 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
+
+// Starts an in-process master on a free port, closed at the end of the test
+func startTestServer(t *testing.T, cfg server.Config) string {
+	t.Helper()
+	cfg.Port = 0
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	addr, err := srv.Start(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start the server: %s", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return addr
+}
+
+func newTestClient(t *testing.T, opts Options) *Client {
+	c := New(opts)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCommands(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	if v, err := c.Ping(); err != nil || v != "PONG" {
+		t.Fatalf("expected PONG, got %q %v", v, err)
+	}
+	if err := c.Set("name", "John"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v, err := c.Get("name"); err != nil || v != "John" {
+		t.Fatalf("expected John, got %q %v", v, err)
+	}
+	if _, err := c.Get("missing"); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if ok, err := c.SetWithArgs("name", "Jane", SetArgs{NX: true}); err != nil || ok {
+		t.Fatalf("expected NX to prevent the write, got %v %v", ok, err)
+	}
+	if ok, err := c.SetWithArgs("short", "lived", SetArgs{TTL: 50 * time.Millisecond}); err != nil || !ok {
+		t.Fatalf("expected the write, got %v %v", ok, err)
+	}
+	if ok, err := c.Copy("name", "copy", false); err != nil || !ok {
+		t.Fatalf("expected the copy, got %v %v", ok, err)
+	}
+	if n, err := c.Exists("name", "copy", "missing"); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d %v", n, err)
+	}
+	if n, err := c.Incr("counter"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d %v", n, err)
+	}
+	if _, err := c.Incr("name"); !errors.As(err, new(Error)) {
+		t.Fatalf("expected an error reply, got %v", err)
+	}
+	if typ, err := c.Type("name"); err != nil || typ != "string" {
+		t.Fatalf("expected string, got %q %v", typ, err)
+	}
+	if n, err := c.Del("name", "copy"); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d %v", n, err)
+	}
+	if config, err := c.ConfigGet("replica-read-only"); err != nil || config["replica-read-only"] != "yes" {
+		t.Fatalf("unexpected config %v %v", config, err)
+	}
+	if keys, err := c.CommandGetKeys("COPY", "a", "b"); err != nil || len(keys) != 2 {
+		t.Fatalf("unexpected keys %v %v", keys, err)
+	}
+	if user, err := c.ACLWhoAmI(); err != nil || user != "default" {
+		t.Fatalf("expected default, got %q %v", user, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.Get("short"); err != ErrNil {
+		t.Fatalf("expected the key to expire, got %v", err)
+	}
+}
+
+func TestDatabases(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+	db2 := newTestClient(t, Options{Addr: addr, DB: 2})
+
+	if err := c.Set("key", "value"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ok, err := c.CopyToDB("key", "copy", 2, false); err != nil || !ok {
+		t.Fatalf("expected the copy, got %v %v", ok, err)
+	}
+	if ok, err := c.Move("key", 2); err != nil || !ok {
+		t.Fatalf("expected the move, got %v %v", ok, err)
+	}
+	if n, err := db2.DBSize(); err != nil || n != 2 {
+		t.Fatalf("expected 2 keys in database 2, got %d %v", n, err)
+	}
+	if err := c.SwapDB(0, 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v, err := c.Get("copy"); err != nil || v != "value" {
+		t.Fatalf("expected value, got %q %v", v, err)
+	}
+	if err := db2.Set("other", "value"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.FlushDB(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, err := db2.DBSize(); err != nil || n != 1 {
+		t.Fatalf("expected FLUSHDB to keep database 2, got %d %v", n, err)
+	}
+	if err := c.FlushAll(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n, err := db2.DBSize(); err != nil || n != 0 {
+		t.Fatalf("expected FLUSHALL to empty database 2, got %d %v", n, err)
+	}
+	if _, err := newTestClient(t, Options{Addr: addr, DB: 16}).Ping(); !errors.As(err, new(Error)) {
+		t.Fatalf("expected an out of range error, got %v", err)
+	}
+}
+
+func TestLists(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	if n, err := c.RPush("jobs", "b", "c", "d"); err != nil || n != 3 {
+		t.Fatalf("expected 3, got %d %v", n, err)
+	}
+	if n, err := c.LPush("jobs", "a"); err != nil || n != 4 {
+		t.Fatalf("expected 4, got %d %v", n, err)
+	}
+	if n, err := c.LInsert("jobs", true, "d", "e"); err != nil || n != 5 {
+		t.Fatalf("expected 5, got %d %v", n, err)
+	}
+	if err := c.LSet("jobs", -1, "E"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v, err := c.LIndex("jobs", -1); err != nil || v != "E" {
+		t.Fatalf("expected E, got %q %v", v, err)
+	}
+	if i, err := c.LPos("jobs", "c"); err != nil || i != 2 {
+		t.Fatalf("expected 2, got %d %v", i, err)
+	}
+	if _, err := c.LPos("jobs", "z"); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if v, err := c.LMove("jobs", "running", "LEFT", "RIGHT"); err != nil || v != "a" {
+		t.Fatalf("expected a, got %q %v", v, err)
+	}
+	if v, err := c.LPop("jobs"); err != nil || v != "b" {
+		t.Fatalf("expected b, got %q %v", v, err)
+	}
+	if v, err := c.RPop("jobs"); err != nil || v != "E" {
+		t.Fatalf("expected E, got %q %v", v, err)
+	}
+	if n, err := c.LRem("jobs", 0, "c"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d %v", n, err)
+	}
+	if err := c.LTrim("jobs", 0, 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if values, err := c.LRange("jobs", 0, -1); err != nil || len(values) != 1 || values[0] != "d" {
+		t.Fatalf("expected [d], got %v %v", values, err)
+	}
+	if values, err := c.LPopCount("jobs", 5); err != nil || len(values) != 1 {
+		t.Fatalf("expected [d], got %v %v", values, err)
+	}
+	if _, err := c.LPopCount("jobs", 5); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if n, err := c.LLen("running"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d %v", n, err)
+	}
+}
+
+func TestBlockingLists(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	c.RPush("jobs", "a", "b", "c", "d")
+	if key, v, err := c.BLPop(BLOCK_FOREVER, "missing", "jobs"); err != nil || key != "jobs" || v != "a" {
+		t.Fatalf("expected jobs a, got %q %q %v", key, v, err)
+	}
+	if key, v, err := c.BRPop(time.Second, "jobs"); err != nil || key != "jobs" || v != "d" {
+		t.Fatalf("expected jobs d, got %q %q %v", key, v, err)
+	}
+	if v, err := c.BLMove("jobs", "done", "LEFT", "RIGHT", 0); err != nil || v != "b" {
+		t.Fatalf("expected b, got %q %v", v, err)
+	}
+	if key, values, err := c.LMPop("LEFT", 5, "jobs", "done"); err != nil || key != "jobs" || len(values) != 1 || values[0] != "c" {
+		t.Fatalf("expected jobs [c], got %q %v %v", key, values, err)
+	}
+	if _, _, err := c.LMPop("LEFT", 1, "jobs"); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if _, _, err := c.BLPop(20*time.Millisecond, "jobs"); err != ErrNil {
+		t.Fatalf("expected ErrNil after the timeout, got %v", err)
+	}
+	if _, _, err := c.BLMPop(20*time.Millisecond, "RIGHT", 1, "jobs"); err != ErrNil {
+		t.Fatalf("expected ErrNil after the timeout, got %v", err)
+	}
+
+	result := make(chan []string, 1)
+	go func() {
+		key, values, err := c.BLMPop(BLOCK_FOREVER, "RIGHT", 2, "jobs")
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		result <- append([]string{key}, values...)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	c.RPush("jobs", "e", "f", "g")
+	select {
+	case values := <-result:
+		if strings.Join(values, " ") != "jobs g f" {
+			t.Fatalf("expected jobs g f, got %v", values)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected BLMPOP to return")
+	}
+}
+
+func TestHashes(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	if n, err := c.HSet("user", "name", "Ada", "born", "1815"); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d %v", n, err)
+	}
+	if ok, err := c.HSetNX("user", "name", "Grace"); err != nil || ok {
+		t.Fatalf("expected false, got %v %v", ok, err)
+	}
+	if v, err := c.HGet("user", "name"); err != nil || v != "Ada" {
+		t.Fatalf("expected Ada, got %q %v", v, err)
+	}
+	if _, err := c.HGet("user", "city"); err != ErrNil {
+		t.Fatalf("expected ErrNil, got %v", err)
+	}
+	if values, err := c.HMGet("user", "born", "city"); err != nil || len(values) != 2 || values[0] != "1815" || values[1] != "" {
+		t.Fatalf("expected [1815 ], got %q %v", values, err)
+	}
+	if n, err := c.HIncrBy("user", "born", 10); err != nil || n != 1825 {
+		t.Fatalf("expected 1825, got %d %v", n, err)
+	}
+	if f, err := c.HIncrByFloat("user", "score", 2.5); err != nil || f != 2.5 {
+		t.Fatalf("expected 2.5, got %v %v", f, err)
+	}
+	if ok, err := c.HExists("user", "score"); err != nil || !ok {
+		t.Fatalf("expected true, got %v %v", ok, err)
+	}
+	if n, err := c.HStrLen("user", "born"); err != nil || n != 4 {
+		t.Fatalf("expected 4, got %d %v", n, err)
+	}
+	all, err := c.HGetAll("user")
+	if err != nil || len(all) != 3 || all["name"] != "Ada" || all["born"] != "1825" || all["score"] != "2.5" {
+		t.Fatalf("unexpected hash %v %v", all, err)
+	}
+	if fields, err := c.HKeys("user"); err != nil || strings.Join(fields, " ") != "born name score" {
+		t.Fatalf("expected born name score, got %v %v", fields, err)
+	}
+	if values, err := c.HVals("user"); err != nil || strings.Join(values, " ") != "1825 Ada 2.5" {
+		t.Fatalf("expected 1825 Ada 2.5, got %v %v", values, err)
+	}
+	if fields, err := c.HRandField("user", -5); err != nil || len(fields) != 5 {
+		t.Fatalf("expected 5 fields, got %v %v", fields, err)
+	}
+	if n, err := c.HDel("user", "score", "city"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d %v", n, err)
+	}
+	if n, err := c.HLen("user"); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d %v", n, err)
+	}
+}
+
+func TestHashFieldExpiry(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	c.HSet("session", "user", "ada", "token", "t1", "theme", "dark")
+	if results, err := c.HExpire("session", time.Minute, "token", "missing"); err != nil || fmt.Sprint(results) != "[1 -2]" {
+		t.Fatalf("expected [1 -2], got %v %v", results, err)
+	}
+	if results, err := c.HExpireAt("session", time.Now().Add(-time.Second), "theme"); err != nil || fmt.Sprint(results) != "[2]" {
+		t.Fatalf("expected the field to be deleted, got %v %v", results, err)
+	}
+	ttls, err := c.HPTTL("session", "token", "user", "theme")
+	if err != nil || len(ttls) != 3 || ttls[0] <= 0 || ttls[0] > 60000 || ttls[1] != -1 || ttls[2] != -2 {
+		t.Fatalf("unexpected ttls %v %v", ttls, err)
+	}
+	if results, err := c.HPersist("session", "token", "user"); err != nil || fmt.Sprint(results) != "[1 -1]" {
+		t.Fatalf("expected [1 -1], got %v %v", results, err)
+	}
+	if values, err := c.HGetEx("session", 50*time.Millisecond, "user", "missing"); err != nil || values[0] != "ada" {
+		t.Fatalf("expected ada, got %v %v", values, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if fields, err := c.HKeys("session"); err != nil || fmt.Sprint(fields) != "[token]" {
+		t.Fatalf("expected the user field to expire, got %v %v", fields, err)
+	}
+}
+
+func TestSets(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	if n, err := c.SAdd("cohort", "1", "2", "3", "2"); err != nil || n != 3 {
+		t.Fatalf("expected 3, got %d %v", n, err)
+	}
+	c.SAdd("beta", "2", "3", "ada")
+	if ok, err := c.SIsMember("cohort", "2"); err != nil || !ok {
+		t.Fatalf("expected 2 to be a member, got %v %v", ok, err)
+	}
+	if found, err := c.SMIsMember("cohort", "1", "ada"); err != nil || fmt.Sprint(found) != "[true false]" {
+		t.Fatalf("expected [true false], got %v %v", found, err)
+	}
+	if members, err := c.SInter("cohort", "beta"); err != nil || fmt.Sprint(members) != "[2 3]" {
+		t.Fatalf("expected [2 3], got %v %v", members, err)
+	}
+	if members, err := c.SUnion("cohort", "beta"); err != nil || fmt.Sprint(members) != "[1 2 3 ada]" {
+		t.Fatalf("expected [1 2 3 ada], got %v %v", members, err)
+	}
+	if members, err := c.SDiff("cohort", "beta"); err != nil || fmt.Sprint(members) != "[1]" {
+		t.Fatalf("expected [1], got %v %v", members, err)
+	}
+	if n, err := c.SInterCard(1, "cohort", "beta"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d %v", n, err)
+	}
+	if n, err := c.SUnionStore("all", "cohort", "beta"); err != nil || n != 4 {
+		t.Fatalf("expected 4, got %d %v", n, err)
+	}
+	if n, err := c.SInterStore("both", "cohort", "beta"); err != nil || n != 2 {
+		t.Fatalf("expected 2, got %d %v", n, err)
+	}
+	if n, err := c.SDiffStore("only", "beta", "cohort"); err != nil || n != 1 {
+		t.Fatalf("expected 1, got %d %v", n, err)
+	}
+	if ok, err := c.SMove("beta", "cohort", "ada"); err != nil || !ok {
+		t.Fatalf("expected ada to be moved, got %v %v", ok, err)
+	}
+	if members, err := c.SRandMember("cohort", -6); err != nil || len(members) != 6 {
+		t.Fatalf("expected 6 members, got %v %v", members, err)
+	}
+	if popped, err := c.SPop("cohort", 2); err != nil || len(popped) != 2 {
+		t.Fatalf("expected 2 members, got %v %v", popped, err)
+	}
+	if n, err := c.SRem("cohort", "1", "2", "3", "ada"); err != nil || n != 2 {
+		t.Fatalf("expected the 2 members left to be removed, got %d %v", n, err)
+	}
+	if n, err := c.SCard("cohort"); err != nil || n != 0 {
+		t.Fatalf("expected 0, got %d %v", n, err)
+	}
+	if members, err := c.SMembers("all"); err != nil || fmt.Sprint(members) != "[1 2 3 ada]" {
+		t.Fatalf("expected [1 2 3 ada], got %v %v", members, err)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	if id, err := c.XAdd("stream", "1-1", "temperature", "20"); err != nil || id != "1-1" {
+		t.Fatalf("expected 1-1, got %q %v", id, err)
+	}
+	messages, err := c.XRange("stream", "-", "+")
+	if err != nil || len(messages) != 1 || messages[0].Values[1] != "20" {
+		t.Fatalf("unexpected entries %v %v", messages, err)
+	}
+	if _, err := c.XRead(XReadArgs{Streams: []string{"stream"}, IDs: []string{"1-1"}, Block: 20 * time.Millisecond}); err != ErrNil {
+		t.Fatalf("expected ErrNil after the timeout, got %v", err)
+	}
+
+	t.Run("blocking read", func(t *testing.T) {
+		result := make(chan []XStream, 1)
+		go func() {
+			streams, err := c.XRead(XReadArgs{Streams: []string{"stream"}, IDs: []string{"$"}, Block: BLOCK_FOREVER})
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			result <- streams
+		}()
+		time.Sleep(50 * time.Millisecond)
+		c.XAdd("stream", "2-1", "temperature", "21")
+		select {
+		case streams := <-result:
+			if len(streams) != 1 || streams[0].Stream != "stream" || streams[0].Messages[0].ID != "2-1" {
+				t.Fatalf("unexpected streams %v", streams)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected XREAD to return")
+		}
+	})
+
+	t.Run("RESP3", func(t *testing.T) {
+		c3 := newTestClient(t, Options{Addr: addr, Protocol: server.RESP3})
+		streams, err := c3.XRead(XReadArgs{Streams: []string{"stream"}, IDs: []string{"0-0"}})
+		if err != nil || len(streams) != 1 || len(streams[0].Messages) != 2 {
+			t.Fatalf("unexpected streams %v %v", streams, err)
+		}
+	})
+
+	t.Run("close interrupts a blocking read", func(t *testing.T) {
+		blocked := New(Options{Addr: addr})
+		done := make(chan error, 1)
+		go func() {
+			_, err := blocked.XRead(XReadArgs{Streams: []string{"stream"}, IDs: []string{"$"}, Block: BLOCK_FOREVER})
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		blocked.Close()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected XREAD to return")
+		}
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	p := c.Pipeline()
+	p.Do("SET", "a", "1")
+	p.Do("INCR", "a")
+	p.Do("ECHO")
+	p.Do("GET", "a")
+	replies, err := p.Exec()
+	if !errors.As(err, new(Error)) {
+		t.Fatalf("expected the arity error, got %v", err)
+	}
+	if len(replies) != 4 || replies[1].Int() != 2 || !replies[2].IsError() || replies[3].Str() != "2" {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("expected the queue to be emptied")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr})
+
+	tx := c.Multi()
+	tx.Do("SET", "balance", "10")
+	tx.Do("INCR", "balance")
+	replies, err := tx.Exec()
+	if err != nil || len(replies) != 2 || replies[1].Int() != 11 {
+		t.Fatalf("unexpected replies %v %v", replies, err)
+	}
+
+	tx.Do("INCR", "balance")
+	tx.Do("NOPE")
+	if _, err := tx.Exec(); err == nil || err.Error()[:4] != "ERR " {
+		t.Fatalf("expected the unknown command error, got %v", err)
+	}
+	if v, _ := c.Get("balance"); v != "11" {
+		t.Fatalf("expected the transaction to be discarded, got %s", v)
+	}
+
+	tx.Do("INCR", "balance")
+	tx.Discard()
+	if replies, err := tx.Exec(); err != nil || len(replies) != 0 {
+		t.Fatalf("expected an empty transaction, got %v %v", replies, err)
+	}
+
+	// MULTI and the SET fill the write buffer exactly, the write of EXEC is the one that fails
+	single := newTestClient(t, Options{Addr: addr, PoolSize: 1})
+	conn, err := single.Pool().Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.conn.Close()
+	single.Pool().Put(conn)
+	tx = single.Multi()
+	value := ""
+	for size := conn.writer.Size() - len(server.NewRequest("MULTI").Encode()); len(server.NewRequest("SET", "k", value).Encode()) < size; {
+		value += "v"
+	}
+	tx.Do("SET", "k", value)
+	if _, err := tx.Exec(); err == nil || errors.As(err, new(Error)) {
+		t.Fatalf("expected the write error, got %v", err)
+	}
+	if n := single.Pool().Len(); n != 0 {
+		t.Fatalf("expected the broken connection to be closed, %d are open", n)
+	}
+}
+
+func TestPool(t *testing.T) {
+	addr := startTestServer(t, server.DefaultConfig())
+	c := newTestClient(t, Options{Addr: addr, PoolSize: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Incr("hits"); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Get("hits"); v != "20" {
+		t.Fatalf("expected 20, got %s", v)
+	}
+	if n := c.Pool().Len(); n < 1 || n > 3 {
+		t.Fatalf("expected at most 3 connections, got %d", n)
+	}
+
+	// The killed connections fail once, then they are dropped from the pool and replaced
+	if n, err := c.ClientKill("TYPE", "normal", "SKIPME", "no"); err != nil || n != int64(c.Pool().Len()) {
+		t.Fatalf("expected the connections to be killed, got %d %v", n, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	failed := 0
+	for i := 0; i < 6; i++ {
+		if _, err := c.Ping(); err != nil {
+			failed++
+		}
+	}
+	if failed > 3 {
+		t.Fatalf("expected the pool to reconnect, %d commands failed", failed)
+	}
+
+	c.Close()
+	if _, err := c.Ping(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	cfg := server.DefaultConfig()
+	cfg.RequirePass = "s3cret"
+	addr := startTestServer(t, cfg)
+
+	if _, err := newTestClient(t, Options{Addr: addr}).Ping(); err == nil || err.Error()[:6] != "NOAUTH" {
+		t.Fatalf("expected NOAUTH, got %v", err)
+	}
+	if _, err := newTestClient(t, Options{Addr: addr, Password: "wrong"}).Ping(); err == nil {
+		t.Fatalf("expected the wrong password to be refused")
+	}
+	c := newTestClient(t, Options{Addr: addr, Password: "s3cret", Protocol: server.RESP3, ClientName: "tester"})
+	if name, err := c.ClientGetName(); err != nil || name != "tester" {
+		t.Fatalf("expected tester, got %q %v", name, err)
+	}
+	if err := c.ACLSetUser("alice", "on", ">pw", "+@all", "~*"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	alice := newTestClient(t, Options{Addr: addr, Username: "alice", Password: "pw"})
+	if user, err := alice.ACLWhoAmI(); err != nil || user != "alice" {
+		t.Fatalf("expected alice, got %q %v", user, err)
+	}
+}
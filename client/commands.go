@@ -0,0 +1,799 @@
+package client
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
+
+/*
+Typed helpers of the commands the server implements.
+AUTH, HELLO, CLIENT SETNAME and SELECT are sent when a connection is opened, see Options,
+MULTI, EXEC and DISCARD are sent by Tx, and the replication commands PSYNC and REPLCONF are left out.
+*/
+
+func stringReply(v server.RespValue, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if v.IsNull() {
+		return "", ErrNil
+	}
+	return v.Str(), nil
+}
+
+func intReply(v server.RespValue, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return v.Int(), nil
+}
+
+func stringsReply(v server.RespValue, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(v.Array()))
+	for _, e := range v.Array() {
+		values = append(values, e.Str())
+	}
+	return values, nil
+}
+
+// Turns a map, or an array of keys and values in RESP2, into a Go map
+func mapReply(v server.RespValue, err error) (map[string]server.RespValue, error) {
+	if err != nil {
+		return nil, err
+	}
+	elems := v.Array()
+	m := make(map[string]server.RespValue, len(elems)/2)
+	for i := 0; i+1 < len(elems); i += 2 {
+		m[elems[i].Str()] = elems[i+1]
+	}
+	return m, nil
+}
+
+func intsReply(v server.RespValue, err error) ([]int64, error) {
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int64, 0, len(v.Array()))
+	for _, e := range v.Array() {
+		values = append(values, e.Int())
+	}
+	return values, nil
+}
+
+func okReply(v server.RespValue, err error) error {
+	return err
+}
+
+// PING
+func (c *Client) Ping() (string, error) {
+	return stringReply(c.Do("PING"))
+}
+
+// ECHO <message>
+func (c *Client) Echo(message string) (string, error) {
+	return stringReply(c.Do("ECHO", message))
+}
+
+// SET <key> <value>
+func (c *Client) Set(key, value string) error {
+	return okReply(c.Do("SET", key, value))
+}
+
+// Options of SET
+type SetArgs struct {
+	TTL time.Duration // the key expires after TTL, rounded to the millisecond, if not 0
+	NX  bool          // only set the key if it does not already exist
+	XX  bool          // only set the key if it already exists
+}
+
+// SET <key> <value> [PX <milliseconds>] [NX|XX], returns false if NX or XX prevented it
+func (c *Client) SetWithArgs(key, value string, args SetArgs) (bool, error) {
+	cmd := []string{"SET", key, value}
+	if args.TTL > 0 {
+		cmd = append(cmd, "PX", strconv.FormatInt(args.TTL.Milliseconds(), 10))
+	}
+	if args.NX {
+		cmd = append(cmd, "NX")
+	}
+	if args.XX {
+		cmd = append(cmd, "XX")
+	}
+	v, err := c.Do(cmd...)
+	if err != nil {
+		return false, err
+	}
+	return !v.IsNull(), nil
+}
+
+// GET <key>, returns ErrNil if the key does not exist
+func (c *Client) Get(key string) (string, error) {
+	return stringReply(c.Do("GET", key))
+}
+
+// DEL <key> [key ...], returns the number of keys deleted
+func (c *Client) Del(keys ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"DEL"}, keys...)...))
+}
+
+// EXISTS <key> [key ...], returns the number of keys that exist
+func (c *Client) Exists(keys ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"EXISTS"}, keys...)...))
+}
+
+// COPY <source> <destination> [REPLACE], returns false if nothing was copied
+func (c *Client) Copy(source, destination string, replace bool) (bool, error) {
+	cmd := []string{"COPY", source, destination}
+	if replace {
+		cmd = append(cmd, "REPLACE")
+	}
+	n, err := intReply(c.Do(cmd...))
+	return n == 1, err
+}
+
+// COPY <source> <destination> DB <db> [REPLACE], copies the key to another database
+func (c *Client) CopyToDB(source, destination string, db int, replace bool) (bool, error) {
+	cmd := []string{"COPY", source, destination, "DB", strconv.Itoa(db)}
+	if replace {
+		cmd = append(cmd, "REPLACE")
+	}
+	n, err := intReply(c.Do(cmd...))
+	return n == 1, err
+}
+
+// MOVE <key> <db>, returns false if the key does not exist or the database db has it
+func (c *Client) Move(key string, db int) (bool, error) {
+	n, err := intReply(c.Do("MOVE", key, strconv.Itoa(db)))
+	return n == 1, err
+}
+
+// INCR <key>, returns the new value
+func (c *Client) Incr(key string) (int64, error) {
+	return intReply(c.Do("INCR", key))
+}
+
+// DBSIZE, returns the number of keys of the selected database
+func (c *Client) DBSize() (int64, error) {
+	return intReply(c.Do("DBSIZE"))
+}
+
+// FLUSHDB, deletes the keys of the selected database
+func (c *Client) FlushDB() error {
+	return okReply(c.Do("FLUSHDB"))
+}
+
+// FLUSHALL, deletes the keys of every database
+func (c *Client) FlushAll() error {
+	return okReply(c.Do("FLUSHALL"))
+}
+
+// SWAPDB <index1> <index2>
+func (c *Client) SwapDB(a, b int) error {
+	return okReply(c.Do("SWAPDB", strconv.Itoa(a), strconv.Itoa(b)))
+}
+
+// TYPE <key>
+func (c *Client) Type(key string) (string, error) {
+	return stringReply(c.Do("TYPE", key))
+}
+
+// KEYS <pattern>
+func (c *Client) Keys(pattern string) ([]string, error) {
+	return stringsReply(c.Do("KEYS", pattern))
+}
+
+// INFO <section>
+func (c *Client) Info(section string) (string, error) {
+	return stringReply(c.Do("INFO", section))
+}
+
+// CONFIG GET <parameter> [parameter ...], returns the value of each matching parameter
+func (c *Client) ConfigGet(parameters ...string) (map[string]string, error) {
+	m, err := mapReply(c.Do(append([]string{"CONFIG", "GET"}, parameters...)...))
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]string, len(m))
+	for name, value := range m {
+		config[name] = value.Str()
+	}
+	return config, nil
+}
+
+// WAIT <numreplicas> <timeout>, returns the number of replicas that acknowledged the writes
+func (c *Client) Wait(replicas int, timeout time.Duration) (int64, error) {
+	return intReply(c.doBlocking(timeout, "WAIT", strconv.Itoa(replicas), strconv.FormatInt(timeout.Milliseconds(), 10)))
+}
+
+/*
+SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT], the server closes the connection when it shuts down.
+Returns nil once the connection is closed, or the error of a shutdown that failed.
+*/
+func (c *Client) Shutdown(flags ...string) error {
+	_, err := c.Do(append([]string{"SHUTDOWN"}, flags...)...)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// CLIENT ID
+func (c *Client) ClientID() (int64, error) {
+	return intReply(c.Do("CLIENT", "ID"))
+}
+
+// CLIENT GETNAME, returns ErrNil if the connection has no name
+func (c *Client) ClientGetName() (string, error) {
+	return stringReply(c.Do("CLIENT", "GETNAME"))
+}
+
+// CLIENT SETNAME <name>, names one connection of the pool, use Options.ClientName to name them all
+func (c *Client) ClientSetName(name string) error {
+	return okReply(c.Do("CLIENT", "SETNAME", name))
+}
+
+// CLIENT INFO
+func (c *Client) ClientInfo() (string, error) {
+	return stringReply(c.Do("CLIENT", "INFO"))
+}
+
+// CLIENT LIST [TYPE <type>] [ID <id> ...]
+func (c *Client) ClientList(filters ...string) (string, error) {
+	return stringReply(c.Do(append([]string{"CLIENT", "LIST"}, filters...)...))
+}
+
+// CLIENT KILL <filter> <value> [filter value ...], returns the number of clients killed
+func (c *Client) ClientKill(filters ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"CLIENT", "KILL"}, filters...)...))
+}
+
+// COMMAND COUNT
+func (c *Client) CommandCount() (int64, error) {
+	return intReply(c.Do("COMMAND", "COUNT"))
+}
+
+// COMMAND LIST
+func (c *Client) CommandList() ([]string, error) {
+	return stringsReply(c.Do("COMMAND", "LIST"))
+}
+
+// COMMAND INFO [command ...], a missing command has a null entry
+func (c *Client) CommandInfo(commands ...string) ([]server.RespValue, error) {
+	v, err := c.Do(append([]string{"COMMAND", "INFO"}, commands...)...)
+	return v.Array(), err
+}
+
+// COMMAND DOCS [command ...], returns the documentation of each command keyed by name
+func (c *Client) CommandDocs(commands ...string) (map[string]server.RespValue, error) {
+	return mapReply(c.Do(append([]string{"COMMAND", "DOCS"}, commands...)...))
+}
+
+// COMMAND GETKEYS <command> [arg ...]
+func (c *Client) CommandGetKeys(args ...string) ([]string, error) {
+	return stringsReply(c.Do(append([]string{"COMMAND", "GETKEYS"}, args...)...))
+}
+
+// ACL SETUSER <username> [rule ...]
+func (c *Client) ACLSetUser(username string, rules ...string) error {
+	return okReply(c.Do(append([]string{"ACL", "SETUSER", username}, rules...)...))
+}
+
+// ACL GETUSER <username>, returns ErrNil if the user does not exist
+func (c *Client) ACLGetUser(username string) (map[string]server.RespValue, error) {
+	v, err := c.Do("ACL", "GETUSER", username)
+	if err == nil && v.IsNull() {
+		return nil, ErrNil
+	}
+	return mapReply(v, err)
+}
+
+// ACL DELUSER <username> [username ...], returns the number of users deleted
+func (c *Client) ACLDelUser(usernames ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"ACL", "DELUSER"}, usernames...)...))
+}
+
+// ACL LIST, returns the rules of each user
+func (c *Client) ACLList() ([]string, error) {
+	return stringsReply(c.Do("ACL", "LIST"))
+}
+
+// ACL USERS
+func (c *Client) ACLUsers() ([]string, error) {
+	return stringsReply(c.Do("ACL", "USERS"))
+}
+
+// ACL WHOAMI
+func (c *Client) ACLWhoAmI() (string, error) {
+	return stringReply(c.Do("ACL", "WHOAMI"))
+}
+
+// ACL SAVE
+func (c *Client) ACLSave() error {
+	return okReply(c.Do("ACL", "SAVE"))
+}
+
+// ACL CAT [category], returns the categories, or the commands of a category
+func (c *Client) ACLCat(category ...string) ([]string, error) {
+	return stringsReply(c.Do(append([]string{"ACL", "CAT"}, category...)...))
+}
+
+// LPUSH <key> <element> [element ...], returns the length of the list
+func (c *Client) LPush(key string, elements ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"LPUSH", key}, elements...)...))
+}
+
+// RPUSH <key> <element> [element ...], returns the length of the list
+func (c *Client) RPush(key string, elements ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"RPUSH", key}, elements...)...))
+}
+
+// LPOP <key>, returns ErrNil if the list does not exist
+func (c *Client) LPop(key string) (string, error) {
+	return stringReply(c.Do("LPOP", key))
+}
+
+// RPOP <key>, returns ErrNil if the list does not exist
+func (c *Client) RPop(key string) (string, error) {
+	return stringReply(c.Do("RPOP", key))
+}
+
+// LPOP <key> <count>, returns ErrNil if the list does not exist
+func (c *Client) LPopCount(key string, count int) ([]string, error) {
+	v, err := c.Do("LPOP", key, strconv.Itoa(count))
+	if err == nil && v.IsNull() {
+		return nil, ErrNil
+	}
+	return stringsReply(v, err)
+}
+
+// LLEN <key>
+func (c *Client) LLen(key string) (int64, error) {
+	return intReply(c.Do("LLEN", key))
+}
+
+// LRANGE <key> <start> <stop>, negative indexes count from the end of the list
+func (c *Client) LRange(key string, start, stop int) ([]string, error) {
+	return stringsReply(c.Do("LRANGE", key, strconv.Itoa(start), strconv.Itoa(stop)))
+}
+
+// LINDEX <key> <index>, returns ErrNil if the index is out of range
+func (c *Client) LIndex(key string, index int) (string, error) {
+	return stringReply(c.Do("LINDEX", key, strconv.Itoa(index)))
+}
+
+// LSET <key> <index> <element>
+func (c *Client) LSet(key string, index int, element string) error {
+	return okReply(c.Do("LSET", key, strconv.Itoa(index), element))
+}
+
+// LREM <key> <count> <element>, returns the number of elements removed
+func (c *Client) LRem(key string, count int, element string) (int64, error) {
+	return intReply(c.Do("LREM", key, strconv.Itoa(count), element))
+}
+
+// LTRIM <key> <start> <stop>
+func (c *Client) LTrim(key string, start, stop int) error {
+	return okReply(c.Do("LTRIM", key, strconv.Itoa(start), strconv.Itoa(stop)))
+}
+
+// LINSERT <key> BEFORE|AFTER <pivot> <element>, returns the length of the list, -1 if pivot was not found
+func (c *Client) LInsert(key string, after bool, pivot, element string) (int64, error) {
+	where := "BEFORE"
+	if after {
+		where = "AFTER"
+	}
+	return intReply(c.Do("LINSERT", key, where, pivot, element))
+}
+
+// LPOS <key> <element>, returns ErrNil if the list has no such element
+func (c *Client) LPos(key, element string) (int64, error) {
+	v, err := c.Do("LPOS", key, element)
+	if err == nil && v.IsNull() {
+		return 0, ErrNil
+	}
+	return intReply(v, err)
+}
+
+// LMOVE <source> <destination> LEFT|RIGHT LEFT|RIGHT, returns ErrNil if source does not exist
+func (c *Client) LMove(source, destination, from, to string) (string, error) {
+	return stringReply(c.Do("LMOVE", source, destination, from, to))
+}
+
+// Formats the timeout of the blocking list commands in seconds, BLOCK_FOREVER and 0 wait forever
+func listTimeout(timeout time.Duration) (string, time.Duration) {
+	if timeout <= 0 {
+		return "0", BLOCK_FOREVER
+	}
+	return strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64), timeout
+}
+
+// Parses the [key, element] reply of BLPOP and BRPOP
+func keyElementReply(v server.RespValue, err error) (string, string, error) {
+	if err != nil {
+		return "", "", err
+	}
+	elems := v.Array()
+	if v.IsNull() || len(elems) != 2 {
+		return "", "", ErrNil
+	}
+	return elems[0].Str(), elems[1].Str(), nil
+}
+
+// BLPOP <key> [key ...] <timeout>, returns the key popped from and the element, ErrNil once the timeout expires
+func (c *Client) BLPop(timeout time.Duration, keys ...string) (string, string, error) {
+	arg, timeout := listTimeout(timeout)
+	return keyElementReply(c.doBlocking(timeout, append(append([]string{"BLPOP"}, keys...), arg)...))
+}
+
+// BRPOP <key> [key ...] <timeout>, returns the key popped from and the element, ErrNil once the timeout expires
+func (c *Client) BRPop(timeout time.Duration, keys ...string) (string, string, error) {
+	arg, timeout := listTimeout(timeout)
+	return keyElementReply(c.doBlocking(timeout, append(append([]string{"BRPOP"}, keys...), arg)...))
+}
+
+// BLMOVE <source> <destination> LEFT|RIGHT LEFT|RIGHT <timeout>, returns ErrNil once the timeout expires
+func (c *Client) BLMove(source, destination, from, to string, timeout time.Duration) (string, error) {
+	arg, timeout := listTimeout(timeout)
+	return stringReply(c.doBlocking(timeout, "BLMOVE", source, destination, from, to, arg))
+}
+
+// Parses the [key, [element ...]] reply of LMPOP and BLMPOP
+func multiPopReply(v server.RespValue, err error) (string, []string, error) {
+	if err != nil {
+		return "", nil, err
+	}
+	elems := v.Array()
+	if v.IsNull() || len(elems) != 2 {
+		return "", nil, ErrNil
+	}
+	values, err := stringsReply(elems[1], nil)
+	return elems[0].Str(), values, err
+}
+
+// LMPOP <numkeys> <key> [key ...] LEFT|RIGHT COUNT <count>, returns the key popped from and its elements, ErrNil if all the lists are empty
+func (c *Client) LMPop(from string, count int, keys ...string) (string, []string, error) {
+	args := append([]string{"LMPOP", strconv.Itoa(len(keys))}, keys...)
+	return multiPopReply(c.Do(append(args, from, "COUNT", strconv.Itoa(count))...))
+}
+
+// BLMPOP <timeout> <numkeys> <key> [key ...] LEFT|RIGHT COUNT <count>, returns ErrNil once the timeout expires
+func (c *Client) BLMPop(timeout time.Duration, from string, count int, keys ...string) (string, []string, error) {
+	arg, timeout := listTimeout(timeout)
+	args := append([]string{"BLMPOP", arg, strconv.Itoa(len(keys))}, keys...)
+	return multiPopReply(c.doBlocking(timeout, append(args, from, "COUNT", strconv.Itoa(count))...))
+}
+
+// HSET <key> <field> <value> [field value ...], returns the number of fields added
+func (c *Client) HSet(key string, fieldValues ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"HSET", key}, fieldValues...)...))
+}
+
+// HSETNX <key> <field> <value>, returns false if the field already exists
+func (c *Client) HSetNX(key, field, value string) (bool, error) {
+	n, err := intReply(c.Do("HSETNX", key, field, value))
+	return n == 1, err
+}
+
+// HGET <key> <field>, returns ErrNil if the field does not exist
+func (c *Client) HGet(key, field string) (string, error) {
+	return stringReply(c.Do("HGET", key, field))
+}
+
+// HMGET <key> <field> [field ...], the fields that don't exist have an empty value
+func (c *Client) HMGet(key string, fields ...string) ([]string, error) {
+	return stringsReply(c.Do(append([]string{"HMGET", key}, fields...)...))
+}
+
+// HDEL <key> <field> [field ...], returns the number of fields removed
+func (c *Client) HDel(key string, fields ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"HDEL", key}, fields...)...))
+}
+
+// HGETALL <key>
+func (c *Client) HGetAll(key string) (map[string]string, error) {
+	m, err := mapReply(c.Do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(m))
+	for field, v := range m {
+		values[field] = v.Str()
+	}
+	return values, nil
+}
+
+// HINCRBY <key> <field> <increment>, returns the new value
+func (c *Client) HIncrBy(key, field string, increment int64) (int64, error) {
+	return intReply(c.Do("HINCRBY", key, field, strconv.FormatInt(increment, 10)))
+}
+
+// HINCRBYFLOAT <key> <field> <increment>, returns the new value
+func (c *Client) HIncrByFloat(key, field string, increment float64) (float64, error) {
+	v, err := stringReply(c.Do("HINCRBYFLOAT", key, field, strconv.FormatFloat(increment, 'f', -1, 64)))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// HEXISTS <key> <field>
+func (c *Client) HExists(key, field string) (bool, error) {
+	n, err := intReply(c.Do("HEXISTS", key, field))
+	return n == 1, err
+}
+
+// HLEN <key>
+func (c *Client) HLen(key string) (int64, error) {
+	return intReply(c.Do("HLEN", key))
+}
+
+// HKEYS <key>
+func (c *Client) HKeys(key string) ([]string, error) {
+	return stringsReply(c.Do("HKEYS", key))
+}
+
+// HVALS <key>
+func (c *Client) HVals(key string) ([]string, error) {
+	return stringsReply(c.Do("HVALS", key))
+}
+
+// HSTRLEN <key> <field>
+func (c *Client) HStrLen(key, field string) (int64, error) {
+	return intReply(c.Do("HSTRLEN", key, field))
+}
+
+// HRANDFIELD <key> <count>, distinct fields if count is positive, fields that may repeat if it is negative
+func (c *Client) HRandField(key string, count int) ([]string, error) {
+	return stringsReply(c.Do("HRANDFIELD", key, strconv.Itoa(count)))
+}
+
+// The FIELDS numfields field [field ...] arguments of the hash field expiration commands
+func hashFieldsArgs(fields []string) []string {
+	return append([]string{"FIELDS", strconv.Itoa(len(fields))}, fields...)
+}
+
+/*
+HPEXPIRE <key> <milliseconds> FIELDS <numfields> <field> [field ...], the ttl is rounded to the millisecond.
+Returns for each field -2 if it does not exist, 1 if its expiry time was set or 2 if it was deleted as the ttl is 0.
+*/
+func (c *Client) HExpire(key string, ttl time.Duration, fields ...string) ([]int64, error) {
+	args := append([]string{"HPEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10)}, hashFieldsArgs(fields)...)
+	return intsReply(c.Do(args...))
+}
+
+// HPEXPIREAT <key> <unix-time-milliseconds> FIELDS <numfields> <field> [field ...], returns the same as HExpire
+func (c *Client) HExpireAt(key string, at time.Time, fields ...string) ([]int64, error) {
+	args := append([]string{"HPEXPIREAT", key, strconv.FormatInt(at.UnixMilli(), 10)}, hashFieldsArgs(fields)...)
+	return intsReply(c.Do(args...))
+}
+
+// HPTTL <key> FIELDS <numfields> <field> [field ...], returns for each field its ttl in milliseconds, -2 if it does not exist or -1 if it has no expiry time
+func (c *Client) HPTTL(key string, fields ...string) ([]int64, error) {
+	return intsReply(c.Do(append([]string{"HPTTL", key}, hashFieldsArgs(fields)...)...))
+}
+
+// HPERSIST <key> FIELDS <numfields> <field> [field ...], returns for each field -2 if it does not exist, -1 if it has no expiry time or 1
+func (c *Client) HPersist(key string, fields ...string) ([]int64, error) {
+	return intsReply(c.Do(append([]string{"HPERSIST", key}, hashFieldsArgs(fields)...)...))
+}
+
+// HGETEX <key> [PX milliseconds] FIELDS <numfields> <field> [field ...], the expiry time is set if ttl isn't 0, like HMGet otherwise
+func (c *Client) HGetEx(key string, ttl time.Duration, fields ...string) ([]string, error) {
+	args := []string{"HGETEX", key}
+	if ttl != 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	return stringsReply(c.Do(append(args, hashFieldsArgs(fields)...)...))
+}
+
+// SADD <key> <member> [member ...], returns the number of members added
+func (c *Client) SAdd(key string, members ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"SADD", key}, members...)...))
+}
+
+// SREM <key> <member> [member ...], returns the number of members removed
+func (c *Client) SRem(key string, members ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"SREM", key}, members...)...))
+}
+
+// SMEMBERS <key>
+func (c *Client) SMembers(key string) ([]string, error) {
+	return stringsReply(c.Do("SMEMBERS", key))
+}
+
+// SISMEMBER <key> <member>
+func (c *Client) SIsMember(key, member string) (bool, error) {
+	n, err := intReply(c.Do("SISMEMBER", key, member))
+	return n == 1, err
+}
+
+// SMISMEMBER <key> <member> [member ...]
+func (c *Client) SMIsMember(key string, members ...string) ([]bool, error) {
+	results, err := intsReply(c.Do(append([]string{"SMISMEMBER", key}, members...)...))
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(results))
+	for i, n := range results {
+		found[i] = n == 1
+	}
+	return found, nil
+}
+
+// SCARD <key>
+func (c *Client) SCard(key string) (int64, error) {
+	return intReply(c.Do("SCARD", key))
+}
+
+// SPOP <key> <count>, returns up to count random members after removing them
+func (c *Client) SPop(key string, count int) ([]string, error) {
+	return stringsReply(c.Do("SPOP", key, strconv.Itoa(count)))
+}
+
+// SRANDMEMBER <key> <count>, distinct members if count is positive, members that may repeat if it is negative
+func (c *Client) SRandMember(key string, count int) ([]string, error) {
+	return stringsReply(c.Do("SRANDMEMBER", key, strconv.Itoa(count)))
+}
+
+// SINTER <key> [key ...]
+func (c *Client) SInter(keys ...string) ([]string, error) {
+	return stringsReply(c.Do(append([]string{"SINTER"}, keys...)...))
+}
+
+// SUNION <key> [key ...]
+func (c *Client) SUnion(keys ...string) ([]string, error) {
+	return stringsReply(c.Do(append([]string{"SUNION"}, keys...)...))
+}
+
+// SDIFF <key> [key ...], the members of the first set that are in none of the others
+func (c *Client) SDiff(keys ...string) ([]string, error) {
+	return stringsReply(c.Do(append([]string{"SDIFF"}, keys...)...))
+}
+
+// SINTERSTORE <destination> <key> [key ...], returns the number of members stored
+func (c *Client) SInterStore(destination string, keys ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"SINTERSTORE", destination}, keys...)...))
+}
+
+// SUNIONSTORE <destination> <key> [key ...], returns the number of members stored
+func (c *Client) SUnionStore(destination string, keys ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"SUNIONSTORE", destination}, keys...)...))
+}
+
+// SDIFFSTORE <destination> <key> [key ...], returns the number of members stored
+func (c *Client) SDiffStore(destination string, keys ...string) (int64, error) {
+	return intReply(c.Do(append([]string{"SDIFFSTORE", destination}, keys...)...))
+}
+
+// SINTERCARD <numkeys> <key> [key ...] LIMIT <limit>, the count stops at limit if it isn't 0
+func (c *Client) SInterCard(limit int, keys ...string) (int64, error) {
+	args := append([]string{"SINTERCARD", strconv.Itoa(len(keys))}, keys...)
+	return intReply(c.Do(append(args, "LIMIT", strconv.Itoa(limit))...))
+}
+
+// SMOVE <source> <destination> <member>, returns false if the member isn't in the source
+func (c *Client) SMove(source, destination, member string) (bool, error) {
+	n, err := intReply(c.Do("SMOVE", source, destination, member))
+	return n == 1, err
+}
+
+// XMessage is an entry of a stream
+type XMessage struct {
+	ID     string
+	Values []string // fields and values, in the order they were added
+}
+
+// XStream holds the entries XREAD read from a stream
+type XStream struct {
+	Stream   string
+	Messages []XMessage
+}
+
+func parseXMessages(v server.RespValue) []XMessage {
+	messages := make([]XMessage, 0, len(v.Array()))
+	for _, entry := range v.Array() {
+		elems := entry.Array()
+		if len(elems) != 2 {
+			continue
+		}
+		values := make([]string, 0, len(elems[1].Array()))
+		for _, value := range elems[1].Array() {
+			values = append(values, value.Str())
+		}
+		messages = append(messages, XMessage{ID: elems[0].Str(), Values: values})
+	}
+	return messages
+}
+
+// XADD <key> <id> <field> <value> [field value ...], returns the ID of the entry
+func (c *Client) XAdd(key, id string, fieldValues ...string) (string, error) {
+	return stringReply(c.Do(append([]string{"XADD", key, id}, fieldValues...)...))
+}
+
+// XRANGE <key> <start> <end>
+func (c *Client) XRange(key, start, end string) ([]XMessage, error) {
+	v, err := c.Do("XRANGE", key, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return parseXMessages(v), nil
+}
+
+// Waits for new entries without a timeout when given as XReadArgs.Block
+const BLOCK_FOREVER time.Duration = -1
+
+// Options of XREAD
+type XReadArgs struct {
+	Streams []string
+	IDs     []string      // ID to read after for each stream, $ for the entries added from now on
+	Block   time.Duration // how long to wait for new entries, 0 does not wait, BLOCK_FOREVER has no timeout
+}
+
+/*
+XREAD [BLOCK <milliseconds>] STREAMS <key> [key ...] <id> [id ...]
+Returns ErrNil if there was no new entry before the timeout.
+A call blocking forever returns once the client is closed.
+*/
+func (c *Client) XRead(args XReadArgs) ([]XStream, error) {
+	cmd := []string{"XREAD"}
+	var timeout time.Duration
+	switch {
+	case args.Block == BLOCK_FOREVER:
+		cmd = append(cmd, "BLOCK", "0")
+		timeout = BLOCK_FOREVER
+	case args.Block > 0:
+		cmd = append(cmd, "BLOCK", strconv.FormatInt(args.Block.Milliseconds(), 10))
+		timeout = args.Block
+	}
+	cmd = append(cmd, "STREAMS")
+	cmd = append(cmd, args.Streams...)
+	cmd = append(cmd, args.IDs...)
+	v, err := c.doBlocking(timeout, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsNull() {
+		return nil, ErrNil
+	}
+	elems := v.Array()
+	streams := make([]XStream, 0, len(elems))
+	// A map of the stream names to their entries in RESP3, an array of pairs in RESP2
+	if v.Kind() == '%' {
+		for i := 0; i+1 < len(elems); i += 2 {
+			streams = append(streams, XStream{Stream: elems[i].Str(), Messages: parseXMessages(elems[i+1])})
+		}
+		return streams, nil
+	}
+	for _, stream := range elems {
+		if pair := stream.Array(); len(pair) == 2 {
+			streams = append(streams, XStream{Stream: pair[0].Str(), Messages: parseXMessages(pair[1])})
+		}
+	}
+	return streams, nil
+}
+
+// Runs a command that blocks on the server for up to timeout, BLOCK_FOREVER waits for the reply without a deadline
+func (c *Client) doBlocking(timeout time.Duration, args ...string) (server.RespValue, error) {
+	var v server.RespValue
+	err := c.withConn(func(conn *Conn) error {
+		if err := conn.send(args...); err != nil {
+			return err
+		}
+		if err := conn.flush(); err != nil {
+			return err
+		}
+		readTimeout := timeout + conn.readTimeout
+		if timeout == BLOCK_FOREVER {
+			readTimeout = 0
+		}
+		var err error
+		v, err = conn.receive(readTimeout)
+		return err
+	})
+	return v, err
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
+
+// Conn is a single connection to the server, it is not safe for concurrent use
+type Conn struct {
+	conn        net.Conn
+	reader      *server.RespReader
+	writer      *bufio.Writer
+	readTimeout time.Duration
+	broken      bool // an I/O error occurred, the connection can't be reused
+}
+
+// Opens a connection, then authenticates it, switches its protocol and names it as configured
+func dial(opts Options) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", opts.Addr, opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: conn, reader: server.NewRespReader(conn), writer: bufio.NewWriter(conn), readTimeout: opts.ReadTimeout}
+	setup := make([][]string, 0)
+	if opts.Protocol == server.RESP3 {
+		hello := []string{"HELLO", "3"}
+		if opts.Password != "" {
+			hello = append(hello, "AUTH", opts.Username, opts.Password)
+			if opts.Username == "" {
+				hello[3] = server.DEFAULT_USER
+			}
+		}
+		setup = append(setup, hello)
+	} else if opts.Password != "" {
+		auth := []string{"AUTH", opts.Password}
+		if opts.Username != "" {
+			auth = []string{"AUTH", opts.Username, opts.Password}
+		}
+		setup = append(setup, auth)
+	}
+	if opts.ClientName != "" {
+		setup = append(setup, []string{"CLIENT", "SETNAME", opts.ClientName})
+	}
+	if opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(opts.DB)})
+	}
+	for _, args := range setup {
+		if _, err := c.Do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Sends a command and reads its reply, an error reply is returned as an Error
+func (c *Conn) Do(args ...string) (server.RespValue, error) {
+	if err := c.send(args...); err != nil {
+		return server.RespValue{}, err
+	}
+	if err := c.flush(); err != nil {
+		return server.RespValue{}, err
+	}
+	return c.receive(c.readTimeout)
+}
+
+// Buffers a command, flush sends the buffered commands
+func (c *Conn) send(args ...string) error {
+	if len(args) == 0 {
+		return ErrNoCommand
+	}
+	_, err := c.writer.Write(server.NewRequest(args[0], args[1:]...).Encode())
+	if err != nil {
+		c.broken = true
+	}
+	return err
+}
+
+func (c *Conn) flush() error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.readTimeout))
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return err
+	}
+	return nil
+}
+
+// Reads a reply, a timeout of 0 waits forever
+func (c *Conn) receive(timeout time.Duration) (server.RespValue, error) {
+	if timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	v, err := c.reader.ReadValue()
+	if err != nil {
+		c.broken = true
+		return v, err
+	}
+	if v.IsError() {
+		return v, Error(v.Str())
+	}
+	return v, nil
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
+
+// Pipeline queues commands and sends them in a single write, their replies are then read at once
+type Pipeline struct {
+	client *Client
+	cmds   [][]string
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Queues a command
+func (p *Pipeline) Do(args ...string) {
+	p.cmds = append(p.cmds, args)
+}
+
+// Returns the number of queued commands
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+/*
+Sends the queued commands and returns their replies in order, the queue is then emptied.
+An error reply does not stop the other commands, it stays in the replies
+and the first one is returned as the error.
+*/
+func (p *Pipeline) Exec() ([]server.RespValue, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	var replies []server.RespValue
+	var firstErr error
+	err := p.client.withConn(func(conn *Conn) error {
+		for _, args := range cmds {
+			if err := conn.send(args...); err != nil {
+				return err
+			}
+		}
+		if err := conn.flush(); err != nil {
+			return err
+		}
+		replies = make([]server.RespValue, 0, len(cmds))
+		for range cmds {
+			v, err := conn.receive(conn.readTimeout)
+			if _, ok := err.(Error); err != nil && !ok {
+				return err
+			} else if ok && firstErr == nil {
+				firstErr = err
+			}
+			replies = append(replies, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replies, firstErr
+}
+
+// Tx queues the commands of a MULTI/EXEC transaction, they are sent with MULTI and EXEC in a single write
+type Tx struct {
+	client *Client
+	cmds   [][]string
+}
+
+func (c *Client) Multi() *Tx {
+	return &Tx{client: c}
+}
+
+// Queues a command
+func (t *Tx) Do(args ...string) {
+	t.cmds = append(t.cmds, args)
+}
+
+// Forgets the queued commands, nothing was sent to the server
+func (t *Tx) Discard() {
+	t.cmds = nil
+}
+
+/*
+Runs the queued commands in a transaction and returns their replies.
+If a command is refused while being queued, the server discards the transaction:
+its error is returned and none of the commands run.
+The error replies of the commands that ran stay in the replies, the first one is returned as the error.
+*/
+func (t *Tx) Exec() ([]server.RespValue, error) {
+	cmds := t.cmds
+	t.cmds = nil
+	var replies []server.RespValue
+	var firstErr error
+	err := t.client.withConn(func(conn *Conn) error {
+		if err := conn.send("MULTI"); err != nil {
+			return err
+		}
+		for _, args := range cmds {
+			if err := conn.send(args...); err != nil {
+				return err
+			}
+		}
+		if err := conn.send("EXEC"); err != nil {
+			return err
+		}
+		if err := conn.flush(); err != nil {
+			return err
+		}
+		var queueErr error
+		// The replies of MULTI and of each queued command
+		for i := 0; i <= len(cmds); i++ {
+			_, err := conn.receive(conn.readTimeout)
+			if _, ok := err.(Error); err != nil && !ok {
+				return err
+			} else if ok && queueErr == nil {
+				queueErr = err
+			}
+		}
+		v, err := conn.receive(conn.readTimeout)
+		if queueErr != nil {
+			if _, ok := err.(Error); err != nil && !ok {
+				return err
+			}
+			return queueErr
+		}
+		if err != nil {
+			return err
+		}
+		if v.IsNull() {
+			return ErrNil
+		}
+		replies = v.Array()
+		for _, reply := range replies {
+			if reply.IsError() {
+				firstErr = Error(reply.Str())
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replies, firstErr
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"sync"
+)
+
+/*
+Pool keeps the idle connections to the server for reuse.
+At most size connections are open at once, Get waits for one to be put back when they are all in use.
+A connection that had an I/O error is closed instead of being put back.
+*/
+type Pool struct {
+	dial  func() (*Conn, error)
+	idle  chan *Conn
+	slots chan struct{} // holds a token per open connection
+	mu    sync.Mutex
+	open  map[*Conn]struct{}
+	done  chan struct{} // closed by Close
+}
+
+func NewPool(size int, dial func() (*Conn, error)) *Pool {
+	return &Pool{
+		dial:  dial,
+		idle:  make(chan *Conn, size),
+		slots: make(chan struct{}, size),
+		open:  make(map[*Conn]struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Returns an idle connection, or opens a new one if the pool is not full
+func (p *Pool) Get() (*Conn, error) {
+	// The idle connections of a closed pool are closed too
+	select {
+	case <-p.done:
+		return nil, ErrClosed
+	default:
+	}
+	select {
+	case <-p.done:
+		return nil, ErrClosed
+	case c := <-p.idle:
+		return c, nil
+	case p.slots <- struct{}{}:
+	}
+	c, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		c.Close()
+		<-p.slots
+		return nil, ErrClosed
+	default:
+	}
+	p.open[c] = struct{}{}
+	return c, nil
+}
+
+// Gives a connection back to the pool
+func (p *Pool) Put(c *Conn) {
+	select {
+	case <-p.done:
+		p.remove(c)
+		return
+	default:
+	}
+	if c.broken {
+		p.remove(c)
+		return
+	}
+	p.idle <- c
+}
+
+func (p *Pool) remove(c *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.open[c]; !ok {
+		return
+	}
+	c.Close()
+	delete(p.open, c)
+	<-p.slots
+}
+
+// Returns the number of open connections, idle or in use
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.open)
+}
+
+// Closes every connection, the ones in use too, which interrupts the blocking commands
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	close(p.done)
+	for c := range p.open {
+		c.Close()
+	}
+	return nil
+}
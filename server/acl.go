@@ -0,0 +1,643 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_USER = "default"
+
+// Every category a command can belong to
+var aclCategories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap", "hyperloglog",
+	"geo", "stream", "pubsub", "admin", "fast", "slow", "blocking", "dangerous", "connection", "transaction", "scripting",
+}
+
+// The keys of XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func xreadKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.ToUpper(arg) == "STREAMS" {
+			streams := args[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+	return nil
+}
+
+// User is an ACL user, its rules decide which commands it can run and which keys and channels it can access
+type User struct {
+	name            string
+	enabled         bool
+	nopass          bool
+	passwords       []string // SHA-256 hashes of the passwords, in hex
+	commandRules    []string // +command, -command, +@category or -@category rules applied in order, the first one is +@all or -@all
+	keyPatterns     []string // glob-style patterns of the keys the user can access
+	channelPatterns []string // "*" after allchannels, empty after resetchannels
+}
+
+// Creates a user that is disabled and can't run any command until it's given rules
+func newUser(name string) *User {
+	return &User{name: name, commandRules: []string{"-@all"}}
+}
+
+func (u *User) Name() string {
+	return u.name
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.commandRules = append([]string(nil), u.commandRules...)
+	c.keyPatterns = append([]string(nil), u.keyPatterns...)
+	c.channelPatterns = append([]string(nil), u.channelPatterns...)
+	return &c
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Checks a password against the hashes of the user's passwords.
+The hashes are compared in constant time, and as they all have the same length
+neither the content nor the length of the passwords leak through the timing.
+*/
+func (u *User) checkPassword(password string) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	hash := []byte(hashPassword(password))
+	match := false
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(p)) == 1 {
+			match = true
+		}
+	}
+	return match
+}
+
+/*
+Applies a single ACL rule to the user:
+
+	on, off                         enable or disable the user
+	nopass, resetpass               allow any password, or forget every password
+	>password, <password            add or remove a password
+	#hash, !hash                    add or remove the SHA-256 hash of a password
+	~pattern, allkeys, resetkeys    allow keys matching a pattern, or every key, or none
+	allchannels, resetchannels      allow every pub/sub channel, or none
+	+command, -command              allow or deny a command, or a subcommand with command|subcommand
+	+@category, -@category          allow or deny every command of a category
+	allcommands, nocommands         same as +@all and -@all
+	reset                           start over from a new user
+
+&pattern rules are rejected, the server has no pub/sub command that could enforce them.
+*/
+func (u *User) applyRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+	case "off":
+		u.enabled = false
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+	case "allkeys":
+		u.keyPatterns = []string{"*"}
+	case "resetkeys":
+		u.keyPatterns = nil
+	case "allchannels":
+		u.channelPatterns = []string{"*"}
+	case "resetchannels":
+		u.channelPatterns = nil
+	case "allcommands":
+		u.commandRules = []string{"+@all"}
+	case "nocommands":
+		u.commandRules = []string{"-@all"}
+	case "reset":
+		*u = *newUser(u.name)
+	default:
+		if rule == "" {
+			return fmt.Errorf("Syntax error")
+		}
+		arg := rule[1:]
+		switch rule[0] {
+		case '>':
+			u.addPassword(hashPassword(arg))
+		case '<':
+			return u.removePassword(hashPassword(arg))
+		case '#':
+			if !isPasswordHash(arg) {
+				return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			u.addPassword(arg)
+		case '!':
+			return u.removePassword(arg)
+		case '~':
+			u.keyPatterns = appendPattern(u.keyPatterns, arg)
+		case '&':
+			return fmt.Errorf("Channel patterns are not supported, use allchannels or resetchannels")
+		case '+', '-':
+			return u.addCommandRule(rule[:1] + strings.ToLower(arg))
+		default:
+			return fmt.Errorf("Syntax error")
+		}
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	u.nopass = false
+	for _, p := range u.passwords {
+		if p == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *User) removePassword(hash string) error {
+	for i, p := range u.passwords {
+		if p == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("The password you are trying to remove from the user does not exist")
+}
+
+// Adds a pattern, nothing is added if every name already matches
+func appendPattern(patterns []string, pattern string) []string {
+	for _, p := range patterns {
+		if p == "*" || p == pattern {
+			return patterns
+		}
+	}
+	if pattern == "*" {
+		return []string{"*"}
+	}
+	return append(patterns, pattern)
+}
+
+// Validates a command rule and adds it, +@all and -@all override every previous rule
+func (u *User) addCommandRule(rule string) error {
+	name := rule[1:]
+	if name == "@all" {
+		u.commandRules = []string{rule}
+		return nil
+	}
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if !isCategory(category) {
+			return fmt.Errorf("Unknown command or category name in ACL")
+		}
+	} else if findCommand(name) == nil {
+		return fmt.Errorf("Unknown command or category name in ACL")
+	}
+	u.commandRules = append(u.commandRules, rule)
+	return nil
+}
+
+func isCategory(category string) bool {
+	for _, c := range aclCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the user can run a command, the rules are applied in order and the last matching one wins
+func (u *User) canRun(cmd *Command) bool {
+	allowed := false
+	for _, rule := range u.commandRules {
+		grant := rule[0] == '+'
+		target := rule[1:]
+		if target == "@all" {
+			allowed = grant
+		} else if category, ok := strings.CutPrefix(target, "@"); ok {
+			for _, c := range cmd.categories {
+				if c == category {
+					allowed = grant
+				}
+			}
+		} else if target == cmd.name || (cmd.parent != nil && target == cmd.parent.name) {
+			allowed = grant
+		}
+	}
+	return allowed
+}
+
+func (u *User) canAccess(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Describes the user with the rules that recreate it, in the format of ACL LIST and of the ACL file
+func (u *User) describe() string {
+	parts := []string{"user", u.name}
+	if u.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	for _, p := range u.keyPatterns {
+		parts = append(parts, "~"+p)
+	}
+	if len(u.channelPatterns) == 0 {
+		parts = append(parts, "resetchannels")
+	}
+	for _, p := range u.channelPatterns {
+		parts = append(parts, "&"+p)
+	}
+	parts = append(parts, u.commandRules...)
+	return strings.Join(parts, " ")
+}
+
+/*
+Matches a name against a glob-style pattern, as Redis does:
+
+	h?llo matches hello, hallo and hxllo
+	h*llo matches hllo and heeeello
+	h[ae]llo matches hello and hallo, but not hillo
+	h[^e]llo matches hallo, hbllo, ... but not hello
+	h[a-b]llo matches hallo and hbllo
+	h\*llo matches h*llo only
+*/
+func matchPattern(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchPattern(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+			name = name[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(name) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) > 1 {
+					pattern = pattern[1:]
+					match = match || pattern[0] == name[0]
+				} else if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					match = match || (name[0] >= lo && name[0] <= hi)
+					pattern = pattern[2:]
+				} else {
+					match = match || pattern[0] == name[0]
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) > 0 {
+				pattern = pattern[1:] // skip ']'
+			}
+			if match == not {
+				return false
+			}
+			name = name[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false
+			}
+			name = name[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(name) == 0
+}
+
+// ACL holds the users, a user is never modified in place but replaced so that it can be read without locking
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+	file  string // the ACL file given with --aclfile, users can't be saved if empty
+}
+
+// Creates the ACL with the default user, which can run every command without a password
+func NewACL(file string) *ACL {
+	return &ACL{users: map[string]*User{DEFAULT_USER: defaultUser()}, file: file}
+}
+
+func defaultUser() *User {
+	return &User{name: DEFAULT_USER, enabled: true, nopass: true, commandRules: []string{"+@all"}, keyPatterns: []string{"*"}, channelPatterns: []string{"*"}}
+}
+
+// Returns a user, nil if it doesn't exist
+func (a *ACL) User(name string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[name]
+}
+
+// Returns the users, ordered by name
+func (a *ACL) Users() []*User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	users := make([]*User, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+// Creates or modifies a user, none of the rules are applied if one of them is invalid
+func (a *ACL) SetUser(name string, rules ...string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user, ok := a.users[name]
+	if ok {
+		user = user.clone()
+	} else {
+		user = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err)
+		}
+	}
+	a.users[name] = user
+	return nil
+}
+
+// Deletes users and returns how many existed, the default user can't be deleted
+func (a *ACL) DelUsers(names ...string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, name := range names {
+		if name == DEFAULT_USER {
+			return 0, fmt.Errorf("The 'default' user cannot be removed")
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Checks the credentials of a user, a disabled user can't authenticate
+func (a *ACL) Authenticate(name, password string) bool {
+	user := a.User(name)
+	return user != nil && user.checkPassword(password)
+}
+
+/*
+Loads the users from the ACL file, one user per line in the format of ACL LIST:
+
+	user alice on #<sha256> ~cache:* resetchannels -@all +@read
+
+The default user keeps its current rules unless the file describes it.
+*/
+func (a *ACL) Load() error {
+	f, err := os.Open(a.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := map[string]*User{DEFAULT_USER: a.User(DEFAULT_USER)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user <username>", a.file, line)
+		}
+		user := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: error in user declaration '%s': %s", a.file, line, rule, err)
+			}
+		}
+		users[user.name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.users = users
+	a.mu.Unlock()
+	return nil
+}
+
+// Writes the users to the ACL file, through a temporary file so that a failure leaves the previous file intact
+func (a *ACL) Save() error {
+	if a.file == "" {
+		return fmt.Errorf("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+	}
+	content := ""
+	for _, u := range a.Users() {
+		content += u.describe() + "\n"
+	}
+	tmp := a.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.file)
+}
+
+/*
+Checks if the client can run the command, returns the error reply if it can't, nil otherwise.
+A client that hasn't authenticated can only run the commands flagged no_auth,
+which are then allowed whatever the rules of the user.
+*/
+func (r *ReqHandlerImpl) checkPermissions(cmd *Command, req *Request) []byte {
+	if cmd.Is(CMD_NOAUTH) {
+		return nil
+	}
+	if !r.client.authenticated {
+		return newSimpleError(NOAUTH_ERROR)
+	}
+	user := r.server.ACL().User(r.client.user)
+	if user == nil {
+		return newSimpleError(fmt.Sprintf("NOPERM User %s has been deleted", r.client.user))
+	}
+	if !user.canRun(cmd) {
+		return newSimpleError(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", user.name, cmd.name))
+	}
+	for _, key := range cmd.getKeys(commandArgs(cmd, req)) {
+		if !user.canAccess(user.keyPatterns, key) {
+			return newSimpleError("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+// ACL SETUSER username [rule [rule ...]]
+func (r *ReqHandlerImpl) aclSetUser(req *Request) []byte {
+	if err := r.server.ACL().SetUser(req.args[1], req.args[2:]...); err != nil {
+		return newSimpleError("ERR " + err.Error())
+	}
+	return newSimpleString("OK")
+}
+
+// ACL DELUSER username [username ...]
+func (r *ReqHandlerImpl) aclDelUser(req *Request) []byte {
+	acl := r.server.ACL()
+	deleted, err := acl.DelUsers(req.args[1:]...)
+	if err != nil {
+		return newSimpleError("ERR " + err.Error())
+	}
+	// The clients authenticated as a deleted user are disconnected
+	for _, c := range r.server.Clients() {
+		if acl.User(c.user) == nil {
+			c.Kill(r.client)
+		}
+	}
+	return newInteger(deleted)
+}
+
+// ACL LIST
+func (r *ReqHandlerImpl) aclList(req *Request) []byte {
+	lines := make([]string, 0)
+	for _, u := range r.server.ACL().Users() {
+		lines = append(lines, u.describe())
+	}
+	return newBulkArray(lines...)
+}
+
+// ACL USERS
+func (r *ReqHandlerImpl) aclUsers(req *Request) []byte {
+	names := make([]string, 0)
+	for _, u := range r.server.ACL().Users() {
+		names = append(names, u.name)
+	}
+	return newBulkArray(names...)
+}
+
+// ACL WHOAMI
+func (r *ReqHandlerImpl) aclWhoami(req *Request) []byte {
+	return newBulkString(r.client.user)
+}
+
+// ACL SAVE
+func (r *ReqHandlerImpl) aclSave(req *Request) []byte {
+	if err := r.server.ACL().Save(); err != nil {
+		return newSimpleError("ERR " + err.Error())
+	}
+	return newSimpleString("OK")
+}
+
+// ACL GETUSER username
+func (r *ReqHandlerImpl) aclGetUser(req *Request) []byte {
+	user := r.server.ACL().User(req.args[1])
+	enc := r.encoder()
+	if user == nil {
+		return enc.Null()
+	}
+	flags := []string{"off"}
+	if user.enabled {
+		flags[0] = "on"
+	}
+	if user.nopass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, 0, len(user.keyPatterns))
+	for _, p := range user.keyPatterns {
+		keys = append(keys, "~"+p)
+	}
+	channels := make([]string, 0, len(user.channelPatterns))
+	for _, p := range user.channelPatterns {
+		channels = append(channels, "&"+p)
+	}
+	return enc.Map(
+		string(newBulkString("flags")), string(newBulkArray(flags...)),
+		string(newBulkString("passwords")), string(newBulkArray(user.passwords...)),
+		string(newBulkString("commands")), string(newBulkString(strings.Join(user.commandRules, " "))),
+		string(newBulkString("keys")), string(newBulkString(strings.Join(keys, " "))),
+		string(newBulkString("channels")), string(newBulkString(strings.Join(channels, " "))),
+		string(newBulkString("selectors")), string(newBulkArray()),
+	)
+}
+
+// ACL CAT [category], lists the categories, or the commands of a category
+func (r *ReqHandlerImpl) aclCat(req *Request) []byte {
+	switch len(req.args) {
+	case 1:
+		return newBulkArray(aclCategories...)
+	case 2:
+		category := strings.ToLower(req.args[1])
+		if !isCategory(category) {
+			return newSimpleError(fmt.Sprintf("ERR Unknown category '%s'", req.args[1]))
+		}
+		commands := make([]string, 0)
+		for _, cmd := range sortedCommands() {
+			for _, c := range append([]*Command{cmd}, cmd.sortedSubcommands()...) {
+				for _, cat := range c.categories {
+					if cat == category {
+						commands = append(commands, c.name)
+					}
+				}
+			}
+		}
+		return newBulkArray(commands...)
+	default:
+		return newSimpleError("ERR wrong number of arguments for 'acl|cat' command")
+	}
+}
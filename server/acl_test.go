@@ -0,0 +1,127 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*", "anything", true},
+		{"cache:*", "cache:users", true},
+		{"cache:*", "session:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+	}
+	for _, test := range tests {
+		if got := matchPattern(test.pattern, test.name); got != test.match {
+			t.Errorf("matchPattern(%q, %q) = %v, expected %v", test.pattern, test.name, got, test.match)
+		}
+	}
+}
+
+func TestUserRules(t *testing.T) {
+	acl := NewACL("")
+	if err := acl.SetUser("reader", "on", ">pass", "~cache:*", "+@read", "-keys"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	u := acl.User("reader")
+	for _, test := range []struct {
+		command string
+		allowed bool
+	}{{"GET", true}, {"EXISTS", true}, {"KEYS", false}, {"SET", false}, {"CONFIG", false}} {
+		if u.canRun(findCommand(test.command)) != test.allowed {
+			t.Errorf("%s: expected allowed=%v", test.command, test.allowed)
+		}
+	}
+	if !acl.Authenticate("reader", "pass") || acl.Authenticate("reader", "wrong") {
+		t.Errorf("unexpected authentication result")
+	}
+	if err := acl.SetUser("reader", "+unknown"); err == nil {
+		t.Errorf("expected an error for an unknown command")
+	}
+	if err := acl.SetUser("reader", "&news:*"); err == nil {
+		t.Errorf("expected an error for a channel pattern")
+	}
+	if err := acl.SetUser("reader", "off", "+@nope"); err == nil || !acl.User("reader").enabled {
+		t.Errorf("expected the rules to be rejected as a whole")
+	}
+	if _, err := acl.DelUsers(DEFAULT_USER); err == nil {
+		t.Errorf("expected the default user to be protected")
+	}
+	want := "user reader on #" + hashPassword("pass") + " ~cache:* resetchannels -@all +@read -keys"
+	if got := u.describe(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestACLCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "users.acl")
+	os.WriteFile(file, []byte("user cache on >cachepass ~cache:* resetchannels -@all +@read +set\n"), 0600)
+	cfg := testConfig()
+	cfg.ACLFile = file
+	_, addr := startTestServer(t, cfg)
+
+	admin := dialTestClient(t, addr)
+	defer admin.Close()
+	if v := admin.do(t, "ACL", "WHOAMI"); v.Str() != DEFAULT_USER {
+		t.Fatalf("expected default, got %q", v.Str())
+	}
+
+	c := dialTestClient(t, addr)
+	defer c.Close()
+	if v := c.do(t, "AUTH", "cache", "cachepass"); v.Str() != "OK" {
+		t.Fatalf("expected OK, got %q", v.Str())
+	}
+
+	t.Run("rules are enforced", func(t *testing.T) {
+		if v := c.do(t, "SET", "cache:a", "1"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+		if v := c.do(t, "SET", "other", "1"); !strings.HasPrefix(v.Str(), "NOPERM") {
+			t.Fatalf("expected NOPERM, got %q", v.Str())
+		}
+		if v := c.do(t, "INCR", "cache:a"); !strings.HasPrefix(v.Str(), "NOPERM") {
+			t.Fatalf("expected NOPERM, got %q", v.Str())
+		}
+		if v := c.do(t, "ACL", "LIST"); !strings.HasPrefix(v.Str(), "NOPERM") {
+			t.Fatalf("expected NOPERM, got %q", v.Str())
+		}
+		if v := c.do(t, "ACL", "WHOAMI"); !strings.HasPrefix(v.Str(), "NOPERM") {
+			t.Fatalf("expected NOPERM, got %q", v.Str())
+		}
+	})
+
+	t.Run("users are managed and saved", func(t *testing.T) {
+		if v := admin.do(t, "ACL", "SETUSER", "cache", "+incr"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+		if v := c.do(t, "INCR", "cache:a"); v.Int() != 2 {
+			t.Fatalf("expected 2, got %q", v.Str())
+		}
+		if v := admin.do(t, "ACL", "SAVE"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+		saved, _ := os.ReadFile(file)
+		if !strings.Contains(string(saved), "user cache on #"+hashPassword("cachepass")+" ~cache:* resetchannels -@all +@read +set +incr\n") {
+			t.Fatalf("unexpected ACL file:\n%s", saved)
+		}
+		if v := admin.do(t, "ACL", "DELUSER", "cache"); v.Int() != 1 {
+			t.Fatalf("expected 1, got %q", v.Str())
+		}
+		if _, err := c.reader.ReadValue(); err == nil {
+			t.Fatalf("expected the clients of the deleted user to be disconnected")
+		}
+	})
+}
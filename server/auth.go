@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+)
+
+const (
+	NOAUTH_ERROR    = "NOAUTH Authentication required."
+	WRONGPASS_ERROR = "WRONGPASS invalid username-password pair or user is disabled."
+)
+
+/*
+Creates the ACL, the users of the ACL file are loaded by Init.
+The password given with --requirepass becomes the password of the default user.
+*/
+func (s *RedisServerImpl) setAuth(cfg Config) {
+	s.acl = NewACL(cfg.ACLFile)
+	if cfg.RequirePass != "" {
+		s.acl.SetUser(DEFAULT_USER, "resetpass", ">"+cfg.RequirePass)
+	}
+}
+
+// Returns the users and their rules
+func (s *RedisServerImpl) ACL() *ACL {
+	return s.acl
+}
+
+// Checks if clients must authenticate before running commands, which is the case unless the default user has no password
+func (s *RedisServerImpl) AuthRequired() bool {
+	user := s.acl.User(DEFAULT_USER)
+	return !user.enabled || !user.nopass
+}
+
+// Checks the credentials of a client
+func (s *RedisServerImpl) CheckPassword(user, password string) bool {
+	return s.acl.Authenticate(user, password)
+}
+
+// AUTH [username] password
+func (r *ReqHandlerImpl) auth(req *Request) []byte {
+	var user, password string
+	switch len(req.args) {
+	case 1:
+		user, password = DEFAULT_USER, req.args[0]
+	case 2:
+		user, password = req.args[0], req.args[1]
+	default:
+		return newSimpleError("ERR syntax error")
+	}
+	if !r.server.AuthRequired() && len(req.args) == 1 {
+		return newSimpleError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if !r.server.CheckPassword(user, password) {
+		fmt.Printf("Client %s failed to authenticate as %s\n", r.client.Addr(), user)
+		return newSimpleError(WRONGPASS_ERROR)
+	}
+	r.client.authenticated = true
+	r.client.user = user
+	return newSimpleString("OK")
+}
+
+// QUIT, the connection is closed once the reply is sent
+func (r *ReqHandlerImpl) quit(req *Request) []byte {
+	r.client.closeAfterReply = true
+	return newSimpleString("OK")
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequirePass = "s3cret"
+	_, addr := startTestServer(t, cfg)
+
+	t.Run("commands are refused until AUTH", func(t *testing.T) {
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		if v := c.do(t, "SET", "k", "v"); v.Str() != NOAUTH_ERROR {
+			t.Fatalf("expected NOAUTH, got %q", v.Str())
+		}
+		if v := c.do(t, "AUTH", "wrong"); v.Str() != WRONGPASS_ERROR {
+			t.Fatalf("expected WRONGPASS, got %q", v.Str())
+		}
+		if v := c.do(t, "AUTH", "default", "s3cret"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+		if v := c.do(t, "SET", "k", "v"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+	})
+
+	t.Run("HELLO with AUTH", func(t *testing.T) {
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		if v := c.do(t, "HELLO", "3"); !v.IsError() {
+			t.Fatalf("expected an error, got %q", v.Str())
+		}
+		if v := c.do(t, "HELLO", "3", "AUTH", "default", "s3cret"); v.Kind() != '%' {
+			t.Fatalf("expected a map, got %q", v.Str())
+		}
+		if v := c.do(t, "GET", "k"); v.Str() != "v" {
+			t.Fatalf("expected v, got %q", v.Str())
+		}
+	})
+
+	t.Run("replica with masterauth", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Port, cfg.ReplicaOf, cfg.MasterAuth = -1, addr, "s3cret"
+		replica, _ := startTestServer(t, cfg)
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		c.do(t, "AUTH", "s3cret")
+		c.do(t, "SET", "replicated", "yes")
+		for i := 0; i < 100; i++ {
+			if v, err := replica.Get("replicated"); err == nil && v == "yes" {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("the write was not propagated to the replica")
+	})
+}
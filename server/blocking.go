@@ -0,0 +1,199 @@
+package server
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+/*
+The clients blocked by BLPOP, BRPOP, BLMOVE, BLMPOP and XREAD wait in a queue per key, in the order they blocked.
+A write that adds elements to a list or entries to a stream signals its key as ready, and once the command returns
+the waiters of the ready keys are served, the first one first, until the list is empty.
+Unlike the clients blocked by WAIT, they are not woken up by every write.
+*/
+
+// A key of a logical database
+type blockingKey struct {
+	db  int
+	key string
+}
+
+type blockedClient struct {
+	client *Client
+	db     int
+	keys   []string
+	stream bool // blocked by XREAD on streams, on lists otherwise
+	// pops from a key and returns the reply, nil if the key can't serve the client, run on the executor
+	serve   func(key string) []byte
+	reply   chan []byte // gets the reply once the client is served, buffered
+	blocked bool        // false once the client is served or unblocked
+}
+
+type blockingState struct {
+	waiters map[blockingKey][]*blockedClient // FIFO of the clients blocked on each key
+	ready   []blockingKey                    // keys signaled since the waiters were last served
+}
+
+// Queues a client on each of its keys, called on the executor
+func (s *RedisServerImpl) blockForKeys(bc *blockedClient) {
+	if s.blocking.waiters == nil {
+		s.blocking.waiters = make(map[blockingKey][]*blockedClient)
+	}
+	bc.blocked = true
+	for _, key := range bc.keys {
+		k := blockingKey{db: bc.db, key: key}
+		s.blocking.waiters[k] = append(s.blocking.waiters[k], bc)
+	}
+}
+
+// Removes a client from the queues of its keys, returns false if it was already served, called on the executor
+func (s *RedisServerImpl) unblockClient(bc *blockedClient) bool {
+	if !bc.blocked {
+		return false
+	}
+	bc.blocked = false
+	for _, key := range bc.keys {
+		k := blockingKey{db: bc.db, key: key}
+		waiters := slices.DeleteFunc(s.blocking.waiters[k], func(w *blockedClient) bool { return w == bc })
+		if len(waiters) == 0 {
+			delete(s.blocking.waiters, k)
+		} else {
+			s.blocking.waiters[k] = waiters
+		}
+	}
+	return true
+}
+
+// Marks a key as ready to serve the clients blocked on it, if any, called on the executor
+func (s *RedisServerImpl) signalKeyAsReady(db int, key string) {
+	k := blockingKey{db: db, key: key}
+	if len(s.blocking.waiters[k]) > 0 && !slices.Contains(s.blocking.ready, k) {
+		s.blocking.ready = append(s.blocking.ready, k)
+	}
+}
+
+/*
+Serves the clients blocked on the keys signaled as ready, called on the executor once a command returns.
+A key that is missing, or isn't of the type a client waits for, serves nobody and its clients stay blocked.
+Serving a client may push to another list, BLMOVE does, whose key is then served too.
+*/
+func (s *RedisServerImpl) serveBlockedClients() {
+	db := s.db
+	defer s.useDB(db)
+	for len(s.blocking.ready) > 0 {
+		ready := s.blocking.ready
+		s.blocking.ready = nil
+		for _, k := range ready {
+			s.useDB(k.db)
+			for _, bc := range slices.Clone(s.blocking.waiters[k]) {
+				v, ok := s.Lookup(k.key)
+				if !ok {
+					break
+				}
+				if !bc.blocked || (bc.stream && v.stream == nil) || (!bc.stream && v.list == nil) {
+					continue
+				}
+				// A stream serves each of its clients, but a client may wait for entries after the new ones
+				reply := bc.serve(k.key)
+				if reply == nil {
+					continue
+				}
+				s.unblockClient(bc)
+				bc.client.SetBlocked(false)
+				bc.reply <- reply
+			}
+		}
+	}
+}
+
+// Parses the timeout of the blocking commands, in seconds with decimals
+func parseBlockingTimeout(arg string) (time.Duration, []byte) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > math.MaxInt64/float64(time.Second) {
+		return 0, newSimpleError("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, newSimpleError("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+/*
+Serves the client from the first of keys that can serve it, or blocks it until a write signals one of them as ready
+or the timeout expires and onTimeout gives the reply, a timeout of 0 waits forever.
+serve propagates what it pops to the replicas itself, the blocking command is never propagated.
+In a transaction the client can't wait, onTimeout gives the reply right away.
+*/
+func (r *ReqHandlerImpl) blockOnKeys(keys []string, timeout time.Duration, serve func(key string) []byte, onTimeout func() []byte) []byte {
+	r.preventPropagation = true
+	for _, key := range keys {
+		if reply := serve(key); reply != nil {
+			return reply
+		}
+	}
+	return r.blockClient(&blockedClient{keys: keys, serve: serve}, timeout, onTimeout)
+}
+
+/*
+Blocks the client of XREAD BLOCK on its streams until an entry is added to one of them, or the timeout expires.
+serve replies every stream with new entries, whichever key was signaled, nil if there is none yet.
+*/
+func (r *ReqHandlerImpl) blockOnStreams(keys []string, timeout time.Duration, serve func(key string) []byte, onTimeout func() []byte) []byte {
+	if reply := serve(""); reply != nil {
+		return reply
+	}
+	return r.blockClient(&blockedClient{keys: keys, stream: true, serve: serve}, timeout, onTimeout)
+}
+
+// Queues the client on the keys of bc and waits off the executor until it is served or the timeout expires
+func (r *ReqHandlerImpl) blockClient(bc *blockedClient, timeout time.Duration, onTimeout func() []byte) []byte {
+	if r.inExec {
+		return onTimeout()
+	}
+	bc.client, bc.db, bc.reply = r.client, r.client.db, make(chan []byte, 1)
+	r.client.SetBlocked(true)
+	r.server.blockForKeys(bc)
+	r.waitOffExecutor(func() []byte {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		gone := false
+		select {
+		case reply := <-bc.reply:
+			return reply
+		case <-expired:
+		case <-r.client.done:
+			gone = true
+		}
+		reply := []byte{}
+		r.server.execute(func() {
+			if r.server.unblockClient(bc) {
+				r.client.SetBlocked(false)
+				r.server.useDB(r.client.db)
+				if !gone {
+					reply = onTimeout()
+				}
+			}
+		})
+		// The client may have been served before it was unblocked
+		select {
+		case served := <-bc.reply:
+			return served
+		default:
+			return reply
+		}
+	})
+	return []byte{}
+}
+
+// Propagates a request to the replicas in place of the request being run, on a master
+func (r *ReqHandlerImpl) propagate(req *Request) {
+	if r.master != nil {
+		r.master.Replicate(req)
+	}
+}
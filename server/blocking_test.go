@@ -0,0 +1,349 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Waits until n clients are blocked
+func waitBlocked(t *testing.T, srv *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		blocked := 0
+		srv.execute(func() {
+			for _, c := range srv.Clients() {
+				if c.flags&CLIENT_BLOCKED != 0 {
+					blocked++
+				}
+			}
+		})
+		if blocked == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d blocked clients, got %d", n, blocked)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Sends a command without waiting for its reply, read with reply
+func (c *testConn) send(args ...string) {
+	c.Write(newBulkArray(args...))
+}
+
+func (c *testConn) reply(t *testing.T) string {
+	t.Helper()
+	v, err := c.reader.ReadValue()
+	if err != nil {
+		t.Fatalf("unable to read the reply: %s", err)
+	}
+	return formatReply(v)
+}
+
+func TestBlockingPops(t *testing.T) {
+	srv, addr := startTestServer(t, testConfig())
+	c := dialTestClient(t, addr)
+	defer c.Close()
+	expect := func(t *testing.T, c *testConn, expected string, args ...string) {
+		t.Helper()
+		if v := formatReply(c.do(t, args...)); v != expected {
+			t.Fatalf("%v: expected %q, got %q", args, expected, v)
+		}
+	}
+
+	t.Run("served right away", func(t *testing.T) {
+		c.do(t, "RPUSH", "list", "a", "b", "c", "d", "e")
+		c.do(t, "SET", "string", "v")
+		expect(t, c, "[list a]", "BLPOP", "missing", "list", "0")
+		expect(t, c, "[list e]", "BRPOP", "list", "missing", "1.5")
+		expect(t, c, "b", "BLMOVE", "list", "other", "LEFT", "RIGHT", "0")
+		expect(t, c, "d", "BRPOPLPUSH", "list", "other", "0")
+		expect(t, c, "[list [c]]", "BLMPOP", "0", "2", "missing", "list", "RIGHT", "COUNT", "5")
+		expect(t, c, "[other [d b]]", "LMPOP", "2", "list", "other", "LEFT", "COUNT", "2")
+		expect(t, c, "nil", "LMPOP", "1", "list", "LEFT")
+		expect(t, c, "0", "EXISTS", "list", "other")
+		expect(t, c, "nil", "BLPOP", "list", "0.01")
+		expect(t, c, "nil", "BLMOVE", "list", "other", "LEFT", "LEFT", "0.01")
+		expect(t, c, errWrongType.Error(), "BLPOP", "missing", "string", "0")
+		expect(t, c, "ERR timeout is negative", "BLPOP", "list", "-1")
+		expect(t, c, "ERR timeout is not a float or out of range", "BRPOP", "list", "soon")
+		expect(t, c, "ERR syntax error", "BLMOVE", "list", "other", "UP", "LEFT", "0")
+		expect(t, c, "ERR numkeys should be greater than 0", "LMPOP", "0", "list", "LEFT")
+		expect(t, c, "ERR count should be greater than 0", "BLMPOP", "0", "1", "list", "LEFT", "COUNT", "0")
+		expect(t, c, "ERR syntax error", "LMPOP", "2", "list", "LEFT")
+		expect(t, c, "ERR syntax error", "LMPOP", "1", "list", "LEFT", "LIMIT", "1")
+		expect(t, c, "[list other]", "COMMAND", "GETKEYS", "BLMPOP", "0", "2", "list", "other", "LEFT")
+		expect(t, c, "[a b]", "COMMAND", "GETKEYS", "BLPOP", "a", "b", "0")
+	})
+
+	t.Run("FIFO per key", func(t *testing.T) {
+		waiters := make([]*testConn, 3)
+		for i := range waiters {
+			waiters[i] = dialTestClient(t, addr)
+			defer waiters[i].Close()
+			waiters[i].send("BLPOP", "queue", "0")
+			waitBlocked(t, srv, i+1)
+		}
+		expect(t, c, "3", "RPUSH", "queue", "a", "b", "c")
+		for i, expected := range []string{"[queue a]", "[queue b]", "[queue c]"} {
+			if v := waiters[i].reply(t); v != expected {
+				t.Fatalf("waiter %d: expected %s, got %s", i, expected, v)
+			}
+		}
+		expect(t, c, "0", "EXISTS", "queue")
+	})
+
+	t.Run("several keys", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		w.send("BRPOP", "k1", "k2", "0")
+		waitBlocked(t, srv, 1)
+		c.do(t, "LPUSH", "k2", "v")
+		if v := w.reply(t); v != "[k2 v]" {
+			t.Fatalf("expected [k2 v], got %s", v)
+		}
+		// The served client waits on none of its keys anymore
+		c.do(t, "LPUSH", "k1", "v")
+		expect(t, c, "1", "LLEN", "k1")
+		c.do(t, "DEL", "k1")
+	})
+
+	t.Run("BLMOVE serves the clients blocked on its destination", func(t *testing.T) {
+		mover := dialTestClient(t, addr)
+		defer mover.Close()
+		popper := dialTestClient(t, addr)
+		defer popper.Close()
+		mover.send("BLMOVE", "src", "dst", "RIGHT", "LEFT", "0")
+		waitBlocked(t, srv, 1)
+		popper.send("BLMPOP", "0", "1", "dst", "LEFT", "COUNT", "10")
+		waitBlocked(t, srv, 2)
+		c.do(t, "RPUSH", "src", "x")
+		if v := mover.reply(t); v != "x" {
+			t.Fatalf("expected x, got %s", v)
+		}
+		if v := popper.reply(t); v != "[dst [x]]" {
+			t.Fatalf("expected [dst [x]], got %s", v)
+		}
+		expect(t, c, "0", "EXISTS", "src", "dst")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		start := time.Now()
+		expect(t, w, "nil", "BLPOP", "queue", "0.1")
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Fatalf("expected to wait for the timeout, returned after %s", elapsed)
+		}
+		waitBlocked(t, srv, 0)
+		c.do(t, "RPUSH", "queue", "a")
+		expect(t, c, "1", "LLEN", "queue")
+		c.do(t, "DEL", "queue")
+	})
+
+	t.Run("MULTI", func(t *testing.T) {
+		c.do(t, "MULTI")
+		c.do(t, "BLPOP", "queue", "0")
+		c.do(t, "RPUSH", "queue", "a")
+		c.do(t, "BLPOP", "queue", "0")
+		c.do(t, "BLMOVE", "queue", "other", "LEFT", "LEFT", "0")
+		expect(t, c, "[nil 1 [queue a] nil]", "EXEC")
+	})
+
+	t.Run("key deleted or changing type", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		w.send("BLPOP", "queue", "0")
+		waitBlocked(t, srv, 1)
+		c.do(t, "SET", "queue", "v")
+		c.do(t, "DEL", "queue")
+		// The key is signaled as ready, but it isn't a list anymore when the clients are served
+		c.do(t, "MULTI")
+		c.do(t, "RPUSH", "queue", "a")
+		c.do(t, "SET", "queue", "v")
+		c.do(t, "EXEC")
+		c.do(t, "MULTI")
+		c.do(t, "RPUSH", "queue", "a")
+		c.do(t, "DEL", "queue")
+		c.do(t, "EXEC")
+		waitBlocked(t, srv, 1)
+		c.do(t, "LPUSH", "queue", "b")
+		if v := w.reply(t); v != "[queue b]" {
+			t.Fatalf("expected [queue b], got %s", v)
+		}
+	})
+
+	t.Run("destination of the wrong type", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		w.send("BLMOVE", "src", "string", "LEFT", "LEFT", "0")
+		waitBlocked(t, srv, 1)
+		c.do(t, "RPUSH", "src", "a")
+		if v := w.reply(t); v != errWrongType.Error() {
+			t.Fatalf("expected WRONGTYPE, got %s", v)
+		}
+		expect(t, c, "[a]", "LRANGE", "src", "0", "-1")
+		c.do(t, "DEL", "src")
+	})
+
+	t.Run("killed client", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		id := w.do(t, "CLIENT", "ID").Int()
+		w.send("BLPOP", "queue", "0")
+		waitBlocked(t, srv, 1)
+		expect(t, c, "1", "CLIENT", "KILL", "ID", strconv.FormatInt(id, 10))
+		waitBlocked(t, srv, 0)
+		c.do(t, "RPUSH", "queue", "a")
+		expect(t, c, "1", "LLEN", "queue")
+		c.do(t, "DEL", "queue")
+	})
+
+	t.Run("disconnected client", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		w.send("BLPOP", "queue", "0")
+		waitBlocked(t, srv, 1)
+		w.Close()
+		waitBlocked(t, srv, 0)
+		// The element isn't popped for the client that is gone
+		expect(t, c, "1", "RPUSH", "queue", "job1")
+		expect(t, c, "1", "LLEN", "queue")
+
+		// XREAD BLOCK and WAIT wait off the executor the same way
+		c.do(t, "XADD", "stream", "1-1", "f", "v")
+		for _, args := range [][]string{{"XREAD", "BLOCK", "0", "STREAMS", "stream", "$"}, {"WAIT", "1", "0"}} {
+			w := dialTestClient(t, addr)
+			w.send(args...)
+			waitBlocked(t, srv, 1)
+			w.Close()
+			waitBlocked(t, srv, 0)
+		}
+		c.do(t, "DEL", "queue", "stream")
+	})
+
+	t.Run("XREAD BLOCK on several streams", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		c.do(t, "XADD", "s1", "1-1", "f", "v")
+		c.do(t, "XADD", "s2", "1-1", "f", "v")
+		w.send("XREAD", "BLOCK", "0", "STREAMS", "s1", "s2", "$", "$")
+		waitBlocked(t, srv, 1)
+		// Both streams get an entry before the client is woken up
+		c.do(t, "MULTI")
+		c.do(t, "XADD", "s2", "2-1", "f", "b")
+		c.do(t, "XADD", "s1", "2-1", "f", "a")
+		c.do(t, "EXEC")
+		if v := w.reply(t); v != "[[s1 [[2-1 [f a]]]] [s2 [[2-1 [f b]]]]]" {
+			t.Fatalf("expected the new entries of both streams, got %s", v)
+		}
+		c.do(t, "DEL", "s1", "s2")
+	})
+
+	t.Run("XREAD BLOCK waits on its streams", func(t *testing.T) {
+		c.do(t, "XADD", "s", "1-1", "f", "v")
+		later := dialTestClient(t, addr)
+		defer later.Close()
+		later.send("XREAD", "BLOCK", "0", "STREAMS", "s", "100-0")
+		waitBlocked(t, srv, 1)
+		next := dialTestClient(t, addr)
+		defer next.Close()
+		next.send("XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+		waitBlocked(t, srv, 2)
+		waiters := 0
+		srv.execute(func() {
+			waiters = len(srv.RedisServer.(*MasterServerImpl).blocking.waiters[blockingKey{db: 0, key: "s"}])
+		})
+		if waiters != 2 {
+			t.Fatalf("expected the clients to wait on the stream, got %d", waiters)
+		}
+		// The first client waits for entries after the new one, the second one is still served
+		c.do(t, "XADD", "s", "2-1", "f", "a")
+		if v := next.reply(t); v != "[[s [[2-1 [f a]]]]]" {
+			t.Fatalf("expected the new entry, got %s", v)
+		}
+		waitBlocked(t, srv, 1)
+		c.do(t, "XADD", "s", "100-1", "f", "b")
+		if v := later.reply(t); v != "[[s [[100-1 [f b]]]]]" {
+			t.Fatalf("expected the entry after 100-0, got %s", v)
+		}
+		c.do(t, "DEL", "s")
+	})
+
+	t.Run("commands pipelined after a blocking one", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		w.Write(append(newBulkArray("BLPOP", "queue", "0"), newBulkArray("PING")...))
+		waitBlocked(t, srv, 1)
+		c.do(t, "RPUSH", "queue", "a")
+		if v := w.reply(t); v != "[queue a]" {
+			t.Fatalf("expected [queue a], got %s", v)
+		}
+		if v := w.reply(t); v != "PONG" {
+			t.Fatalf("expected the pipelined PING to be kept, got %s", v)
+		}
+		expect(t, w, "PONG", "PING")
+	})
+
+	t.Run("databases", func(t *testing.T) {
+		w := dialTestClient(t, addr)
+		defer w.Close()
+		w.do(t, "SELECT", "1")
+		w.send("BLPOP", "queue", "0")
+		waitBlocked(t, srv, 1)
+		c.do(t, "RPUSH", "queue", "db0")
+		c.do(t, "SELECT", "1")
+		c.do(t, "RPUSH", "other", "db1")
+		c.do(t, "SELECT", "0")
+		expect(t, c, "OK", "SWAPDB", "0", "1")
+		if v := w.reply(t); v != "[queue db0]" {
+			t.Fatalf("expected the list swapped in, got %s", v)
+		}
+		expect(t, c, "OK", "FLUSHALL")
+	})
+}
+
+func TestBlockingPopsReplication(t *testing.T) {
+	master, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	w := dialTestClient(t, masterAddr)
+	defer w.Close()
+	w.do(t, "SELECT", "2")
+	w.send("BLPOP", "jobs", "0")
+	waitBlocked(t, master, 1)
+	c.do(t, "SELECT", "2")
+	c.do(t, "RPUSH", "jobs", "1", "2", "3", "4", "5")
+	if v := w.reply(t); v != "[jobs 1]" {
+		t.Fatalf("expected [jobs 1], got %s", v)
+	}
+	w.send("BLMOVE", "todo", "jobs", "LEFT", "RIGHT", "0")
+	waitBlocked(t, master, 1)
+	c.do(t, "RPUSH", "todo", "6")
+	if v := w.reply(t); v != "6" {
+		t.Fatalf("expected 6, got %s", v)
+	}
+	w.do(t, "BRPOP", "jobs", "0")
+	w.do(t, "BLMPOP", "0", "1", "jobs", "LEFT", "COUNT", "2")
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	var values string
+	replica.execute(func() {
+		replica.useDB(2)
+		if v, ok := replica.Lookup("jobs"); ok {
+			values = fmt.Sprint(v.list.Values())
+		}
+	})
+	if values != "[4 5]" {
+		t.Fatalf("expected [4 5] on the replica, got %q", values)
+	}
+}
@@ -0,0 +1,454 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client flags, shown by CLIENT LIST
+const (
+	CLIENT_MULTI      = 1 << iota // x: the client is in a MULTI transaction
+	CLIENT_PUBSUB                 // P: the client is subscribed to channels
+	CLIENT_REPLICA                // S: the client is a replica of this server
+	CLIENT_MASTER                 // M: the client is the master of this replica
+	CLIENT_BLOCKED                // b: the client is waiting in a blocking command
+	CLIENT_DIRTY_EXEC             // a command was refused while queueing, EXEC will abort
+)
+
+/*
+Client holds the state of a connection.
+The reader and the writer belong to the connection's goroutine, the rest of the state is only
+touched by the executor, the buffer sizes shown by CLIENT LIST are taken when a command starts.
+*/
+type Client struct {
+	id              int64
+	conn            net.Conn
+	reader          *RespReader
+	writer          *bufio.Writer // Buffers the replies of the connection, flushed once per batch of requests
+	name            string
+	createdAt       time.Time
+	lastInteraction time.Time
+	lastCommand     string
+	db              int
+	flags           int
+	protocol        int
+	queue           []Request // Requests queued by MULTI, executed by EXEC
+	user            string    // The ACL user the client is authenticated as
+	authenticated   bool      // Whether the client can run commands, always true if the default user has no password
+	closeAfterReply bool      // Set when the client is killed while running a command, or runs QUIT
+	running         bool      // Set while the client runs a batch of commands, a shutdown waits for it
+	waiting         bool      // Set while the client waits in a blocking command, a shutdown doesn't wait for it
+	qbuf            int       // Bytes buffered by the reader when the last command started
+	obl             int       // Bytes buffered by the writer when the last command started
+	done            chan struct{}
+	closeOnce       sync.Once
+}
+
+func newClient(id int64, conn net.Conn) *Client {
+	now := time.Now()
+	return &Client{
+		id:              id,
+		conn:            conn,
+		reader:          NewRespReader(conn),
+		writer:          bufio.NewWriter(conn),
+		createdAt:       now,
+		lastInteraction: now,
+		lastCommand:     "NULL",
+		user:            DEFAULT_USER,
+		protocol:        RESP2,
+		done:            make(chan struct{}),
+	}
+}
+
+func (c *Client) ID() int64 {
+	return c.id
+}
+
+func (c *Client) Addr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) SetName(name string) {
+	c.name = name
+}
+
+// Returns the protocol version used by the client, RESP2 unless negotiated otherwise with HELLO
+func (c *Client) Protocol() int {
+	return c.protocol
+}
+
+func (c *Client) SetProtocol(protocol int) {
+	c.protocol = protocol
+}
+
+// Start queueing requests for a MULTI transaction
+func (c *Client) Multi() {
+	c.flags |= CLIENT_MULTI
+	c.flags &^= CLIENT_DIRTY_EXEC
+	c.queue = make([]Request, 0)
+}
+
+// Checks if the client is in a MULTI transaction
+func (c *Client) IsInMulti() bool {
+	return c.flags&CLIENT_MULTI != 0
+}
+
+// Appends a request to the transaction
+func (c *Client) Queue(req Request) {
+	c.queue = append(c.queue, req)
+}
+
+// Ends the transaction and returns the queued requests
+func (c *Client) TakeQueue() []Request {
+	queue := c.queue
+	c.queue = nil
+	c.flags &^= CLIENT_MULTI | CLIENT_DIRTY_EXEC
+	return queue
+}
+
+// Marks the client as waiting in a blocking command, or done waiting
+func (c *Client) SetBlocked(blocked bool) {
+	if blocked {
+		c.flags |= CLIENT_BLOCKED
+	} else {
+		c.flags &^= CLIENT_BLOCKED
+	}
+}
+
+// Records the command being run, shown in the cmd field of CLIENT LIST, called on the executor
+func (c *Client) touch(req *Request) {
+	c.lastInteraction = time.Now()
+	c.lastCommand = c.commandName(req)
+	// The connection's goroutine waits for the command, its buffers can be read
+	c.qbuf = c.reader.Buffered()
+	c.obl = c.writer.Buffered()
+}
+
+// Returns the name of a command as shown to the users, followed by its subcommand for container commands, e.g. client|list
+func (c *Client) commandName(req *Request) string {
+	name := strings.ToLower(req.command)
+	if cmd, ok := commandTable[req.command]; ok && cmd.subcommands != nil && len(req.args) > 0 {
+		name += "|" + strings.ToLower(req.args[0])
+	}
+	return name
+}
+
+// Returns the type used by the TYPE filters of CLIENT LIST and CLIENT KILL
+func (c *Client) Type() string {
+	switch {
+	case c.flags&CLIENT_MASTER != 0:
+		return "master"
+	case c.flags&CLIENT_REPLICA != 0:
+		return "replica"
+	case c.flags&CLIENT_PUBSUB != 0:
+		return "pubsub"
+	default:
+		return "normal"
+	}
+}
+
+// Closes the connection, immediately or once the reply of the running command is sent if it's the caller itself
+func (c *Client) Kill(self *Client) {
+	if c == self {
+		c.closeAfterReply = true
+		return
+	}
+	c.close()
+}
+
+// Closes the connection, which also wakes the client up if it is blocked
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+/*
+Watches the connection while the client waits in a blocking command, as nothing else reads it then:
+the client is closed if the peer closes the connection, which wakes it up like CLIENT KILL does.
+The commands pipelined after the blocking command are only peeked, they are read once the client is done waiting.
+The returned function stops watching, it must be called before the connection is read again.
+*/
+func (c *Client) watchConnection() func() {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if _, err := c.reader.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			c.close()
+		}
+	}()
+	return func() {
+		// Wakes the watcher up if it still waits for the connection
+		c.conn.SetReadDeadline(time.Now())
+		<-stopped
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func (c *Client) flagString() string {
+	flags := ""
+	for _, f := range []struct {
+		flag int
+		char string
+	}{{CLIENT_MULTI, "x"}, {CLIENT_PUBSUB, "P"}, {CLIENT_REPLICA, "S"}, {CLIENT_MASTER, "M"}, {CLIENT_BLOCKED, "b"}} {
+		if c.flags&f.flag != 0 {
+			flags += f.char
+		}
+	}
+	if flags == "" {
+		return "N"
+	}
+	return flags
+}
+
+// Describes the client, in the format of CLIENT LIST and CLIENT INFO
+func (c *Client) Info() string {
+	now := time.Now()
+	multi := -1
+	if c.IsInMulti() {
+		multi = len(c.queue)
+	}
+	fields := []string{
+		"id=" + strconv.FormatInt(c.id, 10),
+		"addr=" + c.Addr(),
+		"laddr=" + c.conn.LocalAddr().String(),
+		"name=" + c.name,
+		"age=" + strconv.Itoa(int(now.Sub(c.createdAt).Seconds())),
+		"idle=" + strconv.Itoa(int(now.Sub(c.lastInteraction).Seconds())),
+		"flags=" + c.flagString(),
+		"db=" + strconv.Itoa(c.db),
+		"sub=0",
+		"psub=0",
+		"ssub=0",
+		"multi=" + strconv.Itoa(multi),
+		"watch=0",
+		"qbuf=" + strconv.Itoa(c.qbuf),
+		"qbuf-free=" + strconv.Itoa(c.reader.Size()-c.qbuf),
+		"rbs=" + strconv.Itoa(c.reader.Size()),
+		"obl=" + strconv.Itoa(c.obl),
+		"oll=0",
+		"omem=" + strconv.Itoa(c.obl),
+		"events=r",
+		"cmd=" + c.lastCommand,
+		"user=" + c.user,
+		"redir=-1",
+		"resp=" + strconv.Itoa(c.protocol),
+	}
+	return strings.Join(fields, " ")
+}
+
+// Reports a read error on the connection, protocol errors are sent back before the connection is closed
+func (c *Client) handleReadError(err error) {
+	var protoErr *ProtocolError
+	if errors.As(err, &protoErr) {
+		fmt.Printf("Closing client %s: %s\n", c.Addr(), err)
+		c.conn.Write(newSimpleError("ERR " + protoErr.Error()))
+	} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		fmt.Println(err)
+	}
+}
+
+// Registers a new connection and returns its client, called on the executor
+func (s *RedisServerImpl) AddClient(conn net.Conn) *Client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	s.nextClientID++
+	c := newClient(s.nextClientID, conn)
+	c.authenticated = !s.AuthRequired()
+	s.clients[c.id] = c
+	return c
+}
+
+// Unregisters a client and closes its connection
+func (s *RedisServerImpl) RemoveClient(c *Client) {
+	s.clientsMu.Lock()
+	delete(s.clients, c.id)
+	s.clientsMu.Unlock()
+	c.close()
+}
+
+// Returns the connected clients, ordered by ID
+func (s *RedisServerImpl) Clients() []*Client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// Normalises the TYPE argument of CLIENT LIST and CLIENT KILL, slave is an alias of replica
+func parseClientType(t string) (string, bool) {
+	switch strings.ToLower(t) {
+	case "normal", "master", "replica", "pubsub":
+		return strings.ToLower(t), true
+	case "slave":
+		return "replica", true
+	default:
+		return "", false
+	}
+}
+
+// CLIENT ID
+func (r *ReqHandlerImpl) clientID(req *Request) []byte {
+	return newInteger(int(r.client.id))
+}
+
+// CLIENT INFO
+func (r *ReqHandlerImpl) clientInfo(req *Request) []byte {
+	return r.encoder().VerbatimString("txt", r.client.Info()+"\n")
+}
+
+// CLIENT GETNAME
+func (r *ReqHandlerImpl) clientGetName(req *Request) []byte {
+	if r.client.name == "" {
+		return r.encoder().Null()
+	}
+	return newBulkString(r.client.name)
+}
+
+// CLIENT SETNAME name
+func (r *ReqHandlerImpl) clientSetName(req *Request) []byte {
+	if !isValidClientName(req.args[1]) {
+		return newSimpleError("ERR Client names cannot contain spaces, newlines or special characters.")
+	}
+	r.client.SetName(req.args[1])
+	return newSimpleString("OK")
+}
+
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id [client-id ...]]
+func (r *ReqHandlerImpl) clientList(req *Request) []byte {
+	args := req.args[1:]
+	clientType := ""
+	ids := make(map[int64]bool)
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "TYPE":
+			if i+1 >= len(args) {
+				return newSimpleError("ERR syntax error")
+			}
+			t, ok := parseClientType(args[i+1])
+			if !ok {
+				return newSimpleError(fmt.Sprintf("ERR Unknown client type '%s'", args[i+1]))
+			}
+			clientType = t
+			i++
+		case "ID":
+			if i+1 >= len(args) {
+				return newSimpleError("ERR syntax error")
+			}
+			for i+1 < len(args) {
+				id, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || id <= 0 {
+					return newSimpleError("ERR Invalid client ID")
+				}
+				ids[id] = true
+				i++
+			}
+		default:
+			return newSimpleError("ERR syntax error")
+		}
+	}
+	list := ""
+	for _, c := range r.server.Clients() {
+		if clientType != "" && c.Type() != clientType {
+			continue
+		}
+		if len(ids) > 0 && !ids[c.id] {
+			continue
+		}
+		list += c.Info() + "\n"
+	}
+	return r.encoder().VerbatimString("txt", list)
+}
+
+/*
+CLIENT KILL addr
+CLIENT KILL [ID client-id] [ADDR addr] [LADDR addr] [TYPE type] [USER username] [SKIPME yes|no] ...
+*/
+func (r *ReqHandlerImpl) clientKill(req *Request) []byte {
+	args := req.args[1:]
+	// Old form: CLIENT KILL addr, replies OK or an error
+	if len(args) == 1 {
+		for _, c := range r.server.Clients() {
+			if c.Addr() == args[0] {
+				c.Kill(r.client)
+				return newSimpleString("OK")
+			}
+		}
+		return newSimpleError("ERR No such client")
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return newSimpleError("ERR syntax error")
+	}
+	filters := make([]func(c *Client) bool, 0)
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return newSimpleError("ERR client-id should be greater than 0")
+			}
+			filters = append(filters, func(c *Client) bool { return c.id == id })
+		case "ADDR":
+			filters = append(filters, func(c *Client) bool { return c.Addr() == value })
+		case "LADDR":
+			filters = append(filters, func(c *Client) bool { return c.conn.LocalAddr().String() == value })
+		case "TYPE":
+			t, ok := parseClientType(value)
+			if !ok {
+				return newSimpleError(fmt.Sprintf("ERR Unknown client type '%s'", value))
+			}
+			filters = append(filters, func(c *Client) bool { return c.Type() == t })
+		case "USER":
+			filters = append(filters, func(c *Client) bool { return c.user == value })
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return newSimpleError("ERR syntax error")
+			}
+		default:
+			return newSimpleError("ERR syntax error")
+		}
+	}
+	killed := 0
+	for _, c := range r.server.Clients() {
+		if skipMe && c == r.client {
+			continue
+		}
+		match := true
+		for _, filter := range filters {
+			if !filter(c) {
+				match = false
+				break
+			}
+		}
+		if match {
+			c.Kill(r.client)
+			killed++
+		}
+	}
+	return newInteger(killed)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConn struct {
+	net.Conn
+	reader *RespReader
+}
+
+func dialTestClient(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return &testConn{Conn: conn, reader: NewRespReader(conn)}
+}
+
+func (c *testConn) do(t *testing.T, args ...string) RespValue {
+	c.Write(newBulkArray(args...))
+	v, err := c.reader.ReadValue()
+	if err != nil {
+		t.Fatalf("%v: %s", args, err)
+	}
+	return v
+}
+
+func TestClientCommand(t *testing.T) {
+	_, addr := startTestServer(t, testConfig())
+
+	alice := dialTestClient(t, addr)
+	defer alice.Close()
+	bob := dialTestClient(t, addr)
+	defer bob.Close()
+
+	aliceID := alice.do(t, "CLIENT", "ID").Int()
+	bobID := bob.do(t, "CLIENT", "ID").Int()
+	if aliceID <= 0 || bobID <= aliceID {
+		t.Fatalf("expected increasing IDs, got %d and %d", aliceID, bobID)
+	}
+
+	t.Run("names", func(t *testing.T) {
+		if v := alice.do(t, "CLIENT", "GETNAME"); !v.IsNull() {
+			t.Fatalf("expected no name, got %q", v.Str())
+		}
+		if v := alice.do(t, "CLIENT", "SETNAME", "alice"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+		if v := alice.do(t, "CLIENT", "SETNAME", "with space"); !v.IsError() {
+			t.Fatalf("expected an error, got %q", v.Str())
+		}
+		if v := alice.do(t, "CLIENT", "GETNAME"); v.Str() != "alice" {
+			t.Fatalf("expected alice, got %q", v.Str())
+		}
+	})
+
+	t.Run("info and list", func(t *testing.T) {
+		info := alice.do(t, "CLIENT", "INFO").Str()
+		if !strings.Contains(info, "name=alice") || !strings.Contains(info, "cmd=client|info") {
+			t.Fatalf("unexpected CLIENT INFO: %s", info)
+		}
+		list := bob.do(t, "CLIENT", "LIST").Str()
+		if strings.Count(list, "\n") != 2 || !strings.Contains(list, "name=alice") {
+			t.Fatalf("unexpected CLIENT LIST: %s", list)
+		}
+		list = bob.do(t, "CLIENT", "LIST", "TYPE", "replica").Str()
+		if list != "" {
+			t.Fatalf("expected no replica, got %s", list)
+		}
+		if v := bob.do(t, "CLIENT", "LIST", "TYPE", "unknown"); !v.IsError() {
+			t.Fatalf("expected an error, got %q", v.Str())
+		}
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		if v := bob.do(t, "CLIENT", "KILL", "ID", "999"); v.Int() != 0 {
+			t.Fatalf("expected no client killed, got %d", v.Int())
+		}
+		// Bob is skipped by default
+		if v := bob.do(t, "CLIENT", "KILL", "TYPE", "normal"); v.Int() != 1 {
+			t.Fatalf("expected 1 client killed, got %d", v.Int())
+		}
+		if _, err := alice.reader.ReadValue(); err == nil {
+			t.Fatalf("expected alice to be disconnected")
+		}
+		if v := bob.do(t, "PING"); v.Str() != "PONG" {
+			t.Fatalf("expected PONG, got %q", v.Str())
+		}
+	})
+}
@@ -0,0 +1,459 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Command flags, reported by COMMAND INFO
+const (
+	CMD_WRITE    = 1 << iota // may modify the dataset, propagated to the replicas
+	CMD_READONLY             // only reads the dataset
+	CMD_DENYOOM              // may increase the memory usage
+	CMD_ADMIN                // administrative command
+	CMD_PUBSUB               // pub/sub related command
+	CMD_NOSCRIPT             // not allowed in scripts
+	CMD_LOADING              // allowed while the dataset is loading
+	CMD_STALE                // allowed while a replica has stale data
+	CMD_FAST                 // runs in constant or log time
+	CMD_NOAUTH               // allowed before the client authenticates
+	CMD_NO_MULTI             // not allowed in a transaction
+)
+
+var commandFlagNames = []struct {
+	flag int
+	name string
+}{
+	{CMD_WRITE, "write"}, {CMD_READONLY, "readonly"}, {CMD_DENYOOM, "denyoom"}, {CMD_ADMIN, "admin"},
+	{CMD_PUBSUB, "pubsub"}, {CMD_NOSCRIPT, "noscript"}, {CMD_LOADING, "loading"}, {CMD_STALE, "stale"},
+	{CMD_FAST, "fast"}, {CMD_NOAUTH, "no_auth"}, {CMD_NO_MULTI, "no_multi"},
+}
+
+// Command describes a command of the command table
+type Command struct {
+	name        string // lowercase, command|subcommand for a subcommand
+	arity       int    // number of arguments including the command name, -N means at least N
+	flags       int
+	firstKey    int                          // position of the first key in the request, the command name being 0, 0 if there is no key
+	lastKey     int                          // position of the last key, negative positions count from the end
+	keyStep     int                          // distance between two keys
+	movableKeys func(args []string) []string // finds the keys when their positions depend on the arguments
+	categories  []string                     // ACL categories, without the @
+	summary     string                       // short description, reported by COMMAND DOCS
+	since       string                       // version of Redis the command appeared in
+	group       string                       // group of the command in the Redis documentation
+	proc        func(r *ReqHandlerImpl, req *Request) []byte
+	subcommands map[string]*Command // key is the uppercase subcommand name
+	parent      *Command
+}
+
+// Checks if the command has a flag
+func (c *Command) Is(flag int) bool {
+	return c.flags&flag != 0
+}
+
+// Returns the keys of a request, args excluding the command name
+func (c *Command) getKeys(args []string) []string {
+	if c.movableKeys != nil {
+		return c.movableKeys(args)
+	}
+	if c.firstKey == 0 {
+		return nil
+	}
+	last := c.lastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	keys := make([]string, 0)
+	for i := c.firstKey; i <= last && i <= len(args); i += c.keyStep {
+		keys = append(keys, args[i-1])
+	}
+	return keys
+}
+
+// Checks the number of arguments, args excluding the command name
+func (c *Command) checkArity(args []string) bool {
+	n := len(args) + 1
+	if c.parent != nil {
+		n = len(args) + 2
+	}
+	if c.arity < 0 {
+		return n >= -c.arity
+	}
+	return n == c.arity
+}
+
+// The command table, key is the uppercase command name
+var commandTable map[string]*Command
+
+// The table refers to the handlers that serve COMMAND, which refer to the table: it's filled in init
+func init() {
+	commandTable = make(map[string]*Command)
+	for _, c := range commandList() {
+		commandTable[strings.ToUpper(c.name)] = c
+		for _, sub := range c.subcommands {
+			sub.parent = c
+		}
+	}
+}
+
+func commandList() []*Command {
+	cmd := func(name string, arity, flags int, categories string, group, since, summary string, proc func(r *ReqHandlerImpl, req *Request) []byte) *Command {
+		return &Command{name: name, arity: arity, flags: flags, categories: strings.Fields(categories), group: group, since: since, summary: summary, proc: proc}
+	}
+	withKeys := func(c *Command, first, last, step int) *Command {
+		c.firstKey, c.lastKey, c.keyStep = first, last, step
+		return c
+	}
+	container := func(name string, arity int, categories string, group, since, summary string, subs ...*Command) *Command {
+		c := cmd(name, arity, 0, categories, group, since, summary, nil)
+		c.subcommands = make(map[string]*Command)
+		for _, sub := range subs {
+			sub.name = name + "|" + sub.name
+			c.subcommands[strings.ToUpper(sub.name[len(name)+1:])] = sub
+		}
+		return c
+	}
+	// COMMAND answers by itself, and is also a container for the introspection subcommands
+	command := container("command", -1, "slow connection", "server", "2.8.13", "Returns detailed information about all commands.",
+		cmd("count", 2, CMD_LOADING|CMD_STALE, "slow connection", "server", "2.8.13", "Returns a count of commands.", (*ReqHandlerImpl).commandCount),
+		cmd("list", -2, CMD_LOADING|CMD_STALE, "slow connection", "server", "7.0.0", "Returns a list of command names.", (*ReqHandlerImpl).commandNames),
+		cmd("info", -2, CMD_LOADING|CMD_STALE, "slow connection", "server", "2.8.13", "Returns information about one, multiple or all commands.", (*ReqHandlerImpl).commandInfo),
+		cmd("docs", -2, CMD_LOADING|CMD_STALE, "slow connection", "server", "7.0.0", "Returns documentary information about one, multiple or all commands.", (*ReqHandlerImpl).commandDocs),
+		cmd("getkeys", -3, CMD_LOADING|CMD_STALE, "slow connection", "server", "2.8.13", "Extracts the key names from an arbitrary command.", (*ReqHandlerImpl).commandGetKeys),
+	)
+	command.flags, command.proc = CMD_LOADING|CMD_STALE, (*ReqHandlerImpl).commandAll
+
+	xread := cmd("xread", -4, CMD_READONLY, "read stream slow blocking", "stream", "5.0.0", "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.", (*ReqHandlerImpl).xread)
+	xread.movableKeys = xreadKeys
+
+	lmpop := cmd("lmpop", -4, CMD_WRITE, "write list slow", "list", "7.0.0", "Returns multiple elements from a list after removing them. Deletes the list if the last element was popped.", (*ReqHandlerImpl).lmpop)
+	lmpop.movableKeys = lmpopKeys
+	blmpop := cmd("blmpop", -5, CMD_WRITE|CMD_NOSCRIPT, "write list slow blocking", "list", "7.0.0", "Pops the first element from one of multiple lists. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", (*ReqHandlerImpl).blmpop)
+	blmpop.movableKeys = blmpopKeys
+	sintercard := cmd("sintercard", -3, CMD_READONLY, "read set slow", "set", "7.0.0", "Returns the number of members of the intersect of multiple sets.", (*ReqHandlerImpl).sintercard)
+	sintercard.movableKeys = sintercardKeys
+
+	connection := CMD_NOSCRIPT | CMD_LOADING | CMD_STALE
+	return []*Command{
+		cmd("ping", -1, CMD_FAST, "fast connection", "connection", "1.0.0", "Returns the server's liveliness response.", (*ReqHandlerImpl).ping),
+		cmd("echo", 2, CMD_FAST, "fast connection", "connection", "1.0.0", "Returns the given string.", (*ReqHandlerImpl).echo),
+		cmd("hello", -1, connection|CMD_FAST|CMD_NOAUTH, "fast connection", "connection", "6.0.0", "Handshakes with the Redis server.", (*ReqHandlerImpl).hello),
+		cmd("auth", -2, connection|CMD_FAST|CMD_NOAUTH, "fast connection", "connection", "1.0.0", "Authenticates the connection.", (*ReqHandlerImpl).auth),
+		cmd("quit", -1, connection|CMD_FAST|CMD_NOAUTH, "fast connection", "connection", "1.0.0", "Closes the connection.", (*ReqHandlerImpl).quit),
+		cmd("select", 2, CMD_LOADING|CMD_STALE|CMD_FAST, "fast connection", "connection", "1.0.0", "Changes the selected database.", (*ReqHandlerImpl).selectDB),
+		withKeys(cmd("get", 2, CMD_READONLY|CMD_FAST, "read string fast", "string", "1.0.0", "Returns the string value of a key.", (*ReqHandlerImpl).get), 1, 1, 1),
+		withKeys(cmd("set", -3, CMD_WRITE|CMD_DENYOOM, "write string slow", "string", "1.0.0", "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", (*ReqHandlerImpl).set), 1, 1, 1),
+		withKeys(cmd("incr", 2, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write string fast", "string", "1.0.0", "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.", (*ReqHandlerImpl).incr), 1, 1, 1),
+		withKeys(cmd("del", -2, CMD_WRITE, "keyspace write slow", "generic", "1.0.0", "Deletes one or more keys.", (*ReqHandlerImpl).del), 1, -1, 1),
+		withKeys(cmd("exists", -2, CMD_READONLY|CMD_FAST, "keyspace read fast", "generic", "1.0.0", "Determines whether one or more keys exist.", (*ReqHandlerImpl).exists), 1, -1, 1),
+		withKeys(cmd("copy", -3, CMD_WRITE|CMD_DENYOOM, "keyspace write slow", "generic", "6.2.0", "Copies the value of a key to a new key.", (*ReqHandlerImpl).copy), 1, 2, 1),
+		withKeys(cmd("move", 3, CMD_WRITE|CMD_FAST, "keyspace write fast", "generic", "1.0.0", "Moves a key to another database.", (*ReqHandlerImpl).move), 1, 1, 1),
+		cmd("keys", 2, CMD_READONLY, "keyspace read slow dangerous", "generic", "1.0.0", "Returns all key names that match a pattern.", (*ReqHandlerImpl).keys),
+		withKeys(cmd("type", 2, CMD_READONLY|CMD_FAST, "keyspace read fast", "generic", "1.0.0", "Determines the type of value stored at a key.", (*ReqHandlerImpl).typeCommand), 1, 1, 1),
+		withKeys(cmd("xadd", -5, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write stream fast", "stream", "5.0.0", "Appends a new message to a stream. Creates the key if it doesn't exist.", (*ReqHandlerImpl).xadd), 1, 1, 1),
+		withKeys(cmd("xrange", -4, CMD_READONLY, "read stream slow", "stream", "5.0.0", "Returns the messages from a stream within a range of IDs.", (*ReqHandlerImpl).xrange), 1, 1, 1),
+		xread,
+		withKeys(cmd("lpush", -3, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write list fast", "list", "1.0.0", "Prepends one or more elements to a list. Creates the key if it doesn't exist.", (*ReqHandlerImpl).lpush), 1, 1, 1),
+		withKeys(cmd("rpush", -3, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write list fast", "list", "1.0.0", "Appends one or more elements to a list. Creates the key if it doesn't exist.", (*ReqHandlerImpl).rpush), 1, 1, 1),
+		withKeys(cmd("lpushx", -3, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write list fast", "list", "2.2.0", "Prepends one or more elements to a list only when the list exists.", (*ReqHandlerImpl).lpushx), 1, 1, 1),
+		withKeys(cmd("rpushx", -3, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write list fast", "list", "2.2.0", "Appends an element to a list only when the list exists.", (*ReqHandlerImpl).rpushx), 1, 1, 1),
+		withKeys(cmd("lpop", -2, CMD_WRITE|CMD_FAST, "write list fast", "list", "1.0.0", "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", (*ReqHandlerImpl).lpop), 1, 1, 1),
+		withKeys(cmd("rpop", -2, CMD_WRITE|CMD_FAST, "write list fast", "list", "1.0.0", "Returns and removes the last elements of a list. Deletes the list if the last element was popped.", (*ReqHandlerImpl).rpop), 1, 1, 1),
+		withKeys(cmd("llen", 2, CMD_READONLY|CMD_FAST, "read list fast", "list", "1.0.0", "Returns the length of a list.", (*ReqHandlerImpl).llen), 1, 1, 1),
+		withKeys(cmd("lrange", 4, CMD_READONLY, "read list slow", "list", "1.0.0", "Returns a range of elements from a list.", (*ReqHandlerImpl).lrange), 1, 1, 1),
+		withKeys(cmd("lindex", 3, CMD_READONLY, "read list slow", "list", "1.0.0", "Returns an element from a list by its index.", (*ReqHandlerImpl).lindex), 1, 1, 1),
+		withKeys(cmd("lset", 4, CMD_WRITE|CMD_DENYOOM, "write list slow", "list", "1.0.0", "Sets the value of an element in a list by its index.", (*ReqHandlerImpl).lset), 1, 1, 1),
+		withKeys(cmd("lrem", 4, CMD_WRITE, "write list slow", "list", "1.0.0", "Removes elements from a list. Deletes the list if the last element was removed.", (*ReqHandlerImpl).lrem), 1, 1, 1),
+		withKeys(cmd("ltrim", 4, CMD_WRITE, "write list slow", "list", "1.0.0", "Removes elements from both ends a list. Deletes the list if all elements were trimmed.", (*ReqHandlerImpl).ltrim), 1, 1, 1),
+		withKeys(cmd("linsert", 5, CMD_WRITE|CMD_DENYOOM, "write list slow", "list", "2.2.0", "Inserts an element before or after another element in a list.", (*ReqHandlerImpl).linsert), 1, 1, 1),
+		withKeys(cmd("lpos", -3, CMD_READONLY, "read list slow", "list", "6.0.6", "Returns the index of matching elements in a list.", (*ReqHandlerImpl).lpos), 1, 1, 1),
+		withKeys(cmd("lmove", 5, CMD_WRITE|CMD_DENYOOM, "write list slow", "list", "6.2.0", "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.", (*ReqHandlerImpl).lmove), 1, 2, 1),
+		withKeys(cmd("blpop", -3, CMD_WRITE|CMD_NOSCRIPT, "write list slow blocking", "list", "2.0.0", "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", (*ReqHandlerImpl).blpop), 1, -2, 1),
+		withKeys(cmd("brpop", -3, CMD_WRITE|CMD_NOSCRIPT, "write list slow blocking", "list", "2.0.0", "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.", (*ReqHandlerImpl).brpop), 1, -2, 1),
+		withKeys(cmd("blmove", 6, CMD_WRITE|CMD_DENYOOM|CMD_NOSCRIPT, "write list slow blocking", "list", "6.2.0", "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.", (*ReqHandlerImpl).blmove), 1, 2, 1),
+		withKeys(cmd("brpoplpush", 4, CMD_WRITE|CMD_DENYOOM|CMD_NOSCRIPT, "write list slow blocking", "list", "2.2.0", "Pops an element from a list, pushes it to another list and returns it. Block until an element is available otherwise. Deletes the list if the last element was popped.", (*ReqHandlerImpl).brpoplpush), 1, 2, 1),
+		lmpop,
+		blmpop,
+		withKeys(cmd("rpoplpush", 3, CMD_WRITE|CMD_DENYOOM, "write list slow", "list", "1.2.0", "Returns the last element of a list after removing and pushing it to another list. Deletes the list if the last element was popped.", (*ReqHandlerImpl).rpoplpush), 1, 2, 1),
+		withKeys(cmd("hset", -4, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write hash fast", "hash", "2.0.0", "Creates or modifies the value of a field in a hash.", (*ReqHandlerImpl).hset), 1, 1, 1),
+		withKeys(cmd("hsetnx", 4, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write hash fast", "hash", "2.0.0", "Sets the value of a field in a hash only when the field doesn't exist.", (*ReqHandlerImpl).hsetnx), 1, 1, 1),
+		withKeys(cmd("hmset", -4, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write hash fast", "hash", "2.0.0", "Sets the values of multiple fields.", (*ReqHandlerImpl).hmset), 1, 1, 1),
+		withKeys(cmd("hget", 3, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "2.0.0", "Returns the value of a field in a hash.", (*ReqHandlerImpl).hget), 1, 1, 1),
+		withKeys(cmd("hmget", -3, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "2.0.0", "Returns the values of all fields in a hash.", (*ReqHandlerImpl).hmget), 1, 1, 1),
+		withKeys(cmd("hdel", -3, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "2.0.0", "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.", (*ReqHandlerImpl).hdel), 1, 1, 1),
+		withKeys(cmd("hgetall", 2, CMD_READONLY, "read hash slow", "hash", "2.0.0", "Returns all fields and values in a hash.", (*ReqHandlerImpl).hgetall), 1, 1, 1),
+		withKeys(cmd("hincrby", 4, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write hash fast", "hash", "2.0.0", "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.", (*ReqHandlerImpl).hincrby), 1, 1, 1),
+		withKeys(cmd("hincrbyfloat", 4, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write hash fast", "hash", "2.6.0", "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.", (*ReqHandlerImpl).hincrbyfloat), 1, 1, 1),
+		withKeys(cmd("hexists", 3, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "2.0.0", "Determines whether a field exists in a hash.", (*ReqHandlerImpl).hexists), 1, 1, 1),
+		withKeys(cmd("hlen", 2, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "2.0.0", "Returns the number of fields in a hash.", (*ReqHandlerImpl).hlen), 1, 1, 1),
+		withKeys(cmd("hkeys", 2, CMD_READONLY, "read hash slow", "hash", "2.0.0", "Returns all fields in a hash.", (*ReqHandlerImpl).hkeys), 1, 1, 1),
+		withKeys(cmd("hvals", 2, CMD_READONLY, "read hash slow", "hash", "2.0.0", "Returns all values in a hash.", (*ReqHandlerImpl).hvals), 1, 1, 1),
+		withKeys(cmd("hstrlen", 3, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "3.2.0", "Returns the length of the value of a field.", (*ReqHandlerImpl).hstrlen), 1, 1, 1),
+		withKeys(cmd("hrandfield", -2, CMD_READONLY, "read hash slow", "hash", "6.2.0", "Returns one or more random fields from a hash.", (*ReqHandlerImpl).hrandfield), 1, 1, 1),
+		withKeys(cmd("hexpire", -6, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "7.4.0", "Set expiry for hash field using relative time to expire (seconds).", (*ReqHandlerImpl).hexpire), 1, 1, 1),
+		withKeys(cmd("hpexpire", -6, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "7.4.0", "Set expiry for hash field using relative time to expire (milliseconds).", (*ReqHandlerImpl).hpexpire), 1, 1, 1),
+		withKeys(cmd("hexpireat", -6, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "7.4.0", "Set expiry for hash field using an absolute Unix timestamp (seconds).", (*ReqHandlerImpl).hexpireat), 1, 1, 1),
+		withKeys(cmd("hpexpireat", -6, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "7.4.0", "Set expiry for hash field using an absolute Unix timestamp (milliseconds).", (*ReqHandlerImpl).hpexpireat), 1, 1, 1),
+		withKeys(cmd("httl", -5, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "7.4.0", "Returns the TTL in seconds of a hash field.", (*ReqHandlerImpl).httl), 1, 1, 1),
+		withKeys(cmd("hpttl", -5, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "7.4.0", "Returns the TTL in milliseconds of a hash field.", (*ReqHandlerImpl).hpttl), 1, 1, 1),
+		withKeys(cmd("hexpiretime", -5, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "7.4.0", "Returns the expiration time of a hash field as a Unix timestamp, in seconds.", (*ReqHandlerImpl).hexpiretime), 1, 1, 1),
+		withKeys(cmd("hpexpiretime", -5, CMD_READONLY|CMD_FAST, "read hash fast", "hash", "7.4.0", "Returns the expiration time of a hash field as a Unix timestamp, in msec.", (*ReqHandlerImpl).hpexpiretime), 1, 1, 1),
+		withKeys(cmd("hpersist", -5, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "7.4.0", "Removes the expiration time for each specified field.", (*ReqHandlerImpl).hpersist), 1, 1, 1),
+		withKeys(cmd("hgetex", -5, CMD_WRITE|CMD_FAST, "write hash fast", "hash", "8.0.0", "Get the value of one or more fields of a given hash key, and optionally set their expiration.", (*ReqHandlerImpl).hgetex), 1, 1, 1),
+		withKeys(cmd("sadd", -3, CMD_WRITE|CMD_DENYOOM|CMD_FAST, "write set fast", "set", "1.0.0", "Adds one or more members to a set. Creates the key if it doesn't exist.", (*ReqHandlerImpl).sadd), 1, 1, 1),
+		withKeys(cmd("srem", -3, CMD_WRITE|CMD_FAST, "write set fast", "set", "1.0.0", "Removes one or more members from a set. Deletes the set if the last member was removed.", (*ReqHandlerImpl).srem), 1, 1, 1),
+		withKeys(cmd("smembers", 2, CMD_READONLY, "read set slow", "set", "1.0.0", "Returns all members of a set.", (*ReqHandlerImpl).smembers), 1, 1, 1),
+		withKeys(cmd("sismember", 3, CMD_READONLY|CMD_FAST, "read set fast", "set", "1.0.0", "Determines whether a member belongs to a set.", (*ReqHandlerImpl).sismember), 1, 1, 1),
+		withKeys(cmd("smismember", -3, CMD_READONLY|CMD_FAST, "read set fast", "set", "6.2.0", "Determines whether multiple members belong to a set.", (*ReqHandlerImpl).smismember), 1, 1, 1),
+		withKeys(cmd("scard", 2, CMD_READONLY|CMD_FAST, "read set fast", "set", "1.0.0", "Returns the number of members in a set.", (*ReqHandlerImpl).scard), 1, 1, 1),
+		withKeys(cmd("spop", -2, CMD_WRITE|CMD_FAST, "write set fast", "set", "1.0.0", "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.", (*ReqHandlerImpl).spop), 1, 1, 1),
+		withKeys(cmd("srandmember", -2, CMD_READONLY, "read set slow", "set", "1.0.0", "Get one or multiple random members from a set.", (*ReqHandlerImpl).srandmember), 1, 1, 1),
+		withKeys(cmd("sinter", -2, CMD_READONLY, "read set slow", "set", "1.0.0", "Returns the intersect of multiple sets.", (*ReqHandlerImpl).sinter), 1, -1, 1),
+		withKeys(cmd("sunion", -2, CMD_READONLY, "read set slow", "set", "1.0.0", "Returns the union of multiple sets.", (*ReqHandlerImpl).sunion), 1, -1, 1),
+		withKeys(cmd("sdiff", -2, CMD_READONLY, "read set slow", "set", "1.0.0", "Returns the difference of multiple sets.", (*ReqHandlerImpl).sdiff), 1, -1, 1),
+		withKeys(cmd("sinterstore", -3, CMD_WRITE|CMD_DENYOOM, "write set slow", "set", "1.0.0", "Stores the intersect of multiple sets in a key.", (*ReqHandlerImpl).sinterstore), 1, -1, 1),
+		withKeys(cmd("sunionstore", -3, CMD_WRITE|CMD_DENYOOM, "write set slow", "set", "1.0.0", "Stores the union of multiple sets in a key.", (*ReqHandlerImpl).sunionstore), 1, -1, 1),
+		withKeys(cmd("sdiffstore", -3, CMD_WRITE|CMD_DENYOOM, "write set slow", "set", "1.0.0", "Stores the difference of multiple sets in a key.", (*ReqHandlerImpl).sdiffstore), 1, -1, 1),
+		sintercard,
+		withKeys(cmd("smove", 4, CMD_WRITE|CMD_FAST, "write set fast", "set", "1.0.0", "Moves a member from one set to another.", (*ReqHandlerImpl).smove), 1, 2, 1),
+		cmd("multi", 1, connection|CMD_FAST, "fast transaction", "transactions", "1.2.0", "Starts a transaction.", (*ReqHandlerImpl).multi),
+		cmd("exec", 1, connection, "slow transaction", "transactions", "1.2.0", "Executes all commands in a transaction.", (*ReqHandlerImpl).exec),
+		cmd("discard", 1, connection|CMD_FAST, "fast transaction", "transactions", "2.0.0", "Discards a transaction.", (*ReqHandlerImpl).discard),
+		cmd("wait", 3, CMD_NOSCRIPT, "slow connection", "generic", "3.0.0", "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.", (*ReqHandlerImpl).wait),
+		cmd("info", -1, CMD_LOADING|CMD_STALE, "slow dangerous", "server", "1.0.0", "Returns information and statistics about the server.", (*ReqHandlerImpl).info),
+		cmd("dbsize", 1, CMD_READONLY|CMD_FAST, "keyspace read fast", "server", "1.0.0", "Returns the number of keys in the database.", (*ReqHandlerImpl).dbSize),
+		cmd("swapdb", 3, CMD_WRITE|CMD_FAST, "keyspace write fast dangerous", "server", "4.0.0", "Swaps two Redis databases.", (*ReqHandlerImpl).swapDB),
+		cmd("flushdb", -1, CMD_WRITE, "keyspace write slow dangerous", "server", "1.0.0", "Removes all keys from the current database.", (*ReqHandlerImpl).flushDB),
+		cmd("flushall", -1, CMD_WRITE, "keyspace write slow dangerous", "server", "1.0.0", "Removes all keys from all databases.", (*ReqHandlerImpl).flushAll),
+		cmd("replconf", -1, connection|CMD_ADMIN, "admin slow dangerous", "server", "3.0.0", "An internal command for configuring the replication stream.", (*ReqHandlerImpl).replicationConfig),
+		cmd("shutdown", -1, CMD_ADMIN|CMD_NOSCRIPT|CMD_LOADING|CMD_STALE|CMD_NO_MULTI, "admin slow dangerous", "server", "1.0.0", "Synchronously saves the database(s) to disk and shuts down the Redis server.", (*ReqHandlerImpl).shutdown),
+		cmd("psync", -3, CMD_NOSCRIPT|CMD_ADMIN, "admin slow dangerous", "server", "2.8.0", "An internal command used in replication.", (*ReqHandlerImpl).psync),
+		container("config", -2, "slow", "server", "2.0.0", "A container for server configuration commands.",
+			cmd("get", -3, connection|CMD_ADMIN, "admin slow dangerous", "server", "2.0.0", "Returns the effective values of configuration parameters.", (*ReqHandlerImpl).configGet),
+		),
+		container("client", -2, "slow", "connection", "2.4.0", "A container for client connection commands.",
+			cmd("id", 2, connection, "slow connection", "connection", "5.0.0", "Returns the unique client ID of the connection.", (*ReqHandlerImpl).clientID),
+			cmd("info", 2, connection, "slow connection", "connection", "6.2.0", "Returns information about the connection.", (*ReqHandlerImpl).clientInfo),
+			cmd("getname", 2, connection, "slow connection", "connection", "2.6.9", "Returns the name of the connection.", (*ReqHandlerImpl).clientGetName),
+			cmd("setname", 3, connection, "slow connection", "connection", "2.6.9", "Sets the connection name.", (*ReqHandlerImpl).clientSetName),
+			cmd("list", -2, connection|CMD_ADMIN, "admin slow dangerous connection", "connection", "2.4.0", "Lists open connections.", (*ReqHandlerImpl).clientList),
+			cmd("kill", -3, connection|CMD_ADMIN, "admin slow dangerous connection", "connection", "2.4.0", "Terminates open connections.", (*ReqHandlerImpl).clientKill),
+		),
+		container("acl", -2, "slow", "server", "6.0.0", "A container for Access List Control commands.",
+			cmd("setuser", -3, connection|CMD_ADMIN, "admin slow dangerous", "server", "6.0.0", "Creates and modifies an ACL user and its rules.", (*ReqHandlerImpl).aclSetUser),
+			cmd("getuser", 3, connection|CMD_ADMIN, "admin slow dangerous", "server", "6.0.0", "Lists the ACL rules of a user.", (*ReqHandlerImpl).aclGetUser),
+			cmd("deluser", -3, connection|CMD_ADMIN, "admin slow dangerous", "server", "6.0.0", "Deletes ACL users, and terminates their connections.", (*ReqHandlerImpl).aclDelUser),
+			cmd("list", 2, connection|CMD_ADMIN, "admin slow dangerous", "server", "6.0.0", "Dumps the effective rules in ACL file format.", (*ReqHandlerImpl).aclList),
+			cmd("users", 2, connection|CMD_ADMIN, "admin slow dangerous", "server", "6.0.0", "Lists all ACL users.", (*ReqHandlerImpl).aclUsers),
+			cmd("whoami", 2, connection, "slow", "server", "6.0.0", "Returns the authenticated username of the current connection.", (*ReqHandlerImpl).aclWhoami),
+			cmd("cat", -2, connection, "slow", "server", "6.0.0", "Lists the ACL categories, or the commands inside a category.", (*ReqHandlerImpl).aclCat),
+			cmd("save", 2, connection|CMD_ADMIN, "admin slow dangerous", "server", "6.0.0", "Saves the effective ACL rules in the configured ACL file.", (*ReqHandlerImpl).aclSave),
+		),
+		command,
+	}
+}
+
+/*
+Finds the command of a request, the subcommand for container commands.
+Returns the error reply if the command or subcommand is unknown, or if the number of arguments is wrong.
+*/
+func lookupCommand(req *Request) (*Command, []byte) {
+	cmd, ok := commandTable[req.command]
+	if !ok {
+		quoted := make([]string, 0, len(req.args))
+		for _, arg := range req.args {
+			quoted = append(quoted, "'"+arg+"' ")
+		}
+		return nil, newSimpleError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", strings.ToLower(req.command), strings.Join(quoted, "")))
+	}
+	// COMMAND runs by itself when it has no argument
+	if cmd.subcommands != nil && (cmd.proc == nil || len(req.args) > 0) {
+		if len(req.args) == 0 {
+			return nil, newSimpleError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.name))
+		}
+		sub, ok := cmd.subcommands[strings.ToUpper(req.args[0])]
+		if !ok {
+			return nil, newSimpleError(fmt.Sprintf("ERR unknown subcommand '%s'. Try %s HELP.", req.args[0], strings.ToUpper(cmd.name)))
+		}
+		cmd = sub
+		if !cmd.checkArity(req.args[1:]) {
+			return nil, newSimpleError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.name))
+		}
+		return cmd, nil
+	}
+	if !cmd.checkArity(req.args) {
+		return nil, newSimpleError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.name))
+	}
+	return cmd, nil
+}
+
+// Returns the arguments of a request for its command, the subcommand name being dropped
+func commandArgs(cmd *Command, req *Request) []string {
+	if cmd.parent != nil {
+		return req.args[1:]
+	}
+	return req.args
+}
+
+// Returns the commands of the table, ordered by name
+func sortedCommands() []*Command {
+	commands := make([]*Command, 0, len(commandTable))
+	for _, c := range commandTable {
+		commands = append(commands, c)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].name < commands[j].name })
+	return commands
+}
+
+func (c *Command) sortedSubcommands() []*Command {
+	subs := make([]*Command, 0, len(c.subcommands))
+	for _, s := range c.subcommands {
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].name < subs[j].name })
+	return subs
+}
+
+// Finds a command by name, command|subcommand for a subcommand
+func findCommand(name string) *Command {
+	parent, sub, isSub := strings.Cut(strings.ToUpper(name), "|")
+	cmd, ok := commandTable[parent]
+	if !ok || !isSub {
+		return cmd
+	}
+	return cmd.subcommands[sub]
+}
+
+/*
+Encodes the description of a command, as COMMAND INFO does:
+
+	name, arity, flags, first key, last key, key step, ACL categories, tips, key specifications, subcommands
+*/
+func (c *Command) encodeInfo() []byte {
+	flags := make([]string, 0)
+	for _, f := range commandFlagNames {
+		if c.Is(f.flag) {
+			flags = append(flags, string(newSimpleString(f.name)))
+		}
+	}
+	if c.movableKeys != nil {
+		flags = append(flags, string(newSimpleString("movablekeys")))
+	}
+	categories := make([]string, 0, len(c.categories))
+	for _, category := range c.categories {
+		categories = append(categories, string(newSimpleString("@"+category)))
+	}
+	subs := make([]string, 0)
+	for _, s := range c.sortedSubcommands() {
+		subs = append(subs, string(s.encodeInfo()))
+	}
+	return newBulkArrayOfArrays(
+		string(newBulkString(c.name)),
+		string(newInteger(c.arity)),
+		string(newBulkArrayOfArrays(flags...)),
+		string(newInteger(c.firstKey)),
+		string(newInteger(c.lastKey)),
+		string(newInteger(c.keyStep)),
+		string(newBulkArrayOfArrays(categories...)),
+		string(newBulkArray()),
+		string(newBulkArray()),
+		string(newBulkArrayOfArrays(subs...)),
+	)
+}
+
+// Encodes the documentation of a command, as a map, the way COMMAND DOCS does
+func (c *Command) encodeDocs(enc *RespEncoder) []byte {
+	fields := []string{
+		string(newBulkString("summary")), string(newBulkString(c.summary)),
+		string(newBulkString("since")), string(newBulkString(c.since)),
+		string(newBulkString("group")), string(newBulkString(c.group)),
+	}
+	if len(c.subcommands) > 0 {
+		subs := make([]string, 0)
+		for _, s := range c.sortedSubcommands() {
+			subs = append(subs, string(newBulkString(s.name)), string(s.encodeDocs(enc)))
+		}
+		fields = append(fields, string(newBulkString("subcommands")), string(enc.Map(subs...)))
+	}
+	return enc.Map(fields...)
+}
+
+// COMMAND
+func (r *ReqHandlerImpl) commandAll(req *Request) []byte {
+	infos := make([]string, 0, len(commandTable))
+	for _, c := range sortedCommands() {
+		infos = append(infos, string(c.encodeInfo()))
+	}
+	return newBulkArrayOfArrays(infos...)
+}
+
+// COMMAND COUNT
+func (r *ReqHandlerImpl) commandCount(req *Request) []byte {
+	return newInteger(len(commandTable))
+}
+
+// COMMAND LIST
+func (r *ReqHandlerImpl) commandNames(req *Request) []byte {
+	if len(req.args) > 1 {
+		return newSimpleError("ERR syntax error")
+	}
+	names := make([]string, 0, len(commandTable))
+	for _, c := range sortedCommands() {
+		names = append(names, c.name)
+	}
+	return newBulkArray(names...)
+}
+
+// COMMAND INFO [command-name [command-name ...]]
+func (r *ReqHandlerImpl) commandInfo(req *Request) []byte {
+	if len(req.args) == 1 {
+		return r.commandAll(req)
+	}
+	infos := make([]string, 0, len(req.args)-1)
+	for _, name := range req.args[1:] {
+		if c := findCommand(name); c != nil {
+			infos = append(infos, string(c.encodeInfo()))
+		} else {
+			infos = append(infos, string(r.encoder().NullArray()))
+		}
+	}
+	return newBulkArrayOfArrays(infos...)
+}
+
+// COMMAND DOCS [command-name [command-name ...]], unknown commands are left out
+func (r *ReqHandlerImpl) commandDocs(req *Request) []byte {
+	enc := r.encoder()
+	commands := make([]*Command, 0)
+	if len(req.args) == 1 {
+		commands = sortedCommands()
+	}
+	for _, name := range req.args[1:] {
+		if c := findCommand(name); c != nil {
+			commands = append(commands, c)
+		}
+	}
+	docs := make([]string, 0, len(commands)*2)
+	for _, c := range commands {
+		docs = append(docs, string(newBulkString(c.name)), string(c.encodeDocs(enc)))
+	}
+	return enc.Map(docs...)
+}
+
+// COMMAND GETKEYS command [arg [arg ...]]
+func (r *ReqHandlerImpl) commandGetKeys(req *Request) []byte {
+	target := NewRequest(strings.ToUpper(req.args[1]), req.args[2:]...)
+	cmd, errReply := lookupCommand(target)
+	if errReply != nil {
+		if _, ok := commandTable[target.command]; !ok {
+			return newSimpleError("ERR Invalid command specified")
+		}
+		return newSimpleError("ERR Invalid number of arguments specified for command")
+	}
+	keys := cmd.getKeys(commandArgs(cmd, target))
+	if len(keys) == 0 {
+		return newSimpleError("ERR The command has no key arguments")
+	}
+	return newBulkArray(keys...)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		name    string
+		err     string
+	}{
+		{"GET", []string{"a"}, "get", ""},
+		{"GET", []string{}, "", "ERR wrong number of arguments for 'get' command"},
+		{"SET", []string{"a"}, "", "ERR wrong number of arguments for 'set' command"},
+		{"NOPE", []string{"a", "b"}, "", "ERR unknown command 'nope', with args beginning with: 'a' 'b' "},
+		{"CLIENT", []string{"setname", "x"}, "client|setname", ""},
+		{"CLIENT", []string{"SETNAME"}, "", "ERR wrong number of arguments for 'client|setname' command"},
+		{"CLIENT", []string{"nope"}, "", "ERR unknown subcommand 'nope'. Try CLIENT HELP."},
+		{"CLIENT", []string{}, "", "ERR wrong number of arguments for 'client' command"},
+		{"COMMAND", []string{}, "command", ""},
+		{"COMMAND", []string{"count"}, "command|count", ""},
+	}
+	for _, test := range tests {
+		cmd, errReply := lookupCommand(NewRequest(test.command, test.args...))
+		if test.err != "" {
+			if string(errReply) != string(newSimpleError(test.err)) {
+				t.Errorf("%s %v: expected %q, got %q", test.command, test.args, test.err, errReply)
+			}
+			continue
+		}
+		if errReply != nil || cmd.name != test.name {
+			t.Errorf("%s %v: expected %s, got %v %q", test.command, test.args, test.name, cmd, errReply)
+		}
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		keys    []string
+	}{
+		{"GET", []string{"a"}, []string{"a"}},
+		{"DEL", []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"COPY", []string{"a", "b", "REPLACE"}, []string{"a", "b"}},
+		{"XREAD", []string{"BLOCK", "0", "STREAMS", "s1", "s2", "0", "$"}, []string{"s1", "s2"}},
+		{"PING", []string{}, nil},
+	}
+	for _, test := range tests {
+		keys := findCommand(test.command).getKeys(test.args)
+		if strings.Join(keys, ",") != strings.Join(test.keys, ",") {
+			t.Errorf("%s %v: expected keys %v, got %v", test.command, test.args, test.keys, keys)
+		}
+	}
+}
+
+func TestCommandCommand(t *testing.T) {
+	_, addr := startTestServer(t, testConfig())
+
+	c := dialTestClient(t, addr)
+	defer c.Close()
+
+	if v := c.do(t, "COMMAND", "COUNT"); v.Int() != int64(len(commandTable)) {
+		t.Fatalf("expected %d, got %d", len(commandTable), v.Int())
+	}
+	if v := c.do(t, "COMMAND"); len(v.Array()) != len(commandTable) {
+		t.Fatalf("expected %d commands, got %d", len(commandTable), len(v.Array()))
+	}
+
+	t.Run("info", func(t *testing.T) {
+		v := c.do(t, "COMMAND", "INFO", "set", "nope")
+		infos := v.Array()
+		if len(infos) != 2 || !infos[1].IsNull() {
+			t.Fatalf("expected the info of set and a null, got %v", infos)
+		}
+		set := infos[0].Array()
+		if set[0].Str() != "set" || set[1].Int() != -3 || set[3].Int() != 1 || set[4].Int() != 1 || set[5].Int() != 1 {
+			t.Fatalf("unexpected info for set: %v", set)
+		}
+		if flags := set[2].Array(); len(flags) != 2 || flags[0].Str() != "write" || flags[1].Str() != "denyoom" {
+			t.Fatalf("unexpected flags for set: %v", flags)
+		}
+		client := c.do(t, "COMMAND", "INFO", "client").Array()[0].Array()
+		if len(client[9].Array()) != len(commandTable["CLIENT"].subcommands) {
+			t.Fatalf("expected the subcommands of client, got %v", client[9].Array())
+		}
+	})
+
+	t.Run("getkeys", func(t *testing.T) {
+		if v := c.do(t, "COMMAND", "GETKEYS", "MSET", "a"); v.Str() != "ERR Invalid command specified" {
+			t.Fatalf("unexpected reply %q", v.Str())
+		}
+		if v := c.do(t, "COMMAND", "GETKEYS", "GET"); v.Str() != "ERR Invalid number of arguments specified for command" {
+			t.Fatalf("unexpected reply %q", v.Str())
+		}
+		keys := c.do(t, "COMMAND", "GETKEYS", "XREAD", "STREAMS", "s1", "s2", "0", "0").Array()
+		if len(keys) != 2 || keys[0].Str() != "s1" || keys[1].Str() != "s2" {
+			t.Fatalf("unexpected keys %v", keys)
+		}
+	})
+
+	t.Run("docs and list", func(t *testing.T) {
+		docs := c.do(t, "COMMAND", "DOCS", "get").Array()
+		if len(docs) != 2 || docs[0].Str() != "get" {
+			t.Fatalf("unexpected docs %v", docs)
+		}
+		names := c.do(t, "COMMAND", "LIST").Array()
+		if len(names) != len(commandTable) {
+			t.Fatalf("expected %d names, got %d", len(commandTable), len(names))
+		}
+	})
+
+	t.Run("arity is checked before running", func(t *testing.T) {
+		if v := c.do(t, "ECHO"); v.Str() != "ERR wrong number of arguments for 'echo' command" {
+			t.Fatalf("unexpected reply %q", v.Str())
+		}
+		if v := c.do(t, "PING"); v.Str() != "PONG" {
+			t.Fatalf("expected PONG, got %q", v.Str())
+		}
+	})
+}
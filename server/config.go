@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Config holds the options of a server, start from DefaultConfig to get the defaults of Redis
+type Config struct {
+	Port            int         // TCP port, 0 picks a free port reported by Start, -1 disables TCP (--port 0)
+	Bind            []string    // addresses to listen on, a - prefix makes an address optional, 127.0.0.1 if empty
+	ProtectedMode   bool        // only local clients are accepted unless a password is set
+	UnixSocket      string      // path of the unix socket to listen on, none if empty
+	UnixSocketPerm  fs.FileMode // permissions of the unix socket file
+	Dir             string      // directory of the RDB file
+	DBFilename      string      // RDB file loaded on start and saved on shutdown, none if empty
+	PidFile         string      // file the process ID is written to, none if empty
+	ReplicaOf       string      // host:port of the master, the server is a replica if set
+	ReplicaReadOnly bool        // a replica refuses the write commands of its clients
+	MasterAuth      string      // password sent to the master before the handshake
+	MasterUser      string      // user authenticated with MasterAuth, the default user if empty
+	RequirePass     string      // password of the default user, none if empty
+	ACLFile         string      // file the ACL users are loaded from and saved to
+	TLSPort         int         // TLS port, 0 disables TLS
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSCACertFile   string
+	TLSAuthClients  string // yes, no or optional, yes if empty
+	TLSReplication  bool   // a replica connects to its master with TLS
+	Hz              int    // frequency of the active expire cycle, CONFIG_DEFAULT_HZ if 0, at most CONFIG_MAX_HZ
+	Databases       int    // number of logical databases, CONFIG_DEFAULT_DBNUM if 0
+}
+
+// Returns the configuration a server started without options uses
+func DefaultConfig() Config {
+	port, _ := strconv.Atoi(SERVER_PORT)
+	return Config{Port: port, ProtectedMode: true, ReplicaReadOnly: true, TLSAuthClients: "yes", Hz: CONFIG_DEFAULT_HZ, Databases: CONFIG_DEFAULT_DBNUM}
+}
+
+/*
+Builds the configuration from the command line options parsed by utils.ParseOsArgs,
+the options missing from args keep their default value.
+As in Redis, --port 0 disables the TCP listeners.
+*/
+func ConfigFromArgs(args map[string]string) (Config, error) {
+	cfg := DefaultConfig()
+	var err error
+	if port, ok := args["--port"]; ok {
+		if cfg.Port, err = strconv.Atoi(port); err != nil {
+			return cfg, fmt.Errorf("invalid port: %s", port)
+		}
+		if cfg.Port == 0 {
+			cfg.Port = -1
+		}
+	}
+	if port, ok := args["--tls-port"]; ok {
+		if cfg.TLSPort, err = strconv.Atoi(port); err != nil {
+			return cfg, fmt.Errorf("invalid TLS port: %s", port)
+		}
+	}
+	if hz, ok := args["--hz"]; ok {
+		if cfg.Hz, err = strconv.Atoi(hz); err != nil {
+			return cfg, fmt.Errorf("invalid hz: %s", hz)
+		}
+	}
+	if databases, ok := args["--databases"]; ok {
+		if cfg.Databases, err = strconv.Atoi(databases); err != nil || cfg.Databases < 1 {
+			return cfg, fmt.Errorf("invalid number of databases: %s", databases)
+		}
+	}
+	if perm, ok := args["--unixsocketperm"]; ok {
+		mode, err := strconv.ParseUint(perm, 8, 32)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid unix socket permissions: %s", perm)
+		}
+		cfg.UnixSocketPerm = fs.FileMode(mode)
+	}
+	if bind, ok := args["--bind"]; ok {
+		cfg.Bind = strings.Fields(bind)
+	}
+	if authClients, ok := args["--tls-auth-clients"]; ok {
+		cfg.TLSAuthClients = authClients
+	}
+	cfg.ProtectedMode = args["--protected-mode"] != "no"
+	cfg.ReplicaReadOnly = args["--replica-read-only"] != "no"
+	cfg.TLSReplication = args["--tls-replication"] == "yes"
+	cfg.UnixSocket = args["--unixsocket"]
+	cfg.Dir = args["--dir"]
+	cfg.DBFilename = args["--dbfilename"]
+	cfg.PidFile = args["--pidfile"]
+	cfg.ReplicaOf = args["--replicaof"]
+	cfg.MasterAuth = args["--masterauth"]
+	cfg.MasterUser = args["--masteruser"]
+	cfg.RequirePass = args["--requirepass"]
+	cfg.ACLFile = args["--aclfile"]
+	cfg.TLSCertFile = args["--tls-cert-file"]
+	cfg.TLSKeyFile = args["--tls-key-file"]
+	cfg.TLSCACertFile = args["--tls-ca-cert-file"]
+	return cfg, nil
+}
+
+// Checks the options that can't be used as given
+func (c Config) validate() error {
+	if c.Port < -1 || c.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", c.Port)
+	}
+	if c.TLSPort < 0 || c.TLSPort > 65535 {
+		return fmt.Errorf("invalid TLS port: %d", c.TLSPort)
+	}
+	if c.Hz < 0 {
+		return fmt.Errorf("invalid hz: %d", c.Hz)
+	}
+	if c.Databases < 0 {
+		return fmt.Errorf("invalid number of databases: %d", c.Databases)
+	}
+	switch c.TLSAuthClients {
+	case "", "yes", "no", "optional":
+	default:
+		return fmt.Errorf("invalid value for tls-auth-clients: %s", c.TLSAuthClients)
+	}
+	if c.ReplicaOf != "" && !strings.Contains(c.ReplicaOf, ":") {
+		return fmt.Errorf("invalid master address, expected host:port: %s", c.ReplicaOf)
+	}
+	return nil
+}
+
+// Returns the frequency of the active expire cycle, as in Redis a value above the maximum is lowered to it
+func (c Config) hz() int {
+	if c.Hz == 0 {
+		return CONFIG_DEFAULT_HZ
+	}
+	return min(c.Hz, CONFIG_MAX_HZ)
+}
+
+func (c Config) databases() int {
+	if c.Databases == 0 {
+		return CONFIG_DEFAULT_DBNUM
+	}
+	return c.Databases
+}
+
+// Returns the port the TCP listeners use, "" when TCP is disabled
+func (c Config) tcpPort() string {
+	if c.Port < 0 {
+		return ""
+	}
+	return strconv.Itoa(c.Port)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Number of logical databases a server has unless configured otherwise
+const CONFIG_DEFAULT_DBNUM = 16
+
+/*
+Selects the database the cache methods work on. Every command runs against the database its client selected,
+the executor selects it before running the command, the way Redis points the client to its database.
+*/
+func (s *RedisServerImpl) useDB(index int) {
+	s.db = index
+	s.cache = s.dbs[index]
+}
+
+func (s *RedisServerImpl) databases() []Cache {
+	return s.dbs
+}
+
+// Copies a key of the selected database to the database db, the copy has the expiry time of the source
+func (s *RedisServerImpl) CopyTo(source, destination string, db int, replace bool) error {
+	v, ok := s.cache.Lookup(source)
+	if !ok {
+		return fmt.Errorf("source key does not exist")
+	}
+	dst := s.dbs[db]
+	if !replace && dst.KeyExists(destination) {
+		return fmt.Errorf("destination key exists")
+	}
+	dst.Put(destination, v.clone())
+	s.signalKeyAsReady(db, destination)
+	return nil
+}
+
+// Moves a key of the selected database to the database db, fails if the key is missing or db has it
+func (s *RedisServerImpl) MoveTo(key string, db int) bool {
+	v, ok := s.cache.Lookup(key)
+	if !ok || s.dbs[db].KeyExists(key) {
+		return false
+	}
+	s.dbs[db].Put(key, v)
+	s.cache.Del([]string{key})
+	s.signalKeyAsReady(db, key)
+	return true
+}
+
+/*
+Swaps the data of two databases, the clients that selected one of them see the data of the other one.
+The clients blocked on a key of one of them may find their list in the other one.
+*/
+func (s *RedisServerImpl) SwapDB(a, b int) {
+	s.dbs[a], s.dbs[b] = s.dbs[b], s.dbs[a]
+	s.useDB(s.db)
+	for k := range s.blocking.waiters {
+		if k.db == a || k.db == b {
+			s.signalKeyAsReady(k.db, k.key)
+		}
+	}
+}
+
+func (s *RedisServerImpl) FlushAll() {
+	for _, db := range s.dbs {
+		db.Flush()
+	}
+}
+
+// Parses a database index, returns the error reply if it isn't a valid index
+func (r *ReqHandlerImpl) parseDBIndex(arg string) (int, []byte) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, newSimpleError("ERR value is not an integer or out of range")
+	}
+	if index < 0 || index >= len(r.server.databases()) {
+		return 0, newSimpleError("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+// Parses the optional ASYNC or SYNC argument of FLUSHDB and FLUSHALL, returns the error reply if it is neither
+func parseFlushMode(args []string) []byte {
+	if len(args) > 1 || (len(args) == 1 && !strings.EqualFold(args[0], "async") && !strings.EqualFold(args[0], "sync")) {
+		return newSimpleError("ERR syntax error")
+	}
+	return nil
+}
+
+// SELECT index
+func (r *ReqHandlerImpl) selectDB(req *Request) []byte {
+	index, reply := r.parseDBIndex(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	r.client.db = index
+	r.server.useDB(index)
+	return newSimpleString("OK")
+}
+
+// MOVE key db, replies 1 if the key was moved, 0 if it doesn't exist or the destination database has it
+func (r *ReqHandlerImpl) move(req *Request) []byte {
+	db, reply := r.parseDBIndex(req.args[1])
+	if reply != nil {
+		return reply
+	}
+	if db == r.client.db {
+		return newSimpleError("ERR source and destination objects are the same")
+	}
+	if !r.server.MoveTo(req.args[0], db) {
+		return newInteger(0)
+	}
+	return newInteger(1)
+}
+
+// SWAPDB index1 index2
+func (r *ReqHandlerImpl) swapDB(req *Request) []byte {
+	a, err := strconv.Atoi(req.args[0])
+	if err != nil {
+		return newSimpleError("ERR invalid first DB index")
+	}
+	b, err := strconv.Atoi(req.args[1])
+	if err != nil {
+		return newSimpleError("ERR invalid second DB index")
+	}
+	if _, reply := r.parseDBIndex(req.args[0]); reply != nil {
+		return reply
+	}
+	if _, reply := r.parseDBIndex(req.args[1]); reply != nil {
+		return reply
+	}
+	r.server.SwapDB(a, b)
+	return newSimpleString("OK")
+}
+
+/*
+FLUSHDB [ASYNC | SYNC]
+
+Deletes the keys of the selected database. The keys are dropped at once either way,
+the garbage collector reclaims their memory in the background.
+*/
+func (r *ReqHandlerImpl) flushDB(req *Request) []byte {
+	if reply := parseFlushMode(req.args); reply != nil {
+		return reply
+	}
+	r.server.Flush()
+	return newSimpleString("OK")
+}
+
+// FLUSHALL [ASYNC | SYNC], deletes the keys of every database
+func (r *ReqHandlerImpl) flushAll(req *Request) []byte {
+	if reply := parseFlushMode(req.args); reply != nil {
+		return reply
+	}
+	r.server.FlushAll()
+	return newSimpleString("OK")
+}
+
+// DBSIZE
+func (r *ReqHandlerImpl) dbSize(req *Request) []byte {
+	return newInteger(r.server.Size())
+}
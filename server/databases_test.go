@@ -0,0 +1,192 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDatabases(t *testing.T) {
+	cfg := testConfig()
+	cfg.Databases = 4
+	_, addr := startTestServer(t, cfg)
+	c := dialTestClient(t, addr)
+	defer c.Close()
+
+	expect := func(t *testing.T, expected string, args ...string) {
+		t.Helper()
+		if v := formatReply(c.do(t, args...)); v != expected {
+			t.Fatalf("%v: expected %q, got %q", args, expected, v)
+		}
+	}
+
+	t.Run("SELECT and DBSIZE", func(t *testing.T) {
+		expect(t, "[databases 4]", "CONFIG", "GET", "databases")
+		expect(t, "OK", "SET", "key", "db0")
+		expect(t, "1", "DBSIZE")
+		expect(t, "OK", "SELECT", "1")
+		expect(t, "nil", "GET", "key")
+		expect(t, "0", "DBSIZE")
+		expect(t, "OK", "SET", "key", "db1")
+		if info := c.do(t, "CLIENT", "INFO").Str(); !strings.Contains(info, " db=1 ") {
+			t.Fatalf("expected db=1, got %q", info)
+		}
+		expect(t, "ERR DB index is out of range", "SELECT", "4")
+		expect(t, "ERR DB index is out of range", "SELECT", "-1")
+		expect(t, "ERR value is not an integer or out of range", "SELECT", "one")
+		expect(t, "db1", "GET", "key")
+		expect(t, "OK", "SELECT", "0")
+		expect(t, "db0", "GET", "key")
+	})
+
+	t.Run("MULTI", func(t *testing.T) {
+		c.do(t, "MULTI")
+		c.do(t, "SELECT", "2")
+		c.do(t, "SET", "tx", "db2")
+		c.do(t, "SELECT", "0")
+		c.do(t, "EXISTS", "tx")
+		expect(t, "[OK OK OK 0]", "EXEC")
+		expect(t, "OK", "SELECT", "2")
+		expect(t, "db2", "GET", "tx")
+		expect(t, "OK", "FLUSHDB")
+		expect(t, "OK", "SELECT", "0")
+	})
+
+	t.Run("MOVE", func(t *testing.T) {
+		expect(t, "OK", "SET", "moved", "v", "PX", "100000")
+		expect(t, "1", "MOVE", "moved", "2")
+		expect(t, "0", "EXISTS", "moved")
+		expect(t, "0", "MOVE", "moved", "2")
+		expect(t, "0", "MOVE", "key", "1")
+		expect(t, "ERR source and destination objects are the same", "MOVE", "key", "0")
+		expect(t, "ERR DB index is out of range", "MOVE", "key", "9")
+		expect(t, "OK", "SELECT", "2")
+		expect(t, "v", "GET", "moved")
+		expect(t, "OK", "FLUSHDB", "async")
+		expect(t, "OK", "SELECT", "0")
+	})
+
+	t.Run("COPY DB", func(t *testing.T) {
+		expect(t, "1", "COPY", "key", "copy", "DB", "2")
+		expect(t, "0", "COPY", "key", "key", "DB", "1")
+		expect(t, "1", "COPY", "key", "key", "DB", "1", "REPLACE")
+		expect(t, "ERR source and destination objects are the same", "COPY", "key", "key")
+		expect(t, "ERR source and destination objects are the same", "COPY", "key", "key", "DB", "0")
+		expect(t, "ERR DB index is out of range", "COPY", "key", "copy", "DB", "7")
+		expect(t, "ERR syntax error", "COPY", "key", "copy", "DB")
+		expect(t, "ERR syntax error", "COPY", "key", "copy", "NOPE")
+		expect(t, "0", "EXISTS", "copy")
+		expect(t, "OK", "SELECT", "1")
+		expect(t, "db0", "GET", "key")
+		expect(t, "OK", "SELECT", "2")
+		expect(t, "db0", "GET", "copy")
+		expect(t, "OK", "SELECT", "0")
+	})
+
+	t.Run("SWAPDB", func(t *testing.T) {
+		other := dialTestClient(t, addr)
+		defer other.Close()
+		other.do(t, "SELECT", "2")
+		expect(t, "OK", "SWAPDB", "0", "2")
+		expect(t, "db0", "GET", "copy")
+		if v := other.do(t, "GET", "key"); v.Str() != "db0" {
+			t.Fatalf("expected the other client to see the data of db 0, got %q", v.Str())
+		}
+		expect(t, "OK", "SWAPDB", "2", "0")
+		expect(t, "ERR invalid first DB index", "SWAPDB", "a", "0")
+		expect(t, "ERR invalid second DB index", "SWAPDB", "0", "b")
+		expect(t, "ERR DB index is out of range", "SWAPDB", "0", "4")
+	})
+
+	t.Run("FLUSHDB and FLUSHALL", func(t *testing.T) {
+		expect(t, "ERR syntax error", "FLUSHDB", "LATER")
+		expect(t, "ERR syntax error", "FLUSHALL", "SYNC", "ASYNC")
+		expect(t, "OK", "FLUSHDB", "SYNC")
+		expect(t, "0", "DBSIZE")
+		expect(t, "OK", "SELECT", "1")
+		expect(t, "1", "DBSIZE")
+		expect(t, "OK", "FLUSHALL")
+		expect(t, "0", "DBSIZE")
+		expect(t, "OK", "SELECT", "2")
+		expect(t, "0", "DBSIZE")
+	})
+}
+
+func TestDatabasesReplication(t *testing.T) {
+	_, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	c.do(t, "SET", "key", "db0")
+	c.do(t, "SELECT", "3")
+	c.do(t, "SET", "key", "db3")
+	c.do(t, "SET", "moved", "v")
+	c.do(t, "MOVE", "moved", "5")
+	c.do(t, "SELECT", "0")
+	c.do(t, "INCR", "counter")
+	c.do(t, "COPY", "key", "copy", "DB", "7")
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	get := func(db int, key string) (value string) {
+		replica.execute(func() {
+			replica.useDB(db)
+			value, _ = replica.RedisServer.Get(key)
+		})
+		return
+	}
+	for _, check := range []struct {
+		db       int
+		key      string
+		expected string
+	}{
+		{0, "key", "db0"}, {3, "key", "db3"}, {0, "counter", "1"}, {3, "moved", ""}, {5, "moved", "v"}, {7, "copy", "db0"}, {0, "copy", ""},
+	} {
+		if v := get(check.db, check.key); v != check.expected {
+			t.Fatalf("db %d %s: expected %q on the replica, got %q", check.db, check.key, check.expected, v)
+		}
+	}
+}
+
+func TestDatabasesRDB(t *testing.T) {
+	cfg := testConfig()
+	cfg.Dir, cfg.DBFilename = t.TempDir(), "dump.rdb"
+	srv, addr := startTestServer(t, cfg)
+	c := dialTestClient(t, addr)
+	c.do(t, "SET", "key", "db0")
+	c.do(t, "SELECT", "5")
+	c.do(t, "SET", "key", "db5")
+	c.do(t, "SET", "expiring", "v", "PX", "100000")
+	c.do(t, "RPUSH", "list", "a", "b")
+	c.do(t, "HSET", "hash", "f", "v")
+	c.Close()
+	if err := srv.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, addr = startTestServer(t, cfg)
+	c = dialTestClient(t, addr)
+	defer c.Close()
+	for _, check := range []struct {
+		db       string
+		key      string
+		expected string
+	}{
+		{"0", "key", "db0"}, {"5", "key", "db5"}, {"5", "expiring", "v"}, {"0", "expiring", "nil"},
+	} {
+		c.do(t, "SELECT", check.db)
+		if v := formatReply(c.do(t, "GET", check.key)); v != check.expected {
+			t.Fatalf("db %s %s: expected %q after the restart, got %q", check.db, check.key, check.expected, v)
+		}
+	}
+	c.do(t, "SELECT", "5")
+	if v := formatReply(c.do(t, "LRANGE", "list", "0", "-1")); v != "[a b]" {
+		t.Fatalf("expected the list after the restart, got %q", v)
+	}
+	if v := formatReply(c.do(t, "HGETALL", "hash")); v != "[f v]" {
+		t.Fatalf("expected the hash after the restart, got %q", v)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+/*
+The executor runs the commands one at a time on a single goroutine, the way Redis runs them on its main thread.
+The connections are still read and written by their own goroutine, which hands each request to the executor
+and waits for its reply: the dataset, the clients' state and the replication state are only touched by the executor.
+
+A blocking command does not hold the executor while it waits, see ReqHandlerImpl.block.
+*/
+type executor struct {
+	jobs    chan func()
+	changed chan struct{} // closed and replaced by every write, wakes up the blocked clients
+	stop    <-chan struct{}
+}
+
+// Starts the executor goroutine, it returns once stop is closed
+func newExecutor(stop <-chan struct{}) *executor {
+	e := &executor{jobs: make(chan func()), changed: make(chan struct{}), stop: stop}
+	go e.loop()
+	return e
+}
+
+func (e *executor) loop() {
+	for {
+		select {
+		case job := <-e.jobs:
+			job()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+/*
+Runs fn on the executor and waits for it to return.
+Returns false without running fn once the executor is stopped.
+Must not be called by the executor itself, which would wait for itself.
+*/
+func (e *executor) run(fn func()) bool {
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		fn()
+	}
+	select {
+	case e.jobs <- job:
+	case <-e.stop:
+		return false
+	}
+	<-done
+	return true
+}
+
+// Returns a channel closed by the next write, only called on the executor
+func (e *executor) changes() <-chan struct{} {
+	return e.changed
+}
+
+// Wakes up the clients waiting for a write, only called on the executor
+func (e *executor) signalChange() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
+
+func startServer(t *testing.T, cfg server.Config) (*server.Server, string) {
+	cfg.Port = 0
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	addr, err := srv.Start(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start the server: %s", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv, addr
+}
+
+// Many clients hit the same keys at once, run with -race to check that the commands are serialized
+func TestParallelClients(t *testing.T) {
+	_, masterAddr := startServer(t, server.DefaultConfig())
+	cfg := server.DefaultConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startServer(t, cfg)
+
+	const clients, rounds = 16, 100
+	c := client.New(client.Options{Addr: masterAddr, PoolSize: clients})
+	defer c.Close()
+
+	// A reader blocked on the stream is woken up by the writers
+	if _, err := c.XAdd("events", "*", "client", "none"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	entries := make(chan int, 1)
+	go func() {
+		streams, err := c.XRead(client.XReadArgs{Streams: []string{"events"}, IDs: []string{"$"}, Block: client.BLOCK_FOREVER})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		entries <- len(streams)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key:%d", i)
+			for j := 0; j < rounds; j++ {
+				if _, err := c.Incr("counter"); err != nil {
+					t.Errorf("INCR: %s", err)
+					return
+				}
+				if err := c.Set(key, strconv.Itoa(j)); err != nil {
+					t.Errorf("SET: %s", err)
+					return
+				}
+				if v, err := c.Get(key); err != nil || v != strconv.Itoa(j) {
+					t.Errorf("GET %s: expected %d, got %q %v", key, j, v, err)
+					return
+				}
+				tx := c.Multi()
+				tx.Do("INCR", "tx")
+				tx.Do("INCR", "tx")
+				if replies, err := tx.Exec(); err != nil || replies[1].Int() != replies[0].Int()+1 {
+					t.Errorf("EXEC: expected consecutive values, got %v %v", replies, err)
+					return
+				}
+				if _, err := c.XAdd("events", "*", "client", key); err != nil {
+					t.Errorf("XADD: %s", err)
+					return
+				}
+				if j%10 == 0 {
+					if _, err := c.ClientList(); err != nil {
+						t.Errorf("CLIENT LIST: %s", err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case n := <-entries:
+		if n != 1 {
+			t.Fatalf("expected the blocked reader to get the stream, got %d", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected the blocked reader to be woken up")
+	}
+	if v, err := c.Get("counter"); err != nil || v != strconv.Itoa(clients*rounds) {
+		t.Fatalf("expected %d, got %q %v", clients*rounds, v, err)
+	}
+	if v, err := c.Get("tx"); err != nil || v != strconv.Itoa(2*clients*rounds) {
+		t.Fatalf("expected %d, got %q %v", 2*clients*rounds, v, err)
+	}
+	if n, err := c.Wait(1, time.Second); err != nil || n != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d %v", n, err)
+	}
+	if v, err := replica.Get("counter"); err != nil || v != strconv.Itoa(clients*rounds) {
+		t.Fatalf("expected the replica to have %d, got %q %v", clients*rounds, v, err)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"container/heap"
+	"time"
+)
+
+const (
+	CONFIG_DEFAULT_HZ = 10
+	CONFIG_MAX_HZ     = 500
+	// Share of each period of the server cron the active expire cycle can run for, in percent
+	ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC = 25
+	// Number of keys expired between two checks of the time budget
+	ACTIVE_EXPIRE_CYCLE_KEYS_PER_CHECK = 16
+)
+
+// An expiring key in the TTL index, at is its expiry time in milliseconds
+type expireEntry struct {
+	key   string
+	at    uint64
+	index int // position in the heap, kept up to date by Swap
+}
+
+// A min-heap of the expiring keys ordered by expiry time, the next key to expire is at the root
+type expireHeap []*expireEntry
+
+func (h expireHeap) Len() int           { return len(h) }
+func (h expireHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h expireHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expireHeap) Push(x any) {
+	entry := x.(*expireEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expireHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// Keeps the expiry time of key in the TTL index, an expiry of 0 removes the key from it
+func (s *CacheImpl) setTTL(key string, at uint64) {
+	setExpiry(&s.expires, s.expiring, key, at)
+}
+
+// Keeps the first expiry time of the fields of a hash in the index of the hashes, 0 removes the hash from it
+func (s *CacheImpl) setHashTTL(key string, at uint64) {
+	setExpiry(&s.hashExpires, s.hashExpiring, key, at)
+}
+
+// Keeps the expiry time of name in a TTL index made of its heap and its entries by name, an expiry of 0 removes name
+func setExpiry(h *expireHeap, entries map[string]*expireEntry, name string, at uint64) {
+	entry, ok := entries[name]
+	switch {
+	case ok && at == 0:
+		heap.Remove(h, entry.index)
+		delete(entries, name)
+	case ok:
+		entry.at = at
+		heap.Fix(h, entry.index)
+	case at != 0:
+		entry = &expireEntry{key: name, at: at}
+		heap.Push(h, entry)
+		entries[name] = entry
+	}
+}
+
+/*
+Deletes the expired keys in order of expiry time, then the expired fields of the hashes,
+until none is left or the time budget is spent, and returns the keys. The keys left for the next cycle are the ones that expired last.
+A hash left without fields is deleted too, but it isn't returned: the master propagates an HDEL of its fields.
+*/
+func (s *CacheImpl) ExpireCycle(budget time.Duration) []string {
+	deadline := time.Now().Add(budget)
+	now := uint64(s.now().UnixMilli())
+	expired := make([]string, 0)
+	done := 0
+	spent := func() bool {
+		done++
+		return done%ACTIVE_EXPIRE_CYCLE_KEYS_PER_CHECK == 0 && time.Now().After(deadline)
+	}
+	for len(s.expires) > 0 && s.expires[0].at <= now {
+		key := s.expires[0].key
+		s.expire(key)
+		expired = append(expired, key)
+		if spent() {
+			return expired
+		}
+	}
+	for len(s.hashExpires) > 0 && s.hashExpires[0].at <= now {
+		key := s.hashExpires[0].key
+		if v, ok := s.cache[key]; ok && v.hash != nil {
+			s.expireFields(key, v.hash, now)
+		} else {
+			s.setHashTTL(key, 0)
+		}
+		if spent() {
+			break
+		}
+	}
+	return expired
+}
+
+/*
+Runs the active expire cycle hz times per second until the server is shut down.
+Each cycle runs on the executor for at most ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC percent of its period,
+the keys it could not reach are expired by the next cycles.
+*/
+func (s *MasterServerImpl) expireCron() {
+	period := time.Second / time.Duration(s.hz)
+	budget := period * ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC / 100
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.execute(func() { s.activeExpireCycle(budget) })
+		case <-s.done:
+			return
+		}
+	}
+}
+
+/*
+Deletes the expired keys and hash fields of each database, the replicas don't run the cycle and wait for the DEL and HDEL of the master.
+The databases share the time budget, each cycle starts with the database after the one the previous cycle started with.
+*/
+func (s *MasterServerImpl) activeExpireCycle(budget time.Duration) {
+	deadline := time.Now().Add(budget)
+	start := s.expireDB
+	s.expireDB = (s.expireDB + 1) % len(s.dbs)
+	for i := 0; i < len(s.dbs) && time.Now().Before(deadline); i++ {
+		s.useDB((start + i) % len(s.dbs))
+		s.cache.ExpireCycle(time.Until(deadline))
+		s.ReplicateExpired()
+	}
+}
+
+/*
+Propagates a DEL for each key deleted because it expired, by the active expire cycle or by a lookup,
+and an HDEL for the expired fields of each hash.
+*/
+func (s *MasterServerImpl) ReplicateExpired() {
+	fields := s.cache.TakeExpiredFields()
+	for _, f := range fields {
+		s.Replicate(NewRequest("HDEL", append([]string{f.key}, f.fields...)...))
+	}
+	keys := s.cache.TakeExpired()
+	for _, key := range keys {
+		s.Replicate(NewRequest("DEL", key))
+	}
+	if len(keys) > 0 || len(fields) > 0 {
+		s.signalChange()
+	}
+}
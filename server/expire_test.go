@@ -0,0 +1,275 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireCycle(t *testing.T) {
+	cache := NewCache()
+	clock := time.UnixMilli(1000)
+	cache.now = func() time.Time { return clock }
+
+	cache.SetExpiry("c", "v", 1300)
+	cache.SetExpiry("a", "v", 1100)
+	cache.SetExpiry("b", "v", 1200)
+	cache.SetExpiry("persisted", "v", 1100)
+	cache.Set("persisted", "v")
+	cache.SetExpiry("deleted", "v", 1100)
+	cache.Del([]string{"deleted"})
+	cache.SetExpiry("delayed", "v", 1100)
+	cache.ExpireIn("delayed", 1000)
+	cache.Set("forever", "v")
+	if len(cache.expires) != 4 {
+		t.Fatalf("expected 4 keys in the TTL index, got %d", len(cache.expires))
+	}
+
+	steps := []struct {
+		clock    int64
+		expected []string
+	}{
+		{clock: 1099, expected: []string{}},
+		{clock: 1200, expected: []string{"a", "b"}},
+		{clock: 1500, expected: []string{"c"}},
+		{clock: 2000, expected: []string{"delayed"}},
+		{clock: 9000, expected: []string{}},
+	}
+	for _, step := range steps {
+		clock = time.UnixMilli(step.clock)
+		if expired := cache.ExpireCycle(time.Second); fmt.Sprint(expired) != fmt.Sprint(step.expected) {
+			t.Fatalf("at %d: expected %v, got %v", step.clock, step.expected, expired)
+		}
+	}
+	if keys := fmt.Sprint(cache.Keys("*")); keys != "[forever persisted]" && keys != "[persisted forever]" {
+		t.Fatalf("expected the keys without expiry to be kept, got %s", keys)
+	}
+	if len(cache.expires) != 0 || len(cache.expiring) != 0 {
+		t.Fatalf("expected an empty TTL index, got %d %d", len(cache.expires), len(cache.expiring))
+	}
+
+	t.Run("time budget", func(t *testing.T) {
+		for i := 0; i < 40; i++ {
+			cache.SetExpiry(fmt.Sprintf("key:%d", i), "v", uint64(9000-i))
+		}
+		expired := cache.ExpireCycle(0)
+		if len(expired) != ACTIVE_EXPIRE_CYCLE_KEYS_PER_CHECK || expired[0] != "key:39" {
+			t.Fatalf("expected the %d keys expiring first, got %v", ACTIVE_EXPIRE_CYCLE_KEYS_PER_CHECK, expired)
+		}
+		left := len(cache.ExpireCycle(0)) + len(cache.ExpireCycle(0))
+		if left != 40-ACTIVE_EXPIRE_CYCLE_KEYS_PER_CHECK || len(cache.cache) != 2 {
+			t.Fatalf("expected the next cycles to expire the other keys, got %d", left)
+		}
+	})
+
+	t.Run("hash fields", func(t *testing.T) {
+		cache.Flush()
+		kept, emptied := NewHash(), NewHash()
+		for _, field := range []string{"a", "b", "c"} {
+			kept.Set(field, "v", false)
+		}
+		kept.SetExpiry("a", 9100)
+		kept.SetExpiry("b", 9200)
+		emptied.Set("x", "v", false)
+		emptied.SetExpiry("x", 9100)
+		cache.Put("kept", Object{hash: kept})
+		cache.Put("emptied", Object{hash: emptied})
+
+		// The hash left without fields is deleted, the master propagates the HDEL of its fields and no DEL
+		clock = time.UnixMilli(9100)
+		if expired := cache.ExpireCycle(time.Second); len(expired) != 0 {
+			t.Fatalf("expected no key in the returned keys, got %v", expired)
+		}
+		fields := make(map[string]string)
+		for _, f := range cache.TakeExpiredFields() {
+			fields[f.key] = fmt.Sprint(f.fields)
+		}
+		if fmt.Sprint(fields) != "map[emptied:[x] kept:[a]]" {
+			t.Fatalf("unexpected expired fields %v", fields)
+		}
+		if _, ok := cache.cache["emptied"]; ok || fmt.Sprint(kept.Fields()) != "[b c]" {
+			t.Fatalf("expected the emptied hash to be deleted and the kept one to have [b c], got %v", kept.Fields())
+		}
+		if len(cache.hashExpires) != 1 || cache.hashExpires[0].at != 9200 {
+			t.Fatalf("expected the kept hash in the index at 9200, got %d entries", len(cache.hashExpires))
+		}
+
+		clock = time.UnixMilli(9200)
+		cache.ExpireCycle(time.Second)
+		if f := cache.TakeExpiredFields(); len(f) != 1 || fmt.Sprint(f[0].fields) != "[b]" {
+			t.Fatalf("expected b to expire, got %v", f)
+		}
+		if len(cache.hashExpires) != 0 || len(cache.hashExpiring) != 0 || kept.Len() != 1 {
+			t.Fatalf("expected an empty index of the hashes and c left, got %d %v", len(cache.hashExpires), kept.Fields())
+		}
+	})
+}
+
+func TestActiveExpire(t *testing.T) {
+	cfg := testConfig()
+	cfg.Hz = 100
+	master, masterAddr := startTestServer(t, cfg)
+	cfg = testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	if v := c.do(t, "CONFIG", "GET", "hz").Array(); len(v) != 2 || v[1].Str() != "100" {
+		t.Fatalf("expected hz 100, got %v", v)
+	}
+	c.do(t, "SET", "session", "v", "PX", "30")
+	c.do(t, "SET", "kept", "v")
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	// The key is never read again, the master deletes it and the replica gets a DEL
+	exists := func(s *Server, key string) (exists bool) {
+		s.execute(func() {
+			s.useDB(0)
+			exists = s.KeyExists(key)
+		})
+		return
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for exists(master, "session") || exists(replica, "session") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the key to be expired on the master %v and the replica %v", exists(master, "session"), exists(replica, "session"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !exists(master, "kept") || !exists(replica, "kept") {
+		t.Fatalf("expected the key without expiry to be kept")
+	}
+}
+
+// Replaces the clock of the master's database 0 with one that only moves when the returned function is called
+func fakeClock(srv *Server) func(time.Duration) {
+	cache := srv.RedisServer.(*MasterServerImpl).dbs[0].(*CacheImpl)
+	now := time.Now()
+	srv.execute(func() { cache.now = func() time.Time { return now } })
+	return func(d time.Duration) {
+		srv.execute(func() { now = now.Add(d) })
+	}
+}
+
+// Formats a reply to compare it in a table
+func formatReply(v RespValue) string {
+	switch {
+	case v.IsNull():
+		return "nil"
+	case v.Kind() == ':':
+		return strconv.FormatInt(v.Int(), 10)
+	case v.Kind() == '*':
+		elems := make([]string, 0, len(v.Array()))
+		for _, e := range v.Array() {
+			elems = append(elems, formatReply(e))
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	}
+	return v.Str()
+}
+
+func TestLazyExpire(t *testing.T) {
+	tests := []struct {
+		description string
+		setup       [][]string // run before the keys expire
+		command     []string
+		expected    string
+		after       []string // run after command
+		afterReply  string
+	}{
+		{description: "GET", command: []string{"GET", "key"}, expected: "nil"},
+		{description: "EXISTS", command: []string{"EXISTS", "key", "other"}, expected: "1"},
+		{description: "TYPE", command: []string{"TYPE", "key"}, expected: "none"},
+		{description: "KEYS", command: []string{"KEYS", "*"}, expected: "[other]"},
+		{description: "DEL", command: []string{"DEL", "key", "other"}, expected: "1"},
+		{description: "COPY from", command: []string{"COPY", "key", "copy"}, expected: "0",
+			after: []string{"EXISTS", "copy"}, afterReply: "0"},
+		{description: "COPY to", command: []string{"COPY", "other", "key"}, expected: "1",
+			after: []string{"GET", "key"}, afterReply: "value"},
+		{description: "INCR", setup: [][]string{{"SET", "counter", "5", "PX", "100"}}, command: []string{"INCR", "counter"}, expected: "1",
+			after: []string{"TYPE", "counter"}, afterReply: "string"},
+		{description: "SET NX", command: []string{"SET", "key", "new", "NX"}, expected: "OK",
+			after: []string{"GET", "key"}, afterReply: "new"},
+		{description: "SET XX", command: []string{"SET", "key", "new", "XX"}, expected: "nil",
+			after: []string{"EXISTS", "key"}, afterReply: "0"},
+		{description: "SET PX", command: []string{"SET", "other", "new", "PX", "100"}, expected: "OK",
+			after: []string{"GET", "other"}, afterReply: "new"},
+		{description: "XADD", command: []string{"XADD", "key", "1-1", "f", "v"}, expected: "1-1",
+			after: []string{"TYPE", "key"}, afterReply: "stream"},
+		{description: "XRANGE", command: []string{"XRANGE", "key", "-", "+"}, expected: "ERR The key does not exist"},
+		{description: "XREAD", command: []string{"XREAD", "STREAMS", "key", "0-0"}, expected: "ERR The key does not exist"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Hz = 1
+			srv, addr := startTestServer(t, cfg)
+			advance := fakeClock(srv)
+			c := dialTestClient(t, addr)
+			defer c.Close()
+
+			c.do(t, "SET", "key", "value", "PX", "100")
+			c.do(t, "SET", "other", "value")
+			for _, args := range test.setup {
+				c.do(t, args...)
+			}
+			advance(100 * time.Millisecond)
+			if v := formatReply(c.do(t, test.command...)); v != test.expected {
+				t.Fatalf("%v: expected %q, got %q", test.command, test.expected, v)
+			}
+			if test.after != nil {
+				if v := formatReply(c.do(t, test.after...)); v != test.afterReply {
+					t.Fatalf("%v: expected %q, got %q", test.after, test.afterReply, v)
+				}
+			}
+		})
+	}
+
+	t.Run("INCR keeps the expiry time", func(t *testing.T) {
+		srv, addr := startTestServer(t, testConfig())
+		advance := fakeClock(srv)
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		c.do(t, "SET", "counter", "5", "PX", "100")
+		c.do(t, "INCR", "counter")
+		advance(100 * time.Millisecond)
+		if v := c.do(t, "GET", "counter"); !v.IsNull() {
+			t.Fatalf("expected the counter to expire, got %q", v.Str())
+		}
+	})
+
+	t.Run("the replicas get a DEL", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Hz = 1
+		master, masterAddr := startTestServer(t, cfg)
+		advance := fakeClock(master)
+		cfg = testConfig()
+		cfg.ReplicaOf = masterAddr
+		replica, _ := startTestServer(t, cfg)
+		c := dialTestClient(t, masterAddr)
+		defer c.Close()
+
+		// The replica's own clock doesn't move, only the DEL of the master removes the key
+		c.do(t, "SET", "key", "value", "PX", "60000")
+		advance(time.Minute)
+		if v := c.do(t, "EXISTS", "key"); v.Int() != 0 {
+			t.Fatalf("expected the key to be expired, got %d", v.Int())
+		}
+		if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+			t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+		}
+		var exists bool
+		replica.execute(func() {
+			replica.useDB(0)
+			exists = replica.KeyExists("key")
+		})
+		if exists {
+			t.Fatalf("expected the replica to delete the key")
+		}
+	})
+}
@@ -0,0 +1,666 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+/*
+Returns the hash of a key, nil if the key does not exist.
+Returns the WRONGTYPE error reply if the key holds another type.
+*/
+func (r *ReqHandlerImpl) lookupHash(key string) (*Hash, []byte) {
+	v, ok := r.server.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if v.hash == nil {
+		return nil, newSimpleError(errWrongType.Error())
+	}
+	return v.hash, nil
+}
+
+// Returns the hash of a key, created if the key does not exist, or the WRONGTYPE error reply
+func (r *ReqHandlerImpl) lookupOrCreateHash(key string) (*Hash, []byte) {
+	h, reply := r.lookupHash(key)
+	if reply != nil || h != nil {
+		return h, reply
+	}
+	h = NewHash()
+	r.server.Put(key, Object{hash: h})
+	return h, nil
+}
+
+/*
+Sets the fields of HSET key field value [field value ...] and HMSET, creating the hash if the key does not exist.
+Returns the number of fields added, or the error reply.
+*/
+func (r *ReqHandlerImpl) setFields(req *Request) (int, []byte) {
+	if len(req.args)%2 == 0 {
+		return 0, newSimpleError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(req.command)))
+	}
+	h, reply := r.lookupOrCreateHash(req.args[0])
+	if reply != nil {
+		return 0, reply
+	}
+	added := 0
+	for i := 1; i < len(req.args); i += 2 {
+		if h.Set(req.args[i], req.args[i+1], false) {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// HSET key field value [field value ...], replies the number of fields added
+func (r *ReqHandlerImpl) hset(req *Request) []byte {
+	added, reply := r.setFields(req)
+	if reply != nil {
+		return reply
+	}
+	return newInteger(added)
+}
+
+// HMSET key field value [field value ...], HSET replying OK
+func (r *ReqHandlerImpl) hmset(req *Request) []byte {
+	if _, reply := r.setFields(req); reply != nil {
+		return reply
+	}
+	return newSimpleString("OK")
+}
+
+// HSETNX key field value, sets the field only if it does not exist yet, replies 1 if it was set
+func (r *ReqHandlerImpl) hsetnx(req *Request) []byte {
+	h, reply := r.lookupOrCreateHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if _, ok := h.Get(req.args[1]); ok {
+		return newInteger(0)
+	}
+	h.Set(req.args[1], req.args[2], false)
+	return newInteger(1)
+}
+
+// HGET key field
+func (r *ReqHandlerImpl) hget(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return r.encoder().Null()
+	}
+	value, ok := h.Get(req.args[1])
+	if !ok {
+		return r.encoder().Null()
+	}
+	return newBulkString(value)
+}
+
+// HMGET key field [field ...], replies nil for the fields that don't exist
+func (r *ReqHandlerImpl) hmget(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	return r.hashValues(h, req.args[1:])
+}
+
+// Replies the values of fields, nil for the fields that don't exist or if the hash is nil
+func (r *ReqHandlerImpl) hashValues(h *Hash, fields []string) []byte {
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if h == nil {
+			values = append(values, string(r.encoder().Null()))
+		} else if value, ok := h.Get(field); ok {
+			values = append(values, string(newBulkString(value)))
+		} else {
+			values = append(values, string(r.encoder().Null()))
+		}
+	}
+	return newBulkArrayOfArrays(values...)
+}
+
+// HDEL key field [field ...], replies the number of fields removed, the hash left empty is deleted
+func (r *ReqHandlerImpl) hdel(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return newInteger(0)
+	}
+	removed := 0
+	for _, field := range req.args[1:] {
+		if h.Delete(field) {
+			removed++
+		}
+	}
+	if h.Len() == 0 {
+		r.server.Del([]string{req.args[0]})
+	}
+	return newInteger(removed)
+}
+
+// HEXISTS key field
+func (r *ReqHandlerImpl) hexists(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return newInteger(0)
+	}
+	if _, ok := h.Get(req.args[1]); ok {
+		return newInteger(1)
+	}
+	return newInteger(0)
+}
+
+// HLEN key
+func (r *ReqHandlerImpl) hlen(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return newInteger(0)
+	}
+	return newInteger(h.Len())
+}
+
+// HSTRLEN key field, replies the length of the value, 0 if the field does not exist
+func (r *ReqHandlerImpl) hstrlen(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return newInteger(0)
+	}
+	value, _ := h.Get(req.args[1])
+	return newInteger(len(value))
+}
+
+// HKEYS key
+func (r *ReqHandlerImpl) hkeys(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return newBulkArray()
+	}
+	return newBulkArray(h.Fields()...)
+}
+
+// HVALS key
+func (r *ReqHandlerImpl) hvals(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return newBulkArray()
+	}
+	values := make([]string, 0, h.Len())
+	for _, field := range h.Fields() {
+		value, _ := h.Get(field)
+		values = append(values, value)
+	}
+	return newBulkArray(values...)
+}
+
+// HGETALL key, a map of the fields to their values, a flat array of fields and values in RESP2
+func (r *ReqHandlerImpl) hgetall(req *Request) []byte {
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if h == nil {
+		return r.encoder().Map()
+	}
+	pairs := make([]string, 0, 2*h.Len())
+	for _, field := range h.Fields() {
+		value, _ := h.Get(field)
+		pairs = append(pairs, string(newBulkString(field)), string(newBulkString(value)))
+	}
+	return r.encoder().Map(pairs...)
+}
+
+// HINCRBY key field increment, the field is created with 0 if it does not exist, its expiry time is kept
+func (r *ReqHandlerImpl) hincrby(req *Request) []byte {
+	increment, err := strconv.ParseInt(req.args[2], 10, 64)
+	if err != nil {
+		return newSimpleError("ERR value is not an integer or out of range")
+	}
+	h, reply := r.lookupOrCreateHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	var value int64
+	if current, ok := h.Get(req.args[1]); ok {
+		if value, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return newSimpleError("ERR hash value is not an integer")
+		}
+	}
+	if (increment > 0 && value > math.MaxInt64-increment) || (increment < 0 && value < math.MinInt64-increment) {
+		return newSimpleError("ERR increment or decrement would overflow")
+	}
+	value += increment
+	h.Set(req.args[1], strconv.FormatInt(value, 10), true)
+	return newInteger(int(value))
+}
+
+/*
+HINCRBYFLOAT key field increment, the field is created with 0 if it does not exist, its expiry time is kept.
+The new value is replied as a double in RESP3, a bulk string in RESP2.
+The new value is propagated with HSET so that the replicas don't compute it with a different precision,
+followed by HPEXPIREAT if the field expires as HSET removes its expiry time.
+*/
+func (r *ReqHandlerImpl) hincrbyfloat(req *Request) []byte {
+	increment, err := strconv.ParseFloat(req.args[2], 64)
+	if err != nil || math.IsNaN(increment) || math.IsInf(increment, 0) {
+		return newSimpleError("ERR value is not a valid float")
+	}
+	h, reply := r.lookupOrCreateHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	var value float64
+	if current, ok := h.Get(req.args[1]); ok {
+		if value, err = strconv.ParseFloat(current, 64); err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return newSimpleError("ERR hash value is not a float")
+		}
+	}
+	value += increment
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return newSimpleError("ERR increment would produce NaN or Infinity")
+	}
+	formatted := formatDouble(value)
+	h.Set(req.args[1], formatted, true)
+	r.preventPropagation = true
+	r.propagate(NewRequest("HSET", req.args[0], req.args[1], formatted))
+	if at := h.Expiry(req.args[1]); at != 0 {
+		r.propagate(NewRequest("HPEXPIREAT", req.args[0], strconv.FormatUint(at, 10), "FIELDS", "1", req.args[1]))
+	}
+	return r.encoder().Double(value)
+}
+
+/*
+HRANDFIELD key [count [WITHVALUES]].
+Without count replies a random field, nil if the key does not exist.
+A positive count replies up to count distinct fields, a negative one -count fields that may repeat,
+picked as the reply grows. WITHVALUES replies each field along with its value, as pairs in RESP3.
+*/
+func (r *ReqHandlerImpl) hrandfield(req *Request) []byte {
+	if len(req.args) > 3 || (len(req.args) == 3 && !strings.EqualFold(req.args[2], "WITHVALUES")) {
+		return newSimpleError("ERR syntax error")
+	}
+	count, withCount := 1, len(req.args) > 1
+	if withCount {
+		var reply []byte
+		if count, reply = parseRandomCount(req.args[1]); reply != nil {
+			return reply
+		}
+	}
+	withValues := len(req.args) == 3
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if !withCount {
+		if h == nil {
+			return r.encoder().Null()
+		}
+		fields := h.Fields()
+		return newBulkString(fields[rand.IntN(len(fields))])
+	}
+	if h == nil {
+		return newBulkArray()
+	}
+	fields := h.Fields()
+	var picked []string
+	if count >= 0 {
+		rand.Shuffle(len(fields), func(i, j int) { fields[i], fields[j] = fields[j], fields[i] })
+		picked = fields[:min(count, len(fields))]
+	} else if len(fields) > 0 {
+		for i := 0; i < -count; i++ {
+			picked = append(picked, fields[rand.IntN(len(fields))])
+		}
+	}
+	elements := make([]string, 0, len(picked))
+	for _, field := range picked {
+		value, _ := h.Get(field)
+		switch {
+		case !withValues:
+			elements = append(elements, string(newBulkString(field)))
+		case r.encoder().Protocol() == RESP3:
+			elements = append(elements, string(newBulkArray(field, value)))
+		default:
+			elements = append(elements, string(newBulkString(field)), string(newBulkString(value)))
+		}
+	}
+	return newBulkArrayOfArrays(elements...)
+}
+
+// The latest expiry time of a hash field in milliseconds, the one of Redis
+const HASH_FIELD_MAX_EXPIRE = (1<<48 - 1) >> 2
+
+// Parses the FIELDS numfields field [field ...] arguments ending the hash field expiration commands
+func parseHashFields(args []string) ([]string, []byte) {
+	if len(args) < 2 || !strings.EqualFold(args[0], "FIELDS") {
+		return nil, newSimpleError("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.Atoi(args[1])
+	if err != nil || numFields <= 0 {
+		return nil, newSimpleError("ERR Parameter `numFields` should be greater than 0")
+	}
+	if numFields != len(args)-2 {
+		return nil, newSimpleError("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+/*
+Parses the expiry time of a hash field expiration command, in seconds unless ms and relative to now unless absolute.
+Returns the expiry time in milliseconds, or the error reply.
+*/
+func parseHashExpiry(arg, command string, ms, absolute bool, now uint64) (uint64, []byte) {
+	expiry, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, newSimpleError("ERR value is not an integer or out of range")
+	}
+	if expiry < 0 {
+		return 0, newSimpleError("ERR invalid expire time, must be >= 0")
+	}
+	invalid := newSimpleError(fmt.Sprintf("ERR invalid expire time in '%s' command", command))
+	if !ms {
+		if expiry > HASH_FIELD_MAX_EXPIRE/1000 {
+			return 0, invalid
+		}
+		expiry *= 1000
+	}
+	at := uint64(expiry)
+	if !absolute {
+		at += now
+	}
+	if at > HASH_FIELD_MAX_EXPIRE {
+		return 0, invalid
+	}
+	return at, nil
+}
+
+/*
+Stores a hash again after the expiry times of its fields changed, so that the cache indexes the first one,
+or deletes its key if no field is left.
+*/
+func (r *ReqHandlerImpl) storeHash(key string, h *Hash) {
+	if h.Len() == 0 {
+		r.server.Del([]string{key})
+	} else if v, ok := r.server.Lookup(key); ok {
+		r.server.Put(key, v)
+	}
+}
+
+/*
+Propagates the changes of the expiry times of hash fields as absolute times, so that the replicas expire them at the same time as the master:
+HPEXPIREAT for the fields given the expiry time at and HDEL for the fields deleted.
+*/
+func (r *ReqHandlerImpl) propagateHashExpiry(key string, at uint64, set, deleted []string) {
+	r.preventPropagation = true
+	if len(set) > 0 {
+		args := append([]string{key, strconv.FormatUint(at, 10), "FIELDS", strconv.Itoa(len(set))}, set...)
+		r.propagate(NewRequest("HPEXPIREAT", args...))
+	}
+	if len(deleted) > 0 {
+		r.propagate(NewRequest("HDEL", append([]string{key}, deleted...)...))
+	}
+}
+
+/*
+HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...], HPEXPIRE in milliseconds,
+HEXPIREAT and HPEXPIREAT at a unix time.
+Replies for each field -2 if it does not exist, 0 if the condition is not met, 1 if the expiry time is set,
+or 2 if the field is deleted as the time has passed.
+NX sets the expiry time only of the fields that have none, XX only of those that have one,
+GT only if it is later than the current one and LT if it is earlier, no expiry time counting as an infinite one.
+*/
+func (r *ReqHandlerImpl) hexpireGeneric(req *Request, ms, absolute bool) []byte {
+	now := uint64(r.server.Now().UnixMilli())
+	at, reply := parseHashExpiry(req.args[1], strings.ToLower(req.command), ms, absolute, now)
+	if reply != nil {
+		return reply
+	}
+	condition, args := "", req.args[2:]
+	if !strings.EqualFold(args[0], "FIELDS") {
+		condition, args = strings.ToUpper(args[0]), args[1:]
+		if condition != "NX" && condition != "XX" && condition != "GT" && condition != "LT" {
+			return newSimpleError(fmt.Sprintf("ERR Unsupported argument: %s", req.args[2]))
+		}
+	}
+	fields, reply := parseHashFields(args)
+	if reply != nil {
+		return reply
+	}
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	results := make([]string, 0, len(fields))
+	var set, deleted []string
+	for _, field := range fields {
+		if h == nil {
+			results = append(results, string(newInteger(-2)))
+			continue
+		}
+		if _, ok := h.Get(field); !ok {
+			results = append(results, string(newInteger(-2)))
+			continue
+		}
+		current := h.Expiry(field)
+		met := true
+		switch condition {
+		case "NX":
+			met = current == 0
+		case "XX":
+			met = current != 0
+		case "GT":
+			met = current != 0 && at > current
+		case "LT":
+			met = current == 0 || at < current
+		}
+		switch {
+		case !met:
+			results = append(results, string(newInteger(0)))
+		case at <= now:
+			h.Delete(field)
+			deleted = append(deleted, field)
+			results = append(results, string(newInteger(2)))
+		default:
+			h.SetExpiry(field, at)
+			set = append(set, field)
+			results = append(results, string(newInteger(1)))
+		}
+	}
+	if h != nil {
+		r.storeHash(req.args[0], h)
+	}
+	r.propagateHashExpiry(req.args[0], at, set, deleted)
+	return newBulkArrayOfArrays(results...)
+}
+
+func (r *ReqHandlerImpl) hexpire(req *Request) []byte {
+	return r.hexpireGeneric(req, false, false)
+}
+
+func (r *ReqHandlerImpl) hpexpire(req *Request) []byte {
+	return r.hexpireGeneric(req, true, false)
+}
+
+func (r *ReqHandlerImpl) hexpireat(req *Request) []byte {
+	return r.hexpireGeneric(req, false, true)
+}
+
+func (r *ReqHandlerImpl) hpexpireat(req *Request) []byte {
+	return r.hexpireGeneric(req, true, true)
+}
+
+/*
+HTTL key FIELDS numfields field [field ...], HPTTL in milliseconds, HEXPIRETIME and HPEXPIRETIME as a unix time.
+Replies for each field -2 if it does not exist, -1 if it has no expiry time, otherwise the time to live or the expiry time.
+*/
+func (r *ReqHandlerImpl) httlGeneric(req *Request, ms, absolute bool) []byte {
+	fields, reply := parseHashFields(req.args[1:])
+	if reply != nil {
+		return reply
+	}
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	now := uint64(r.server.Now().UnixMilli())
+	results := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if h == nil {
+			results = append(results, string(newInteger(-2)))
+			continue
+		}
+		if _, ok := h.Get(field); !ok {
+			results = append(results, string(newInteger(-2)))
+			continue
+		}
+		at := h.Expiry(field)
+		if at == 0 {
+			results = append(results, string(newInteger(-1)))
+			continue
+		}
+		if !absolute {
+			at -= now
+		}
+		if !ms {
+			at = (at + 999) / 1000
+		}
+		results = append(results, string(newInteger(int(at))))
+	}
+	return newBulkArrayOfArrays(results...)
+}
+
+func (r *ReqHandlerImpl) httl(req *Request) []byte {
+	return r.httlGeneric(req, false, false)
+}
+
+func (r *ReqHandlerImpl) hpttl(req *Request) []byte {
+	return r.httlGeneric(req, true, false)
+}
+
+func (r *ReqHandlerImpl) hexpiretime(req *Request) []byte {
+	return r.httlGeneric(req, false, true)
+}
+
+func (r *ReqHandlerImpl) hpexpiretime(req *Request) []byte {
+	return r.httlGeneric(req, true, true)
+}
+
+// HPERSIST key FIELDS numfields field [field ...], replies for each field -2 if it does not exist, -1 if it has no expiry time or 1 if it is removed
+func (r *ReqHandlerImpl) hpersist(req *Request) []byte {
+	fields, reply := parseHashFields(req.args[1:])
+	if reply != nil {
+		return reply
+	}
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	results := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if h == nil {
+			results = append(results, string(newInteger(-2)))
+			continue
+		}
+		if _, ok := h.Get(field); !ok {
+			results = append(results, string(newInteger(-2)))
+		} else if h.Expiry(field) == 0 {
+			results = append(results, string(newInteger(-1)))
+		} else {
+			h.SetExpiry(field, 0)
+			results = append(results, string(newInteger(1)))
+		}
+	}
+	return newBulkArrayOfArrays(results...)
+}
+
+/*
+HGETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST] FIELDS numfields field [field ...].
+Replies the values of the fields like HMGET, and sets or removes the expiry time of those that exist.
+The fields are deleted if the time has passed, the key too if no field is left.
+*/
+func (r *ReqHandlerImpl) hgetex(req *Request) []byte {
+	now := uint64(r.server.Now().UnixMilli())
+	option, args := "", req.args[1:]
+	var at uint64
+	if !strings.EqualFold(args[0], "FIELDS") {
+		option = strings.ToUpper(args[0])
+		switch option {
+		case "PERSIST":
+			args = args[1:]
+		case "EX", "PX", "EXAT", "PXAT":
+			if len(args) < 2 {
+				return newSimpleError("ERR syntax error")
+			}
+			if expiry, err := strconv.ParseInt(args[1], 10, 64); err == nil && expiry <= 0 {
+				return newSimpleError("ERR invalid expire time in 'hgetex' command")
+			}
+			var reply []byte
+			if at, reply = parseHashExpiry(args[1], "hgetex", option == "PX" || option == "PXAT", option == "EXAT" || option == "PXAT", now); reply != nil {
+				return reply
+			}
+			args = args[2:]
+		default:
+			return newSimpleError("ERR syntax error")
+		}
+	}
+	fields, reply := parseHashFields(args)
+	if reply != nil {
+		return reply
+	}
+	h, reply := r.lookupHash(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	values := r.hashValues(h, fields)
+	r.preventPropagation = true
+	if h == nil || option == "" {
+		return values
+	}
+	var set, deleted, persisted []string
+	for _, field := range fields {
+		if _, ok := h.Get(field); !ok {
+			continue
+		}
+		switch {
+		case option == "PERSIST":
+			if h.Expiry(field) != 0 {
+				h.SetExpiry(field, 0)
+				persisted = append(persisted, field)
+			}
+		case at <= now:
+			h.Delete(field)
+			deleted = append(deleted, field)
+		default:
+			h.SetExpiry(field, at)
+			set = append(set, field)
+		}
+	}
+	r.storeHash(req.args[0], h)
+	r.propagateHashExpiry(req.args[0], at, set, deleted)
+	if len(persisted) > 0 {
+		args := append([]string{req.args[0], "FIELDS", strconv.Itoa(len(persisted))}, persisted...)
+		r.propagate(NewRequest("HPERSIST", args...))
+	}
+	return values
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashCommands(t *testing.T) {
+	_, addr := startTestServer(t, testConfig())
+	c := dialTestClient(t, addr)
+	defer c.Close()
+
+	steps := []struct {
+		command  []string
+		expected string
+	}{
+		{[]string{"HSET", "user", "name", "Ada", "lang", "en"}, "2"},
+		{[]string{"HSET", "user", "name", "Ada Lovelace", "born", "1815"}, "1"},
+		{[]string{"HSET", "user", "name"}, "ERR wrong number of arguments for 'hset' command"},
+		{[]string{"HMSET", "user", "city", "London"}, "OK"},
+		{[]string{"HMSET", "user", "city", "London", "zip"}, "ERR wrong number of arguments for 'hmset' command"},
+		{[]string{"TYPE", "user"}, "hash"},
+		{[]string{"HLEN", "user"}, "4"},
+		{[]string{"HGET", "user", "name"}, "Ada Lovelace"},
+		{[]string{"HGET", "user", "missing"}, "nil"},
+		{[]string{"HGET", "missing", "name"}, "nil"},
+		{[]string{"HMGET", "user", "lang", "missing", "born"}, "[en nil 1815]"},
+		{[]string{"HMGET", "missing", "lang"}, "[nil]"},
+		{[]string{"HGETALL", "user"}, "[born 1815 city London lang en name Ada Lovelace]"},
+		{[]string{"HKEYS", "user"}, "[born city lang name]"},
+		{[]string{"HVALS", "user"}, "[1815 London en Ada Lovelace]"},
+		{[]string{"HGETALL", "missing"}, "[]"},
+		{[]string{"HEXISTS", "user", "city"}, "1"},
+		{[]string{"HEXISTS", "user", "zip"}, "0"},
+		{[]string{"HSTRLEN", "user", "name"}, "12"},
+		{[]string{"HSTRLEN", "user", "zip"}, "0"},
+		{[]string{"HSETNX", "user", "city", "Paris"}, "0"},
+		{[]string{"HSETNX", "user", "zip", "W1"}, "1"},
+		{[]string{"HGET", "user", "city"}, "London"},
+		{[]string{"HINCRBY", "user", "born", "10"}, "1825"},
+		{[]string{"HINCRBY", "user", "visits", "-3"}, "-3"},
+		{[]string{"HINCRBY", "user", "name", "1"}, "ERR hash value is not an integer"},
+		{[]string{"HINCRBY", "user", "born", "1.5"}, "ERR value is not an integer or out of range"},
+		{[]string{"HSET", "user", "big", "9223372036854775807"}, "1"},
+		{[]string{"HINCRBY", "user", "big", "1"}, "ERR increment or decrement would overflow"},
+		{[]string{"HINCRBYFLOAT", "user", "born", "0.5"}, "1825.5"},
+		{[]string{"HSET", "user", "score", "5.0e3"}, "1"},
+		{[]string{"HINCRBYFLOAT", "user", "score", "2.0e2"}, "5200"},
+		{[]string{"HINCRBYFLOAT", "user", "ratio", "-0.25"}, "-0.25"},
+		{[]string{"HINCRBYFLOAT", "user", "name", "1"}, "ERR hash value is not a float"},
+		{[]string{"HINCRBYFLOAT", "user", "score", "abc"}, "ERR value is not a valid float"},
+		{[]string{"HINCRBYFLOAT", "user", "score", "inf"}, "ERR value is not a valid float"},
+		{[]string{"HSET", "user", "huge", "1.7e308"}, "1"},
+		{[]string{"HINCRBYFLOAT", "user", "huge", "1.7e308"}, "ERR increment would produce NaN or Infinity"},
+		{[]string{"HDEL", "user", "huge", "big", "missing"}, "2"},
+		{[]string{"HDEL", "missing", "name"}, "0"},
+		{[]string{"HSET", "small", "a", "1"}, "1"},
+		{[]string{"HDEL", "small", "a"}, "1"},
+		{[]string{"EXISTS", "small"}, "0"},
+		{[]string{"HLEN", "missing"}, "0"},
+		{[]string{"HKEYS", "missing"}, "[]"},
+	}
+	for _, step := range steps {
+		if v := formatReply(c.do(t, step.command...)); v != step.expected {
+			t.Fatalf("%v: expected %q, got %q", step.command, step.expected, v)
+		}
+	}
+
+	t.Run("HINCRBYFLOAT RESP3", func(t *testing.T) {
+		resp3 := dialTestClient(t, addr)
+		defer resp3.Close()
+		resp3.do(t, "HELLO", "3")
+		if v := resp3.do(t, "HINCRBYFLOAT", "user", "ratio", "1.5"); v.Kind() != ',' || v.Str() != "1.25" {
+			t.Fatalf("expected the double 1.25 in RESP3, got %c %s", v.Kind(), formatReply(v))
+		}
+		if v := c.do(t, "HINCRBYFLOAT", "user", "ratio", "-1"); v.Kind() != '$' || v.Str() != "0.25" {
+			t.Fatalf("expected the bulk string 0.25 in RESP2, got %c %s", v.Kind(), formatReply(v))
+		}
+	})
+
+	t.Run("HRANDFIELD", func(t *testing.T) {
+		c.do(t, "HSET", "letters", "a", "1", "b", "2", "c", "3")
+		if v := c.do(t, "HRANDFIELD", "letters").Str(); v != "a" && v != "b" && v != "c" {
+			t.Fatalf("unexpected field %q", v)
+		}
+		fields := c.do(t, "HRANDFIELD", "letters", "10").Array()
+		seen := make(map[string]bool)
+		for _, f := range fields {
+			seen[f.Str()] = true
+		}
+		if len(fields) != 3 || len(seen) != 3 {
+			t.Fatalf("expected the 3 distinct fields, got %s", formatReply(c.do(t, "HRANDFIELD", "letters", "10")))
+		}
+		if n := len(c.do(t, "HRANDFIELD", "letters", "2").Array()); n != 2 {
+			t.Fatalf("expected 2 fields, got %d", n)
+		}
+		if n := len(c.do(t, "HRANDFIELD", "letters", "-7").Array()); n != 7 {
+			t.Fatalf("expected 7 fields, got %d", n)
+		}
+		pairs := c.do(t, "HRANDFIELD", "letters", "-4", "WITHVALUES").Array()
+		if len(pairs) != 8 {
+			t.Fatalf("expected 4 fields and their values, got %d elements", len(pairs))
+		}
+		letters := map[string]string{"a": "1", "b": "2", "c": "3"}
+		for i := 0; i < len(pairs); i += 2 {
+			if letters[pairs[i].Str()] != pairs[i+1].Str() {
+				t.Fatalf("unexpected pair %s %s", pairs[i].Str(), pairs[i+1].Str())
+			}
+		}
+		for command, expected := range map[string]string{
+			"HRANDFIELD missing":                      "nil",
+			"HRANDFIELD missing 5":                    "[]",
+			"HRANDFIELD letters 0":                    "[]",
+			"HRANDFIELD letters x":                    "ERR value is not an integer or out of range",
+			"HRANDFIELD letters -9223372036854775808": "ERR value is out of range",
+			"HRANDFIELD missing -9223372036854775807": "[]",
+			"HRANDFIELD letters 1 WITHSCORES":         "ERR syntax error",
+			"HRANDFIELD letters 1 WITHVALUES no":      "ERR syntax error",
+		} {
+			if v := formatReply(c.do(t, strings.Fields(command)...)); v != expected {
+				t.Fatalf("%s: expected %q, got %q", command, expected, v)
+			}
+		}
+
+		resp3 := dialTestClient(t, addr)
+		defer resp3.Close()
+		resp3.do(t, "HELLO", "3")
+		pairs = resp3.do(t, "HRANDFIELD", "letters", "2", "WITHVALUES").Array()
+		if len(pairs) != 2 || len(pairs[0].Array()) != 2 {
+			t.Fatalf("expected 2 pairs in RESP3, got %v", pairs)
+		}
+		if v := resp3.do(t, "HGETALL", "letters"); v.Kind() != '%' || len(v.Array()) != 6 {
+			t.Fatalf("expected a map in RESP3, got %c %d", v.Kind(), len(v.Array()))
+		}
+	})
+
+	t.Run("WRONGTYPE", func(t *testing.T) {
+		c.do(t, "SET", "string", "v")
+		c.do(t, "RPUSH", "list", "a")
+		wrongType := errWrongType.Error()
+		for _, command := range [][]string{
+			{"HSET", "string", "f", "v"}, {"HMSET", "list", "f", "v"}, {"HSETNX", "string", "f", "v"},
+			{"HGET", "string", "f"}, {"HMGET", "string", "f"}, {"HDEL", "string", "f"}, {"HGETALL", "list"},
+			{"HINCRBY", "string", "f", "1"}, {"HINCRBYFLOAT", "string", "f", "1"}, {"HEXISTS", "string", "f"},
+			{"HLEN", "string"}, {"HKEYS", "string"}, {"HVALS", "string"}, {"HSTRLEN", "string", "f"},
+			{"HRANDFIELD", "string"}, {"GET", "user"}, {"INCR", "user"}, {"LPUSH", "user", "a"}, {"LLEN", "user"},
+		} {
+			if v := formatReply(c.do(t, command...)); v != wrongType {
+				t.Fatalf("%v: expected %q, got %q", command, wrongType, v)
+			}
+		}
+	})
+
+	t.Run("COPY", func(t *testing.T) {
+		c.do(t, "HSET", "source", "a", "1")
+		c.do(t, "COPY", "source", "copy")
+		c.do(t, "HSET", "copy", "b", "2")
+		if v := formatReply(c.do(t, "HGETALL", "source")); v != "[a 1]" {
+			t.Fatalf("expected the copy not to share the hash, got %s", v)
+		}
+	})
+}
+
+func TestHashReplication(t *testing.T) {
+	_, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	for _, command := range [][]string{
+		{"HSET", "user", "name", "Ada", "visits", "1", "score", "0.1"},
+		{"HMSET", "user", "lang", "en"},
+		{"HSETNX", "user", "city", "London"},
+		{"HINCRBY", "user", "visits", "41"},
+		{"HINCRBYFLOAT", "user", "score", "0.2"},
+		{"HDEL", "user", "lang"},
+		{"HSET", "gone", "a", "1"},
+		{"HDEL", "gone", "a"},
+	} {
+		c.do(t, command...)
+	}
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	values := func(key string) (values string) {
+		replica.execute(func() {
+			replica.useDB(0)
+			if v, ok := replica.Lookup(key); ok {
+				for _, field := range v.hash.Fields() {
+					value, _ := v.hash.Get(field)
+					values += field + "=" + value + " "
+				}
+			}
+		})
+		return
+	}
+	// HINCRBYFLOAT is propagated as the value computed by the master
+	expected := "city=London name=Ada score=" + c.do(t, "HGET", "user", "score").Str() + " visits=42 "
+	if v := values("user"); v != expected {
+		t.Fatalf("expected %q on the replica, got %q", expected, v)
+	}
+	if v := values("gone"); v != "" {
+		t.Fatalf("expected gone to be deleted on the replica, got %q", v)
+	}
+}
+
+func TestHashFieldExpiry(t *testing.T) {
+	srv, addr := startTestServer(t, testConfig())
+	advance := fakeClock(srv)
+	c := dialTestClient(t, addr)
+	defer c.Close()
+	var now int64
+	srv.execute(func() { now = srv.Now().UnixMilli() })
+	at := strconv.FormatInt(now/1000+100, 10)
+
+	steps := []struct {
+		command  string
+		expected string
+	}{
+		{"HSET session user ada token t1 theme dark lang en", "4"},
+		{"HEXPIRE session 10 FIELDS 2 token missing", "[1 -2]"},
+		{"HPEXPIRE session 20000 NX FIELDS 2 token user", "[0 1]"},
+		{"HEXPIRE session 30 XX FIELDS 2 token theme", "[1 0]"},
+		{"HEXPIRE session 5 GT FIELDS 2 token lang", "[0 0]"},
+		{"HEXPIRE session 50 LT FIELDS 2 token lang", "[0 1]"},
+		{"HEXPIREAT session " + at + " FIELDS 1 theme", "[1]"},
+		{"HTTL session FIELDS 5 token user lang theme missing", "[30 20 50 100 -2]"},
+		{"HPTTL session FIELDS 1 user", "[20000]"},
+		{"HEXPIRETIME session FIELDS 2 theme lang", "[" + at + " " + strconv.FormatInt((now+50000+999)/1000, 10) + "]"},
+		{"HPEXPIRETIME session FIELDS 1 user", "[" + strconv.FormatInt(now+20000, 10) + "]"},
+		{"HPERSIST session FIELDS 3 lang user missing", "[1 1 -2]"},
+		{"HPERSIST session FIELDS 1 lang", "[-1]"},
+		{"HTTL missing FIELDS 1 f", "[-2]"},
+		{"HEXPIRE missing 10 FIELDS 1 f", "[-2]"},
+		{"HSET session token t2", "0"},
+		{"HTTL session FIELDS 1 token", "[-1]"},
+		{"HEXPIRE session 0 FIELDS 1 lang", "[2]"},
+		{"HPEXPIREAT session 1 FIELDS 1 user", "[2]"},
+		{"HKEYS session", "[theme token]"},
+		{"HGETEX session PX 1500 FIELDS 2 token missing", "[t2 nil]"},
+		{"HPTTL session FIELDS 1 token", "[1500]"},
+		{"HGETEX session PERSIST FIELDS 1 token", "[t2]"},
+		{"HGETEX session FIELDS 1 token", "[t2]"},
+		{"HTTL session FIELDS 1 token", "[-1]"},
+		{"HGETEX missing EX 10 FIELDS 1 f", "[nil]"},
+		{"HEXPIRE session 10 FIELDS", "ERR wrong number of arguments for 'hexpire' command"},
+		{"HEXPIRE session 10 FIELDS 0 token", "ERR Parameter `numFields` should be greater than 0"},
+		{"HEXPIRE session 10 FIELDS 2 token", "ERR The `numfields` parameter must match the number of arguments"},
+		{"HEXPIRE session 10 NX XX FIELDS 1 token", "ERR Mandatory argument FIELDS is missing or not at the right position"},
+		{"HTTL session FIELD 1 token", "ERR Mandatory argument FIELDS is missing or not at the right position"},
+		{"HEXPIRE session soon FIELDS 1 token", "ERR value is not an integer or out of range"},
+		{"HEXPIRE session -1 FIELDS 1 token", "ERR invalid expire time, must be >= 0"},
+		{"HPEXPIREAT session 70368744177664 FIELDS 1 token", "ERR invalid expire time in 'hpexpireat' command"},
+		{"HGETEX session EX 0 FIELDS 1 token", "ERR invalid expire time in 'hgetex' command"},
+		{"HGETEX session KEEPTTL FIELDS 1 token", "ERR syntax error"},
+		{"HEXPIRE string 10 FIELDS 1 f", errWrongType.Error()},
+	}
+	c.do(t, "SET", "string", "v")
+	for _, step := range steps {
+		if v := formatReply(c.do(t, strings.Fields(step.command)...)); v != step.expected {
+			t.Fatalf("%s: expected %q, got %q", step.command, step.expected, v)
+		}
+	}
+
+	t.Run("lazy expiry", func(t *testing.T) {
+		c.do(t, "HSET", "cart", "apples", "3", "pears", "1", "plums", "2")
+		c.do(t, "HPEXPIRE", "cart", "100", "FIELDS", "2", "apples", "pears")
+		c.do(t, "HPEXPIRE", "cart", "200", "FIELDS", "1", "plums")
+		c.do(t, "HINCRBY", "cart", "apples", "1")
+		advance(100 * time.Millisecond)
+		if v := formatReply(c.do(t, "HGETALL", "cart")); v != "[plums 2]" {
+			t.Fatalf("expected the fields to expire, got %s", v)
+		}
+		advance(100 * time.Millisecond)
+		if v := formatReply(c.do(t, "EXISTS", "cart")); v != "0" {
+			t.Fatalf("expected the key to be removed with its last field, got %s", v)
+		}
+	})
+
+	t.Run("COPY", func(t *testing.T) {
+		c.do(t, "HSET", "source", "a", "1", "b", "2")
+		c.do(t, "HPEXPIRE", "source", "100", "FIELDS", "1", "a")
+		c.do(t, "COPY", "source", "copy")
+		c.do(t, "HPERSIST", "copy", "FIELDS", "1", "a")
+		advance(100 * time.Millisecond)
+		if v := formatReply(c.do(t, "HKEYS", "source")); v != "[b]" {
+			t.Fatalf("expected the field to expire in the source, got %s", v)
+		}
+		if v := formatReply(c.do(t, "HKEYS", "copy")); v != "[a b]" {
+			t.Fatalf("expected the copy not to share the expiry times, got %s", v)
+		}
+	})
+}
+
+func TestHashFieldExpiryReplication(t *testing.T) {
+	cfg := testConfig()
+	cfg.Hz = 1
+	master, masterAddr := startTestServer(t, cfg)
+	advance := fakeClock(master)
+	cfg = testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	for _, command := range []string{
+		"HSET session user ada token t1 score 1.5 theme dark",
+		"HEXPIRE session 60 FIELDS 2 token score",
+		"HINCRBYFLOAT session score 1",
+		"HGETEX session PX 120000 FIELDS 1 theme",
+		"HSET gone a 1",
+		"HPEXPIRE gone 60000 FIELDS 1 a",
+	} {
+		c.do(t, strings.Fields(command)...)
+	}
+	expected := c.do(t, "HPEXPIRETIME", "session", "FIELDS", "4", "user", "token", "score", "theme")
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	fields := func(key string) (fields string) {
+		replica.execute(func() {
+			replica.useDB(0)
+			if v, ok := replica.Lookup(key); ok {
+				for _, field := range v.hash.Fields() {
+					fields += field + "@" + strconv.FormatUint(v.hash.Expiry(field), 10) + " "
+				}
+			}
+		})
+		return
+	}
+	// The replica gets the absolute expiry times of the master
+	e := expected.Array()
+	want := fmt.Sprintf("score@%d theme@%d token@%d user@0 ", e[2].Int(), e[3].Int(), e[1].Int())
+	if v := fields("session"); v != want {
+		t.Fatalf("expected %q on the replica, got %q", want, v)
+	}
+
+	// The replica's own clock doesn't move, only the HDEL of the master removes the fields
+	advance(time.Minute)
+	if v := formatReply(c.do(t, "HKEYS", "session")); v != "[theme user]" {
+		t.Fatalf("expected the fields to expire on the master, got %s", v)
+	}
+	c.do(t, "EXISTS", "gone")
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+	if v := fields("session"); v != fmt.Sprintf("theme@%d user@0 ", e[3].Int()) {
+		t.Fatalf("expected the expired fields to be deleted on the replica, got %q", v)
+	}
+	if v := fields("gone"); v != "" {
+		t.Fatalf("expected the key to be deleted on the replica, got %q", v)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"container/heap"
+	"maps"
+	"slices"
+)
+
+/*
+Hash is the value of a hash: its fields and their values.
+The fields given an expiry time by HEXPIRE and the like are kept in a TTL index like the one of the keys,
+the cache deletes them once they expired, see CacheImpl.expireFields.
+*/
+type Hash struct {
+	fields   map[string]string
+	expires  expireHeap              // TTL index of the fields with an expiry time
+	expiring map[string]*expireEntry // entries of the TTL index by field
+}
+
+func NewHash() *Hash {
+	return &Hash{fields: make(map[string]string), expiring: make(map[string]*expireEntry)}
+}
+
+func (h *Hash) Len() int {
+	return len(h.fields)
+}
+
+func (h *Hash) Get(field string) (string, bool) {
+	value, ok := h.fields[field]
+	return value, ok
+}
+
+// Sets the value of a field and returns true if it was added, the expiry time of the field is removed unless keepTTL
+func (h *Hash) Set(field, value string, keepTTL bool) bool {
+	_, exists := h.fields[field]
+	h.fields[field] = value
+	if !keepTTL {
+		h.SetExpiry(field, 0)
+	}
+	return !exists
+}
+
+// Deletes a field, false if it does not exist
+func (h *Hash) Delete(field string) bool {
+	if _, ok := h.fields[field]; !ok {
+		return false
+	}
+	delete(h.fields, field)
+	h.SetExpiry(field, 0)
+	return true
+}
+
+// Returns the fields sorted, so that HKEYS, HVALS and HGETALL list them in the same order
+func (h *Hash) Fields() []string {
+	fields := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// Returns the expiry time of a field in milliseconds, 0 if it has none
+func (h *Hash) Expiry(field string) uint64 {
+	if entry, ok := h.expiring[field]; ok {
+		return entry.at
+	}
+	return 0
+}
+
+// Sets the expiry time of a field in milliseconds, 0 removes it
+func (h *Hash) SetExpiry(field string, at uint64) {
+	setExpiry(&h.expires, h.expiring, field, at)
+}
+
+// Returns the first expiry time of the fields, 0 if none has one
+func (h *Hash) nextExpiry() uint64 {
+	if len(h.expires) == 0 {
+		return 0
+	}
+	return h.expires[0].at
+}
+
+// Deletes the fields whose expiry time is now or earlier and returns them, in order of expiry time
+func (h *Hash) expire(now uint64) []string {
+	var expired []string
+	for len(h.expires) > 0 && h.expires[0].at <= now {
+		entry := heap.Pop(&h.expires).(*expireEntry)
+		delete(h.expiring, entry.key)
+		delete(h.fields, entry.key)
+		expired = append(expired, entry.key)
+	}
+	return expired
+}
+
+// Returns a copy of the hash that doesn't share its fields or their expiry times
+func (h *Hash) clone() *Hash {
+	c := NewHash()
+	c.fields = maps.Clone(h.fields)
+	for field, entry := range h.expiring {
+		c.SetExpiry(field, entry.at)
+	}
+	return c
+}
@@ -0,0 +1,612 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Parses an integer argument, returns the error reply if it isn't one
+func parseInteger(arg string) (int, []byte) {
+	i, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, newSimpleError("ERR value is not an integer or out of range")
+	}
+	return i, nil
+}
+
+/*
+Returns the list of a key, nil if the key does not exist.
+Returns the WRONGTYPE error reply if the key holds another type.
+*/
+func (r *ReqHandlerImpl) lookupList(key string) (*Quicklist, []byte) {
+	v, ok := r.server.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if v.list == nil {
+		return nil, newSimpleError(errWrongType.Error())
+	}
+	return v.list, nil
+}
+
+// Deletes the key of a list left empty, Redis doesn't keep empty lists
+func (r *ReqHandlerImpl) deleteIfEmpty(key string, l *Quicklist) {
+	if l.Len() == 0 {
+		r.server.Del([]string{key})
+	}
+}
+
+// Converts an index counted from the tail when negative, -1 being the last element, to an index from the head
+func listIndex(index, length int) int {
+	if index < 0 {
+		return index + length
+	}
+	return index
+}
+
+// Converts the start and stop of LRANGE and LTRIM to indexes within the list, start > stop if the range is empty
+func listRange(start, stop, length int) (int, int) {
+	start, stop = max(listIndex(start, length), 0), min(listIndex(stop, length), length-1)
+	if start >= length {
+		return 1, 0
+	}
+	return start, stop
+}
+
+/*
+LPUSH key element [element ...], RPUSH, LPUSHX and RPUSHX.
+Adds the elements at the head, or at the tail for RPUSH, creating the list if the key does not exist
+unless onlyExisting: LPUSHX and RPUSHX don't create it. Replies the length of the list.
+*/
+func (r *ReqHandlerImpl) push(req *Request, tail, onlyExisting bool) []byte {
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		if onlyExisting {
+			return newInteger(0)
+		}
+		l = NewQuicklist()
+		r.server.Put(req.args[0], Object{list: l})
+	}
+	for _, element := range req.args[1:] {
+		if tail {
+			l.PushTail(element)
+		} else {
+			l.PushHead(element)
+		}
+	}
+	r.server.signalKeyAsReady(r.client.db, req.args[0])
+	return newInteger(l.Len())
+}
+
+func (r *ReqHandlerImpl) lpush(req *Request) []byte {
+	return r.push(req, false, false)
+}
+
+func (r *ReqHandlerImpl) rpush(req *Request) []byte {
+	return r.push(req, true, false)
+}
+
+func (r *ReqHandlerImpl) lpushx(req *Request) []byte {
+	return r.push(req, false, true)
+}
+
+func (r *ReqHandlerImpl) rpushx(req *Request) []byte {
+	return r.push(req, true, true)
+}
+
+/*
+LPOP key [count] and RPOP, removes the first or the last elements.
+Without count the element is replied, nil if the key doesn't exist,
+with count an array of up to count elements is replied, a nil array if the key doesn't exist.
+*/
+func (r *ReqHandlerImpl) pop(req *Request, tail bool) []byte {
+	if len(req.args) > 2 {
+		return newSimpleError("ERR syntax error")
+	}
+	count := -1
+	if len(req.args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(req.args[1]); err != nil || count < 0 {
+			return newSimpleError("ERR value is out of range, must be positive")
+		}
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		if count >= 0 {
+			return r.encoder().NullArray()
+		}
+		return r.encoder().Null()
+	}
+	n := min(count, l.Len())
+	if count == -1 {
+		n = 1
+	}
+	popped := make([]string, 0, n)
+	for range n {
+		popped = append(popped, popElement(l, tail))
+	}
+	r.deleteIfEmpty(req.args[0], l)
+	if count == -1 {
+		return newBulkString(popped[0])
+	}
+	return newBulkArray(popped...)
+}
+
+// Pops the first element of a list that isn't empty, or its last one if tail
+func popElement(l *Quicklist, tail bool) string {
+	if tail {
+		element, _ := l.PopTail()
+		return element
+	}
+	element, _ := l.PopHead()
+	return element
+}
+
+func (r *ReqHandlerImpl) lpop(req *Request) []byte {
+	return r.pop(req, false)
+}
+
+func (r *ReqHandlerImpl) rpop(req *Request) []byte {
+	return r.pop(req, true)
+}
+
+// LLEN key
+func (r *ReqHandlerImpl) llen(req *Request) []byte {
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return newInteger(0)
+	}
+	return newInteger(l.Len())
+}
+
+// LRANGE key start stop, negative indexes count from the tail and the range is clamped to the list
+func (r *ReqHandlerImpl) lrange(req *Request) []byte {
+	start, reply := parseInteger(req.args[1])
+	if reply != nil {
+		return reply
+	}
+	stop, reply := parseInteger(req.args[2])
+	if reply != nil {
+		return reply
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return newBulkArray()
+	}
+	start, stop = listRange(start, stop, l.Len())
+	return newBulkArray(l.Range(start, stop)...)
+}
+
+// LINDEX key index
+func (r *ReqHandlerImpl) lindex(req *Request) []byte {
+	index, reply := parseInteger(req.args[1])
+	if reply != nil {
+		return reply
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return r.encoder().Null()
+	}
+	element, ok := l.Index(listIndex(index, l.Len()))
+	if !ok {
+		return r.encoder().Null()
+	}
+	return newBulkString(element)
+}
+
+// LSET key index element
+func (r *ReqHandlerImpl) lset(req *Request) []byte {
+	index, reply := parseInteger(req.args[1])
+	if reply != nil {
+		return reply
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return newSimpleError("ERR no such key")
+	}
+	if !l.Set(listIndex(index, l.Len()), req.args[2]) {
+		return newSimpleError("ERR index out of range")
+	}
+	return newSimpleString("OK")
+}
+
+/*
+LREM key count element, replies the number of elements removed.
+count > 0 removes the first count elements equal to element, count < 0 the last ones, 0 all of them.
+*/
+func (r *ReqHandlerImpl) lrem(req *Request) []byte {
+	count, reply := parseInteger(req.args[1])
+	if reply != nil {
+		return reply
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return newInteger(0)
+	}
+	removed := l.Remove(count, req.args[2])
+	r.deleteIfEmpty(req.args[0], l)
+	return newInteger(removed)
+}
+
+// LTRIM key start stop, keeps the elements of the range, the key is deleted if the range is empty
+func (r *ReqHandlerImpl) ltrim(req *Request) []byte {
+	start, reply := parseInteger(req.args[1])
+	if reply != nil {
+		return reply
+	}
+	stop, reply := parseInteger(req.args[2])
+	if reply != nil {
+		return reply
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return newSimpleString("OK")
+	}
+	start, stop = listRange(start, stop, l.Len())
+	l.Trim(start, stop)
+	r.deleteIfEmpty(req.args[0], l)
+	return newSimpleString("OK")
+}
+
+// LINSERT key BEFORE | AFTER pivot element, replies the length of the list, -1 if pivot isn't found
+func (r *ReqHandlerImpl) linsert(req *Request) []byte {
+	var after bool
+	switch strings.ToUpper(req.args[1]) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		return newSimpleError("ERR syntax error")
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if l == nil {
+		return newInteger(0)
+	}
+	if !l.Insert(req.args[2], req.args[3], after) {
+		return newInteger(-1)
+	}
+	return newInteger(l.Len())
+}
+
+/*
+LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+
+Replies the index of the first element equal to element, or of the rank-th one, counted from the tail if rank is negative.
+With COUNT an array of up to num-matches indexes is replied, all of them if it is 0.
+MAXLEN limits the number of elements looked at, 0 looks at all of them.
+*/
+func (r *ReqHandlerImpl) lpos(req *Request) []byte {
+	rank, count, maxLen := 1, -1, 0
+	for i := 2; i < len(req.args); i += 2 {
+		if i+1 == len(req.args) {
+			return newSimpleError("ERR syntax error")
+		}
+		value, reply := parseInteger(req.args[i+1])
+		if reply != nil {
+			return reply
+		}
+		switch strings.ToUpper(req.args[i]) {
+		case "RANK":
+			if value == 0 {
+				return newSimpleError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = value
+		case "COUNT":
+			if value < 0 {
+				return newSimpleError("ERR COUNT can't be negative")
+			}
+			count = value
+		case "MAXLEN":
+			if value < 0 {
+				return newSimpleError("ERR MAXLEN can't be negative")
+			}
+			maxLen = value
+		default:
+			return newSimpleError("ERR syntax error")
+		}
+	}
+	l, reply := r.lookupList(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	matches := make([]int, 0)
+	if l != nil {
+		skip, seen := max(rank, -rank)-1, 0
+		l.Iterate(rank < 0, func(index int, element string) bool {
+			if maxLen > 0 && seen == maxLen {
+				return false
+			}
+			seen++
+			if element != req.args[1] {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			matches = append(matches, index)
+			return count == 0 || len(matches) < max(count, 1)
+		})
+	}
+	if count >= 0 {
+		replies := make([]string, 0, len(matches))
+		for _, index := range matches {
+			replies = append(replies, string(newInteger(index)))
+		}
+		return newBulkArrayOfArrays(replies...)
+	}
+	if len(matches) == 0 {
+		return r.encoder().Null()
+	}
+	return newInteger(matches[0])
+}
+
+/*
+Pops an element from the head of source, or its tail if fromTail, and pushes it to destination.
+Returns a nil element with a nil reply if source doesn't exist, the error reply if a key holds another type.
+*/
+func (r *ReqHandlerImpl) moveElement(source, destination string, fromTail, toTail bool) (*string, []byte) {
+	src, reply := r.lookupList(source)
+	if reply != nil || src == nil {
+		return nil, reply
+	}
+	dst, reply := r.lookupList(destination)
+	if reply != nil {
+		return nil, reply
+	}
+	var element string
+	if fromTail {
+		element, _ = src.PopTail()
+	} else {
+		element, _ = src.PopHead()
+	}
+	// The element may go back to the same list, which is then never empty
+	if dst == nil {
+		dst = NewQuicklist()
+		r.server.Put(destination, Object{list: dst})
+	}
+	if toTail {
+		dst.PushTail(element)
+	} else {
+		dst.PushHead(element)
+	}
+	r.server.signalKeyAsReady(r.client.db, destination)
+	r.deleteIfEmpty(source, src)
+	return &element, nil
+}
+
+// Parses the LEFT or RIGHT argument of LMOVE, true for RIGHT
+func parseListSide(arg string) (bool, bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return false, true
+	case "RIGHT":
+		return true, true
+	}
+	return false, false
+}
+
+// LMOVE source destination LEFT | RIGHT LEFT | RIGHT, replies the element moved, nil if source doesn't exist
+func (r *ReqHandlerImpl) lmove(req *Request) []byte {
+	fromTail, ok := parseListSide(req.args[2])
+	toTail, ok2 := parseListSide(req.args[3])
+	if !ok || !ok2 {
+		return newSimpleError("ERR syntax error")
+	}
+	element, reply := r.moveElement(req.args[0], req.args[1], fromTail, toTail)
+	if reply != nil {
+		return reply
+	}
+	if element == nil {
+		return r.encoder().Null()
+	}
+	return newBulkString(*element)
+}
+
+// RPOPLPUSH source destination, the same as LMOVE source destination RIGHT LEFT
+func (r *ReqHandlerImpl) rpoplpush(req *Request) []byte {
+	element, reply := r.moveElement(req.args[0], req.args[1], true, false)
+	if reply != nil {
+		return reply
+	}
+	if element == nil {
+		return r.encoder().Null()
+	}
+	return newBulkString(*element)
+}
+
+/*
+BLPOP key [key ...] timeout and BRPOP.
+Pops an element from the first of the lists that isn't empty and replies the key and the element,
+or blocks until an element is pushed to one of them, a nil array is replied after the timeout.
+The pop is propagated as LPOP or RPOP.
+*/
+func (r *ReqHandlerImpl) blockingPop(req *Request, tail bool) []byte {
+	timeout, reply := parseBlockingTimeout(req.args[len(req.args)-1])
+	if reply != nil {
+		return reply
+	}
+	command := "LPOP"
+	if tail {
+		command = "RPOP"
+	}
+	serve := func(key string) []byte {
+		l, reply := r.lookupList(key)
+		if reply != nil || l == nil {
+			return reply
+		}
+		element := popElement(l, tail)
+		r.deleteIfEmpty(key, l)
+		r.propagate(NewRequest(command, key))
+		return newBulkArray(key, element)
+	}
+	return r.blockOnKeys(req.args[:len(req.args)-1], timeout, serve, r.encoder().NullArray)
+}
+
+func (r *ReqHandlerImpl) blpop(req *Request) []byte {
+	return r.blockingPop(req, false)
+}
+
+func (r *ReqHandlerImpl) brpop(req *Request) []byte {
+	return r.blockingPop(req, true)
+}
+
+/*
+Moves an element like LMOVE, or blocks until an element is pushed to source, nil is replied after the timeout.
+The move is propagated as the request propagated, an LMOVE or an RPOPLPUSH.
+*/
+func (r *ReqHandlerImpl) blockingMove(source, destination string, fromTail, toTail bool, timeoutArg string, propagated *Request) []byte {
+	timeout, reply := parseBlockingTimeout(timeoutArg)
+	if reply != nil {
+		return reply
+	}
+	serve := func(key string) []byte {
+		element, reply := r.moveElement(source, destination, fromTail, toTail)
+		if reply != nil || element == nil {
+			return reply
+		}
+		r.propagate(propagated)
+		return newBulkString(*element)
+	}
+	return r.blockOnKeys([]string{source}, timeout, serve, r.encoder().Null)
+}
+
+// BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout
+func (r *ReqHandlerImpl) blmove(req *Request) []byte {
+	fromTail, ok := parseListSide(req.args[2])
+	toTail, ok2 := parseListSide(req.args[3])
+	if !ok || !ok2 {
+		return newSimpleError("ERR syntax error")
+	}
+	return r.blockingMove(req.args[0], req.args[1], fromTail, toTail, req.args[4], NewRequest("LMOVE", req.args[:4]...))
+}
+
+// BRPOPLPUSH source destination timeout, the same as BLMOVE source destination RIGHT LEFT timeout
+func (r *ReqHandlerImpl) brpoplpush(req *Request) []byte {
+	return r.blockingMove(req.args[0], req.args[1], true, false, req.args[2], NewRequest("RPOPLPUSH", req.args[:2]...))
+}
+
+// The keys of LMPOP numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func lmpopKeys(args []string) []string {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 1 || numKeys+2 > len(args) {
+		return nil
+	}
+	return args[1 : 1+numKeys]
+}
+
+// The keys of BLMPOP timeout numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func blmpopKeys(args []string) []string {
+	return lmpopKeys(args[1:])
+}
+
+/*
+Parses numkeys key [key ...] LEFT | RIGHT [COUNT count], the arguments of LMPOP and BLMPOP,
+returns the keys, true for RIGHT and the count, 1 if not given, or the error reply.
+*/
+func parseMultiPopArgs(args []string) ([]string, bool, int, []byte) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 1 {
+		return nil, false, 0, newSimpleError("ERR numkeys should be greater than 0")
+	}
+	if numKeys+2 > len(args) {
+		return nil, false, 0, newSimpleError("ERR syntax error")
+	}
+	keys, rest := args[1:1+numKeys], args[1+numKeys:]
+	tail, ok := parseListSide(rest[0])
+	if !ok {
+		return nil, false, 0, newSimpleError("ERR syntax error")
+	}
+	count := 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.EqualFold(rest[1], "COUNT"):
+		if count, err = strconv.Atoi(rest[2]); err != nil || count < 1 {
+			return nil, false, 0, newSimpleError("ERR count should be greater than 0")
+		}
+	default:
+		return nil, false, 0, newSimpleError("ERR syntax error")
+	}
+	return keys, tail, count, nil
+}
+
+/*
+Returns the function popping up to count elements from a key for LMPOP and BLMPOP,
+its reply is the key and the elements, nil if the key doesn't exist. The pop is propagated as LPOP or RPOP with a count.
+*/
+func (r *ReqHandlerImpl) multiPop(tail bool, count int) func(key string) []byte {
+	command := "LPOP"
+	if tail {
+		command = "RPOP"
+	}
+	return func(key string) []byte {
+		l, reply := r.lookupList(key)
+		if reply != nil || l == nil {
+			return reply
+		}
+		n := min(count, l.Len())
+		elements := make([]string, 0, n)
+		for range n {
+			elements = append(elements, popElement(l, tail))
+		}
+		r.deleteIfEmpty(key, l)
+		r.propagate(NewRequest(command, key, strconv.Itoa(len(elements))))
+		return newBulkArrayOfArrays(string(newBulkString(key)), string(newBulkArray(elements...)))
+	}
+}
+
+// LMPOP numkeys key [key ...] LEFT | RIGHT [COUNT count], pops from the first of the lists that isn't empty
+func (r *ReqHandlerImpl) lmpop(req *Request) []byte {
+	keys, tail, count, reply := parseMultiPopArgs(req.args)
+	if reply != nil {
+		return reply
+	}
+	r.preventPropagation = true
+	serve := r.multiPop(tail, count)
+	for _, key := range keys {
+		if reply := serve(key); reply != nil {
+			return reply
+		}
+	}
+	return r.encoder().NullArray()
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT | RIGHT [COUNT count], LMPOP blocking until one of the lists gets an element
+func (r *ReqHandlerImpl) blmpop(req *Request) []byte {
+	timeout, reply := parseBlockingTimeout(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	keys, tail, count, reply := parseMultiPopArgs(req.args[1:])
+	if reply != nil {
+		return reply
+	}
+	return r.blockOnKeys(keys, timeout, r.multiPop(tail, count), r.encoder().NullArray)
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// Checks the quicklist against a slice, with enough elements to span many nodes
+func TestQuicklist(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := NewQuicklist()
+	expected := make([]string, 0)
+	check := func(op string) {
+		t.Helper()
+		if l.Len() != len(expected) || fmt.Sprint(l.Values()) != fmt.Sprint(expected) {
+			t.Fatalf("after %s: expected %d elements %v, got %d %v", op, len(expected), expected, l.Len(), l.Values())
+		}
+		for n := l.head; n != nil; n = n.next {
+			if len(n.elements) == 0 || len(n.elements) > QUICKLIST_NODE_SIZE {
+				t.Fatalf("after %s: unexpected node of %d elements", op, len(n.elements))
+			}
+		}
+	}
+	for i := 0; i < 5000; i++ {
+		value := strconv.Itoa(rng.Intn(20))
+		switch op := rng.Intn(10); {
+		case op < 3:
+			l.PushHead(value)
+			expected = append([]string{value}, expected...)
+		case op < 6:
+			l.PushTail(value)
+			expected = append(expected, value)
+		case op == 6 && len(expected) > 0:
+			v, _ := l.PopHead()
+			if v != expected[0] {
+				t.Fatalf("expected to pop %s, got %s", expected[0], v)
+			}
+			expected = expected[1:]
+		case op == 7 && len(expected) > 0:
+			v, _ := l.PopTail()
+			if v != expected[len(expected)-1] {
+				t.Fatalf("expected to pop %s, got %s", expected[len(expected)-1], v)
+			}
+			expected = expected[:len(expected)-1]
+		case op == 8:
+			pivot := strconv.Itoa(rng.Intn(20))
+			if i := slices.Index(expected, pivot); i >= 0 {
+				expected = slices.Insert(expected, i+1, value)
+			}
+			l.Insert(pivot, value, true)
+		case op == 9 && len(expected) > 0:
+			index := rng.Intn(len(expected))
+			if v, ok := l.Index(index); !ok || v != expected[index] {
+				t.Fatalf("expected %s at %d, got %s", expected[index], index, v)
+			}
+			l.Set(index, value)
+			expected[index] = value
+		}
+		if l.Len() != len(expected) {
+			t.Fatalf("after operation %d: expected %d elements, got %d", i, len(expected), l.Len())
+		}
+		if i%100 == 0 {
+			check("operation " + strconv.Itoa(i))
+		}
+	}
+	check("the operations")
+
+	if _, ok := l.Index(len(expected)); ok {
+		t.Fatalf("expected an out of range index")
+	}
+	if r := l.Range(100, 400); fmt.Sprint(r) != fmt.Sprint(expected[100:401]) {
+		t.Fatalf("unexpected range %v", r)
+	}
+	reversed := make([]string, 0)
+	l.Iterate(true, func(index int, value string) bool {
+		if value != expected[index] {
+			t.Fatalf("expected %s at %d, got %s", expected[index], index, value)
+		}
+		reversed = append(reversed, value)
+		return true
+	})
+	if len(reversed) != len(expected) {
+		t.Fatalf("expected to iterate over %d elements, got %d", len(expected), len(reversed))
+	}
+
+	c := l.clone()
+	c.Set(0, "cloned")
+	if v, _ := l.Index(0); v == "cloned" {
+		t.Fatalf("expected the clone not to share its nodes")
+	}
+
+	removeFrom := func(count int, value string) {
+		removed, kept := 0, make([]string, 0)
+		if count < 0 {
+			slices.Reverse(expected)
+		}
+		for _, v := range expected {
+			if v == value && (count == 0 || removed < max(count, -count)) {
+				removed++
+				continue
+			}
+			kept = append(kept, v)
+		}
+		if count < 0 {
+			slices.Reverse(kept)
+		}
+		expected = kept
+		if n := l.Remove(count, value); n != removed {
+			t.Fatalf("expected %d elements removed, got %d", removed, n)
+		}
+		check(fmt.Sprintf("Remove(%d, %s)", count, value))
+	}
+	removeFrom(3, "1")
+	removeFrom(-5, "2")
+	removeFrom(0, "3")
+
+	l.Trim(150, len(expected)-200)
+	expected = expected[150 : len(expected)-199]
+	check("Trim")
+	l.Trim(1, 0)
+	expected = expected[:0]
+	check("Trim of everything")
+}
+
+func TestListCommands(t *testing.T) {
+	_, addr := startTestServer(t, testConfig())
+	c := dialTestClient(t, addr)
+	defer c.Close()
+
+	steps := []struct {
+		command  []string
+		expected string
+	}{
+		{[]string{"RPUSH", "list", "a", "b", "c"}, "3"},
+		{[]string{"LPUSH", "list", "z", "y"}, "5"},
+		{[]string{"TYPE", "list"}, "list"},
+		{[]string{"LLEN", "list"}, "5"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "[y z a b c]"},
+		{[]string{"LRANGE", "list", "-2", "100"}, "[b c]"},
+		{[]string{"LRANGE", "list", "-100", "1"}, "[y z]"},
+		{[]string{"LRANGE", "list", "3", "1"}, "[]"},
+		{[]string{"LRANGE", "list", "5", "10"}, "[]"},
+		{[]string{"LRANGE", "list", "a", "1"}, "ERR value is not an integer or out of range"},
+		{[]string{"LINDEX", "list", "0"}, "y"},
+		{[]string{"LINDEX", "list", "-1"}, "c"},
+		{[]string{"LINDEX", "list", "5"}, "nil"},
+		{[]string{"LINDEX", "list", "-6"}, "nil"},
+		{[]string{"LSET", "list", "-2", "B"}, "OK"},
+		{[]string{"LSET", "list", "5", "x"}, "ERR index out of range"},
+		{[]string{"LSET", "missing", "0", "x"}, "ERR no such key"},
+		{[]string{"LINSERT", "list", "BEFORE", "a", "x"}, "6"},
+		{[]string{"LINSERT", "list", "after", "c", "x"}, "7"},
+		{[]string{"LINSERT", "list", "AFTER", "none", "x"}, "-1"},
+		{[]string{"LINSERT", "missing", "AFTER", "a", "x"}, "0"},
+		{[]string{"LINSERT", "list", "NEAR", "a", "x"}, "ERR syntax error"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "[y z x a B c x]"},
+		{[]string{"LPOS", "list", "x"}, "2"},
+		{[]string{"LPOS", "list", "x", "RANK", "2"}, "6"},
+		{[]string{"LPOS", "list", "x", "RANK", "-1"}, "6"},
+		{[]string{"LPOS", "list", "x", "COUNT", "0"}, "[2 6]"},
+		{[]string{"LPOS", "list", "x", "RANK", "-1", "COUNT", "5"}, "[6 2]"},
+		{[]string{"LPOS", "list", "x", "MAXLEN", "2"}, "nil"},
+		{[]string{"LPOS", "list", "x", "COUNT", "1", "MAXLEN", "3"}, "[2]"},
+		{[]string{"LPOS", "list", "none"}, "nil"},
+		{[]string{"LPOS", "missing", "x", "COUNT", "0"}, "[]"},
+		{[]string{"LPOS", "list", "x", "RANK", "0"}, "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"},
+		{[]string{"LPOS", "list", "x", "COUNT", "-1"}, "ERR COUNT can't be negative"},
+		{[]string{"LPOS", "list", "x", "MAXLEN"}, "ERR syntax error"},
+		{[]string{"LREM", "list", "-1", "x"}, "1"},
+		{[]string{"LREM", "list", "0", "none"}, "0"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "[y z x a B c]"},
+		{[]string{"LPOP", "list"}, "y"},
+		{[]string{"RPOP", "list"}, "c"},
+		{[]string{"LPOP", "list", "2"}, "[z x]"},
+		{[]string{"RPOP", "list", "0"}, "[]"},
+		{[]string{"RPOP", "list", "-1"}, "ERR value is out of range, must be positive"},
+		{[]string{"LPOP", "missing"}, "nil"},
+		{[]string{"LPOP", "missing", "1"}, "nil"},
+		{[]string{"RPOP", "list", "5"}, "[B a]"},
+		{[]string{"EXISTS", "list"}, "0"},
+		{[]string{"LPUSHX", "list", "a"}, "0"},
+		{[]string{"RPUSH", "list", "a", "b", "c", "d"}, "4"},
+		{[]string{"RPUSHX", "list", "e"}, "5"},
+		{[]string{"LPUSHX", "list", "0"}, "6"},
+		{[]string{"LTRIM", "list", "1", "-2"}, "OK"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "[a b c d]"},
+		{[]string{"LMOVE", "list", "other", "RIGHT", "LEFT"}, "d"},
+		{[]string{"LMOVE", "list", "other", "LEFT", "RIGHT"}, "a"},
+		{[]string{"LMOVE", "list", "list", "LEFT", "RIGHT"}, "b"},
+		{[]string{"LMOVE", "list", "other", "UP", "LEFT"}, "ERR syntax error"},
+		{[]string{"LMOVE", "missing", "other", "LEFT", "LEFT"}, "nil"},
+		{[]string{"RPOPLPUSH", "other", "list"}, "a"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "[a c b]"},
+		{[]string{"LRANGE", "other", "0", "-1"}, "[d]"},
+		{[]string{"LTRIM", "other", "1", "0"}, "OK"},
+		{[]string{"EXISTS", "other"}, "0"},
+		{[]string{"LREM", "list", "0", "a"}, "1"},
+		{[]string{"LREM", "list", "0", "b"}, "1"},
+		{[]string{"LREM", "list", "0", "c"}, "1"},
+		{[]string{"EXISTS", "list"}, "0"},
+	}
+	for _, step := range steps {
+		if v := formatReply(c.do(t, step.command...)); v != step.expected {
+			t.Fatalf("%v: expected %q, got %q", step.command, step.expected, v)
+		}
+	}
+
+	t.Run("WRONGTYPE", func(t *testing.T) {
+		c.do(t, "SET", "string", "v")
+		c.do(t, "RPUSH", "list", "a")
+		wrongType := errWrongType.Error()
+		for _, command := range [][]string{
+			{"LPUSH", "string", "a"}, {"RPUSHX", "string", "a"}, {"LPOP", "string"}, {"LLEN", "string"},
+			{"LRANGE", "string", "0", "-1"}, {"LINDEX", "string", "0"}, {"LSET", "string", "0", "a"},
+			{"LREM", "string", "0", "a"}, {"LTRIM", "string", "0", "1"}, {"LINSERT", "string", "BEFORE", "a", "b"},
+			{"LPOS", "string", "a"}, {"LMOVE", "string", "list", "LEFT", "LEFT"}, {"LMOVE", "list", "string", "LEFT", "LEFT"},
+			{"GET", "list"}, {"INCR", "list"},
+		} {
+			if v := formatReply(c.do(t, command...)); v != wrongType {
+				t.Fatalf("%v: expected %q, got %q", command, wrongType, v)
+			}
+		}
+		if v := formatReply(c.do(t, "LRANGE", "list", "0", "-1")); v != "[a]" {
+			t.Fatalf("expected the failed LMOVE to keep the source, got %s", v)
+		}
+		if v := formatReply(c.do(t, "SET", "list", "v", "NX")); v != "nil" {
+			t.Fatalf("expected SET NX not to overwrite the list, got %s", v)
+		}
+		if v := formatReply(c.do(t, "SET", "list", "v")); v != "OK" {
+			t.Fatalf("expected SET to overwrite the list, got %s", v)
+		}
+	})
+
+	t.Run("COPY", func(t *testing.T) {
+		c.do(t, "RPUSH", "source", "a", "b")
+		c.do(t, "COPY", "source", "copy")
+		c.do(t, "RPUSH", "copy", "c")
+		if v := formatReply(c.do(t, "LRANGE", "source", "0", "-1")); v != "[a b]" {
+			t.Fatalf("expected the copy not to share the list, got %s", v)
+		}
+	})
+}
+
+func TestListReplication(t *testing.T) {
+	_, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	for _, command := range [][]string{
+		{"RPUSH", "jobs", "1", "2", "3", "4", "5"},
+		{"LPOP", "jobs"},
+		{"LMOVE", "jobs", "running", "LEFT", "RIGHT"},
+		{"LSET", "jobs", "0", "three"},
+		{"LINSERT", "jobs", "AFTER", "three", "3.5"},
+		{"LTRIM", "jobs", "0", "2"},
+		{"RPUSH", "done", "x"},
+		{"RPOP", "done"},
+	} {
+		c.do(t, command...)
+	}
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	values := func(key string) (values string) {
+		replica.execute(func() {
+			replica.useDB(0)
+			if v, ok := replica.Lookup(key); ok {
+				values = fmt.Sprint(v.list.Values())
+			}
+		})
+		return
+	}
+	for key, expected := range map[string]string{"jobs": "[three 3.5 4]", "running": "[2]", "done": ""} {
+		if v := values(key); v != expected {
+			t.Fatalf("%s: expected %q on the replica, got %q", key, expected, v)
+		}
+	}
+}
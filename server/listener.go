@@ -0,0 +1,163 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const PROTECTED_MODE_ERROR = "DENIED Redis is running in protected mode because protected mode is enabled and no password is set for the default user. " +
+	"In this mode connections are only accepted from the loopback interface. " +
+	"If you want to connect from external computers to Redis you may adopt one of the following solutions: " +
+	"1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from the loopback interface by connecting to Redis from the same host the server is running, " +
+	"however MAKE SURE Redis is not publicly accessible from internet if you do so. Use CONFIG REWRITE to make this change permanent. " +
+	"2) Alternatively you can just disable the protected mode by editing the Redis configuration file, and setting the protected mode option to 'no', and then restarting the server. " +
+	"3) If you started the server manually just for testing, restart it with the '--protected-mode no' option. " +
+	"4) Set up an authentication password for the default user. " +
+	"NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside."
+
+/*
+Sets the bind addresses and the protected mode option.
+Several addresses can be given, each one gets its own listener:
+
+	--bind 127.0.0.1 ::1 -192.168.1.10
+
+"*" stands for every IPv4 interface, "::*" for every IPv6 interface,
+an address prefixed with "-" is optional: failing to bind it isn't fatal.
+*/
+func (s *RedisServerImpl) setBind(cfg Config) {
+	s.bindAddresses = append([]string{}, cfg.Bind...)
+	if len(s.bindAddresses) == 0 {
+		s.bindAddresses = []string{SERVER_ADDR}
+	}
+	s.protectedMode = cfg.ProtectedMode
+}
+
+// Returns the host to listen on for a bind address, and whether failing to bind it is fatal
+func bindHost(bind string) (string, bool) {
+	optional := strings.HasPrefix(bind, "-")
+	host := strings.TrimPrefix(bind, "-")
+	switch host {
+	case "*":
+		host = "0.0.0.0"
+	case "::*":
+		host = "::"
+	}
+	return host, optional
+}
+
+/*
+Listens on host:port, restricting IP addresses to their own family:
+"::" must not also accept IPv4 connections, otherwise it can't be bound along "0.0.0.0".
+*/
+func listenTCP(host, port string) (net.Listener, error) {
+	network := "tcp"
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			network = "tcp4"
+		} else {
+			network = "tcp6"
+		}
+	}
+	return net.Listen(network, net.JoinHostPort(host, port))
+}
+
+/*
+Checks if a connection must be refused because of the protected mode.
+When protected mode is enabled and no password is set, only the loopback
+interface and the unix socket are allowed to connect.
+*/
+func (s *RedisServerImpl) isRefusedByProtectedMode(conn net.Conn) bool {
+	if !s.protectedMode || s.AuthRequired() {
+		return false
+	}
+	// Unix socket clients are local
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return !addr.IP.IsLoopback()
+	}
+	return false
+}
+
+/*
+Sends an error to a connection that isn't allowed in, then closes it.
+What the client already sent is drained for a short while first: closing a connection
+with unread data resets it, and the client could lose the error.
+*/
+func refuseConnection(conn net.Conn, reason string) {
+	defer conn.Close()
+	conn.Write(newSimpleError(reason))
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	io.Copy(io.Discard, conn)
+}
+
+// The address of a client connected through a unix socket, the socket path followed by the connection number
+type unixAddr struct {
+	addr string
+}
+
+func (a unixAddr) Network() string {
+	return "unix"
+}
+
+func (a unixAddr) String() string {
+	return a.addr
+}
+
+// A unix socket connection, clients are told apart by their address so every connection gets its own
+type unixConn struct {
+	net.Conn
+	addr unixAddr
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// Listens on a unix socket, numbering the connections it accepts
+type unixListener struct {
+	net.Listener
+	path  string
+	count atomic.Uint64
+}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	n := l.count.Add(1) - 1
+	return &unixConn{Conn: conn, addr: unixAddr{addr: fmt.Sprintf("%s:%d", l.path, n)}}, nil
+}
+
+/*
+Creates the unix socket at path, replacing a stale socket file left by a previous run.
+The socket file is removed when the listener is closed.
+A perm of 0 leaves the permissions to the umask.
+*/
+func listenUnix(path string, perm fs.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return &unixListener{Listener: l, path: path}, nil
+}
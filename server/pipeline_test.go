@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Writes the commands in a single write to a connection handled by srv, and returns what a single read gets back.
+net.Pipe never merges two writes into one read, all the replies are read at once only if they were flushed once.
+*/
+func pipeline(t *testing.T, srv *Server, commands ...[]string) string {
+	t.Helper()
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	go srv.HandleClientConnections(peer)
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	var batch []byte
+	for _, args := range commands {
+		batch = append(batch, newBulkArray(args...)...)
+	}
+	if _, err := conn.Write(batch); err != nil {
+		t.Fatalf("unable to write the commands: %s", err)
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("unable to read the replies: %s", err)
+	}
+	return string(buf[:n])
+}
+
+func TestPipelining(t *testing.T) {
+	master, masterAddr := startTestServer(t, testConfig())
+
+	t.Run("master", func(t *testing.T) {
+		commands := make([][]string, 0)
+		expected := ""
+		for i := 1; i <= 10; i++ {
+			commands = append(commands, []string{"INCR", "counter"})
+			expected += fmt.Sprintf(":%d\r\n", i)
+		}
+		commands = append(commands, []string{"GET", "counter"}, []string{"PING"})
+		expected += "$2\r\n10\r\n+PONG\r\n"
+		if v := pipeline(t, master, commands...); v != expected {
+			t.Fatalf("expected %q in one write, got %q", expected, v)
+		}
+	})
+
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	t.Run("replica", func(t *testing.T) {
+		m := dialTestClient(t, masterAddr)
+		defer m.Close()
+		m.do(t, "SET", "counter", "10")
+		if v := m.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+			t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+		}
+		expected := "$2\r\n10\r\n:1\r\n+PONG\r\n-READONLY You can't write against a read only replica.\r\n$2\r\n10\r\n"
+		v := pipeline(t, replica, []string{"GET", "counter"}, []string{"EXISTS", "counter"}, []string{"PING"},
+			[]string{"INCR", "counter"}, []string{"GET", "counter"})
+		if v != expected {
+			t.Fatalf("expected %q in one write, got %q", expected, v)
+		}
+	})
+
+	t.Run("replica master link", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %s", err)
+		}
+		defer l.Close()
+		// A fake master, it accepts the handshake then sends the commands itself
+		linked := make(chan *testConn, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			m := &testConn{Conn: conn, reader: NewRespReader(conn)}
+			for _, reply := range []string{"+PONG\r\n", "+OK\r\n", "+OK\r\n"} {
+				m.reader.ReadValue()
+				m.Write([]byte(reply))
+			}
+			m.reader.ReadValue()
+			rdb, _ := hex.DecodeString(EMPTY_RDB)
+			m.Write([]byte(fmt.Sprintf("+FULLRESYNC %s 0\r\n$%d\r\n%s", strings.Repeat("a", 40), len(rdb), rdb)))
+			linked <- m
+		}()
+		cfg := testConfig()
+		cfg.ReplicaOf = l.Addr().String()
+		linkedReplica, _ := startTestServer(t, cfg)
+		m := <-linked
+		defer m.Close()
+
+		// Only REPLCONF GETACK is answered, with the offset of the commands before it
+		commands := [][]string{
+			{"SET", "a", "1"}, {"REPLCONF", "GETACK", "*"}, {"SET", "b", "2"}, {"INCR", "c"}, {"REPLCONF", "GETACK", "*"},
+		}
+		var batch []byte
+		offsets := make([]int, 0)
+		for _, args := range commands {
+			if args[0] == "REPLCONF" {
+				offsets = append(offsets, len(batch))
+			}
+			batch = append(batch, newBulkArray(args...)...)
+		}
+		m.Write(batch)
+		for _, offset := range offsets {
+			if v, expected := m.reply(t), fmt.Sprintf("[REPLCONF ACK %d]", offset); v != expected {
+				t.Fatalf("expected %s, got %s", expected, v)
+			}
+		}
+		if v, err := linkedReplica.Get("c"); err != nil || v != "1" {
+			t.Fatalf("expected the commands to be run in order, got %q %v", v, err)
+		}
+		// Nothing else is written to the master
+		m.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if v, err := m.reader.ReadValue(); err == nil {
+			t.Fatalf("expected no other reply, got %s", formatReply(v))
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package server
+
+import "slices"
+
+// Largest number of elements of a quicklist node, a full node is split when an element is inserted in it
+const QUICKLIST_NODE_SIZE = 128
+
+/*
+Quicklist is the value of a list: a doubly linked list of nodes each holding up to QUICKLIST_NODE_SIZE elements.
+Pushing and popping at either end only touches the head or the tail node, the index based operations
+walk the nodes from the nearest end, skipping a whole node at a time.
+*/
+type Quicklist struct {
+	head  *quicklistNode
+	tail  *quicklistNode
+	count int // number of elements of all the nodes
+}
+
+type quicklistNode struct {
+	prev     *quicklistNode
+	next     *quicklistNode
+	elements []string
+}
+
+func NewQuicklist() *Quicklist {
+	return &Quicklist{}
+}
+
+func (l *Quicklist) Len() int {
+	return l.count
+}
+
+// Adds a node after prev, or as the head if prev is nil
+func (l *Quicklist) insertNode(prev *quicklistNode, elements []string) *quicklistNode {
+	n := &quicklistNode{prev: prev, elements: elements}
+	if prev == nil {
+		n.next = l.head
+		l.head = n
+	} else {
+		n.next = prev.next
+		prev.next = n
+	}
+	if n.next == nil {
+		l.tail = n
+	} else {
+		n.next.prev = n
+	}
+	return n
+}
+
+func (l *Quicklist) unlinkNode(n *quicklistNode) {
+	if n.prev == nil {
+		l.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		l.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+}
+
+func (l *Quicklist) PushHead(value string) {
+	if l.head == nil || len(l.head.elements) >= QUICKLIST_NODE_SIZE {
+		l.insertNode(nil, make([]string, 0, 1))
+	}
+	l.head.elements = append([]string{value}, l.head.elements...)
+	l.count++
+}
+
+func (l *Quicklist) PushTail(value string) {
+	if l.tail == nil || len(l.tail.elements) >= QUICKLIST_NODE_SIZE {
+		l.insertNode(l.tail, make([]string, 0, 1))
+	}
+	l.tail.elements = append(l.tail.elements, value)
+	l.count++
+}
+
+// Removes and returns the first element, false if the list is empty
+func (l *Quicklist) PopHead() (string, bool) {
+	if l.head == nil {
+		return "", false
+	}
+	value := l.head.elements[0]
+	l.head.elements = l.head.elements[1:]
+	l.count--
+	if len(l.head.elements) == 0 {
+		l.unlinkNode(l.head)
+	}
+	return value, true
+}
+
+// Removes and returns the last element, false if the list is empty
+func (l *Quicklist) PopTail() (string, bool) {
+	if l.tail == nil {
+		return "", false
+	}
+	n := l.tail
+	value := n.elements[len(n.elements)-1]
+	n.elements = n.elements[:len(n.elements)-1]
+	l.count--
+	if len(n.elements) == 0 {
+		l.unlinkNode(n)
+	}
+	return value, true
+}
+
+// Returns the node holding the element at index, 0 <= index < Len, and the offset of the element in the node
+func (l *Quicklist) locate(index int) (*quicklistNode, int) {
+	if index < l.count/2 {
+		n := l.head
+		for index >= len(n.elements) {
+			index -= len(n.elements)
+			n = n.next
+		}
+		return n, index
+	}
+	n := l.tail
+	index = l.count - 1 - index
+	for index >= len(n.elements) {
+		index -= len(n.elements)
+		n = n.prev
+	}
+	return n, len(n.elements) - 1 - index
+}
+
+// Returns the element at index, counted from the head, false if it is out of range
+func (l *Quicklist) Index(index int) (string, bool) {
+	if index < 0 || index >= l.count {
+		return "", false
+	}
+	n, offset := l.locate(index)
+	return n.elements[offset], true
+}
+
+// Replaces the element at index, false if it is out of range
+func (l *Quicklist) Set(index int, value string) bool {
+	if index < 0 || index >= l.count {
+		return false
+	}
+	n, offset := l.locate(index)
+	n.elements[offset] = value
+	return true
+}
+
+// Returns the elements between start and stop inclusive, both within the list
+func (l *Quicklist) Range(start, stop int) []string {
+	if start > stop || l.count == 0 {
+		return []string{}
+	}
+	values := make([]string, 0, stop-start+1)
+	n, offset := l.locate(start)
+	for len(values) < stop-start+1 {
+		take := min(len(n.elements)-offset, stop-start+1-len(values))
+		values = append(values, n.elements[offset:offset+take]...)
+		n, offset = n.next, 0
+	}
+	return values
+}
+
+// Returns all the elements, from the head to the tail
+func (l *Quicklist) Values() []string {
+	return l.Range(0, l.count-1)
+}
+
+/*
+Calls fn with each element and its index, from the head or, if reverse, from the tail.
+The iteration stops when fn returns false.
+*/
+func (l *Quicklist) Iterate(reverse bool, fn func(index int, value string) bool) {
+	if !reverse {
+		index := 0
+		for n := l.head; n != nil; n = n.next {
+			for _, v := range n.elements {
+				if !fn(index, v) {
+					return
+				}
+				index++
+			}
+		}
+		return
+	}
+	index := l.count - 1
+	for n := l.tail; n != nil; n = n.prev {
+		for i := len(n.elements) - 1; i >= 0; i-- {
+			if !fn(index, n.elements[i]) {
+				return
+			}
+			index--
+		}
+	}
+}
+
+/*
+Inserts value before or after the first element equal to pivot, false if there is no such element.
+A full node is split in two halves so the nodes stay within QUICKLIST_NODE_SIZE.
+*/
+func (l *Quicklist) Insert(pivot, value string, after bool) bool {
+	for n := l.head; n != nil; n = n.next {
+		for i, v := range n.elements {
+			if v != pivot {
+				continue
+			}
+			if after {
+				i++
+			}
+			n.elements = append(n.elements[:i], append([]string{value}, n.elements[i:]...)...)
+			l.count++
+			if len(n.elements) > QUICKLIST_NODE_SIZE {
+				half := len(n.elements) / 2
+				l.insertNode(n, append([]string(nil), n.elements[half:]...))
+				n.elements = n.elements[:half:half]
+			}
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Removes the elements equal to value and returns how many were removed:
+count > 0 removes the first count ones from the head, count < 0 the first -count ones from the tail, 0 all of them.
+*/
+func (l *Quicklist) Remove(count int, value string) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	n := l.head
+	if count < 0 {
+		n = l.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if count < 0 {
+			next = n.prev
+		}
+		kept := make([]string, 0, len(n.elements))
+		for i := range n.elements {
+			// From the tail the elements of the node are looked at backwards, the kept ones are reversed back
+			j := i
+			if count < 0 {
+				j = len(n.elements) - 1 - i
+			}
+			if n.elements[j] == value && (limit == 0 || removed < limit) {
+				removed++
+				continue
+			}
+			kept = append(kept, n.elements[j])
+		}
+		if count < 0 {
+			slices.Reverse(kept)
+		}
+		n.elements = kept
+		if len(kept) == 0 {
+			l.unlinkNode(n)
+		}
+		n = next
+	}
+	l.count -= removed
+	return removed
+}
+
+// Keeps the elements between start and stop inclusive, removes all of them if start > stop
+func (l *Quicklist) Trim(start, stop int) {
+	if start > stop {
+		*l = Quicklist{}
+		return
+	}
+	for dropped := 0; dropped < start; {
+		n := l.head
+		if len(n.elements) <= start-dropped {
+			dropped += len(n.elements)
+			l.unlinkNode(n)
+			continue
+		}
+		n.elements = n.elements[start-dropped:]
+		dropped = start
+	}
+	last := l.count - 1 - stop
+	for dropped := 0; dropped < last; {
+		n := l.tail
+		if len(n.elements) <= last-dropped {
+			dropped += len(n.elements)
+			l.unlinkNode(n)
+			continue
+		}
+		n.elements = n.elements[:len(n.elements)-(last-dropped)]
+		dropped = last
+	}
+	l.count = stop - start + 1
+}
+
+// Returns a copy of the list that doesn't share its nodes
+func (l *Quicklist) clone() *Quicklist {
+	c := NewQuicklist()
+	for n := l.head; n != nil; n = n.next {
+		c.insertNode(c.tail, append([]string(nil), n.elements...))
+	}
+	c.count = l.count
+	return c
+}
@@ -24,21 +24,31 @@ const (
 )
 
 var ValueType = []string{
-	"String Encoding",                // 0
-	"List Encoding",                  // 1
-	"Set Encoding",                   // 2
-	"Sorted Set Encoding",            // 3
-	"Hash Encoding",                  // 4
-	"",                               // 5
-	"",                               // 6
-	"",                               // 7
-	"",                               // 8
-	"Zipmap Encoding",                // 9
-	"Ziplist Encoding",               // 10
-	"Intset Encoding",                // 11
-	"Sorted Set in Ziplist Encoding", // 12
-	"Hashmap in Ziplist Encoding",    // 13
-	"List in Quicklist encoding",     // 14
+	"String Encoding",                  // 0
+	"List Encoding",                    // 1
+	"Set Encoding",                     // 2
+	"Sorted Set Encoding",              // 3
+	"Hash Encoding",                    // 4
+	"",                                 // 5
+	"",                                 // 6
+	"",                                 // 7
+	"",                                 // 8
+	"Zipmap Encoding",                  // 9
+	"Ziplist Encoding",                 // 10
+	"Intset Encoding",                  // 11
+	"Sorted Set in Ziplist Encoding",   // 12
+	"Hashmap in Ziplist Encoding",      // 13
+	"List in Quicklist encoding",       // 14
+	"Stream Listpacks Encoding",        // 15
+	"Hash Listpack Encoding",           // 16
+	"Zset Listpack Encoding",           // 17
+	"List Quicklist 2 Encoding",        // 18
+	"Stream Listpacks 2 Encoding",      // 19
+	"Set Listpack Encoding",            // 20
+	"Stream Listpacks 3 Encoding",      // 21
+	"Hash Metadata Pre GA Encoding",    // 22
+	"Hash Listpack Ex Pre GA Encoding", // 23
+	"Hash Metadata Encoding",           // 24
 }
 
 // Struct to decode the content of the RDB file
@@ -52,7 +62,7 @@ func NewRDBDecoder(data []byte) *RDBDecoder {
 	return &RDBDecoder{data: data, max: len(data)}
 }
 
-// Decode the RDB file and return a map of key value pairs and their expiry
+// Decode the RDB file and return the key value pairs and their expiry, by database index
 func (r *RDBDecoder) Decode() (interface{}, error) {
 	header, err := r.decodeHeader()
 	if err != nil {
@@ -64,12 +74,22 @@ func (r *RDBDecoder) Decode() (interface{}, error) {
 		return nil, err
 	}
 	fmt.Print(mt.String())
-	db, err := r.decodeDatabase()
+	dbs, err := r.decodeDatabases()
 	if err != nil {
 		return nil, err
 	}
-	fmt.Print(db.String())
-	return db.objects, err
+	objects := make(map[int]map[string]Object)
+	for _, db := range dbs {
+		fmt.Print(db.String())
+		if objects[db.dbIndex] == nil {
+			objects[db.dbIndex] = db.objects
+			continue
+		}
+		for k, v := range db.objects {
+			objects[db.dbIndex][k] = v
+		}
+	}
+	return objects, err
 }
 
 // Decode the header of the RDB file
@@ -102,16 +122,19 @@ func (r *RDBDecoder) decodeMetadata() (*RDBMetadata, error) {
 	return &RDBMetadata{data: metadata}, nil
 }
 
-// Decode the database content of the RDB file
-func (r *RDBDecoder) decodeDatabase() (*RDBdatabase, error) {
+// Decode the databases of the RDB file, each one starts with the SELECTDB opcode and its index
+func (r *RDBDecoder) decodeDatabases() ([]*RDBdatabase, error) {
 	db := NewRDBDatabase()
+	dbs := []*RDBdatabase{db}
 	fmt.Printf("Decoding database, starting at pos: %d\n", r.offset)
 	for {
 		opcode := r.readUInt8()
 		fmt.Printf("Opcode: %x\n", opcode)
 		switch {
 		case opcode == OPCodeSelectDB:
-			db.dbIndex = int(r.readUInt8())
+			db = NewRDBDatabase()
+			db.dbIndex = r.readLength()
+			dbs = append(dbs, db)
 			fmt.Printf("Db Index found: %d\n", db.dbIndex)
 		case opcode == OPCodeResizeDB:
 			fmt.Printf("Offset before num of keys: %d\n", r.offset)
@@ -121,42 +144,110 @@ func (r *RDBDecoder) decodeDatabase() (*RDBdatabase, error) {
 			fmt.Printf("Num of keys: %d, Num of expires: %d\n", db.numOfKeys, db.numOfExpires)
 			fmt.Printf("Offset resize DB: %d\n", r.offset)
 		case opcode == OPCodeExpireTimeMS:
-			obj := Object{}
-			obj.expiry = r.decodeExpiryMS()
-			vtype := ValueType[r.readUInt8()]
+			expiry := r.decodeExpiryMS()
+			vtype := r.getValueType()
 			key := r.readString()
-			if vtype == "String Encoding" {
-				obj.value = r.readString()
+			obj, ok := r.readObject(vtype)
+			if !ok {
+				fmt.Printf("Value type not implemented yet: %s\n", vtype)
+				return dbs, nil
 			}
+			obj.expiry = expiry
 			db.objects[key] = obj
 		case opcode == OPCodeExpireTime:
-			obj := Object{}
-			obj.expiry = uint64(r.decodeExpiryS())
-			vtype := ValueType[r.readUInt8()]
+			expiry := uint64(r.decodeExpiryS())
+			vtype := r.getValueType()
 			key := r.readString()
-			if vtype == "String Encoding" {
-				obj.value = r.readString()
+			obj, ok := r.readObject(vtype)
+			if !ok {
+				fmt.Printf("Value type not implemented yet: %s\n", vtype)
+				return dbs, nil
 			}
+			obj.expiry = expiry
 			db.objects[key] = obj
 		case opcode == OPCodeEOF:
-			return db, nil
+			return dbs, nil
 		default:
 			// Decrease the offset by 1 to read the value type
 			r.offset--
 			fmt.Printf("Unknown opcode: %x, about to decode the value type, offset is: %d\n", opcode, r.offset)
 			e := r.getValueType()
-			switch e {
-			case "String Encoding":
-				fmt.Println("A string encoding found in the key value pairs")
-				key := r.readString()
-				value := r.readString()
-				db.objects[key] = Object{value: value}
-			default:
+			key := r.readString()
+			obj, ok := r.readObject(e)
+			if !ok {
 				fmt.Printf("Value type not implemented yet: %s\n", e)
-				return db, nil
+				return dbs, nil
+			}
+			db.objects[key] = obj
+		}
+	}
+}
+
+// Read the value of a key, false if its value type is not implemented yet
+func (r *RDBDecoder) readObject(vtype string) (Object, bool) {
+	switch vtype {
+	case "String Encoding":
+		return Object{value: r.readString()}, true
+	case "List Encoding":
+		// The number of elements, then each element as a string
+		l := NewQuicklist()
+		for n := r.readLength(); n > 0; n-- {
+			l.PushTail(r.readString())
+		}
+		return Object{list: l}, true
+	case "Set Encoding":
+		// The number of members, then each member as a string
+		s := NewSet()
+		for n := r.readLength(); n > 0; n-- {
+			s.Add(r.readString())
+		}
+		return Object{set: s}, true
+	case "Intset Encoding":
+		// One string holding the intset, see writeIntset
+		s := NewSet()
+		blob := []byte(r.readString())
+		if len(blob) < 8 {
+			return Object{}, false
+		}
+		size, n := int(binary.LittleEndian.Uint32(blob)), int(binary.LittleEndian.Uint32(blob[4:]))
+		if (size != 2 && size != 4 && size != 8) || len(blob) != 8+size*n {
+			return Object{}, false
+		}
+		for i := 0; i < n; i++ {
+			b := blob[8+size*i:]
+			switch size {
+			case 2:
+				s.Add(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10))
+			case 4:
+				s.Add(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10))
+			default:
+				s.Add(strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10))
 			}
 		}
+		return Object{set: s}, true
+	case "Hash Encoding":
+		// The number of fields, then each field and its value as strings
+		h := NewHash()
+		for n := r.readLength(); n > 0; n-- {
+			field := r.readString()
+			h.Set(field, r.readString(), false)
+		}
+		return Object{hash: h}, true
+	case "Hash Metadata Encoding":
+		// The first expiry time of the fields, then like a hash with the expiry time of each field before it, see writeHashMetadata
+		minExpire := r.decodeExpiryMS()
+		h := NewHash()
+		for n := r.readLength(); n > 0; n-- {
+			ttl := r.readLength()
+			field := r.readString()
+			h.Set(field, r.readString(), false)
+			if ttl > 0 {
+				h.SetExpiry(field, minExpire+uint64(ttl)-1)
+			}
+		}
+		return Object{hash: h}, true
 	}
+	return Object{}, false
 }
 
 /*
@@ -173,10 +264,12 @@ A one byte flag indicates encoding used to save the Value.
 12 = Sorted Set in Ziplist Encoding
 13 = Hashmap in Ziplist Encoding (Introduced in RDB version 4)
 14 = List in Quicklist encoding (Introduced in RDB version 7)
+15 to 23 = Listpack encodings and the pre-release hash metadata ones
+24 = Hash Metadata Encoding, a hash with fields that expire (Introduced in RDB version 12)
 */
 func (r *RDBDecoder) getValueType() string {
 	typ := r.readUInt8()
-	if typ > 14 || typ == 5 || typ == 6 || typ == 7 || typ == 8 {
+	if int(typ) >= len(ValueType) || ValueType[typ] == "" {
 		fmt.Printf("Invalid value type: %d\n", typ)
 		return ""
 	}
@@ -247,9 +340,12 @@ func (r *RDBDecoder) readLength() int {
 		len := int(br.ReadBits(14))
 		r.offset += 2
 		return len
-	// Discard the remaining 6 bites, the next 4 bytes represent the length
+	// Discard the remaining 6 bites, the next 4 bytes represent the length, or the next 8 bytes if the first byte is 0x81
 	case bitLen == 2:
 		r.offset++
+		if r.data[r.offset-1] == 0x81 {
+			return int(r.readUInt64())
+		}
 		len := int(r.readUInt32())
 		return len
 	// The next object is encoded in a special format. The remaining 6 bits indicate the format. May be used to store numbers or Strings, see String Encoding
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	RDB_VERSION = "0012"
+	// Value types written to the snapshots, see ValueType
+	RDBStringType       = 0x00
+	RDBListType         = 0x01
+	RDBSetType          = 0x02
+	RDBHashType         = 0x04
+	RDBIntsetType       = 0x0b
+	RDBHashMetadataType = 0x18
+	// Reflected polynomial of the CRC-64-Jones checksum ending the RDB file
+	RDBChecksumPoly = 0x95ac9329ac4bc9b5
+)
+
+// Struct to encode the dataset as an RDB file
+type RDBEncoder struct {
+	buf bytes.Buffer
+}
+
+func NewRDBEncoder() *RDBEncoder {
+	return &RDBEncoder{}
+}
+
+/*
+Encode the databases as an RDB file: header, metadata, the databases and the checksum.
+The objects of a database are the ones at its index, the empty databases are skipped.
+The keys are written in order, with their expiry in milliseconds if they have one.
+Lists are written as their number of elements followed by the elements.
+Hashes are written as their number of fields followed by the fields and their values,
+the ones with fields that expire as a hash with metadata, see writeHashMetadata.
+Sets are written as their number of members followed by the members, intsets as one string, see writeIntset.
+Streams are not written, the decoder does not read them back yet.
+*/
+func (e *RDBEncoder) Encode(databases []map[string]Object) []byte {
+	e.buf.Reset()
+	e.buf.WriteString("REDIS" + RDB_VERSION)
+	e.writeAuxField("redis-ver", REDIS_VERSION)
+	e.writeAuxField("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.writeAuxField("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	for index, objects := range databases {
+		if len(objects) > 0 {
+			e.writeDatabase(index, objects)
+		}
+	}
+	e.buf.WriteByte(OPCodeEOF)
+	binary.Write(&e.buf, binary.LittleEndian, crc64Jones(e.buf.Bytes()))
+	return e.buf.Bytes()
+}
+
+func (e *RDBEncoder) writeDatabase(index int, objects map[string]Object) {
+	keys := make([]string, 0, len(objects))
+	expires := 0
+	for k, v := range objects {
+		if v.stream != nil {
+			fmt.Printf("Stream %s is not written to the RDB file\n", k)
+			continue
+		}
+		if v.expiry != 0 {
+			expires++
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	e.buf.WriteByte(OPCodeSelectDB)
+	e.writeLength(index)
+	e.buf.WriteByte(OPCodeResizeDB)
+	e.writeLength(len(keys))
+	e.writeLength(expires)
+	for _, k := range keys {
+		v := objects[k]
+		if v.expiry != 0 {
+			e.buf.WriteByte(OPCodeExpireTimeMS)
+			binary.Write(&e.buf, binary.LittleEndian, v.expiry)
+		}
+		if v.list != nil {
+			e.buf.WriteByte(RDBListType)
+			e.writeString(k)
+			e.writeLength(v.list.Len())
+			for _, element := range v.list.Values() {
+				e.writeString(element)
+			}
+			continue
+		}
+		if v.set != nil && v.set.isIntset() {
+			e.buf.WriteByte(RDBIntsetType)
+			e.writeString(k)
+			e.writeIntset(v.set.intset)
+			continue
+		}
+		if v.set != nil {
+			e.buf.WriteByte(RDBSetType)
+			e.writeString(k)
+			e.writeLength(v.set.Len())
+			for _, member := range v.set.Members() {
+				e.writeString(member)
+			}
+			continue
+		}
+		if v.hash != nil && v.hash.nextExpiry() != 0 {
+			e.buf.WriteByte(RDBHashMetadataType)
+			e.writeString(k)
+			e.writeHashMetadata(v.hash)
+			continue
+		}
+		if v.hash != nil {
+			e.buf.WriteByte(RDBHashType)
+			e.writeString(k)
+			e.writeLength(v.hash.Len())
+			for _, field := range v.hash.Fields() {
+				value, _ := v.hash.Get(field)
+				e.writeString(field)
+				e.writeString(value)
+			}
+			continue
+		}
+		e.buf.WriteByte(RDBStringType)
+		e.writeString(k)
+		e.writeString(v.value)
+	}
+}
+
+func (e *RDBEncoder) writeAuxField(key, value string) {
+	e.buf.WriteByte(OPCodeAuxField)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+// Write a length prefixed string, see readString
+func (e *RDBEncoder) writeString(s string) {
+	e.writeLength(len(s))
+	e.buf.WriteString(s)
+}
+
+// Write a length on 6 bits, 14 bits, 4 bytes or 8 bytes, see readLength
+func (e *RDBEncoder) writeLength(length int) {
+	switch {
+	case length < 1<<6:
+		e.buf.WriteByte(byte(length))
+	case length < 1<<14:
+		e.buf.WriteByte(byte(length>>8) | 0x40)
+		e.buf.WriteByte(byte(length))
+	case length < 1<<32:
+		e.buf.WriteByte(0x80)
+		binary.Write(&e.buf, binary.BigEndian, uint32(length))
+	default:
+		e.buf.WriteByte(0x81)
+		binary.Write(&e.buf, binary.BigEndian, uint64(length))
+	}
+}
+
+/*
+Writes the members of an intset as one string, the way Redis keeps it in memory: the size of the integers in bytes,
+2, 4 or 8 depending on the largest one, and their number as 32 bits little endian, then the integers sorted in little endian.
+*/
+func (e *RDBEncoder) writeIntset(intset []int64) {
+	size := 2
+	for _, i := range intset {
+		switch {
+		case i < math.MinInt32 || i > math.MaxInt32:
+			size = 8
+		case (i < math.MinInt16 || i > math.MaxInt16) && size < 4:
+			size = 4
+		}
+	}
+	blob := binary.LittleEndian.AppendUint32(nil, uint32(size))
+	blob = binary.LittleEndian.AppendUint32(blob, uint32(len(intset)))
+	for _, i := range intset {
+		switch size {
+		case 2:
+			blob = binary.LittleEndian.AppendUint16(blob, uint16(i))
+		case 4:
+			blob = binary.LittleEndian.AppendUint32(blob, uint32(i))
+		default:
+			blob = binary.LittleEndian.AppendUint64(blob, uint64(i))
+		}
+	}
+	e.writeString(string(blob))
+}
+
+/*
+Writes a hash with fields that expire the way Redis 7.4 does: the first expiry time of the fields in milliseconds,
+the number of fields, then for each field its expiry time as a length relative to the first one, plus 1 or 0 if it has none,
+followed by the field and its value.
+*/
+func (e *RDBEncoder) writeHashMetadata(h *Hash) {
+	minExpire := h.nextExpiry()
+	binary.Write(&e.buf, binary.LittleEndian, minExpire)
+	e.writeLength(h.Len())
+	for _, field := range h.Fields() {
+		ttl := 0
+		if at := h.Expiry(field); at != 0 {
+			ttl = int(at-minExpire) + 1
+		}
+		value, _ := h.Get(field)
+		e.writeLength(ttl)
+		e.writeString(field)
+		e.writeString(value)
+	}
+}
+
+// The checksum Redis computes over the RDB file, CRC-64-Jones without inversion
+func crc64Jones(data []byte) uint64 {
+	var crc uint64
+	for _, b := range data {
+		crc ^= uint64(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ RDBChecksumPoly
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
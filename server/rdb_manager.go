@@ -2,6 +2,8 @@ package server
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	log "github.com/codecrafters-io/redis-starter-go/logger"
@@ -18,6 +20,8 @@ type RDBManager interface {
 	// Returns the directory and file name of the RDB file
 	RDBInfo() (string, string)
 	LoadRDBToCache() error
+	// Writes a snapshot of the dataset to the RDB file
+	SaveRDB() error
 }
 
 type RDBManagerImpl struct {
@@ -46,39 +50,65 @@ func (r *RDBManagerImpl) LoadRDBToCache() error {
 	if err != nil {
 		return err
 	}
-	// Load the objects into the cache, making sure to not add an object that has expired
-	for k, v := range objs {
-		if v.expiry != 0 {
-			now := time.Now().UnixMilli()
-			if v.expiry < uint64(now) {
+	// Load the objects into their database, making sure to not add an object that has expired
+	databases := r.server.databases()
+	for index, objects := range objs {
+		if index >= len(databases) {
+			fmt.Printf("database %d is out of range - not loading its %d keys\n", index, len(objects))
+			continue
+		}
+		for k, v := range objects {
+			if v.expiry != 0 && v.expiry < uint64(time.Now().UnixMilli()) {
 				fmt.Printf("key %s has expired - not loading it to cache\n", k)
 				continue
 			}
-			err := r.server.SetExpiry(k, v.value, v.expiry)
-			if err != nil {
-				fmt.Printf("error while setting key %s with expiry:%d - error: %s\n", k, v.expiry, err)
-			}
-		} else {
-			err := r.server.Set(k, v.value)
-			if err != nil {
-				fmt.Printf("error while setting key %s: %s\n", k, err)
-			}
+			databases[index].Put(k, v)
 		}
 	}
 	return nil
 }
 
-// Decode the RDB data and return a map of keys-values, with an expiry if any
-func (r *RDBManagerImpl) decodeRDB(data []byte) (map[string]Object, error) {
+/*
+Write the dataset to the RDB file, dump.rdb in the working directory unless configured otherwise.
+The snapshot is written to a temporary file first, renamed once complete so that the previous
+snapshot is never left half written.
+*/
+func (r *RDBManagerImpl) SaveRDB() error {
+	dir, dbfile := r.dir, r.dbfile
+	if dir == "" {
+		dir = "."
+	}
+	if dbfile == "" {
+		dbfile = "dump.rdb"
+	}
+	path := filepath.Join(dir, dbfile)
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	databases := make([]map[string]Object, 0, len(r.server.databases()))
+	for _, db := range r.server.databases() {
+		databases = append(databases, db.Objects())
+	}
+	if err := os.WriteFile(tmp, NewRDBEncoder().Encode(databases), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("DB saved on disk: %s\n", path)
+	return nil
+}
+
+// Decode the RDB data and return the keys-values of each database by index, with an expiry if any
+func (r *RDBManagerImpl) decodeRDB(data []byte) (map[int]map[string]Object, error) {
 	d := NewRDBDecoder(data)
 	result, err := d.Decode()
 	if err != nil {
-		return map[string]Object{}, err
+		return map[int]map[string]Object{}, err
 	}
 	// Perform type assertion
-	objects, ok := result.(map[string]Object)
+	objects, ok := result.(map[int]map[string]Object)
 	if !ok {
-		return map[string]Object{}, fmt.Errorf("error: expected map[string]Object")
+		return map[int]map[string]Object{}, fmt.Errorf("error: expected map[int]map[string]Object")
 	}
 	return objects, nil
 }
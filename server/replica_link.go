@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// The replication stream a replica may lag behind before it is disconnected, the hard limit Redis gives replicas
+	REPLICA_OUTPUT_BUFFER_LIMIT = 256 << 20
+	// How long a shutdown waits for the replication stream still queued to be written
+	REPLICA_FLUSH_TIMEOUT = time.Second
+)
+
+/*
+replicaLink sends the replication stream to a replica.
+The executor only queues the commands, the link's own goroutine writes them,
+so that a slow or stalled replica never holds the executor.
+*/
+type replicaLink struct {
+	client  *Client
+	mu      sync.Mutex
+	pending []byte        // queued by the executor, not written yet
+	wake    chan struct{} // holds a signal while data is pending
+	writeMu sync.Mutex    // held while pending is taken and written, the writes keep the order of the stream
+}
+
+// Starts the goroutine of the link, it returns once the replica is disconnected or stop is closed
+func newReplicaLink(c *Client, stop <-chan struct{}) *replicaLink {
+	l := &replicaLink{client: c, wake: make(chan struct{}, 1)}
+	go l.loop(stop)
+	return l
+}
+
+/*
+Queues data for the replica without waiting for the write.
+Returns false once the replica is disconnected, a replica lagging behind by more than
+REPLICA_OUTPUT_BUFFER_LIMIT bytes is disconnected.
+*/
+func (l *replicaLink) send(data []byte) bool {
+	select {
+	case <-l.client.done:
+		return false
+	default:
+	}
+	l.mu.Lock()
+	if len(l.pending)+len(data) > REPLICA_OUTPUT_BUFFER_LIMIT {
+		l.mu.Unlock()
+		fmt.Printf("Replica %s reached the output buffer limit, disconnecting it\n", l.client.Addr())
+		l.client.close()
+		return false
+	}
+	l.pending = append(l.pending, data...)
+	l.mu.Unlock()
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (l *replicaLink) loop(stop <-chan struct{}) {
+	for {
+		select {
+		case <-l.wake:
+		case <-l.client.done:
+			return
+		case <-stop:
+			return
+		}
+		if err := l.write(); err != nil {
+			fmt.Printf("Error writing to %s replica: %s\n", l.client.Addr(), err)
+			l.client.close()
+			return
+		}
+	}
+}
+
+// Writes the data queued so far
+func (l *replicaLink) write() error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.mu.Lock()
+	data := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(data) == 0 {
+		return nil
+	}
+	_, err := l.client.conn.Write(data)
+	return err
+}
+
+// Writes the data still queued before the replica is disconnected by a shutdown, waits at most timeout
+func (l *replicaLink) flush(timeout time.Duration) {
+	l.client.conn.SetWriteDeadline(time.Now().Add(timeout))
+	if err := l.write(); err != nil {
+		fmt.Printf("Error writing to %s replica: %s\n", l.client.Addr(), err)
+	}
+}
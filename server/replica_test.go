@@ -0,0 +1,110 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicaCommands(t *testing.T) {
+	master, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, replicaAddr := startTestServer(t, cfg)
+
+	m := dialTestClient(t, masterAddr)
+	defer m.Close()
+	m.do(t, "SET", "key", "value")
+	m.do(t, "XADD", "stream", "1-1", "field", "value")
+	if v := m.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	r := dialTestClient(t, replicaAddr)
+	defer r.Close()
+
+	t.Run("read commands are served", func(t *testing.T) {
+		if v := r.do(t, "GET", "key"); v.Str() != "value" {
+			t.Fatalf("expected value, got %q", v.Str())
+		}
+		if v := r.do(t, "EXISTS", "key", "stream", "missing"); v.Int() != 2 {
+			t.Fatalf("expected 2, got %d", v.Int())
+		}
+		if v := r.do(t, "TYPE", "stream"); v.Str() != "stream" {
+			t.Fatalf("expected stream, got %q", v.Str())
+		}
+		if v := r.do(t, "XRANGE", "stream", "-", "+"); len(v.Array()) != 1 {
+			t.Fatalf("expected one entry, got %v", v.Array())
+		}
+		if v := r.do(t, "XREAD", "STREAMS", "stream", "0-0"); len(v.Array()) != 1 {
+			t.Fatalf("expected one stream, got %v", v.Array())
+		}
+	})
+
+	t.Run("write commands are refused", func(t *testing.T) {
+		for _, args := range [][]string{{"SET", "key", "other"}, {"DEL", "key"}, {"INCR", "counter"}, {"XADD", "stream", "*", "f", "v"}} {
+			if v := r.do(t, args...); v.Str() != "READONLY You can't write against a read only replica." {
+				t.Fatalf("%v: expected READONLY, got %q", args, v.Str())
+			}
+		}
+		if v := r.do(t, "GET", "key"); v.Str() != "value" {
+			t.Fatalf("expected value, got %q", v.Str())
+		}
+		if v := r.do(t, "CONFIG", "GET", "replica-read-only").Array(); len(v) != 2 || v[1].Str() != "yes" {
+			t.Fatalf("expected replica-read-only yes, got %v", v)
+		}
+	})
+
+	t.Run("replica-read-only no", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.ReplicaOf, cfg.ReplicaReadOnly = masterAddr, false
+		_, rwAddr := startTestServer(t, cfg)
+		c := dialTestClient(t, rwAddr)
+		defer c.Close()
+		if v := c.do(t, "SET", "local", "1"); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+		if v := c.do(t, "INCR", "local"); v.Int() != 2 {
+			t.Fatalf("expected 2, got %d", v.Int())
+		}
+		// The local writes of a replica are not propagated
+		time.Sleep(50 * time.Millisecond)
+		if _, err := master.Get("local"); err == nil {
+			t.Fatalf("expected the write to stay on the replica")
+		}
+		if _, err := replica.Get("local"); err == nil {
+			t.Fatalf("expected the write to stay on the replica")
+		}
+	})
+}
+
+func TestStalledReplica(t *testing.T) {
+	_, addr := startTestServer(t, testConfig())
+	// A replica that syncs, then never reads the replication stream
+	r := dialTestClient(t, addr)
+	defer r.Close()
+	r.do(t, "PING")
+	r.do(t, "REPLCONF", "listening-port", "6380")
+	r.do(t, "REPLCONF", "capa", "psync2")
+	r.do(t, "PSYNC", "?", "-1")
+	if _, err := r.reader.ReadRDB(); err != nil {
+		t.Fatalf("unable to read the RDB: %s", err)
+	}
+
+	c := dialTestClient(t, addr)
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	// Far more than the socket buffers hold, the writes would wait for the replica if the executor wrote to it
+	value := strings.Repeat("v", 1<<20)
+	for i := 0; i < 16; i++ {
+		if v := c.do(t, "SET", "key", value); v.Str() != "OK" {
+			t.Fatalf("expected OK, got %q", v.Str())
+		}
+	}
+	if v := c.do(t, "WAIT", "1", "50"); v.Int() != 0 {
+		t.Fatalf("expected no acknowledgement, got %d", v.Int())
+	}
+	if v := c.do(t, "PING"); v.Str() != "PONG" {
+		t.Fatalf("expected PONG, got %q", v.Str())
+	}
+}
@@ -1,11 +1,12 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -16,57 +17,201 @@ var (
 )
 
 type RequestHandler interface {
-	HandleRequest() []byte
+	HandleRequest() error
 }
 
 type ReqHandlerImpl struct {
-	request []byte
-	server  RedisServer
-	conn    net.Conn
+	requests []Request
+	server   RedisServer
+	master   MasterServer // nil on a replica
+	client   *Client
+	inExec   bool          // set while EXEC runs the queued commands, which can't block
+	waiting  func() []byte // set by a command that waits off the executor, see block
+	// set by a command that propagates its own requests to the replicas in place of itself, see blockOnKeys
+	preventPropagation bool
 }
 
-func NewRequestHandler(request []byte, s RedisServer, conn net.Conn) *ReqHandlerImpl {
-	return &ReqHandlerImpl{request: request, server: s, conn: conn}
+func NewRequestHandler(requests []Request, s RedisServer, c *Client) *ReqHandlerImpl {
+	return &ReqHandlerImpl{requests: requests, server: s, client: c}
 }
 
-// Handles a request and returns a response
-func (r *ReqHandlerImpl) HandleRequest() []byte {
-	re := strings.Split(string(r.request), CRLF)
-	fmt.Printf("Client request: %v\n", re)
-	reqD := NewRequestDecoder(re)
-	reqs, err := reqD.Decode()
-	if err != nil {
-		fmt.Printf("error while decoding the request: %s", err)
-		return []byte{}
-	}
-
-	for _, req := range reqs {
-		switch req.command {
-		case "PING":
-			r.server.SendTo(r.conn, r.ping(&req))
-		case "ECHO":
-			r.server.SendTo(r.conn, r.echo(&req))
-		case "GET":
-			r.server.SendTo(r.conn, r.get(&req))
-		case "CONFIG":
-			r.server.SendTo(r.conn, r.config(&req))
-		case "KEYS":
-			r.server.SendTo(r.conn, r.keys(&req))
-		case "INFO":
-			r.server.SendTo(r.conn, r.info(&req))
-		case "TYPE":
-			if len(req.args) < 1 {
-				r.server.SendTo(r.conn, newSimpleError("ERR TYPE command requires at least 1 argument"))
-				continue
+// Handles the requests, queueing one reply per request in the connection's writer, and flushes the replies
+func (r *ReqHandlerImpl) HandleRequest() error {
+	return r.writeReplies(r.handle)
+}
+
+/*
+Queues the reply of every request in order, then flushes them in a single write.
+Pipelined requests get one reply each, the way clients expect them.
+An empty reply means the request doesn't expect an answer, nothing is written.
+Each request is handled on the executor, a blocking command then waits here,
+after the replies of the requests before it are flushed.
+*/
+func (r *ReqHandlerImpl) writeReplies(handle func(req *Request) []byte) error {
+	for i := range r.requests {
+		var reply []byte
+		r.server.execute(func() {
+			r.client.touch(&r.requests[i])
+			reply = handle(&r.requests[i])
+		})
+		if r.waiting != nil {
+			wait := r.waiting
+			r.waiting = nil
+			if err := r.client.writer.Flush(); err != nil {
+				return err
 			}
-			r.server.SendTo(r.conn, newSimpleString(r.server.Type(req.args[0])))
-		default:
-			r.server.SendTo(r.conn, newSimpleError("ERR unknown command"))
+			reply = wait()
+		}
+		if _, err := r.client.writer.Write(reply); err != nil {
+			return err
 		}
+		// The client killed itself or shut the server down, the requests after it are dropped
+		if r.client.closeAfterReply {
+			break
+		}
+	}
+	return r.client.writer.Flush()
+}
+
+/*
+Handles a single request and returns its reply.
+The command is looked up in the command table, which checks its arity,
+then the client's permissions are checked before the command is run,
+or queued if the client is in a transaction.
+*/
+func (r *ReqHandlerImpl) handle(req *Request) []byte {
+	fmt.Printf("Client request: command: %s, args: %v\n", req.command, req.args)
+	cmd, errReply := lookupCommand(req)
+	if errReply == nil {
+		errReply = r.checkPermissions(cmd, req)
+	}
+	if errReply != nil {
+		// A transaction with a command refused while queueing is aborted by EXEC
+		if r.client.IsInMulti() {
+			r.client.flags |= CLIENT_DIRTY_EXEC
+		}
+		return errReply
+	}
+	if r.client.IsInMulti() && cmd.Is(CMD_NO_MULTI) {
+		r.client.flags |= CLIENT_DIRTY_EXEC
+		return newSimpleError("ERR Command not allowed inside a transaction")
+	}
+	// Do not queue the commands that exec or interrupt the transaction
+	if r.client.IsInMulti() && !transactionCommands[cmd.name] {
+		r.client.Queue(*req)
+		return newSimpleString("QUEUED")
+	}
+	return r.call(cmd, req)
+}
+
+// Commands that are run right away by a client in a transaction
+var transactionCommands = map[string]bool{"multi": true, "exec": true, "discard": true, "quit": true}
+
+/*
+Runs a command and returns its reply.
+On a master, a write command that succeeded is propagated to the replicas,
+a replica refuses write commands from its clients unless replica-read-only is disabled.
+*/
+func (r *ReqHandlerImpl) call(cmd *Command, req *Request) []byte {
+	if r.master == nil && cmd.Is(CMD_WRITE) && r.server.ReplicaReadOnly() {
+		return newSimpleError("READONLY You can't write against a read only replica.")
+	}
+	r.server.useDB(r.client.db)
+	r.preventPropagation = false
+	reply := cmd.proc(r, req)
+	// The keys the command found expired are deleted on the replicas before the command runs there
+	if r.master != nil {
+		r.master.ReplicateExpired()
+	} else {
+		r.server.TakeExpired()
+		r.server.TakeExpiredFields()
+	}
+	if cmd.Is(CMD_WRITE) && !isErrorReply(reply) {
+		r.server.signalChange()
 	}
+	if r.master != nil && cmd.Is(CMD_WRITE) && !isErrorReply(reply) && !r.preventPropagation {
+		r.master.Replicate(req)
+	}
+	// The commands of a transaction are all run before the blocked clients are served
+	if !r.inExec {
+		r.server.serveBlockedClients()
+	}
+	return reply
+}
+
+/*
+Blocks the client until ready returns a reply, or until the timeout expires and onTimeout gives the reply,
+a timeout of 0 waits forever. Called on the executor by a command that found nothing to reply yet:
+the command returns and the client waits off the executor, ready is run again on the executor after every write.
+In a transaction the client can't wait, onTimeout gives the reply right away.
+*/
+func (r *ReqHandlerImpl) block(timeout time.Duration, ready func() []byte, onTimeout func() []byte) []byte {
+	if r.inExec {
+		return onTimeout()
+	}
+	r.client.SetBlocked(true)
+	changed := r.server.changes()
+	r.waitOffExecutor(func() []byte {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		for {
+			select {
+			case <-changed:
+			case <-expired:
+				var reply []byte
+				r.server.execute(func() {
+					r.server.useDB(r.client.db)
+					r.client.SetBlocked(false)
+					reply = onTimeout()
+				})
+				return reply
+			case <-r.client.done:
+				return []byte{}
+			}
+			var reply []byte
+			r.server.execute(func() {
+				r.server.useDB(r.client.db)
+				if reply = ready(); reply != nil {
+					r.client.SetBlocked(false)
+				} else {
+					changed = r.server.changes()
+				}
+			})
+			if reply != nil {
+				return reply
+			}
+		}
+	})
 	return []byte{}
 }
 
+/*
+Makes the client run wait off the executor once the command returns, wait gives the reply of the command.
+A shutdown doesn't wait for the client while it waits, and the client is closed if its connection is,
+so that wait returns instead of serving a client that is gone.
+*/
+func (r *ReqHandlerImpl) waitOffExecutor(wait func() []byte) {
+	r.waiting = func() []byte {
+		r.server.setWaiting(r.client, true)
+		defer r.server.setWaiting(r.client, false)
+		defer r.client.watchConnection()()
+		return wait()
+	}
+}
+
+func isErrorReply(reply []byte) bool {
+	return len(reply) > 0 && reply[0] == '-'
+}
+
+// Returns the error replied by the commands a replica can't run
+func notOnReplica(req *Request) []byte {
+	return newSimpleError(fmt.Sprintf("ERR %s cannot be used with replica instances", req.command))
+}
+
 func (r *ReqHandlerImpl) ping(req *Request) []byte {
 	if len(req.args) > 0 {
 		return newBulkString(strings.Join(req.args, " "))
@@ -78,6 +223,96 @@ func (r *ReqHandlerImpl) echo(req *Request) []byte {
 	return newBulkString(strings.Join(req.args, " "))
 }
 
+// Returns the encoder matching the protocol negotiated by the client
+func (r *ReqHandlerImpl) encoder() *RespEncoder {
+	if r.client == nil {
+		return NewRespEncoder(RESP2)
+	}
+	return NewRespEncoder(r.client.Protocol())
+}
+
+/*
+HELLO [protover [AUTH username password] [SETNAME clientname]]
+
+Switches the connection to the protocol version requested, 2 or 3, and replies with
+information about the server. Every reply sent after a switch to 3 uses RESP3 types.
+*/
+func (r *ReqHandlerImpl) hello(req *Request) []byte {
+	protocol := r.client.Protocol()
+	args := req.args
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return newSimpleError("ERR Protocol version is not an integer or out of range")
+		}
+		if version != RESP2 && version != RESP3 {
+			return newSimpleError("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+		args = args[1:]
+	}
+	name, setName := "", false
+	user, authenticated := "", false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return newSimpleError("ERR Syntax error in HELLO option 'AUTH'")
+			}
+			if !r.server.CheckPassword(args[i+1], args[i+2]) {
+				return newSimpleError(WRONGPASS_ERROR)
+			}
+			user, authenticated = args[i+1], true
+			i += 2
+		case "SETNAME":
+			if i+1 >= len(args) {
+				return newSimpleError("ERR Syntax error in HELLO option 'SETNAME'")
+			}
+			name, setName = args[i+1], true
+			if !isValidClientName(name) {
+				return newSimpleError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return newSimpleError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+		}
+	}
+	if authenticated {
+		r.client.authenticated = true
+		r.client.user = user
+	} else if !r.client.authenticated {
+		return newSimpleError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	r.client.SetProtocol(protocol)
+	if setName {
+		r.client.SetName(name)
+	}
+
+	role := r.server.Info()["role"]
+	if role == "slave" {
+		role = "replica"
+	}
+	return NewRespEncoder(protocol).Map(
+		string(newBulkString("server")), string(newBulkString("redis")),
+		string(newBulkString("version")), string(newBulkString(REDIS_VERSION)),
+		string(newBulkString("proto")), string(newInteger(protocol)),
+		string(newBulkString("id")), string(newInteger(int(r.client.ID()))),
+		string(newBulkString("mode")), string(newBulkString("standalone")),
+		string(newBulkString("role")), string(newBulkString(role)),
+		string(newBulkString("modules")), string(newBulkArray()),
+	)
+}
+
+// Client names are limited to the printable characters, spaces excluded
+func isValidClientName(name string) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 type SetArgs struct {
 	expiry int64 // expiry in milliseconds
 	nx     bool  // only set the key if it does not already exist
@@ -125,35 +360,36 @@ func (r *ReqHandlerImpl) ExtractSetArgs(args []string) (SetArgs, error) {
 	return setArgs, nil
 }
 
-func (r *ReqHandlerImpl) set(req *Request) ([]byte, error) {
-	if len(req.args) < 2 {
-		return newSimpleString("error"), fmt.Errorf("error: SET command requires at least 2 arguments")
-	}
-	args, err := r.ExtractSetArgs(req.args)
+// SET <key> <value> [EX <seconds>] [PX <milliseconds>] [NX|XX], replies null if NX or XX prevented it
+func (r *ReqHandlerImpl) set(req *Request) []byte {
+	args, err := r.ExtractSetArgs(req.args[2:])
 	if err != nil {
-		return newSimpleString("error"), fmt.Errorf("error: while extracting set args")
+		return newSimpleError("ERR syntax error")
 	}
 	if args.nx {
-		if _, ok := r.server.Get(req.args[0]); ok == nil {
-			return newBulkString(""), fmt.Errorf("error: key already exists")
+		if r.server.KeyExists(req.args[0]) {
+			return r.encoder().Null()
 		}
 	} else if args.xx {
-		if _, ok := r.server.Get(req.args[0]); ok != nil {
-			return newSimpleString(""), fmt.Errorf("error: key does not exist")
+		if !r.server.KeyExists(req.args[0]) {
+			return r.encoder().Null()
 		}
 	}
 	r.server.Set(req.args[0], req.args[1])
 	if args.expiry > 0 {
 		r.server.ExpireIn(req.args[0], uint64(args.expiry))
 	}
-
-	return newSimpleString("OK"), nil
+	return newSimpleString("OK")
 }
+
 func (r *ReqHandlerImpl) get(req *Request) []byte {
-	if len(req.args) < 1 {
-		return newSimpleString("Error: GET command requires at least 1 argument")
+	v, err := r.server.Get(req.args[0])
+	if errors.Is(err, errWrongType) {
+		return newSimpleError(err.Error())
+	}
+	if err != nil {
+		return r.encoder().Null()
 	}
-	v, _ := r.server.Get(req.args[0])
 	return newBulkString(v)
 }
 
@@ -167,8 +403,8 @@ func (r *ReqHandlerImpl) info(req *Request) []byte {
 	role := fmt.Sprintf("role:%s", infos["role"])
 	replID := fmt.Sprintf("%s_replid:%s", infos["role"], infos["replicationID"])
 	replOffset := fmt.Sprintf("%s_repl_offset:%s", infos["role"], infos["replicationOffset"])
-	return newBulkString(
-		header + "\n" + role + "\n" + replID + "\n" + replOffset + "\n",
+	return r.encoder().VerbatimString("txt",
+		header+"\n"+role+"\n"+replID+"\n"+replOffset+"\n",
 	)
 }
 
@@ -178,23 +414,22 @@ func (r *ReqHandlerImpl) keys(req *Request) []byte {
 	return newBulkArray(keys...)
 }
 
-func (r *ReqHandlerImpl) config(req *Request) []byte {
-	if len(req.args) < 1 {
-		return newSimpleString("Error: CONFIG command requires at least 1 argument (GET or SET)")
-	} else if req.args[0] == "GET" {
-		return r.configGet(req.args[1])
-	}
-	return []byte{0}
-}
+// The parameters CONFIG GET knows about
+var configParameters = []string{"dir", "dbfilename", "bind", "protected-mode", "replica-read-only", "hz", "databases"}
 
-func (r *ReqHandlerImpl) configGet(key string) []byte {
+// CONFIG GET parameter [parameter ...], parameters are glob-style patterns
+func (r *ReqHandlerImpl) configGet(req *Request) []byte {
 	dir, fn := r.server.RDBInfo()
-	switch key {
-	case "dir":
-		return newBulkArray("dir", dir)
-	case "dbfilename":
-		return newBulkArray("dbfilename", fn)
-	default:
-		return newBulkString("")
+	infos := r.server.Info()
+	values := map[string]string{"dir": dir, "dbfilename": fn, "bind": infos["bind"], "protected-mode": infos["protected-mode"], "replica-read-only": infos["replica-read-only"], "hz": infos["hz"], "databases": infos["databases"]}
+	pairs := make([]string, 0)
+	for _, name := range configParameters {
+		for _, pattern := range req.args[1:] {
+			if matchPattern(strings.ToLower(pattern), name) {
+				pairs = append(pairs, string(newBulkString(name)), string(newBulkString(values[name])))
+				break
+			}
+		}
 	}
+	return r.encoder().Map(pairs...)
 }
@@ -1,233 +1,259 @@
 package server
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ReqHandlerMaster struct {
 	ReqHandlerImpl
-	master MasterServer
-	conn   net.Conn
 }
 
-func NewReqHandlerMaster(request []byte, s MasterServer, c net.Conn) *ReqHandlerMaster {
-	return &ReqHandlerMaster{ReqHandlerImpl: ReqHandlerImpl{request: request, server: s}, master: s, conn: c}
+func NewReqHandlerMaster(requests []Request, s MasterServer, c *Client) *ReqHandlerMaster {
+	return &ReqHandlerMaster{ReqHandlerImpl: ReqHandlerImpl{requests: requests, server: s, master: s, client: c}}
 }
 
-// Handles a request and returns a response
-func (r *ReqHandlerMaster) HandleRequest() []byte {
-	// The request can be stringifyied
-	re := strings.Split(string(r.request), CRLF)
-	fmt.Printf("Client request: %v\n", re)
-	reqD := NewRequestDecoder(re)
-	reqs, err := reqD.Decode()
-	if err != nil {
-		fmt.Printf("error while decoding the request: %s", err)
-		return []byte{}
+// Handles the requests, queueing one reply per request in the connection's writer, and flushes the replies
+func (r *ReqHandlerMaster) HandleRequest() error {
+	if len(r.requests) > 1 {
+		fmt.Printf("Processing pipelined requests, number of requests: %d\n", len(r.requests))
 	}
+	return r.writeReplies(r.handle)
+}
 
-	// This properly handles multiple requests received at once
-	// It decodes each request and processes them individually
-	// It then sends the responses back to the client
-	if len(reqs) > 1 {
-		fmt.Printf("Processing multiple requests, number of requests: %d\n", len(reqs))
-		bigResp := make([]string, 0)
-		for x, req := range reqs {
-			fmt.Printf("Sending decoded request %d to subreqhandler: command: %s, args: %v\n", x, req.command, req.args)
-			reqHandler := NewReqHandlerMaster(req.Encode(), r.master, r.conn)
-			resp := reqHandler.HandleRequest()
-			bigResp = append(bigResp, string(resp))
+// EXISTS <key> [key ...]
+func (r *ReqHandlerImpl) exists(req *Request) []byte {
+	return newInteger(r.server.Exists(req.args))
+}
+
+// COPY <source> <destination> [DB <destination-db>] [REPLACE]
+func (r *ReqHandlerImpl) copy(req *Request) []byte {
+	replace := false
+	db := r.client.db
+	for i := 2; i < len(req.args); i++ {
+		switch strings.ToUpper(req.args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 == len(req.args) {
+				return newSimpleError("ERR syntax error")
+			}
+			i++
+			var reply []byte
+			if db, reply = r.parseDBIndex(req.args[i]); reply != nil {
+				return reply
+			}
+		default:
+			return newSimpleError("ERR syntax error")
 		}
-		r.master.SendTo(r.conn, newBulkArrayOfArrays(bigResp...))
-		return []byte{}
 	}
+	if db == r.client.db && req.args[0] == req.args[1] {
+		return newSimpleError("ERR source and destination objects are the same")
+	}
+	err := r.server.CopyTo(req.args[0], req.args[1], db, replace)
+	if err != nil {
+		return newInteger(0)
+	}
+	return newInteger(1)
+}
+
+// DEL <key> [key ...]
+func (r *ReqHandlerImpl) del(req *Request) []byte {
+	return newInteger(r.server.Del(req.args))
+}
 
-	req := reqs[0]
-	fmt.Printf("Decoded request: command: %s, args: %v\n", req.command, req.args)
-	// Check if the request needs to be queued, if so, add it to the queue and return QUEUED
-	// Do not queue EXEC && DISCARD commands as they are meant to exec/interrupt the queue
-	if r.master.IsInQueue(r.conn.RemoteAddr().String()) && req.command != "EXEC" && req.command != "DISCARD" {
-		r.master.AddToQueue(r.conn.RemoteAddr().String(), req)
-		return newSimpleString("QUEUED")
+// INCR <key>
+func (r *ReqHandlerImpl) incr(req *Request) []byte {
+	newValue, err := r.server.Increment(req.args[0])
+	if errors.Is(err, errWrongType) {
+		return newSimpleError(err.Error())
 	}
+	if err != nil {
+		return newSimpleError("ERR value is not an integer or out of range")
+	}
+	return newInteger(newValue)
+}
 
-	commandLen := len(newBulkArray(append([]string{req.command}, req.args...)...))
-	switch req.command {
-	case "EXISTS":
-		return newInteger(r.master.Exists(req.args))
-	// COPY <source> <destination> [REPLACE]
-	case "COPY":
-		if len(req.args) < 2 {
-			return newSimpleError("ERR COPY command requires at least 2 arguments")
-		}
-		// check if REPLACE flag is present
-		replace := false
-		if len(req.args) > 2 {
-			for _, arg := range req.args[2:] {
-				if strings.ToUpper(arg) == "REPLACE" {
-					replace = true
-				}
-			}
-		}
-		err := r.master.CopyTo(req.args[0], req.args[1], replace)
-		if err != nil {
-			return newInteger(0)
-		}
-		return newInteger(1)
-	// PING [message]
-	case "PING":
-		return r.ping(&req)
-	// ECHO <message>
-	case "ECHO":
-		return r.echo(&req)
-	// DEL <key> [key ...]
-	case "DEL":
-		return newInteger(r.master.Del(req.args))
-	// XADD <key> <ID> <field> <value> [field value ...]
-	case "XADD":
-		resp, err := r.master.XAdd(&req)
-		if err != nil {
-			return newSimpleError(err.Error())
-		}
-		go r.master.Propagate(&req)
-		r.master.AddAckOffset(commandLen)
-		r.master.CacheRequest(&req)
-		fmt.Printf("Added %d bytes to Master offset, offset: %d\n", commandLen, r.master.GetAckOffset())
-		return newBulkString(resp)
-	// SET <key> <value> [EX <seconds>] [PX <milliseconds>] [NX|XX]
-	case "SET":
-		resp, err := r.set(&req)
-		if err != nil {
-			return newSimpleError("ERR " + err.Error())
-		}
-		go r.master.Propagate(&req)
-		r.master.AddAckOffset(commandLen)
-		r.master.CacheRequest(&req)
-		fmt.Printf("Added %d bytes to Master offset, offset: %d\n", commandLen, r.master.GetAckOffset())
-		return resp
-	// INCR <key>
-	case "INCR":
-		if len(req.args) < 1 {
-			return newSimpleError("ERR INCR command requires at least 1 argument")
-		}
-		newValue, err := r.master.Increment(req.args[0])
-		if err != nil {
-			return newSimpleError("ERR value is not an integer or out of range")
-		}
-		go r.master.Propagate(&req)
-		r.master.AddAckOffset(commandLen)
-		r.master.CacheRequest(&req)
-		fmt.Printf("Added %d bytes to Master offset, offset: %d\n", commandLen, r.master.GetAckOffset())
-		return newInteger(newValue)
-	// GET <key>
-	case "GET":
-		return r.get(&req)
-	// XRANGE <key> - + [COUNT <count>] [LIMIT <offset> <count>]
-	case "XRANGE":
-		entries, err := r.master.XRange(&req)
-		if err != nil {
-			return newSimpleError(err.Error())
+// TYPE <key>
+func (r *ReqHandlerImpl) typeCommand(req *Request) []byte {
+	return newSimpleString(r.server.Type(req.args[0]))
+}
+
+// XADD <key> <ID> <field> <value> [field value ...]
+func (r *ReqHandlerImpl) xadd(req *Request) []byte {
+	resp, err := r.server.XAdd(req)
+	if err != nil {
+		return newSimpleError(err.Error())
+	}
+	r.server.signalKeyAsReady(r.client.db, req.args[0])
+	return newBulkString(resp)
+}
+
+// XRANGE <key> - + [COUNT <count>] [LIMIT <offset> <count>]
+func (r *ReqHandlerImpl) xrange(req *Request) []byte {
+	entries, err := r.server.XRange(req)
+	if err != nil {
+		return newSimpleError(err.Error())
+	}
+	return encodeXRangeResponse(entries)
+}
+
+// XREAD STREAMS <key> <id> [key id ...] [BLOCK <milliseconds>]
+func (r *ReqHandlerImpl) xread(req *Request) []byte {
+	args, err := r.XReadArgParser(req.args)
+	if err != nil {
+		return newSimpleError(err.Error())
+	}
+	xreadEntries, err := r.server.XRead(args)
+	if err != nil {
+		return newSimpleError(err.Error())
+	}
+	if !args.lock {
+		if len(xreadEntries) == 0 {
+			return r.encoder().NullArray()
 		}
-		return encodeXRangeResponse(entries)
-	// XREAD STREAMS <key> <id> [key id ...] [BLOCK <milliseconds>]
-	case "XREAD":
-		args, err := r.XReadArgParser(req.args)
+		return encodeXReadResponse(r.encoder(), args.keys, xreadEntries)
+	}
+	// BLOCK waits for an entry to be added to one of the streams, every stream with new entries is replied
+	serve := func(key string) []byte {
+		entries, err := r.server.XRead(args)
 		if err != nil {
 			return newSimpleError(err.Error())
 		}
-		xreadEntries, err := r.master.XRead(args)
-		if err != nil {
-			return newSimpleError(err.Error())
-		} else if len(xreadEntries) == 0 {
-			return newBulkString("")
+		keys := make([]string, 0, len(args.keys))
+		for _, key := range args.keys {
+			if len(entries[key]) > 0 {
+				keys = append(keys, key)
+			}
 		}
-		return encodeXReadResponse(args.keys, xreadEntries)
-	// MULTI
-	case "MULTI":
-		r.master.Multi(r.conn.RemoteAddr().String())
-		return newSimpleString("OK")
-	// EXEC
-	case "EXEC":
-		r.exec() // exec is self sufficient, it sends the response to the client
-		return []byte{}
-	// DISCARD
-	case "DISCARD":
-		return r.discard()
-	// CONFIG <set|get> <parameter> [value]
-	case "CONFIG":
-		return r.config(&req)
-	// KEYS <pattern>
-	case "KEYS":
-		return r.keys(&req)
-	// INFO [section]
-	case "INFO":
-		return r.info(&req)
-	// REPLCONF <option> <value>
-	case "REPLCONF":
-		return r.replicationConfig(&req)
-	// PSYNC <replicationID> <offset>
-	case "PSYNC":
-		return r.psync(&req)
-	// WAIT <numreplicas> <timeout>
-	case "WAIT":
-		return r.master.Wait(&req)
-	// TYPE <key>
-	case "TYPE":
-		if len(req.args) < 1 {
-			return newSimpleError("ERR TYPE command requires at least 1 argument")
+		if len(keys) == 0 {
+			return nil
 		}
-		return newSimpleString(r.master.Type(req.args[0]))
-	default:
-		return newSimpleError("ERR unknown command")
+		return encodeXReadResponse(r.encoder(), keys, entries)
+	}
+	return r.blockOnStreams(args.keys, time.Duration(args.blockMs)*time.Millisecond, serve, r.encoder().NullArray)
+}
+
+// MULTI
+func (r *ReqHandlerImpl) multi(req *Request) []byte {
+	if r.client.IsInMulti() {
+		return newSimpleError("ERR MULTI calls can not be nested")
 	}
+	r.client.Multi()
+	return newSimpleString("OK")
 }
 
-func (r *ReqHandlerMaster) discard() []byte {
-	if r.master.IsInQueue(r.conn.RemoteAddr().String()) {
-		r.master.RemoveFromQueue(r.conn.RemoteAddr().String())
+// DISCARD
+func (r *ReqHandlerImpl) discard(req *Request) []byte {
+	if r.client.IsInMulti() {
+		r.client.TakeQueue()
 		return newSimpleString("OK")
 	}
 	return newSimpleError("ERR DISCARD without MULTI")
 }
 
-func (r *ReqHandlerMaster) exec() {
-	if r.master.IsInQueue(r.conn.RemoteAddr().String()) {
-		reqs := r.master.GetQueuedRequests(r.conn.RemoteAddr().String())
-		if len(reqs) == 0 {
-			r.master.SendTo(r.conn, newBulkArray([]string{}...))
-			r.master.RemoveFromQueue(r.conn.RemoteAddr().String())
-			return
-		}
-		bigReq := make([]byte, 0)
-		for _, req := range reqs {
-			bigReq = append(bigReq, req.Encode()...)
-		}
-		r.master.RemoveFromQueue(r.conn.RemoteAddr().String())
-		reqHandler := NewReqHandlerMaster(bigReq, r.master, r.conn)
-		resp := reqHandler.HandleRequest()
-		if len(resp) > 0 {
-			go r.master.SendTo(r.conn, resp)
+// EXEC, executes the queued requests and returns their replies in an array
+func (r *ReqHandlerImpl) exec(req *Request) []byte {
+	if !r.client.IsInMulti() {
+		return newSimpleError("ERR EXEC without MULTI")
+	}
+	dirty := r.client.flags&CLIENT_DIRTY_EXEC != 0
+	reqs := r.client.TakeQueue()
+	if dirty {
+		return newSimpleError("EXECABORT Transaction discarded because of previous errors.")
+	}
+	replies := make([]string, 0, len(reqs))
+	r.inExec = true
+	defer func() { r.inExec = false }()
+	for _, req := range reqs {
+		// The requests were looked up when they were queued, but the rules may have changed since
+		cmd, _ := lookupCommand(&req)
+		if reply := r.checkPermissions(cmd, &req); reply != nil {
+			replies = append(replies, string(reply))
+			continue
 		}
-	} else {
-		r.master.SendTo(r.conn, newSimpleError("ERR EXEC without MULTI"))
+		replies = append(replies, string(r.call(cmd, &req)))
+	}
+	return newBulkArrayOfArrays(replies...)
+}
+
+/*
+WAIT numreplicas timeout
+
+This command blocks the current client until all the previous write commands are successfully transferred and acknowledged
+by at least the number of replicas specified in the numreplicas argument.
+If the value you specify for the timeout argument (in milliseconds) is reached, the command returns even if the specified number of replicas were not yet reached.
+A timeout of 0 blocks forever.
+
+The command will always return the number of replicas that acknowledged the write commands sent by the current client before the WAIT command
+both in the case where the specified number of replicas are reached, or when the timeout is reached.
+*/
+func (r *ReqHandlerImpl) wait(req *Request) []byte {
+	if r.master == nil {
+		return notOnReplica(req)
+	}
+	numOfReplicas, err := strconv.Atoi(req.args[0])
+	if err != nil {
+		return newSimpleString("Error parsing replicas count")
+	}
+	timeout, err := strconv.Atoi(req.args[1])
+	if err != nil {
+		return newSimpleString("Error parsing timeout")
 	}
+
+	if numOfReplicas == 0 {
+		// We can return immediately since the number of replicas is 0
+		return newInteger(0)
+	} else if r.master.GetAckOffset() == 0 {
+		// Return the number of known replicas
+		return newInteger(len(r.master.GetReplicas()))
+	}
+
+	fmt.Printf("Waiting for %d replicas with %dms timeout\n", numOfReplicas, timeout)
+	r.master.RequestAcks()
+	// The ACKs are counted as the replicas answer, see replicationConfig
+	return r.block(time.Duration(timeout)*time.Millisecond, func() []byte {
+		if r.master.AcksReceived() < numOfReplicas {
+			return nil
+		}
+		return newInteger(r.master.AcksReceived())
+	}, func() []byte {
+		fmt.Printf("Timeout reached\n")
+		return newInteger(r.master.AcksReceived())
+	})
 }
 
-func (r *ReqHandlerMaster) replicationConfig(req *Request) []byte {
+/*
+REPLCONF <option> <value>
+
+A replica receives REPLCONF GETACK from its master and answers with its offset,
+the only reply a replica sends to its master.
+*/
+func (r *ReqHandlerImpl) replicationConfig(req *Request) []byte {
 	if len(req.args) < 2 {
 		return newSimpleError("ERR REPLCONF command requires at least 2 arguments")
 	}
+	if r.client.flags&CLIENT_MASTER != 0 {
+		if strings.ToUpper(req.args[0]) == "GETACK" {
+			offset := r.server.GetAckOffset()
+			r.client.writer.Write(newBulkArray("REPLCONF", "ACK", strconv.Itoa(offset)))
+			if err := r.client.writer.Flush(); err != nil {
+				fmt.Printf("Error sending ACK to master: %s\n", err)
+			}
+		}
+		return []byte{}
+	}
+	if r.master == nil {
+		return notOnReplica(req)
+	}
 	for _, arg := range req.args {
 		switch arg {
-		case "listening-port":
-			addr := r.conn.RemoteAddr().String()
-			r.master.AddReplica(addr, r.conn)
 		case "ACK":
-			fmt.Printf("Received ACK from replica %s\n", r.conn.RemoteAddr().String())
+			fmt.Printf("Received ACK from replica %s\n", r.client.Addr())
 			r.master.AddAckReceived()
 			return []byte{}
 		}
@@ -236,18 +262,34 @@ func (r *ReqHandlerMaster) replicationConfig(req *Request) []byte {
 	return newSimpleString("OK")
 }
 
-func (r *ReqHandlerMaster) psync(req *Request) []byte {
-	if len(req.args) < 2 {
-		return newSimpleError("ERR PSYNC command requires at least 2 arguments")
+/*
+PSYNC <replicationID> <offset>
+
+Replies FULLRESYNC followed by the RDB file, both are flushed before the connection
+is registered as a replica so that propagated commands can only come after the RDB.
+*/
+func (r *ReqHandlerImpl) psync(req *Request) []byte {
+	if r.master == nil {
+		return notOnReplica(req)
 	}
 	infos := r.master.Info()
-	go r.master.SendRDBFile(r.conn)
-	return newBulkString("+FULLRESYNC " + infos["replicationID"] + " 0")
+	r.client.writer.Write(newSimpleString("FULLRESYNC " + infos["replicationID"] + " 0"))
+	if err := r.master.SendRDBFile(r.client.writer); err != nil {
+		fmt.Printf("Error sending RDB file: %s\n", err)
+		return []byte{}
+	}
+	if err := r.client.writer.Flush(); err != nil {
+		fmt.Printf("Error sending RDB file: %s\n", err)
+		return []byte{}
+	}
+	r.client.flags |= CLIENT_REPLICA
+	r.master.AddReplica(r.client)
+	return []byte{}
 }
 
 type XReadArg struct {
 	keys    []string
-	ids     []int
+	ids     []StreamID // the entries after these IDs are read
 	blockMs int
 	lock    bool
 }
@@ -255,13 +297,13 @@ type XReadArg struct {
 func newXReadArg() XReadArg {
 	return XReadArg{
 		keys:    make([]string, 0),
-		ids:     make([]int, 0),
+		ids:     make([]StreamID, 0),
 		blockMs: 0,
 		lock:    false,
 	}
 }
 
-func (r *ReqHandlerMaster) XReadArgParser(args []string) (XReadArg, error) {
+func (r *ReqHandlerImpl) XReadArgParser(args []string) (XReadArg, error) {
 	blockRegexp := regexp.MustCompile(`(?i)^BLOCK$`)
 	regexStream := regexp.MustCompile(`(?i)^STREAMS$`)
 	regexID := regexp.MustCompile(`^\d+-?\d*$`)
@@ -273,22 +315,21 @@ func (r *ReqHandlerMaster) XReadArgParser(args []string) (XReadArg, error) {
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 		if arg == "$" { // Akin to using the lastID entry in the stream
-			argsParsed.ids = append(argsParsed.ids, -1)
-			keyIndex := len(argsParsed.ids) - 1
-			if keyIndex < 0 {
+			keyIndex := len(argsParsed.ids)
+			if keyIndex >= len(argsParsed.keys) {
 				return XReadArg{}, fmt.Errorf("XREAD command requires a key before the $ argument")
 			}
 			// Get the last entry from the stream
-			entry, err := r.master.GetLastEntryFromStream(argsParsed.keys[keyIndex])
+			entry, err := r.server.GetLastEntryFromStream(argsParsed.keys[keyIndex])
 			if err != nil {
 				return XReadArg{}, err
 			}
-			if entry.IsEmpty() { // If the stream is empty, set the ID to 0 to effectively read from the beginning
-				argsParsed.ids[keyIndex] = 0
-				continue
+			// An empty stream is read from the beginning
+			id := StreamID{}
+			if !entry.IsEmpty() {
+				id, _ = parseStreamID(entry.ID())
 			}
-			id, _ := strconv.Atoi(strings.ReplaceAll(entry.ID(), "-", ""))
-			argsParsed.ids[keyIndex] = id + 1
+			argsParsed.ids = append(argsParsed.ids, id)
 		} else if blockRegexp.MatchString(arg) {
 			if i+1 >= len(args) {
 				return XReadArg{}, fmt.Errorf("XREAD block argument requires a timestamp")
@@ -303,11 +344,11 @@ func (r *ReqHandlerMaster) XReadArgParser(args []string) (XReadArg, error) {
 		} else if regexStream.MatchString(arg) {
 			isStream = true
 		} else if regexID.MatchString(arg) {
-			id, err := strconv.Atoi(strings.ReplaceAll(arg, "-", ""))
+			id, err := parseStreamID(arg)
 			if err != nil {
 				return XReadArg{}, err
 			}
-			argsParsed.ids = append(argsParsed.ids, id+1)
+			argsParsed.ids = append(argsParsed.ids, id)
 		} else {
 			if isStream {
 				argsParsed.keys = append(argsParsed.keys, arg)
@@ -336,7 +377,8 @@ func encodeXRangeResponse(entries []StreamEntry) []byte {
 	return resp
 }
 
-func encodeXReadResponse(keyOrder []string, entryMap map[string][]StreamEntry) []byte {
+// Encodes the entries of each stream, as a map keyed by stream name in RESP3
+func encodeXReadResponse(enc *RespEncoder, keyOrder []string, entryMap map[string][]StreamEntry) []byte {
 	keys := make([]string, 0)
 	for _, key := range keyOrder {
 		entries, ok := entryMap[key]
@@ -354,9 +396,18 @@ func encodeXReadResponse(keyOrder []string, entryMap map[string][]StreamEntry) [
 		}
 		keyContent := string(newBulkArrayOfArrays(content...))
 		keyName := string(newBulkString(key))
-		keys = append(keys, string(newBulkArrayOfArrays(keyName, keyContent)))
+		if enc.Protocol() == RESP3 {
+			keys = append(keys, keyName, keyContent)
+		} else {
+			keys = append(keys, string(newBulkArrayOfArrays(keyName, keyContent)))
+		}
+	}
+	var resp []byte
+	if enc.Protocol() == RESP3 {
+		resp = enc.Map(keys...)
+	} else {
+		resp = newBulkArrayOfArrays(keys...)
 	}
-	resp := newBulkArrayOfArrays(keys...)
 	fmt.Printf("XREAD: '%s'\n", strings.ReplaceAll(string(resp), "\r\n", "\\r\\n"))
 	return resp
 }
@@ -1,15 +1,21 @@
 package server
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
 
 func newSimpleString(s string) []byte {
 	return []byte(fmt.Sprintf("+%s%s", s, CRLF))
 }
 
 func newBulkString(s string) []byte {
-	if s == "" {
-		return []byte("$-1" + CRLF)
-	}
 	return []byte(fmt.Sprintf("$%d%s%s%s", len(s), CRLF, s, CRLF))
 }
 
@@ -36,3 +42,107 @@ func newInteger(i int) []byte {
 func newSimpleError(s string) []byte {
 	return []byte(fmt.Sprintf("-%s%s", s, CRLF))
 }
+
+// Formats a float the way Redis does, without exponent for usual magnitudes
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	abs := math.Abs(f)
+	if abs != 0 && (abs >= 1e21 || abs < 1e-6) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+/*
+RespEncoder encodes the replies whose representation depends on the protocol negotiated with HELLO.
+
+RESP3 types are downgraded to their RESP2 equivalent when the connection speaks RESP2:
+maps and sets become flat arrays, doubles and verbatim strings become bulk strings
+and push messages become arrays.
+The elements passed to the aggregate types must already be encoded.
+*/
+type RespEncoder struct {
+	protocol int
+}
+
+func NewRespEncoder(protocol int) *RespEncoder {
+	if protocol != RESP3 {
+		protocol = RESP2
+	}
+	return &RespEncoder{protocol: protocol}
+}
+
+func (e *RespEncoder) Protocol() int {
+	return e.protocol
+}
+
+// The null reply used in place of a missing bulk string
+func (e *RespEncoder) Null() []byte {
+	if e.protocol == RESP3 {
+		return []byte("_" + CRLF)
+	}
+	return []byte("$-1" + CRLF)
+}
+
+// The null reply used in place of a missing array
+func (e *RespEncoder) NullArray() []byte {
+	if e.protocol == RESP3 {
+		return []byte("_" + CRLF)
+	}
+	return []byte("*-1" + CRLF)
+}
+
+// A map made of encoded keys and values: key1, value1, key2, value2...
+func (e *RespEncoder) Map(pairs ...string) []byte {
+	if e.protocol == RESP3 {
+		return e.aggregate('%', len(pairs)/2, pairs)
+	}
+	return newBulkArrayOfArrays(pairs...)
+}
+
+// An unordered collection of encoded elements
+func (e *RespEncoder) Set(elements ...string) []byte {
+	if e.protocol == RESP3 {
+		return e.aggregate('~', len(elements), elements)
+	}
+	return newBulkArrayOfArrays(elements...)
+}
+
+// An out of band message, such as a pub/sub message
+func (e *RespEncoder) Push(elements ...string) []byte {
+	if e.protocol == RESP3 {
+		return e.aggregate('>', len(elements), elements)
+	}
+	return newBulkArrayOfArrays(elements...)
+}
+
+// A floating point number, such as the result of HINCRBYFLOAT
+func (e *RespEncoder) Double(f float64) []byte {
+	if e.protocol == RESP3 {
+		return []byte("," + formatDouble(f) + CRLF)
+	}
+	return newBulkString(formatDouble(f))
+}
+
+// A string along with its format, a three letters type such as "txt" or "mkd"
+func (e *RespEncoder) VerbatimString(format, s string) []byte {
+	if e.protocol == RESP3 {
+		return []byte(fmt.Sprintf("=%d%s%s:%s%s", len(s)+4, CRLF, format, s, CRLF))
+	}
+	return newBulkString(s)
+}
+
+func (e *RespEncoder) aggregate(prefix byte, count int, elements []string) []byte {
+	str := []byte(fmt.Sprintf("%c%d%s", prefix, count, CRLF))
+	for _, element := range elements {
+		str = append(str, []byte(element)...)
+	}
+	return str
+}
@@ -0,0 +1,544 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Largest bulk string accepted, matches the proto-max-bulk-len default of Redis
+	MAX_BULK_LEN = 512 * 1024 * 1024
+	// Largest number of elements accepted in a multibulk request
+	MAX_MULTIBULK_LEN = 1024 * 1024
+	// Largest line accepted for the header of a frame
+	MAX_LINE_LEN = 64 * 1024
+	// Largest buffer allocated for a bulk string before its data is read, it grows as the data comes in
+	MAX_BULK_PREALLOC = 64 * 1024
+)
+
+// ProtocolError is returned when the data read from a connection is not valid RESP
+// The connection must be closed after replying with the error, the stream cannot be resynchronised
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+func newProtocolError(format string, a ...interface{}) error {
+	return &ProtocolError{msg: fmt.Sprintf(format, a...)}
+}
+
+// RespValue is a single decoded RESP frame
+type RespValue struct {
+	kind  byte        // The RESP type prefix, e.g. '+', '$', '*' or '%'
+	str   string      // Payload of strings, errors, doubles and big numbers
+	num   int64       // Payload of integers and booleans
+	elems []RespValue // Elements of arrays, sets and pushes, keys and values of maps
+	null  bool        // Null bulk string, null array or RESP3 null
+}
+
+func (v RespValue) Kind() byte {
+	return v.kind
+}
+
+func (v RespValue) Str() string {
+	return v.str
+}
+
+func (v RespValue) Int() int64 {
+	return v.num
+}
+
+func (v RespValue) Array() []RespValue {
+	return v.elems
+}
+
+func (v RespValue) IsNull() bool {
+	return v.null
+}
+
+func (v RespValue) IsError() bool {
+	return v.kind == '-' || v.kind == '!'
+}
+
+/*
+RespReader decodes RESP frames from a connection.
+
+The reader is backed by a bufio.Reader, so a frame split across several TCP reads is
+reassembled transparently and the bytes following a frame stay buffered for the next call.
+Bulk strings are read using their declared length, which makes the reader binary safe:
+a value may contain "\r\n" or any other byte.
+*/
+type RespReader struct {
+	rd *bufio.Reader
+	// Number of bytes consumed by the frame being read
+	consumed int
+}
+
+func NewRespReader(r io.Reader) *RespReader {
+	return &RespReader{rd: bufio.NewReader(r)}
+}
+
+// Returns the number of bytes that can be read without touching the connection
+func (r *RespReader) Buffered() int {
+	return r.rd.Buffered()
+}
+
+// Returns the next n bytes without consuming them, blocking until they are read from the connection
+func (r *RespReader) Peek(n int) ([]byte, error) {
+	return r.rd.Peek(n)
+}
+
+// Returns the size of the read buffer
+func (r *RespReader) Size() int {
+	return r.rd.Size()
+}
+
+// Reads one request sent by a client
+func (r *RespReader) ReadRequest() (*Request, error) {
+	for {
+		r.consumed = 0
+		prefix, err := r.rd.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if prefix[0] != '*' {
+			req, err := r.readInlineRequest()
+			// Empty lines are silently skipped, as Redis does
+			if err == nil && req == nil {
+				continue
+			}
+			return req, err
+		}
+		r.rd.Discard(1)
+		r.consumed++
+		count, err := r.readInteger()
+		if err != nil {
+			return nil, err
+		}
+		if count > MAX_MULTIBULK_LEN {
+			return nil, newProtocolError("invalid multibulk length")
+		}
+		// Empty multibulk requests are silently skipped, as Redis does
+		if count <= 0 {
+			continue
+		}
+		parts := make([]string, 0, count)
+		for i := 0; i < int(count); i++ {
+			prefix, err := r.rd.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			r.consumed++
+			if prefix != '$' {
+				return nil, newProtocolError("expected '$', got '%c'", prefix)
+			}
+			s, null, err := r.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			if null {
+				return nil, newProtocolError("invalid bulk length")
+			}
+			parts = append(parts, s)
+		}
+		req := NewRequest(strings.ToUpper(parts[0]), parts[1:]...)
+		req.size = r.consumed
+		return req, nil
+	}
+}
+
+/*
+Reads all the requests available: blocks until one request is complete, then
+keeps reading the requests already sitting in the buffer.
+This is how pipelined requests sent in a single write are grouped together.
+*/
+func (r *RespReader) ReadRequests() ([]Request, error) {
+	req, err := r.ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	reqs := []Request{*req}
+	for r.hasBufferedFrame() {
+		req, err := r.ReadRequest()
+		if err != nil {
+			return reqs, err
+		}
+		reqs = append(reqs, *req)
+	}
+	return reqs, nil
+}
+
+// Reads any RESP2 or RESP3 frame, used to read replies
+func (r *RespReader) ReadValue() (RespValue, error) {
+	prefix, err := r.rd.ReadByte()
+	if err != nil {
+		return RespValue{}, err
+	}
+	r.consumed++
+	switch prefix {
+	case '+', '-', '(':
+		line, err := r.readLine()
+		if err != nil {
+			return RespValue{}, err
+		}
+		return RespValue{kind: prefix, str: line}, nil
+	case ':':
+		n, err := r.readInteger()
+		if err != nil {
+			return RespValue{}, err
+		}
+		return RespValue{kind: prefix, num: n}, nil
+	case ',':
+		line, err := r.readLine()
+		if err != nil {
+			return RespValue{}, err
+		}
+		if _, err := strconv.ParseFloat(line, 64); err != nil {
+			return RespValue{}, newProtocolError("invalid double '%s'", line)
+		}
+		return RespValue{kind: prefix, str: line}, nil
+	case '#':
+		line, err := r.readLine()
+		if err != nil {
+			return RespValue{}, err
+		}
+		if line != "t" && line != "f" {
+			return RespValue{}, newProtocolError("invalid boolean '%s'", line)
+		}
+		if line == "t" {
+			return RespValue{kind: prefix, num: 1}, nil
+		}
+		return RespValue{kind: prefix, num: 0}, nil
+	case '_':
+		if _, err := r.readLine(); err != nil {
+			return RespValue{}, err
+		}
+		return RespValue{kind: prefix, null: true}, nil
+	case '$', '!', '=':
+		s, null, err := r.readBulk()
+		if err != nil {
+			return RespValue{}, err
+		}
+		// Verbatim strings start with their format, e.g. "txt:"
+		if prefix == '=' {
+			if len(s) < 4 || s[3] != ':' {
+				return RespValue{}, newProtocolError("invalid verbatim string")
+			}
+			s = s[4:]
+		}
+		return RespValue{kind: prefix, str: s, null: null}, nil
+	case '*', '~', '>', '%', '|':
+		count, err := r.readInteger()
+		if err != nil {
+			return RespValue{}, err
+		}
+		if count < 0 {
+			return RespValue{kind: prefix, null: true}, nil
+		}
+		if count > MAX_MULTIBULK_LEN {
+			return RespValue{}, newProtocolError("invalid multibulk length")
+		}
+		// Maps and attributes hold a key and a value per entry
+		if prefix == '%' || prefix == '|' {
+			count *= 2
+		}
+		elems := make([]RespValue, 0, count)
+		for i := 0; i < int(count); i++ {
+			v, err := r.ReadValue()
+			if err != nil {
+				return RespValue{}, err
+			}
+			elems = append(elems, v)
+		}
+		// Attributes are metadata about the reply that follows them, they are skipped
+		if prefix == '|' {
+			return r.ReadValue()
+		}
+		return RespValue{kind: prefix, elems: elems}, nil
+	default:
+		return RespValue{}, newProtocolError("unexpected type byte '%c'", prefix)
+	}
+}
+
+/*
+Reads the RDB payload sent by a master after FULLRESYNC.
+Its format is $<length>\r\n<content>, it's a bulk string without the trailing CRLF.
+*/
+func (r *RespReader) ReadRDB() ([]byte, error) {
+	prefix, err := r.rd.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if prefix != '$' {
+		return nil, newProtocolError("expected '$' before the RDB payload, got '%c'", prefix)
+	}
+	length, err := r.readInteger()
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 || length > MAX_BULK_LEN {
+		return nil, newProtocolError("invalid RDB length")
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r.rd, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+/*
+Reads a request sent in the inline format, a line of space separated arguments such as:
+
+	SET greeting "hello world"\r\n
+
+This is what telnet and netcat send. Arguments are split the way redis-cli does it,
+see splitArgs. Returns a nil request for an empty line.
+*/
+func (r *RespReader) readInlineRequest() (*Request, error) {
+	line, err := r.readRawLine()
+	if err != nil {
+		if errors.Is(err, errLineTooLong) {
+			return nil, newProtocolError("too big inline request")
+		}
+		return nil, err
+	}
+	parts, err := splitArgs(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	req := NewRequest(strings.ToUpper(parts[0]), parts[1:]...)
+	req.size = r.consumed
+	return req, nil
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// Reads a line up to and including '\n'
+func (r *RespReader) readRawLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > MAX_LINE_LEN {
+			return "", errLineTooLong
+		}
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+	r.consumed += len(line)
+	return string(line), nil
+}
+
+// Reads a line terminated by CRLF and returns it without the terminator
+func (r *RespReader) readLine() (string, error) {
+	line, err := r.readRawLine()
+	if err != nil {
+		if errors.Is(err, errLineTooLong) {
+			return "", newProtocolError("too big line")
+		}
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", newProtocolError("expected CRLF line terminator")
+	}
+	return line[:len(line)-2], nil
+}
+
+/*
+Splits a line into arguments, following the rules of redis-cli (sdssplitargs in Redis):
+
+	foo bar "hello world"    -> [foo bar "hello world"]
+	"\x41\x42\tC\n"           -> ["AB\tC\n"], escapes are interpreted in double quotes
+	'it\'s raw\n'             -> ["it's raw\n"], only \' is an escape in single quotes
+
+A closing quote must be followed by a space or the end of the line.
+*/
+func splitArgs(line string) ([]string, error) {
+	args := make([]string, 0)
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+	}
+	isHex := func(c byte) bool {
+		return strings.IndexByte("0123456789abcdefABCDEF", c) >= 0
+	}
+	unbalanced := newProtocolError("unbalanced quotes in request")
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var current []byte
+		inDoubleQuotes, inSingleQuotes, done := false, false, false
+		for !done {
+			if inDoubleQuotes {
+				switch {
+				case i == len(line):
+					return nil, unbalanced
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current = append(current, byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, line[i])
+					}
+				case line[i] == '"':
+					// The closing quote must be followed by a space or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, unbalanced
+					}
+					done = true
+				default:
+					current = append(current, line[i])
+				}
+			} else if inSingleQuotes {
+				switch {
+				case i == len(line):
+					return nil, unbalanced
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current = append(current, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, unbalanced
+					}
+					done = true
+				default:
+					current = append(current, line[i])
+				}
+			} else {
+				switch {
+				case i == len(line) || isSpace(line[i]):
+					done = true
+				case line[i] == '"':
+					inDoubleQuotes = true
+				case line[i] == '\'':
+					inSingleQuotes = true
+				default:
+					current = append(current, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
+
+// Reads a line holding a base 10 integer
+func (r *RespReader) readInteger() (int64, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return 0, newProtocolError("invalid integer '%s'", line)
+	}
+	return n, nil
+}
+
+// Reads the length and the content of a bulk string, the '$' prefix must already be consumed
+func (r *RespReader) readBulk() (string, bool, error) {
+	length, err := r.readInteger()
+	if err != nil {
+		return "", false, err
+	}
+	if length < 0 {
+		return "", true, nil
+	}
+	if length > MAX_BULK_LEN {
+		return "", false, newProtocolError("invalid bulk length")
+	}
+	// The length is sent by the client, a few bytes must not be enough to allocate MAX_BULK_LEN
+	var buf bytes.Buffer
+	buf.Grow(int(min(length+2, MAX_BULK_PREALLOC)))
+	if _, err := io.CopyN(&buf, r.rd, length+2); err != nil {
+		return "", false, err
+	}
+	data := buf.Bytes()
+	r.consumed += len(data)
+	if !bytes.HasSuffix(data, []byte(CRLF)) {
+		return "", false, newProtocolError("expected CRLF after bulk string")
+	}
+	return string(data[:length]), false, nil
+}
+
+// Checks whether a complete request sits in the buffer, without reading from the connection
+func (r *RespReader) hasBufferedFrame() bool {
+	buf, _ := r.rd.Peek(r.rd.Buffered())
+	return len(buf) > 0 && frameLength(buf) > 0
+}
+
+// Returns the length of the request at the start of buf, or -1 if the request is incomplete
+func frameLength(buf []byte) int {
+	lineEnd := func(from int) int {
+		i := bytes.IndexByte(buf[from:], '\n')
+		if i < 0 {
+			return -1
+		}
+		return from + i + 1
+	}
+	end := lineEnd(0)
+	if end < 0 {
+		return -1
+	}
+	// Inline requests end with the line
+	if buf[0] != '*' {
+		return end
+	}
+	count, err := strconv.Atoi(strings.TrimRight(string(buf[1:end]), CRLF))
+	if err != nil {
+		return end
+	}
+	cursor := end
+	for i := 0; i < count; i++ {
+		if cursor >= len(buf) {
+			return -1
+		}
+		end = lineEnd(cursor)
+		if end < 0 {
+			return -1
+		}
+		length, err := strconv.Atoi(strings.TrimRight(string(buf[cursor+1:end]), CRLF))
+		if err != nil || buf[cursor] != '$' {
+			return end
+		}
+		cursor = end + length + 2
+	}
+	if cursor > len(buf) {
+		return -1
+	}
+	return cursor
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Returns the data in chunks of a fixed size, to simulate a request split across several TCP reads
+type chunkedReader struct {
+	data  []byte
+	chunk int
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.chunk
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	if n > len(p) {
+		n = len(p)
+	}
+	copy(p, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func TestRespReaderSplitReads(t *testing.T) {
+	value := `{"name":"John",` + "\r\n" + `"age":42}`
+	data := string(newBulkArray("SET", "profile", value)) + string(newBulkArray("GET", "profile"))
+	for _, chunk := range []int{1, 3, 7, len(data)} {
+		reader := NewRespReader(&chunkedReader{data: []byte(data), chunk: chunk})
+		req, err := reader.ReadRequest()
+		if err != nil {
+			t.Fatalf("chunk %d: unexpected error: %s", chunk, err)
+		}
+		if req.command != "SET" || len(req.args) != 2 || req.args[1] != value {
+			t.Fatalf("chunk %d: unexpected request: %s %q", chunk, req.command, req.args)
+		}
+		if req.size != len(req.Encode()) {
+			t.Fatalf("chunk %d: expected size %d, got %d", chunk, len(req.Encode()), req.size)
+		}
+		req, err = reader.ReadRequest()
+		if err != nil {
+			t.Fatalf("chunk %d: unexpected error: %s", chunk, err)
+		}
+		if req.command != "GET" || req.args[0] != "profile" {
+			t.Fatalf("chunk %d: unexpected request: %s %q", chunk, req.command, req.args)
+		}
+		if _, err := reader.ReadRequest(); !errors.Is(err, io.EOF) {
+			t.Fatalf("chunk %d: expected EOF, got %v", chunk, err)
+		}
+	}
+}
+
+func TestRespReaderBinaryValue(t *testing.T) {
+	value := string([]byte{0x00, 0xff, '\r', '\n', '$', '3', '\r', '\n'})
+	reader := NewRespReader(strings.NewReader(string(newBulkArray("SET", "bin", value))))
+	req, err := reader.ReadRequest()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if req.args[1] != value {
+		t.Fatalf("expected %q, got %q", value, req.args[1])
+	}
+}
+
+func TestRespReaderPipeline(t *testing.T) {
+	data := string(newBulkArray("PING")) + string(newBulkArray("ECHO", "hey")) + "*2\r\n$3\r\nGET\r\n$3\r\nfo"
+	reader := NewRespReader(strings.NewReader(data))
+	reqs, err := reader.ReadRequests()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The trailing partial request must not be part of the batch
+	if len(reqs) != 2 || reqs[0].command != "PING" || reqs[1].command != "ECHO" {
+		t.Fatalf("unexpected batch: %v", reqs)
+	}
+}
+
+func TestRespReaderProtocolErrors(t *testing.T) {
+	testCases := []string{
+		"*1\r\n:12\r\n",
+		"*1\r\n$abc\r\n",
+		"*1\r\n$3\r\nGETXX",
+		"*2000000\r\n",
+	}
+	for _, tc := range testCases {
+		reader := NewRespReader(strings.NewReader(tc))
+		_, err := reader.ReadRequest()
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			t.Fatalf("%q: expected a protocol error, got %v", tc, err)
+		}
+	}
+}
+
+func TestRespReaderRDB(t *testing.T) {
+	data := "+FULLRESYNC abc 0\r\n$5\r\nREDIS" + string(newBulkArray("SET", "k", "v"))
+	reader := NewRespReader(strings.NewReader(data))
+	v, err := reader.ReadValue()
+	if err != nil || v.Str() != "FULLRESYNC abc 0" {
+		t.Fatalf("unexpected value: %v %v", v, err)
+	}
+	rdb, err := reader.ReadRDB()
+	if err != nil || string(rdb) != "REDIS" {
+		t.Fatalf("unexpected RDB: %q %v", rdb, err)
+	}
+	req, err := reader.ReadRequest()
+	if err != nil || req.command != "SET" {
+		t.Fatalf("unexpected request: %v %v", req, err)
+	}
+}
+
+func TestRespReaderRESP3(t *testing.T) {
+	enc := NewRespEncoder(RESP3)
+	data := string(enc.Map(
+		string(newBulkString("proto")), string(newInteger(3)),
+		string(newBulkString("score")), string(enc.Double(1.5)),
+		string(newBulkString("flag")), "#t\r\n",
+		string(newBulkString("big")), "(3492890328409238509324850943850943825024385\r\n",
+		string(newBulkString("info")), string(enc.VerbatimString("txt", "role:master")),
+		string(newBulkString("missing")), string(enc.Null()),
+	))
+	v, err := NewRespReader(strings.NewReader(data)).ReadValue()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	elems := v.Array()
+	if v.Kind() != '%' || len(elems) != 12 {
+		t.Fatalf("expected a map of 6 entries, got %c with %d elements", v.Kind(), len(elems))
+	}
+	if elems[1].Int() != 3 || elems[3].Str() != "1.5" || elems[5].Int() != 1 {
+		t.Fatalf("unexpected values: %v", elems)
+	}
+	if elems[7].Kind() != '(' || elems[9].Str() != "role:master" || !elems[11].IsNull() {
+		t.Fatalf("unexpected values: %v", elems)
+	}
+}
+
+func TestRespReaderLargeBulkLength(t *testing.T) {
+	// Only the header of a bulk string as large as allowed is sent
+	data := fmt.Sprintf("*1\r\n$%d\r\nGET", MAX_BULK_LEN)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := NewRespReader(strings.NewReader(data)).ReadRequest()
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("expected the buffer to grow with the data, %d bytes were allocated", allocated)
+	}
+}
+
+func TestRespReaderInline(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected []string
+	}{
+		{"PING\r\n", []string{"PING"}},
+		{"set foo bar\n", []string{"SET", "foo", "bar"}},
+		{"  SET   foo   bar  \r\n", []string{"SET", "foo", "bar"}},
+		{`SET greeting "hello world"` + "\r\n", []string{"SET", "greeting", "hello world"}},
+		{`SET k "a\x41\tb\n\"q\""` + "\r\n", []string{"SET", "k", "aA\tb\n\"q\""}},
+		{`SET k 'it\'s \n raw'` + "\r\n", []string{"SET", "k", `it's \n raw`}},
+		{`SET k ""` + "\r\n", []string{"SET", "k", ""}},
+	}
+	for _, tc := range testCases {
+		req, err := NewRespReader(strings.NewReader(tc.line)).ReadRequest()
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", tc.line, err)
+		}
+		got := append([]string{req.command}, req.args...)
+		if strings.Join(got, "|") != strings.Join(tc.expected, "|") || len(got) != len(tc.expected) {
+			t.Fatalf("%q: expected %q, got %q", tc.line, tc.expected, got)
+		}
+		if req.size != len(tc.line) {
+			t.Fatalf("%q: expected size %d, got %d", tc.line, len(tc.line), req.size)
+		}
+	}
+}
+
+func TestRespReaderInlineMixed(t *testing.T) {
+	data := "\r\nPING\r\n" + string(newBulkArray("ECHO", "hey")) + "GET foo\r\n"
+	reqs, err := NewRespReader(strings.NewReader(data)).ReadRequests()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(reqs) != 3 || reqs[0].command != "PING" || reqs[1].command != "ECHO" || reqs[2].command != "GET" {
+		t.Fatalf("unexpected batch: %v", reqs)
+	}
+}
+
+func TestRespReaderInlineUnbalancedQuotes(t *testing.T) {
+	for _, line := range []string{`SET k "abc` + "\r\n", `SET k "abc"def` + "\r\n", `SET k 'abc` + "\r\n"} {
+		_, err := NewRespReader(strings.NewReader(line)).ReadRequest()
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			t.Fatalf("%q: expected a protocol error, got %v", line, err)
+		}
+	}
+}
@@ -1,45 +1,82 @@
 package server
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	utils "github.com/codecrafters-io/redis-starter-go/utils"
 )
 
 type RedisServer interface {
-	CopyTo(source string, destination string, replace bool) error
+	// Copies a key of the selected database to the database db
+	CopyTo(source string, destination string, db int, replace bool) error
+	// Moves a key of the selected database to the database db
+	MoveTo(key string, db int) bool
+	SwapDB(a, b int)
+	FlushAll()
 	Exists(keys []string) int
-	Init()
+	// Loads the ACL file and opens the listeners
+	Init() error
+	// Returns the address of the first listener
+	Addr() string
 	// Returns various information about the server
 	Info() map[string]string
-	Listen()
-	SendTo(net.Conn, []byte)
+	// Accepts connections until the server is shut down
+	Listen() error
 	HandleClientConnections(conn net.Conn)
 	AddAckOffset(offset int)
 	GetAckOffset() int
-	// Appends a request to the queue of requests for a given client, used for the MULTI command
-	AddToQueue(addr string, req Request)
-	// Add a client to the connected clients
-	AddClient(addr string)
-	// Removes a client from the queue of requests
-	RemoveFromQueue(addr string)
-	// Removes a client from the connected clients
-	RemoveClient(addr string)
-	// Returns the queued requests for a given client
-	GetQueuedRequests(string) []Request
-	// Checks if a client is in the queue
-	IsInQueue(addr string) bool
-	// Check if a client is connected
-	IsConnected(addr string) bool
+	// Registers a new connection and returns its client
+	AddClient(conn net.Conn) *Client
+	// Unregisters a client and closes its connection
+	RemoveClient(c *Client)
+	// Returns the connected clients, ordered by ID
+	Clients() []*Client
+	// Checks if clients must authenticate before running commands
+	AuthRequired() bool
+	// Checks the credentials of a client
+	CheckPassword(user, password string) bool
+	// Returns the users and their rules
+	ACL() *ACL
+	// Checks if a replica refuses the write commands of its clients
+	ReplicaReadOnly() bool
+	// Shuts the server down, caller is the client running SHUTDOWN, nil otherwise
+	Shutdown(opts ShutdownOptions, caller *Client) error
+	// Cancels a shutdown waiting for the replicas
+	AbortShutdown() error
+	// Stops the executor and the background goroutines of a server that never listens
+	stop()
+	// Runs fn on the executor, returns false once the server is shut down
+	execute(fn func()) bool
+	// Returns a channel closed by the next write, called on the executor
+	changes() <-chan struct{}
+	// Wakes up the blocked clients after a write, called on the executor
+	signalChange()
+	// Marks a client as waiting off the executor in a blocking command
+	setWaiting(c *Client, waiting bool)
+	// Selects the database the next commands run against, called on the executor
+	useDB(index int)
+	// Returns the logical databases
+	databases() []Cache
+	// Queues a client blocked on lists until one of its keys is ready, called on the executor
+	blockForKeys(bc *blockedClient)
+	// Removes a blocked client from the queues, false if it was already served, called on the executor
+	unblockClient(bc *blockedClient) bool
+	// Marks a key as ready to serve the clients blocked on it, called on the executor by the writes to lists
+	signalKeyAsReady(db int, key string)
+	// Serves the clients blocked on the keys signaled as ready, called on the executor after a command
+	serveBlockedClients()
 
 	// Advanced commands
 	XAdd(*Request) (string, error)
 	XRange(*Request) ([]StreamEntry, error)
 	XRead(XReadArg) (map[string][]StreamEntry, error)
-	Multi(addr string) error
 
 	// Implement the RDBManager interface
 	RDBManager
@@ -51,37 +88,84 @@ const (
 	SERVER_ADDR = "127.0.0.1"
 	SERVER_PORT = "6379"
 	CRLF        = "\r\n"
-	LARGEST_INT = int(^uint(0) >> 1)
 	EMPTY_RDB   = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
+	// Version reported to the clients, the command set mimics this version of Redis
+	REDIS_VERSION = "7.4.0"
 )
 
 // RedisServerImpl implements the RedisServer interface
 type RedisServerImpl struct {
 	role              string
-	address           string
-	port              string
-	listener          net.Listener
+	bindAddresses     []string
+	protectedMode     bool
+	replicaReadOnly   bool        // a replica refuses the write commands of its clients, its own writes are never propagated
+	port              string      // TCP port, empty when TCP is disabled
+	unixSocket        string      // path of the unix socket to listen on, none if empty
+	unixSocketPerm    fs.FileMode // permissions of the unix socket file
+	tls               tlsOptions
+	listeners         []net.Listener
 	rdb               RDBManager
-	cache             Cache
+	dbs               []Cache // the logical databases, selected by index
+	db                int     // index of the database of the command being run
+	cache             Cache   // the database of the command being run, see useDB
 	replicationID     string
 	replicationOffset int
-	QueuedRequests    map[string][]Request // key is the address of the client
-	ConnectedClients  map[string]bool      // key is the address of the client
+	acl               *ACL
+	clients           map[int64]*Client // key is the ID of the client
+	nextClientID      int64
+	clientsMu         sync.Mutex
+	batches           *sync.Cond // on clientsMu, broadcast when paused or a client's running or waiting changes
+	paused            bool       // set by a shutdown, the clients wait before running new commands
+	pidFile           string     // file the process ID is written to, none if empty
+	shutdownMu        sync.Mutex
+	shutdownAbort     chan struct{} // closed by SHUTDOWN ABORT, nil unless a shutdown waits for the replicas
+	closing           bool          // the listeners are being closed by a shutdown
+	done              chan struct{} // closed once the server is shut down
+	executor          *executor     // runs the commands, see executor
+	flushReplicas     func()        // writes the replication stream still queued before a shutdown closes the connections, set by a master
+	hz                int           // frequency of the active expire cycle
+	blocking          blockingState // clients blocked on lists, see blockForKeys
+}
+
+// Sets the state shared by masters and replicas from the configuration
+func (s *RedisServerImpl) configure(role string, cfg Config) {
+	s.role = role
+	s.port = cfg.tcpPort()
+	s.dbs = make([]Cache, cfg.databases())
+	for i := range s.dbs {
+		s.dbs[i] = NewCache()
+	}
+	s.cache = s.dbs[0]
+	s.replicationID = utils.CreateReplicationID()
+	s.clients = make(map[int64]*Client)
+	s.batches = sync.NewCond(&s.clientsMu)
+	s.done = make(chan struct{})
+	s.executor = newExecutor(s.done)
+	s.unixSocket = cfg.UnixSocket
+	s.unixSocketPerm = cfg.UnixSocketPerm
+	s.pidFile = cfg.PidFile
+	s.replicaReadOnly = cfg.ReplicaReadOnly
+	s.hz = cfg.hz()
+	s.setBind(cfg)
+	s.setTLS(cfg)
+	s.setAuth(cfg)
 }
 
-// Increment the replication offset
-func (s *RedisServerImpl) AddAckOffset(offset int) {
-	s.replicationOffset += offset
+func (s *RedisServerImpl) execute(fn func()) bool {
+	return s.executor.run(fn)
 }
 
-// Add a client to the connected clients
-func (s *RedisServerImpl) AddClient(addr string) {
-	s.ConnectedClients[addr] = true
+func (s *RedisServerImpl) changes() <-chan struct{} {
+	return s.executor.changes()
 }
 
-// Appends a request to the queue of requests for a given client
-func (s *RedisServerImpl) AddToQueue(addr string, req Request) {
-	s.QueuedRequests[addr] = append(s.QueuedRequests[addr], req)
+func (s *RedisServerImpl) signalChange() {
+	s.executor.signalChange()
+}
+
+// Increment the replication offset
+func (s *RedisServerImpl) AddAckOffset(offset int) {
+	s.replicationOffset += offset
 }
 
 func (s *RedisServerImpl) Exists(keys []string) int {
@@ -94,73 +178,167 @@ func (s *RedisServerImpl) Exists(keys []string) int {
 	return count
 }
 
-// Removes a client from the queue of requests
-func (s *RedisServerImpl) RemoveFromQueue(addr string) {
-	delete(s.QueuedRequests, addr)
-}
-
-// Checks if a client is in the queue
-func (s *RedisServerImpl) IsInQueue(addr string) bool {
-	_, ok := s.QueuedRequests[addr]
-	return ok
-}
-
-// Check if a client is connected
-func (s *RedisServerImpl) IsConnected(addr string) bool {
-	_, ok := s.ConnectedClients[addr]
-	return ok
+/*
+Initialise the server, loading the ACL file, then creating a TCP listener per bind address,
+and a TLS listener and a unix socket listener if they are configured.
+TCP is disabled when the port is empty, to only accept TLS connections for instance.
+A port of 0 picks a free port, shared by the listeners of the other bind addresses.
+*/
+func (s *RedisServerImpl) Init() error {
+	if s.acl.file != "" {
+		if err := s.acl.Load(); err != nil {
+			return fmt.Errorf("error loading the ACL file: %w", err)
+		}
+	}
+	for _, bind := range s.bindAddresses {
+		host, optional := bindHost(bind)
+		if s.port != "" {
+			l, err := listenTCP(host, s.port)
+			if err := s.addListener(l, err, fmt.Sprintf("port %s on %s", s.port, host), optional); err != nil {
+				return err
+			}
+			if err == nil && s.port == "0" {
+				s.port = listenerPort(l)
+			}
+		}
+		if s.tls.enabled() {
+			l, err := listenTCP(host, s.tls.port)
+			if err == nil {
+				var tcp net.Listener = l
+				if l, err = s.tls.wrap(tcp); err != nil {
+					tcp.Close()
+				}
+			}
+			if err := s.addListener(l, err, fmt.Sprintf("TLS port %s on %s", s.tls.port, host), optional); err != nil {
+				return err
+			}
+		}
+	}
+	if s.unixSocket != "" {
+		l, err := listenUnix(s.unixSocket, s.unixSocketPerm)
+		if err := s.addListener(l, err, "unix socket "+s.unixSocket, false); err != nil {
+			return err
+		}
+	}
+	if len(s.listeners) == 0 && (s.port != "" || s.tls.enabled()) {
+		return errors.New("failed to bind any address")
+	}
+	s.writePidFile()
+	return nil
 }
 
-// Removes a client from the connected clients
-func (s *RedisServerImpl) RemoveClient(addr string) {
-	delete(s.ConnectedClients, addr)
+// Keeps a new listener, a failure closes the other listeners and is returned unless the listener is optional
+func (s *RedisServerImpl) addListener(l net.Listener, err error, description string, optional bool) error {
+	if err != nil {
+		fmt.Printf("Failed to listen on %s: %s\n", description, err)
+		if optional {
+			return nil
+		}
+		s.closeListeners()
+		return fmt.Errorf("failed to listen on %s: %w", description, err)
+	}
+	fmt.Printf("Listening on %s\n", description)
+	s.listeners = append(s.listeners, l)
+	return nil
 }
 
-// Returns the queued requests for a given client
-func (s *RedisServerImpl) GetQueuedRequests(addr string) []Request {
-	return s.QueuedRequests[addr]
+// Returns the port a TCP listener is bound to
+func listenerPort(l net.Listener) string {
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
 }
 
-// Sends data to a client, removing the client if the send fails
-func (s *RedisServerImpl) SendTo(conn net.Conn, data []byte) {
-	_, err := conn.Write(data)
-	if err != nil {
-		fmt.Println("Error sending data to client: ", err.Error())
-		fmt.Printf("Removing client: %s\n", conn.RemoteAddr().String())
-		s.RemoveClient(conn.RemoteAddr().String())
+// Returns the address of the first listener, the TCP one unless TCP is disabled
+func (s *RedisServerImpl) Addr() string {
+	if len(s.listeners) == 0 {
+		return ""
+	}
+	return s.listeners[0].Addr().String()
+}
+
+/*
+Accepts connections on every listener, passing each new connection to accepted.
+All the listeners feed the same handler, a client gets the same behaviour whether
+it is connected through TCP or through the unix socket.
+Blocks until the server is shut down, a listener failing outside of a shutdown
+closes the listeners and its error is returned.
+*/
+func (s *RedisServerImpl) serve(accepted func(conn net.Conn)) error {
+	errs := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		go func(l net.Listener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					errs <- err
+					return
+				}
+				// The password of the default user may be changed by ACL SETUSER on the executor
+				refused := false
+				s.execute(func() { refused = s.isRefusedByProtectedMode(conn) })
+				if refused {
+					fmt.Printf("Refusing %s, protected mode is enabled\n", conn.RemoteAddr().String())
+					go refuseConnection(conn, PROTECTED_MODE_ERROR)
+					continue
+				}
+				accepted(conn)
+			}
+		}(l)
+	}
+	select {
+	case err := <-errs:
+		if s.isClosing() {
+			<-s.done
+			return nil
+		}
+		fmt.Println("Error accepting connection: ", err.Error())
+		s.closeListeners()
+		return err
+	case <-s.done:
+		return nil
 	}
 }
 
-// Initialise the server, creating a listener
-func (s *RedisServerImpl) Init() {
-	l, err := net.Listen("tcp", fmt.Sprintf("%s:%s", s.address, s.port))
-	if err != nil {
-		fmt.Printf("Failed to bind to port %s\n", s.port)
-		os.Exit(1)
+func (s *RedisServerImpl) closeListeners() {
+	for _, l := range s.listeners {
+		l.Close()
 	}
-	s.listener = l
 }
 
 // Return information about the server
 func (s *RedisServerImpl) Info() map[string]string {
 	return map[string]string{
 		"role":              s.role,
-		"address":           s.address,
-		"port":              s.port,
+		"address":           strings.Join(s.bindAddresses, " "),
+		"bind":              strings.Join(s.bindAddresses, " "),
+		"protected-mode":    yesNo(s.protectedMode),
+		"replica-read-only": yesNo(s.replicaReadOnly),
+		"hz":                strconv.Itoa(s.hz),
+		"databases":         strconv.Itoa(len(s.dbs)),
+		"port":              s.infoPort(),
 		"replicationID":     s.replicationID,
 		"replicationOffset": strconv.Itoa(s.replicationOffset),
 	}
 }
 
-func (s *RedisServerImpl) CopyTo(source, destination string, replace bool) error {
-	if replace {
-		s.cache.Del([]string{destination})
-	} else {
-		if ok := s.cache.KeyExists(destination); ok {
-			return fmt.Errorf("destination key exists")
-		}
+// Returns the TCP port, 0 when TCP is disabled
+func (s *RedisServerImpl) infoPort() string {
+	if s.port == "" {
+		return "0"
 	}
-	return s.cache.Copy(source, destination)
+	return s.port
+}
+
+func (s *RedisServerImpl) ReplicaReadOnly() bool {
+	return s.replicaReadOnly
+}
+
+// Formats a boolean option the way the configuration does
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
 }
 
 // Get the current replication offset
@@ -178,6 +356,10 @@ func (s *RedisServerImpl) LoadRDBToCache() error {
 	return s.rdb.LoadRDBToCache()
 }
 
+func (s *RedisServerImpl) SaveRDB() error {
+	return s.rdb.SaveRDB()
+}
+
 // Implement the Cache interface
 
 func (s *RedisServerImpl) Copy(source, destination string) error {
@@ -228,6 +410,42 @@ func (s *RedisServerImpl) IsExpired(key string) bool {
 	return s.cache.IsExpired(key)
 }
 
+func (s *RedisServerImpl) Objects() map[string]Object {
+	return s.cache.Objects()
+}
+
+func (s *RedisServerImpl) ExpireCycle(budget time.Duration) []string {
+	return s.cache.ExpireCycle(budget)
+}
+
+func (s *RedisServerImpl) Lookup(key string) (Object, bool) {
+	return s.cache.Lookup(key)
+}
+
+func (s *RedisServerImpl) Put(key string, v Object) {
+	s.cache.Put(key, v)
+}
+
+func (s *RedisServerImpl) Size() int {
+	return s.cache.Size()
+}
+
+func (s *RedisServerImpl) Flush() {
+	s.cache.Flush()
+}
+
+func (s *RedisServerImpl) TakeExpired() []string {
+	return s.cache.TakeExpired()
+}
+
+func (s *RedisServerImpl) TakeExpiredFields() []expiredFields {
+	return s.cache.TakeExpiredFields()
+}
+
+func (s *RedisServerImpl) Now() time.Time {
+	return s.cache.Now()
+}
+
 func (s *RedisServerImpl) Type(key string) string {
 	return s.cache.Type(key)
 }
@@ -250,16 +468,12 @@ func (s *RedisServerImpl) XAdd(req *Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// The replicas are sent the generated ID, their clock can't give the same one
+	req.args[1] = newID
 	return newID, nil
 }
 
-// Start queueing requests for a MULTI transaction
-func (s *RedisServerImpl) Multi(addr string) error {
-	s.QueuedRequests[addr] = make([]Request, 0)
-	return nil
-}
-
-func (s *RedisServerImpl) GetStream(key string, start, end int) ([]StreamEntry, error) {
+func (s *RedisServerImpl) GetStream(key string, start, end StreamID) ([]StreamEntry, error) {
 	return s.cache.GetStream(key, start, end)
 }
 
@@ -267,60 +481,30 @@ func (s *RedisServerImpl) XRange(req *Request) ([]StreamEntry, error) {
 	if len(req.args) < 3 {
 		return nil, fmt.Errorf("XRANGE command requires at least 3 arguments")
 	}
-	key := req.args[0]
-	err := error(nil)
-	startID, endID := 0, 0
-	if req.args[1] != "-" {
-		startID, err = strconv.Atoi(strings.ReplaceAll(req.args[1], "-", ""))
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		startID = 0
+	startID, err := parseRangeID(req.args[1], false)
+	if err != nil {
+		return nil, err
 	}
-	if req.args[2] != "+" {
-		endID, err = strconv.Atoi(strings.ReplaceAll(req.args[2], "-", ""))
-		if err != nil {
-			return nil, fmt.Errorf("error parsing end ID")
-		}
-	} else {
-		endID = int(^uint(0) >> 1) // largest int
+	endID, err := parseRangeID(req.args[2], true)
+	if err != nil {
+		return nil, err
 	}
-	return s.GetStream(key, startID, endID)
+	return s.GetStream(req.args[0], startID, endID)
 }
 
+// Returns the entries of each stream after the ID given for it, XREAD BLOCK waits for them off the executor
 func (s *RedisServerImpl) XRead(args XReadArg) (map[string][]StreamEntry, error) {
 	entriesMap := make(map[string][]StreamEntry)
-	if args.lock {
-		now := time.Now().UnixMilli()
-		var endTime int64
-		if args.blockMs == 0 { // Lock the transaction until a new entry is added
-			endTime = int64(^uint(0) >> 1)
-		} else {
-			endTime = now + int64(args.blockMs)
-		}
-		for now < endTime {
-			for x, key := range args.keys {
-				entries, err := s.GetStream(key, args.ids[x], LARGEST_INT)
-				if err != nil {
-					return nil, err
-				}
-				if len(entries) > 0 {
-					entriesMap[key] = entries
-					return entriesMap, nil
-				}
-			}
-			time.Sleep(5 * time.Millisecond)
-			now = time.Now().UnixMilli()
+	for x, key := range args.keys {
+		entries, err := s.GetStream(key, args.ids[x], maxStreamID)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		for x, key := range args.keys {
-			entries, err := s.GetStream(key, args.ids[x], LARGEST_INT)
-			if err != nil {
-				return nil, err
-			}
-			entriesMap[key] = entries
+		// The ID given is excluded
+		if len(entries) > 0 && entries[0].id == args.ids[x].String() {
+			entries = entries[1:]
 		}
+		entriesMap[key] = entries
 	}
 	return entriesMap, nil
 }
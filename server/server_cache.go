@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -8,6 +9,8 @@ import (
 	"time"
 )
 
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 type Cache interface {
 	// Copy the value of a key to another key
 	Copy(source, destination string) error
@@ -24,7 +27,7 @@ type Cache interface {
 	// Get the value of a key
 	Get(key string) (string, error)
 	// Get the stream entries between start and end inclusive
-	GetStream(key string, start, end int) ([]StreamEntry, error)
+	GetStream(key string, start, end StreamID) ([]StreamEntry, error)
 	// Get the last entry from a stream
 	GetLastEntryFromStream(key string) (StreamEntry, error)
 	// Increment the value of a key, if the key does not exist, create it with a value of 1
@@ -35,18 +38,76 @@ type Cache interface {
 	Type(key string) string
 	// Set the expiry time of a key in milliseconds from now
 	ExpireIn(key string, milliseconds uint64) error
-	// Check if a key is expired
+	// Check if a key is expired, a missing key is
 	IsExpired(key string) bool
+	// Return the objects that have not expired, key is the key name
+	Objects() map[string]Object
+	// Delete the expired keys within the time budget and return them
+	ExpireCycle(budget time.Duration) []string
+	// Return the keys deleted because they expired since the last call
+	TakeExpired() []string
+	// Return the hash fields deleted because they expired since the last call
+	TakeExpiredFields() []expiredFields
+	// Return the time of the clock of the expiry times
+	Now() time.Time
+	// Return the object of a key, false if it does not exist or expired
+	Lookup(key string) (Object, bool)
+	// Store the object of a key, with its expiry time
+	Put(key string, v Object)
+	// Return the number of keys, the expired ones not deleted yet included
+	Size() int
+	// Delete every key
+	Flush()
 }
 
 type CacheImpl struct {
-	cache map[string]Object
+	cache    map[string]Object
+	expires  expireHeap              // TTL index of the keys with an expiry time, see ExpireCycle
+	expiring map[string]*expireEntry // entries of the TTL index by key
+	now      func() time.Time        // the clock of the expiry times and the auto-generated stream IDs, replaced by the tests
+	expired  []string                // keys deleted because they expired, until TakeExpired
+	// Index of the hashes with fields that expire, by the first expiry time of their fields.
+	// It may be earlier than the hash's own first expiry time, see expireFields
+	hashExpires   expireHeap
+	hashExpiring  map[string]*expireEntry
+	expiredFields []expiredFields // hash fields deleted because they expired, until TakeExpiredFields
+}
+
+// Fields of a hash deleted because they expired
+type expiredFields struct {
+	key    string
+	fields []string
 }
 
+// The value of a key: a string in value, or a stream, a list, a hash or a set
 type Object struct {
 	value  string
 	expiry uint64
 	stream *Stream
+	list   *Quicklist
+	hash   *Hash
+	set    *Set
+}
+
+// Returns a copy of the object that doesn't share its stream, list, hash or set
+func (o Object) clone() Object {
+	if o.stream != nil {
+		o.stream = &Stream{entries: append([]StreamEntry(nil), o.stream.entries...)}
+	}
+	if o.list != nil {
+		o.list = o.list.clone()
+	}
+	if o.hash != nil {
+		o.hash = o.hash.clone()
+	}
+	if o.set != nil {
+		o.set = o.set.clone()
+	}
+	return o
+}
+
+func (o Object) isString() bool {
+	return o.stream == nil && o.list == nil && o.hash == nil && o.set == nil
 }
 
 /*
@@ -90,65 +151,178 @@ func (s *StreamEntry) IsEmpty() bool {
 }
 
 func NewCache() *CacheImpl {
-	return &CacheImpl{cache: make(map[string]Object)}
+	return &CacheImpl{
+		cache:        make(map[string]Object),
+		expiring:     make(map[string]*expireEntry),
+		hashExpiring: make(map[string]*expireEntry),
+		now:          time.Now,
+	}
+}
+
+// Stores the object of a key and keeps its expiry time, and the one of its fields for a hash, in the TTL indexes
+func (s *CacheImpl) put(key string, v Object) {
+	s.cache[key] = v
+	s.setTTL(key, v.expiry)
+	if v.hash != nil {
+		s.setHashTTL(key, v.hash.nextExpiry())
+	} else {
+		s.setHashTTL(key, 0)
+	}
+}
+
+func (s *CacheImpl) remove(key string) {
+	delete(s.cache, key)
+	s.setTTL(key, 0)
+	s.setHashTTL(key, 0)
+}
+
+/*
+Returns the object of a key, every read and write of an existing key goes through it.
+An expired key is deleted and reported missing, as if it was deleted when it expired.
+The expired fields of a hash are deleted the same way, and the hash too once it has no field left.
+*/
+func (s *CacheImpl) lookup(key string) (Object, bool) {
+	v, ok := s.cache[key]
+	if !ok {
+		return Object{}, false
+	}
+	now := uint64(s.now().UnixMilli())
+	if v.expiry != 0 && now >= v.expiry {
+		s.expire(key)
+		return Object{}, false
+	}
+	if v.hash != nil {
+		if next := v.hash.nextExpiry(); next != 0 && now >= next && !s.expireFields(key, v.hash, now) {
+			return Object{}, false
+		}
+	}
+	return v, true
+}
+
+/*
+Deletes the expired fields of a hash, the master propagates an HDEL for them to the replicas.
+The hash is deleted if no field is left and false is returned, otherwise its entry in the index of the hashes
+is set to the first expiry time of the fields left: the entry isn't updated when a field loses its expiry time,
+it is only early then.
+*/
+func (s *CacheImpl) expireFields(key string, h *Hash, now uint64) bool {
+	if fields := h.expire(now); len(fields) > 0 {
+		s.expiredFields = append(s.expiredFields, expiredFields{key: key, fields: fields})
+	}
+	if h.Len() == 0 {
+		s.remove(key)
+		return false
+	}
+	s.setHashTTL(key, h.nextExpiry())
+	return true
+}
+
+func (s *CacheImpl) Lookup(key string) (Object, bool) {
+	return s.lookup(key)
+}
+
+func (s *CacheImpl) Put(key string, v Object) {
+	s.put(key, v)
+}
+
+func (s *CacheImpl) Size() int {
+	return len(s.cache)
+}
+
+func (s *CacheImpl) Flush() {
+	s.cache = make(map[string]Object)
+	s.expires = nil
+	s.expiring = make(map[string]*expireEntry)
+	s.expired = nil
+	s.hashExpires = nil
+	s.hashExpiring = make(map[string]*expireEntry)
+	s.expiredFields = nil
+}
+
+// Deletes an expired key, the master propagates a DEL for it to the replicas
+func (s *CacheImpl) expire(key string) {
+	s.remove(key)
+	s.expired = append(s.expired, key)
+}
+
+func (s *CacheImpl) TakeExpired() []string {
+	expired := s.expired
+	s.expired = nil
+	return expired
+}
+
+func (s *CacheImpl) TakeExpiredFields() []expiredFields {
+	expired := s.expiredFields
+	s.expiredFields = nil
+	return expired
+}
+
+func (s *CacheImpl) Now() time.Time {
+	return s.now()
 }
 
 func (s *CacheImpl) Copy(source, destination string) error {
-	if v, ok := s.cache[source]; ok {
-		s.cache[destination] = v
+	if v, ok := s.lookup(source); ok {
+		s.put(destination, v.clone())
 		return nil
 	}
 	return fmt.Errorf("source key does not exist")
 }
 
 func (s *CacheImpl) KeyExists(key string) bool {
-	_, ok := s.cache[key]
+	_, ok := s.lookup(key)
 	return ok
 }
 
 func (s *CacheImpl) Set(key string, value string) error {
-	s.cache[key] = Object{value: value, stream: nil}
+	s.put(key, Object{value: value, stream: nil})
 	return nil
 }
 
 func (s *CacheImpl) Del(keys []string) int {
 	count := 0
 	for _, key := range keys {
-		if _, ok := s.cache[key]; ok {
-			delete(s.cache, key)
+		if _, ok := s.lookup(key); ok {
+			s.remove(key)
 			count++
 		}
 	}
 	return count
 }
 
+// The expiry time of the key is kept
 func (s *CacheImpl) Increment(key string) (int, error) {
-	if v, ok := s.cache[key]; ok {
+	if v, ok := s.lookup(key); ok {
+		if !v.isString() {
+			return 0, errWrongType
+		}
 		i, err := strconv.Atoi(v.value)
 		if err != nil {
 			return 0, err
 		}
 		i++
-		s.cache[key] = Object{value: strconv.Itoa(i), stream: nil}
+		v.value = strconv.Itoa(i)
+		s.put(key, v)
 		return i, nil
 	} else {
-		s.cache[key] = Object{value: "1", stream: nil}
+		s.put(key, Object{value: "1", stream: nil})
 		return 1, nil
 	}
 }
 
-func (s *CacheImpl) GetStream(key string, start, end int) ([]StreamEntry, error) {
-	if v, ok := s.cache[key]; ok {
+// Returns the entries of a stream whose ID is between start and end, both included
+func (s *CacheImpl) GetStream(key string, start, end StreamID) ([]StreamEntry, error) {
+	if v, ok := s.lookup(key); ok {
 		if v.stream == nil {
 			return nil, fmt.Errorf("ERR The key is not a stream")
 		}
 		entries := make([]StreamEntry, 0)
 		for _, entry := range v.stream.entries {
-			entryID, err := strconv.Atoi(strings.ReplaceAll(entry.id, "-", ""))
+			entryID, err := parseStreamID(entry.id)
 			if err != nil {
 				return nil, err
 			}
-			if entryID >= start && entryID <= end {
+			if !entryID.Less(start) && !end.Less(entryID) {
 				entries = append(entries, entry)
 			}
 		}
@@ -158,7 +332,7 @@ func (s *CacheImpl) GetStream(key string, start, end int) ([]StreamEntry, error)
 }
 
 func (s *CacheImpl) GetLastEntryFromStream(key string) (StreamEntry, error) {
-	if v, ok := s.cache[key]; ok {
+	if v, ok := s.lookup(key); ok {
 		if v.stream == nil {
 			return StreamEntry{}, fmt.Errorf("ERR The key is not a stream")
 		}
@@ -170,164 +344,47 @@ func (s *CacheImpl) GetLastEntryFromStream(key string) (StreamEntry, error) {
 	return StreamEntry{}, fmt.Errorf("ERR The key does not exist")
 }
 
-// Create or append to a stream
+/*
+Creates or appends to a stream, id is the argument of XADD and the ID of the new entry is returned.
+The auto-generated IDs are based on the cache clock.
+*/
 func (s *CacheImpl) SetStream(key, id string, fields map[string]string) (string, error) {
-	if !isValidID(id) {
-		return "", fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
+	v, keyExists := s.lookup(key)
+	if keyExists && v.stream == nil {
+		return "", errWrongType
+	}
+	last := StreamID{}
+	if keyExists && len(v.stream.entries) != 0 {
+		last, _ = parseStreamID(v.stream.entries[len(v.stream.entries)-1].id)
 	}
-	type_, ms, seq, err := decodeID(id)
+	newID, err := nextStreamID(id, last, uint64(s.now().UnixMilli()))
 	if err != nil {
 		return "", err
 	}
-	v, keyExists := s.cache[key]
-	switch type_ {
-	case AUTO:
-		ms = int(time.Now().UnixMilli())
-		if keyExists {
-			seq, err = defineSeq(v.stream, ms)
-			if err != nil {
-				return "", err
-			}
-		} else {
-			seq = 0
-		}
-		id = fmt.Sprintf("%d-%d", ms, seq)
-		if keyExists {
-			v.stream.entries = append(v.stream.entries, StreamEntry{id: id, fields: fields})
-			s.cache[key] = v
-		} else {
-			s.cache[key] = Object{stream: &Stream{entries: []StreamEntry{{id: id, fields: fields}}}}
-		}
-	case INCREMENT:
-		if keyExists {
-			// Define the sequence number, incrementing from the last ID if the last ID equals the current ID
-			seq, err = defineSeq(v.stream, ms)
-			if err != nil {
-				return "", err
-			}
-		} else {
-			if ms == 0 {
-				seq = 1
-			} else {
-				seq = 0
-			}
-		}
-		id = fmt.Sprintf("%d-%d", ms, seq)
-		if keyExists {
-			v.stream.entries = append(v.stream.entries, StreamEntry{id: id, fields: fields})
-			s.cache[key] = v
-		} else {
-			s.cache[key] = Object{stream: &Stream{entries: []StreamEntry{{id: id, fields: fields}}}}
-		}
-	case CLIENT:
-		if keyExists {
-			if len(v.stream.entries) != 0 {
-				lastID := v.stream.entries[len(v.stream.entries)-1].id
-				_, lastMs, lastSeq, _ := decodeID(lastID)
-				if lastMs == ms {
-					if lastSeq >= seq {
-						return "", fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
-					}
-				} else if lastMs > ms {
-					return "", fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
-				}
-			}
-		} else {
-			if (ms == 0 && seq < 1) || ms < 0 || (ms > 0 && seq < 0) {
-				return "", fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
-			}
-		}
-		id = fmt.Sprintf("%d-%d", ms, seq)
-		if keyExists {
-			v.stream.entries = append(v.stream.entries, StreamEntry{id: id, fields: fields})
-			s.cache[key] = v
-		} else {
-			s.cache[key] = Object{stream: &Stream{entries: []StreamEntry{{id: id, fields: fields}}}}
-		}
-	default:
-		return "", fmt.Errorf("ERR Invalid ID type")
-	}
-	fmt.Printf("About to return id: %s\n", id)
-	return id, nil
-}
-
-// Define the next sequence number depending on the last ID
-func defineSeq(stream *Stream, ms int) (int, error) {
-	seq := 0
-	if len(stream.entries) == 0 {
-		seq = 1
+	entry := StreamEntry{id: newID.String(), fields: fields}
+	if keyExists {
+		v.stream.entries = append(v.stream.entries, entry)
+		s.put(key, v)
 	} else {
-		lastID := stream.entries[len(stream.entries)-1].id
-		_, lastMs, lastSeq, _ := decodeID(lastID)
-		if lastMs == ms {
-			seq = lastSeq + 1
-		} else if lastMs < ms {
-			seq = 0
-		} else {
-			return -1, fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
-		}
+		s.put(key, Object{stream: &Stream{entries: []StreamEntry{entry}}})
 	}
-	return seq, nil
+	return entry.id, nil
 }
 
 func (s *CacheImpl) SetExpiry(key string, value string, expiry uint64) error {
-	s.cache[key] = Object{value: value, expiry: expiry}
+	s.put(key, Object{value: value, expiry: expiry})
 	return nil
 }
 
-const (
-	AUTO = iota
-	INCREMENT
-	CLIENT
-)
-
-// Type auto 0 - incrementing 1 - provided by the client 2
-func decodeID(id string) (type_, ms, seq int, err error) {
-	// Auto generatedID
-	if id == "*" {
-		return
-	}
-	parts := strings.Split(id, "-")
-	ms, _ = strconv.Atoi(parts[0])
-	if parts[1] == "*" {
-		return INCREMENT, ms, 0, nil
-	}
-	seq, _ = strconv.Atoi(parts[1])
-	return CLIENT, ms, seq, nil
-}
-
-func isValidID(id string) bool {
-	if id == "*" {
-		return true
-	}
-	parts := strings.Split(id, "-")
-	ms, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return false
-	}
-	if parts[1] == "*" {
-		return ms >= 0
-	}
-	seq, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return false
-	}
-	if ms < 0 || seq < 1 {
-		return false
-	}
-	return true
-}
-
 // Need to edit this to return the object instead of the value
 func (s *CacheImpl) Get(key string) (string, error) {
-	if v, ok := s.cache[key]; ok {
-		if s.IsExpired(key) {
-			return "", fmt.Errorf("key expired")
+	if v, ok := s.lookup(key); ok {
+		if !v.isString() {
+			return "", errWrongType
 		}
 		return v.value, nil
-	} else {
-		return "", fmt.Errorf("key not found")
 	}
+	return "", fmt.Errorf("key not found")
 }
 
 // Return the keys matching the pattern
@@ -336,7 +393,7 @@ func (s *CacheImpl) Keys(key string) []string {
 	keyRegexp := parseKey(key)
 	fmt.Printf("keyRegexp: %s\n", keyRegexp.String())
 	for k := range s.cache {
-		if keyRegexp.MatchString(k) {
+		if _, ok := s.lookup(k); ok && keyRegexp.MatchString(k) {
 			keys = append(keys, k)
 		}
 	}
@@ -345,9 +402,16 @@ func (s *CacheImpl) Keys(key string) []string {
 
 // Return the type of the key
 func (s *CacheImpl) Type(key string) string {
-	if v, ok := s.cache[key]; ok {
-		if v.stream != nil {
+	if v, ok := s.lookup(key); ok {
+		switch {
+		case v.stream != nil:
 			return "stream"
+		case v.list != nil:
+			return "list"
+		case v.hash != nil:
+			return "hash"
+		case v.set != nil:
+			return "set"
 		}
 		return "string"
 	}
@@ -376,27 +440,27 @@ func parseKey(key string) *regexp.Regexp {
 
 // ExpireIn sets the expiry time of a key in milliseconds from now
 func (s *CacheImpl) ExpireIn(key string, milliseconds uint64) error {
-	if v, ok := s.cache[key]; !ok {
+	if v, ok := s.lookup(key); !ok {
 		return fmt.Errorf("key not found")
 	} else {
-		now := time.Now().UnixMilli()
+		now := s.now().UnixMilli()
 		v.expiry = uint64(now) + milliseconds
-		s.cache[key] = v
+		s.put(key, v)
 		return nil
 	}
 }
 
 func (s *CacheImpl) IsExpired(key string) bool {
-	if v, ok := s.cache[key]; ok {
-		if v.expiry != 0 {
-			now := time.Now().UnixMilli()
-			if uint64(now) >= v.expiry {
-				delete(s.cache, key)
-				return true
-			}
+	_, ok := s.lookup(key)
+	return !ok
+}
+
+func (s *CacheImpl) Objects() map[string]Object {
+	objects := make(map[string]Object, len(s.cache))
+	for k := range s.cache {
+		if v, ok := s.lookup(k); ok {
+			objects[k] = v
 		}
-	} else {
-		return true
 	}
-	return false
+	return objects
 }
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+Server runs a master or a replica inside the current process:
+
+	srv, err := server.New(server.Config{Port: 0})
+	addr, err := srv.Start(ctx)
+	defer srv.Close()
+
+Nothing calls os.Exit, the failures are returned by New, Start and Wait.
+*/
+type Server struct {
+	RedisServer
+	mu      sync.Mutex
+	started bool
+	inited  chan struct{} // closed once Init returns
+	stopped chan struct{} // closed once Listen returns, or once the server started with a cancelled ctx is closed
+	err     error         // returned by Listen
+}
+
+// Creates a server from its configuration, a replica if ReplicaOf is set
+func New(cfg Config) (*Server, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &Server{RedisServer: NewServerManager(cfg).SpawnServer(), inited: make(chan struct{}), stopped: make(chan struct{})}, nil
+}
+
+/*
+Loads the RDB file, opens the listeners and, for a replica, syncs with the master,
+then accepts connections in the background.
+Returns the address of the first listener, which holds the port picked when the port is 0.
+The server runs until Close, Shutdown or SHUTDOWN stops it, or ctx is cancelled.
+*/
+func (s *Server) Start(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return "", errors.New("server already started")
+	}
+	s.started = true
+	s.mu.Unlock()
+
+	if _, dbfile := s.RDBInfo(); dbfile != "" {
+		var err error
+		s.execute(func() { err = s.LoadRDBToCache() })
+		if err != nil {
+			fmt.Printf("Unable to load the RDB file: %s\n", err)
+		}
+	}
+	initialized := make(chan error, 1)
+	go func() {
+		err := s.Init()
+		if err != nil {
+			s.RedisServer.stop()
+			close(s.stopped)
+		}
+		close(s.inited)
+		initialized <- err
+	}()
+	select {
+	case err := <-initialized:
+		if err != nil {
+			return "", err
+		}
+	case <-ctx.Done():
+		// Listen never runs, the server is shut down once Init returns so that the listeners are closed
+		go func() {
+			if err := <-initialized; err == nil {
+				s.RedisServer.Shutdown(ShutdownOptions{now: true, force: true}, nil)
+				close(s.stopped)
+			}
+		}()
+		return "", ctx.Err()
+	}
+
+	go func() {
+		s.err = s.Listen()
+		close(s.stopped)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.stopped:
+		}
+	}()
+	return s.Addr(), nil
+}
+
+// Returns the value of a key of the database 0, read on the executor while the server runs
+func (s *Server) Get(key string) (value string, err error) {
+	get := func() {
+		s.useDB(0)
+		value, err = s.RedisServer.Get(key)
+	}
+	if !s.execute(get) {
+		get()
+	}
+	return value, err
+}
+
+// Returns various information about the server, read on the executor while the server runs
+func (s *Server) Info() (info map[string]string) {
+	if !s.execute(func() { info = s.RedisServer.Info() }) {
+		return s.RedisServer.Info()
+	}
+	return info
+}
+
+// Shuts the server down the way SHUTDOWN does, the server keeps running if the shutdown fails
+func (s *Server) Shutdown() error {
+	return s.RedisServer.Shutdown(ShutdownOptions{}, nil)
+}
+
+/*
+Stops the server without waiting for the replicas and waits for Listen to return,
+the snapshot is saved if an RDB file is configured.
+A server still being initialized is stopped once Init returns,
+a server that was never started can't be started afterwards.
+*/
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		s.RedisServer.stop()
+		close(s.inited)
+		close(s.stopped)
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+	<-s.inited
+	select {
+	case <-s.stopped:
+		return nil
+	default:
+	}
+	// A shutdown already in progress, by SHUTDOWN for instance, is waited for
+	if err := s.RedisServer.Shutdown(ShutdownOptions{now: true, force: true}, nil); err != nil && err != errShutdownInProgress {
+		return err
+	}
+	<-s.stopped
+	return nil
+}
+
+// Blocks until the server stops, returns the error that stopped it if it didn't shut down
+func (s *Server) Wait() error {
+	<-s.stopped
+	return s.err
+}
+
+// Returns a channel closed once the server has stopped
+func (s *Server) Done() <-chan struct{} {
+	return s.stopped
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	utils "github.com/codecrafters-io/redis-starter-go/utils"
+)
+
+// Returns the default configuration with a free port picked on start
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	return cfg
+}
+
+// Starts a server that is closed at the end of the test, returns it with its address
+func startTestServer(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	addr, err := srv.Start(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start the server: %s", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv, addr
+}
+
+func TestEmbeddedServer(t *testing.T) {
+	t.Run("port 0 picks a free port", func(t *testing.T) {
+		srv, addr := startTestServer(t, testConfig())
+		if _, port, _ := net.SplitHostPort(addr); port == "0" {
+			t.Fatalf("expected the bound address, got %q", addr)
+		}
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		c.do(t, "SET", "key", "value")
+		if v, err := srv.Get("key"); err != nil || v != "value" {
+			t.Fatalf("expected value, got %q %v", v, err)
+		}
+		if _, port, _ := net.SplitHostPort(addr); srv.Info()["port"] != port {
+			t.Fatalf("expected INFO to report port %s, got %s", port, srv.Info()["port"])
+		}
+	})
+
+	t.Run("errors are returned", func(t *testing.T) {
+		_, addr := startTestServer(t, testConfig())
+		cfg := testConfig()
+		_, port, _ := net.SplitHostPort(addr)
+		cfg.Port, _ = strconv.Atoi(port)
+		srv, err := New(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := srv.Start(context.Background()); err == nil {
+			t.Fatalf("expected the bind to fail")
+		}
+		if _, err := New(Config{Port: 70000}); err == nil {
+			t.Fatalf("expected an invalid port error")
+		}
+		if _, err := ConfigFromArgs(map[string]string{"--databases": "0"}); err == nil {
+			t.Fatalf("expected an invalid number of databases error")
+		}
+		replica := testConfig()
+		replica.ReplicaOf = "127.0.0.1:1"
+		srv, _ = New(replica)
+		if _, err := srv.Start(context.Background()); err == nil {
+			t.Fatalf("expected the sync with the master to fail")
+		}
+	})
+
+	t.Run("zero configuration", func(t *testing.T) {
+		// The example of the documentation of Server
+		srv, err := New(Config{Port: 0})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer srv.Close()
+		addr, err := srv.Start(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		if v := c.do(t, "PING"); v.Str() != "PONG" {
+			t.Fatalf("expected PONG, got %q", v.Str())
+		}
+	})
+
+	t.Run("close and cancel", func(t *testing.T) {
+		srv, addr := startTestServer(t, testConfig())
+		if err := srv.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := srv.Wait(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := net.Dial("tcp", addr); err == nil {
+			t.Fatalf("expected the listener to be closed")
+		}
+		if err := srv.Close(); err != nil {
+			t.Fatalf("expected a second Close to do nothing, got %s", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		srv, _ = New(testConfig())
+		if _, err := srv.Start(ctx); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		cancel()
+		select {
+		case <-srv.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("expected the server to stop")
+		}
+
+		// ctx is cancelled before Start returns, most of the time while Init runs
+		for i := 0; i < 20; i++ {
+			srv, _ = New(testConfig())
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			srv.Start(ctx)
+			closed := make(chan error, 1)
+			go func() { closed <- srv.Close() }()
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected Close to return")
+			}
+			if err := srv.Close(); err != nil {
+				t.Fatalf("expected a second Close to do nothing, got %s", err)
+			}
+			if _, err := net.Dial("tcp", srv.Addr()); err == nil {
+				t.Fatalf("expected the listener to be closed")
+			}
+		}
+	})
+
+	t.Run("command line options", func(t *testing.T) {
+		cfg, err := ConfigFromArgs(map[string]string{"--port": "0", "--replica-read-only": "no", "--hz": "1000", "--databases": "4"})
+		if err != nil || cfg.Port != -1 || cfg.ReplicaReadOnly || !cfg.ProtectedMode || cfg.hz() != CONFIG_MAX_HZ || cfg.databases() != 4 {
+			t.Fatalf("unexpected configuration %+v %v", cfg, err)
+		}
+		if _, err := ConfigFromArgs(map[string]string{"--port": "abc"}); err == nil {
+			t.Fatalf("expected an invalid port error")
+		}
+		if _, err := ConfigFromArgs(map[string]string{"--databases": "0"}); err == nil {
+			t.Fatalf("expected an invalid number of databases error")
+		}
+
+		args, err := utils.ParseOsArgs([]string{"redis-server", "--port", "0", "--databases", "4", "--hz", "50"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if cfg, err = ConfigFromArgs(args); err != nil || cfg.databases() != 4 || cfg.hz() != 50 {
+			t.Fatalf("expected 4 databases and hz 50 from the command line, got %+v %v", cfg, err)
+		}
+		for _, option := range []string{"--databases", "--hz"} {
+			if _, err := utils.ParseOsArgs([]string{"redis-server", option}); err == nil {
+				t.Fatalf("%s: expected a missing argument error", option)
+			}
+		}
+	})
+	t.Run("no goroutine is left behind", func(t *testing.T) {
+		// The port is taken so that Start fails
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %s", err)
+		}
+		defer l.Close()
+		cfg := testConfig()
+		cfg.Port = l.Addr().(*net.TCPAddr).Port
+		before := runtime.NumGoroutine()
+		for i := 0; i < 20; i++ {
+			srv, _ := New(cfg)
+			if _, err := srv.Start(context.Background()); err == nil {
+				t.Fatalf("expected the bind to fail")
+			}
+			srv.Close()
+			// Never started
+			srv, _ = New(testConfig())
+			srv.Close()
+			if _, err := srv.Start(context.Background()); err == nil {
+				t.Fatalf("expected a closed server not to start")
+			}
+		}
+		// The goroutines take a moment to return
+		deadline := time.Now().Add(time.Second)
+		for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before {
+			t.Fatalf("expected at most %d goroutines, got %d", before, n)
+		}
+	})
+}
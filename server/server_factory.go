@@ -1,7 +1,7 @@
 package server
 
 // Design pattern: Factory Method
-// This pattern is used to create Redis servers based on the arguments passed to the manager
+// This pattern is used to create Redis servers based on the configuration passed to the manager
 
 const (
 	MASTER  = "master"
@@ -15,31 +15,31 @@ type ServerManager interface {
 
 // Implementation of the ServerManager interface
 type ServerManagerImpl struct {
-	args   map[string]string
+	cfg    Config
 	server RedisServer
 }
 
-func NewServerManager(args map[string]string) ServerManager {
-	return &ServerManagerImpl{args: args}
+func NewServerManager(cfg Config) ServerManager {
+	return &ServerManagerImpl{cfg: cfg}
 }
 
-// Spawns a Redis server based on the arguments passed to the manager
+// Spawns a Redis server based on the configuration passed to the manager
 func (s *ServerManagerImpl) SpawnServer() RedisServer {
-	switch getServerType(s.args) {
+	switch getServerType(s.cfg) {
 	case MASTER:
-		s.server = NewMasterServer(s.args)
+		s.server = NewMasterServer(s.cfg)
 		return s.server
 	case REPLICA:
-		s.server = NewReplicaServer(s.args)
+		s.server = NewReplicaServer(s.cfg)
 		return s.server
 	default:
 		return nil
 	}
 }
 
-// Parses args and return the server type
-func getServerType(args map[string]string) string {
-	if _, ok := args["--replicaof"]; ok {
+// Returns the server type, a replica when the master is configured
+func getServerType(cfg Config) string {
+	if cfg.ReplicaOf != "" {
 		return REPLICA
 	}
 	return MASTER
@@ -2,71 +2,85 @@ package server
 
 import (
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/codecrafters-io/redis-starter-go/utils"
 )
 
 type MasterServer interface {
 	RedisServer
-	AddReplica(addr string, r net.Conn)
-	GetReplicas() map[string]net.Conn
+	// Registers the client as a replica, the replication stream is sent to it from now on
+	AddReplica(c *Client)
+	GetReplicas() map[string]*replicaLink
 	Propagate(req *Request)
-	SendRDBFile(conn net.Conn) error
-	Wait(req *Request) []byte
+	// Propagates a write to the replicas and adds it to the replication offset and backlog
+	Replicate(req *Request)
+	ReplicateExpired()
+	SendRDBFile(w io.Writer) error
 	CacheRequest(req *Request)
 	GetReplicationBacklog() map[int]Request
+	// Asks the replicas for their offset, their acknowledgements are counted from now on
+	RequestAcks()
 	AddAckReceived()
+	AcksReceived() int
 	ResetAckReceived()
 }
 
 type MasterServerImpl struct {
 	RedisServerImpl
-	replicas map[string]net.Conn
+	replicas map[string]*replicaLink // keyed by the address of the replica
 	// The replication backlog keeps track of the requests that need to be propagated to the replicas
 	// The key is the offset of the request in the replication stream
 	replicationBacklog map[int]Request
 	acksReceived       int
+	replicationDB      int // database selected in the replication stream, -1 until a SELECT is sent
+	expireDB           int // database the next active expire cycle starts with
 }
 
-func NewMasterServer(args map[string]string) *MasterServerImpl {
-	port, ok := args["--port"]
-	if !ok {
-		port = SERVER_PORT
-	}
-	dir, ok := args["--dir"]
-	if !ok {
-		dir = ""
-	}
-	dbfile, ok := args["--dbfilename"]
-	if !ok {
-		dbfile = ""
-	}
-	server := &MasterServerImpl{RedisServerImpl: RedisServerImpl{
-		role: "master", address: SERVER_ADDR, port: port, cache: NewCache(), replicationID: utils.CreateReplicationID(), ConnectedClients: map[string]bool{}, QueuedRequests: make(map[string][]Request)},
-		replicas:           make(map[string]net.Conn),
+func NewMasterServer(cfg Config) *MasterServerImpl {
+	server := &MasterServerImpl{
+		replicas:           make(map[string]*replicaLink),
 		replicationBacklog: make(map[int]Request),
+		replicationDB:      -1,
 	}
-	server.rdb = NewRDBManager(dir, dbfile, server)
-	fmt.Printf("Master RedisServerImpl created with address: %s:%s and RDB info dir: %s file: %s\n", server.address, server.port, dir, dbfile)
+	server.configure("master", cfg)
+	server.rdb = NewRDBManager(cfg.Dir, cfg.DBFilename, server)
+	server.flushReplicas = server.flushReplicationStream
+	go server.expireCron()
+	fmt.Printf("Master RedisServerImpl created with address: %s port: %s and RDB info dir: %s file: %s\n", strings.Join(server.bindAddresses, " "), server.infoPort(), cfg.Dir, cfg.DBFilename)
 	return server
 }
 
+// Counts the acknowledgement of a replica and wakes up the clients waiting for it
 func (s *MasterServerImpl) AddAckReceived() {
 	s.acksReceived++
+	s.signalChange()
+}
+
+func (s *MasterServerImpl) AcksReceived() int {
+	return s.acksReceived
+}
+
+/*
+Sends REPLCONF GETACK to every replica, the replicas answer with REPLCONF ACK
+and the acknowledgements are counted by AddAckReceived.
+The replication offset grows by the 37 bytes of the REPLCONF GETACK command.
+*/
+func (s *MasterServerImpl) RequestAcks() {
+	s.ResetAckReceived()
+	s.sendToReplicas(newBulkArray("REPLCONF", "GETACK", "*"))
+	s.replicationOffset += 37
 }
 
 func (s *MasterServerImpl) ResetAckReceived() {
 	s.acksReceived = 0
 }
 
-func (s *MasterServerImpl) GetReplicas() map[string]net.Conn {
+func (s *MasterServerImpl) GetReplicas() map[string]*replicaLink {
 	return s.replicas
 }
 
@@ -78,67 +92,90 @@ func (s *MasterServerImpl) CacheRequest(req *Request) {
 	s.replicationBacklog[s.replicationOffset] = *req
 }
 
-func (s *MasterServerImpl) AddReplica(addr string, r net.Conn) {
-	s.replicas[addr] = r
+// The new replica starts with a SELECT before the next write
+func (s *MasterServerImpl) AddReplica(c *Client) {
+	s.replicas[c.Addr()] = newReplicaLink(c, s.done)
+	s.replicationDB = -1
 }
 
 func (s *MasterServerImpl) Propagate(req *Request) {
+	s.sendToReplicas(newBulkArray(append([]string{req.command}, req.args...)...))
+}
+
+// Queues data for every replica, the replicas that are disconnected are forgotten
+func (s *MasterServerImpl) sendToReplicas(data []byte) {
 	for addr, replica := range s.replicas {
-		_, err := replica.Write(newBulkArray(append([]string{req.command}, req.args...)...))
-		if err != nil {
-			fmt.Printf("Error writing to %s replica: %s\n", addr, err)
+		if !replica.send(data) {
+			fmt.Printf("Replica %s is disconnected\n", addr)
+			delete(s.replicas, addr)
 		}
 	}
 }
 
+// Writes the replication stream still queued for the replicas, called on the executor by a shutdown
+func (s *MasterServerImpl) flushReplicationStream() {
+	for _, replica := range s.replicas {
+		replica.flush(REPLICA_FLUSH_TIMEOUT)
+	}
+}
+
+// A SELECT is sent first when the write is on another database than the previous one
+func (s *MasterServerImpl) Replicate(req *Request) {
+	if s.db != s.replicationDB {
+		s.replicationDB = s.db
+		s.Replicate(NewRequest("SELECT", strconv.Itoa(s.db)))
+	}
+	commandLen := len(req.Encode())
+	s.Propagate(req)
+	s.AddAckOffset(commandLen)
+	s.CacheRequest(req)
+	fmt.Printf("Added %d bytes to Master offset, offset: %d\n", commandLen, s.GetAckOffset())
+}
+
 // Event loop, handles requests inside it
-func (s *MasterServerImpl) Listen() {
-	for {
-		conn, err := s.listener.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
-			os.Exit(1)
-		}
+func (s *MasterServerImpl) Listen() error {
+	return s.serve(func(conn net.Conn) {
 		go s.HandleClientConnections(conn)
-	}
+	})
 }
 
 // Handle incoming TCP Requests
 func (s *MasterServerImpl) HandleClientConnections(conn net.Conn) {
-	buff := make([]byte, 1024)
+	var client *Client
+	s.execute(func() { client = s.AddClient(conn) })
+	if client == nil {
+		conn.Close()
+		return
+	}
+	defer s.RemoveClient(client)
 	for {
-		// Read from the connection
-		bytesRead, err := conn.Read(buff)
+		// Read every complete request available on the connection
+		requests, err := client.reader.ReadRequests()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				conn.Close()
-				break
-			}
-			fmt.Println(err)
+			client.handleReadError(err)
 			return
 		}
-		// The data read from the TCP stream
-		request := buff[:bytesRead]
-
-		// Handles the decoded request and produce an answer
-		reqHandler := NewReqHandlerMaster(request, s, conn)
-		response := reqHandler.HandleRequest()
-		// The requestHandler can return an empty response, in which case we don't write anything
-		if len(response) == 0 {
-			continue
-		}
-		_, err = conn.Write(response)
+
+		// Handles the decoded requests and writes one reply per request
+		reqHandler := NewReqHandlerMaster(requests, s, client)
+		s.beginBatch(client)
+		err = reqHandler.HandleRequest()
+		s.endBatch(client)
 		if err != nil {
 			fmt.Println(err)
-			break
+			return
+		}
+
+		// The client was killed by one of its own requests
+		if client.closeAfterReply {
+			return
 		}
 	}
 }
 
 // The Redis Database file contains the on-disk representation of the Redis database
-// SendRDBFile sends the RDB file to the replica's connection passed in as an argument
-// This method is executed in a goroutine and we add a 1ms sleep to prevent the replica from receiving the RDB file before the FULLRESYNC command
-func (s *MasterServerImpl) SendRDBFile(conn net.Conn) error {
+// SendRDBFile writes the RDB file to the replica's connection writer passed in as an argument
+func (s *MasterServerImpl) SendRDBFile(w io.Writer) error {
 	fmt.Printf("Sending RDB file to replica\n")
 	dir, dbfile := s.rdb.RDBInfo()
 	buffer, err := utils.ReadFile(dir + "/" + dbfile)
@@ -154,73 +191,9 @@ func (s *MasterServerImpl) SendRDBFile(conn net.Conn) error {
 		buffer.Write(data)
 	}
 	content := buffer.Bytes()
-	// Sleep 1 ms to prevent the replica receiving the RDB file before the FULLRESYNC command
-	time.Sleep(1 * time.Millisecond)
-	_, err = conn.Write(append([]byte(fmt.Sprintf("$%d\r\n", len(content))), content...))
+	_, err = w.Write(append([]byte(fmt.Sprintf("$%d\r\n", len(content))), content...))
 	if err != nil {
 		return err
 	}
 	return nil
 }
-
-/*
-WAIT numreplicas timeout
-
-This command blocks the current client until all the previous write commands are successfully transferred and acknowledged
-by at least the number of replicas specified in the numreplicas argument.
-If the value you specify for the timeout argument (in milliseconds) is reached, the command returns even if the specified number of replicas were not yet reached.
-
-The command will always return the number of replicas that acknowledged the write commands sent by the current client before the WAIT command
-both in the case where the specified number of replicas are reached, or when the timeout is reached.
-*/
-func (s *MasterServerImpl) Wait(req *Request) []byte {
-	if len(req.args) < 2 {
-		return newSimpleString("WAIT command requires at least 2 arguments (count of replicas and timeout)")
-	}
-	numOfReplicas, err := strconv.Atoi(req.args[0])
-	if err != nil {
-		return newSimpleString("Error parsing replicas count")
-	}
-	timeout, err := strconv.Atoi(req.args[1])
-	if err != nil {
-		return newSimpleString("Error parsing timeout")
-	}
-
-	if numOfReplicas == 0 {
-		// We can return immediately since the number of replicas is 0
-		return newInteger(0)
-	} else if s.replicationOffset == 0 {
-		// Return the number of known replicas
-		return newInteger(len(s.GetReplicas()))
-	}
-
-	fmt.Printf("Waiting for %d replicas with %dms timeout\n", numOfReplicas, timeout)
-
-	start := time.Now().UnixMilli()
-	end := start + int64(timeout)
-
-	/*
-		Sends the REPLCONF GETACK command to all the replicas,
-		some replicas may answer or not, the number of ACKs received is counted
-		in the RequestHandlerMaster.replicationConfig method
-	*/
-	for _, replica := range s.replicas {
-		go replica.Write(newBulkArray("REPLCONF", "GETACK", "*"))
-		fmt.Printf("Sent REPLCONF GETACK to replica %s\n", replica.RemoteAddr().String())
-	}
-
-	// The replication offset is incremented by 37 bytes for the REPLCONF GETACK command sent
-	s.replicationOffset += 37
-
-	// Count the number of ACKs received from the replicas
-	for s.acksReceived < numOfReplicas {
-		if time.Now().UnixMilli() > end {
-			fmt.Printf("Timeout reached\n")
-			break
-		}
-	}
-
-	ackCopy := s.acksReceived
-	s.ResetAckReceived()
-	return newInteger(ackCopy)
-}
@@ -5,49 +5,31 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"os"
-	"reflect"
-	"strconv"
 	"strings"
-
-	utils "github.com/codecrafters-io/redis-starter-go/utils"
 )
 
 type ReplicaServer interface {
 	RedisServer
 	HandleMasterConnection()
-	SyncWithMaster()
+	SyncWithMaster() error
 	SendToMaster(data []byte) error
-	ReadFromMaster() ([]byte, error)
+	ReadFromMaster() (RespValue, error)
 }
 
 type ReplicaServerImpl struct {
 	RedisServerImpl
 	masterAddress string
 	masterConn    net.Conn
+	masterClient  *Client // The master link, listed by CLIENT LIST with the M flag
+	masterAuth    string  // password sent to the master before the handshake, if the master requires one
+	masterUser    string  // user authenticated with masterauth, the default user if empty
 }
 
-func NewReplicaServer(args map[string]string) *ReplicaServerImpl {
-	port, ok := args["--port"]
-	if !ok {
-		port = SERVER_PORT
-	}
-	dir, ok := args["--dir"]
-	if !ok {
-		dir = ""
-	}
-	dbfile, ok := args["--dbfilename"]
-	if !ok {
-		dbfile = ""
-	}
-	replicaof, ok := args["--replicaof"]
-	if !ok {
-		fmt.Println("Missing argument for --replicaof")
-		os.Exit(1)
-	}
-	server := &ReplicaServerImpl{RedisServerImpl: RedisServerImpl{role: "slave", address: SERVER_ADDR, port: port, ConnectedClients: map[string]bool{}, cache: NewCache(), replicationID: utils.CreateReplicationID(), QueuedRequests: map[string][]Request{}}, masterAddress: replicaof}
-	server.rdb = NewRDBManager(dir, dbfile, server)
-	fmt.Printf("Replica RedisServer created with address: %s:%s and RDB info dir: %s file: %s\n", server.address, server.port, dir, dbfile)
+func NewReplicaServer(cfg Config) *ReplicaServerImpl {
+	server := &ReplicaServerImpl{masterAddress: cfg.ReplicaOf, masterAuth: cfg.MasterAuth, masterUser: cfg.MasterUser}
+	server.configure("slave", cfg)
+	server.rdb = NewRDBManager(cfg.Dir, cfg.DBFilename, server)
+	fmt.Printf("Replica RedisServer created with address: %s port: %s and RDB info dir: %s file: %s\n", strings.Join(server.bindAddresses, " "), server.infoPort(), cfg.Dir, cfg.DBFilename)
 	return server
 }
 
@@ -56,145 +38,174 @@ func (r *ReplicaServerImpl) SendToMaster(data []byte) error {
 	return err
 }
 
-func (r *ReplicaServerImpl) ReadFromMaster() ([]byte, error) {
-	buf := make([]byte, 1024)
-	n, err := r.masterConn.Read(buf)
+// Reads a single reply from the master
+func (r *ReplicaServerImpl) ReadFromMaster() (RespValue, error) {
+	v, err := r.masterClient.reader.ReadValue()
 	if err != nil {
-		return nil, err
+		return RespValue{}, err
 	}
-	fmt.Printf("Received data from master: %s\n", buf[:n])
-	return buf[:n], nil
+	fmt.Printf("Received data from master: %s\n", v.Str())
+	return v, nil
 }
 
-// Override the Init method to allow the replica to sync with the master
-func (r *ReplicaServerImpl) Init() {
-	r.SyncWithMaster()
-	r.RedisServerImpl.Init()
+/*
+Override the Init method to allow the replica to sync with the master.
+The listeners are opened first, the master learns the actual port of the replica.
+*/
+func (r *ReplicaServerImpl) Init() error {
+	if err := r.RedisServerImpl.Init(); err != nil {
+		return err
+	}
+	if err := r.SyncWithMaster(); err != nil {
+		r.closeListeners()
+		return err
+	}
+	return nil
 }
 
 // Event loop, handles requests inside it
-func (s *ReplicaServerImpl) Listen() {
-	for {
-		conn, err := s.listener.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection: ", err.Error())
-			os.Exit(1)
-		}
-		s.AddClient(conn.RemoteAddr().String())
+func (s *ReplicaServerImpl) Listen() error {
+	return s.serve(func(conn net.Conn) {
 		go s.HandleClientConnections(conn)
-	}
+	})
 }
 
 // Handle incoming TCP Requests
 func (s *ReplicaServerImpl) HandleClientConnections(conn net.Conn) {
-	defer conn.Close()
-	buff := make([]byte, 1024)
+	var client *Client
+	s.execute(func() { client = s.AddClient(conn) })
+	if client == nil {
+		conn.Close()
+		return
+	}
+	defer s.RemoveClient(client)
 	for {
-		// Read from the connection
-		bytesRead, err := conn.Read(buff)
+		// Read every complete request available on the connection
+		requests, err := client.reader.ReadRequests()
+		if err != nil {
+			client.handleReadError(err)
+			return
+		}
+		reqHandler := NewRequestHandler(requests, s, client)
+		// Handles the requests and sends one reply per request
+		s.beginBatch(client)
+		err = reqHandler.HandleRequest()
+		s.endBatch(client)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				conn.Close()
-				break
-			}
 			fmt.Println(err)
 			return
 		}
-		// The data read from the TCP stream
-		request := buff[:bytesRead]
-		reqHandler := NewRequestHandler(request, s, conn)
-		// Handles the request and sends a response
-		reqHandler.HandleRequest()
 
-		// Check if the client is still connected
-		if !s.IsConnected(conn.RemoteAddr().String()) {
-			fmt.Printf("Client %s disconnected\n", conn.RemoteAddr().String())
-			break
+		// The client was killed by one of its own requests
+		if client.closeAfterReply {
+			fmt.Printf("Client %s disconnected\n", client.Addr())
+			return
 		}
 	}
 }
 
 // Connects to the master server and adds its connection to r.masterConn
-func (r *ReplicaServerImpl) dialMaster() {
-	conn, err := net.Dial("tcp", r.masterAddress)
+// The connection uses TLS when tls-replication is enabled
+func (r *ReplicaServerImpl) dialMaster() error {
+	var conn net.Conn
+	var err error
+	if r.tls.replication {
+		conn, err = r.tls.dial(r.masterAddress)
+	} else {
+		conn, err = net.Dial("tcp", r.masterAddress)
+	}
 	if err != nil {
-		fmt.Println("Error connecting to master: ", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error connecting to master: %w", err)
 	}
 	r.masterConn = conn
+	r.masterClient = r.AddClient(conn)
+	r.masterClient.flags |= CLIENT_MASTER
+	return nil
 }
 
 // Sync with master, do the handshake and start handling the master replica connection
-func (r *ReplicaServerImpl) SyncWithMaster() {
+// A replica cannot function without syncing with the master, the error is returned to Init
+func (r *ReplicaServerImpl) SyncWithMaster() error {
 	fmt.Printf("Syncing with master: %s\n", r.masterAddress)
-	r.dialMaster()
-	err := r.doHandshake()
-	if err != nil {
-		fmt.Println("Error syncing with master: ", err)
-		// Fatal error, a replica cannot function without syncing with the master
-		os.Exit(1)
+	if err := r.dialMaster(); err != nil {
+		return err
+	}
+	if err := r.doHandshake(); err != nil {
+		r.RemoveClient(r.masterClient)
+		return fmt.Errorf("error syncing with master: %w", err)
 	}
 	fmt.Printf("Synced with master: %s\n", r.masterAddress)
 	go r.HandleMasterConnection()
+	return nil
 }
 
 func (r *ReplicaServerImpl) HandleMasterConnection() {
-	buff := make([]byte, 1024)
+	defer r.RemoveClient(r.masterClient)
 	for {
-		// Read from the connection
-		bytesRead, err := r.masterConn.Read(buff)
+		// Read every complete request propagated by the master
+		requests, err := r.masterClient.reader.ReadRequests()
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				r.masterConn.Close()
-				break
+			if !errors.Is(err, io.EOF) {
+				fmt.Println(err)
 			}
-			fmt.Println(err)
 			return
 		}
-		// The data read from the TCP stream
-		request := buff[:bytesRead]
 		// Handles the decoded request and produce an answer
-		reqHandler := NewReqHandlerMasterReplica(request, r)
+		reqHandler := NewReqHandlerMasterReplica(requests, r, r.masterClient)
+		r.beginBatch(r.masterClient)
 		reqHandler.HandleRequest()
+		r.endBatch(r.masterClient)
 	}
 }
 
 func (r *ReplicaServerImpl) doHandshake() error {
 
-	expect := func(got, want []byte) bool {
-		if !reflect.DeepEqual(got, want) {
-			fmt.Printf("Expected '%s' but got '%s'\n", want, got)
+	expect := func(got RespValue, want string) bool {
+		if got.Kind() != '+' || got.Str() != want {
+			fmt.Printf("Expected '%s' but got '%s'\n", want, got.Str())
 			return false
 		}
 		return true
 	}
 
+	// Authenticate first, the master refuses every other command until then
+	if r.masterAuth != "" {
+		if r.masterUser != "" {
+			r.SendToMaster(newBulkArray("AUTH", r.masterUser, r.masterAuth))
+		} else {
+			r.SendToMaster(newBulkArray("AUTH", r.masterAuth))
+		}
+		resp, err := r.ReadFromMaster()
+		if err != nil {
+			return fmt.Errorf("error reading from master during handshake AUTH: %s", err)
+		}
+		if !expect(resp, "OK") {
+			return fmt.Errorf("unable to authenticate with the master")
+		}
+	}
+
 	// Send the PING command
 	r.SendToMaster(newBulkArray("PING"))
 
 	// Read the response
 	resp, err := r.ReadFromMaster()
 	if err != nil {
-		fmt.Println("Error reading from master during handshake PING: ", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error reading from master during handshake PING: %s", err)
 	}
-	if !expect(resp, newSimpleString("PONG")) {
-		fmt.Println("Error syncing with master")
-		os.Exit(1)
+	if !expect(resp, "PONG") {
+		return fmt.Errorf("unexpected reply to PING")
 	}
 
 	// Send the 1st REPLCONF commands
-	r.SendToMaster(newBulkArray("REPLCONF", "listening-port", r.port))
+	r.SendToMaster(newBulkArray("REPLCONF", "listening-port", r.infoPort()))
 
 	// Read the response
 	resp, err = r.ReadFromMaster()
 	if err != nil {
-		fmt.Println("Error reading from master during handshake 1st REPLCONF: ", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error reading from master during handshake 1st REPLCONF: %s", err)
 	}
-	if !expect(resp, newSimpleString("OK")) {
-		fmt.Println("Error syncing with master")
-		os.Exit(1)
+	if !expect(resp, "OK") {
+		return fmt.Errorf("unexpected reply to 1st REPLCONF")
 	}
 
 	// Send the 2nd REPLCONF commands
@@ -203,100 +214,38 @@ func (r *ReplicaServerImpl) doHandshake() error {
 	// Read the response
 	resp, err = r.ReadFromMaster()
 	if err != nil {
-		fmt.Println("Error reading from master during handshake 2nd REPLCONF: ", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("error reading from master during handshake 2nd REPLCONF: %s", err)
 	}
-	if !expect(resp, newSimpleString("OK")) {
-		fmt.Println("Error syncing with master")
-		os.Exit(1)
+	if !expect(resp, "OK") {
+		return fmt.Errorf("unexpected reply to 2nd REPLCONF")
 	}
 
 	// Send the PSYNC command
 	r.SendToMaster(newBulkArray("PSYNC", "?", "-1"))
-	r.handlePostHandshakeData()
-	return nil
+	return r.handlePostHandshakeData()
 }
 
+// Reads the FULLRESYNC reply and the RDB that follows it, the commands sent after the RDB are left in the reader
 func (r *ReplicaServerImpl) handlePostHandshakeData() error {
-	// Read the response
 	resp, err := r.ReadFromMaster()
 	if err != nil {
-		fmt.Println("Error reading from master: ", err.Error())
-		os.Exit(1)
-	}
-
-	// Decipher the response. It can be a mix of FULLRESYNC and the RDB and one or more commands
-	// We will need to decipher it like a stream of bytes whether we have handled the RDB or not
-	data := resp
-	cursor := 0
-	// Our goal is to find the end bound of the FULLRESYNC message which ends with \n
-	for i := 0; i < len(data); i++ {
-		if data[i] == '\n' {
-			cursor = i
-			fmt.Printf("FULLRESYNC: '%s'\n", data[:cursor])
-			break
-		}
+		return fmt.Errorf("error reading FULLRESYNC from master: %s", err)
 	}
+	fmt.Printf("FULLRESYNC: '%s'\n", resp.Str())
 
 	// Set the replicationID and the offset
-	parts := strings.Split(string(data[:cursor]), " ")
+	parts := strings.Split(resp.Str(), " ")
+	if len(parts) < 3 || parts[0] != "FULLRESYNC" {
+		return fmt.Errorf("unexpected reply to PSYNC: '%s'", resp.Str())
+	}
 	r.replicationID = parts[1]
-
 	fmt.Printf("Replication ID set: %s\n", r.replicationID)
 
-	// The RDB wasn't sent with the FULLRESYNC message, attempt to read it
-	if cursor+1 == len(data) {
-		buf := make([]byte, 1024)
-		n, err := r.masterConn.Read(buf)
-		if err != nil {
-			fmt.Println("Error reading from master: ", err.Error())
-			os.Exit(1)
-		}
-		fmt.Printf("Received response from master: %s\n", buf[:n])
-		data = buf[:n]
-		cursor = 0
-		// The RDB file isn't received yet
-		if len(data) == 0 {
-			fmt.Println("No data received")
-			return nil
-		}
-	} else {
-		cursor++
-	}
-
-	// We need to handle the RDB
-	lenToignore := 0
 	// The RDB length is prefixed with a '$' character, grab the length and ignore the RDB
-	if data[cursor] == '$' {
-		str := ""
-		for i := cursor + 1; i < len(data); i++ {
-			if data[i] != '\r' {
-				str += string(data[i])
-			} else {
-				cursor = i
-				break
-			}
-		}
-		lenToignore, err = strconv.Atoi(str)
-		if err != nil {
-			fmt.Println("Error converting RDB length: ", err)
-			return nil
-		}
-	} else {
-		fmt.Printf("Unexpected character: '%c'\n", data[cursor])
-		return nil
-	}
-
-	// Ignore the RDB
-	cursor += lenToignore + 2
-	if cursor >= len(data) {
-		fmt.Printf("RDB ignored and end of data\n")
-		return nil
+	rdb, err := r.masterClient.reader.ReadRDB()
+	if err != nil {
+		return fmt.Errorf("error reading RDB from master: %s", err)
 	}
-
-	// Handle the rest of the data
-	fmt.Printf("Data remaining: %s\n", data[cursor:])
-	reqH := NewReqHandlerMasterReplica(data[cursor:], r)
-	go reqH.HandleRequest()
+	fmt.Printf("RDB of %d bytes ignored\n", len(rdb))
 	return nil
 }
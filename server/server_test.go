@@ -1,53 +1,47 @@
-package server
+package server_test
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
 	"testing"
 	"time"
-)
 
-const APP = "../your_program.sh"
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/server"
+)
 
 var TestCases = []struct {
 	description    string
-	command        string
 	args           []string
 	expectedOutput string
 }{
 	{
 		description:    "Ping command",
-		command:        "redis-cli",
 		args:           []string{"PING"},
 		expectedOutput: "PONG\n",
 	},
 	{
 		description:    "Ping with arg command",
-		command:        "redis-cli",
 		args:           []string{"PING", "Hellou you silly pirate"},
 		expectedOutput: "Hellou you silly pirate\n",
 	},
 	{
 		description:    "Echo command",
-		command:        "redis-cli",
 		args:           []string{"ECHO", "'Hello World'"},
 		expectedOutput: "'Hello World'\n",
 	},
 	{
 		description:    "Set command",
-		command:        "redis-cli",
 		args:           []string{"SET", "name", "John"},
 		expectedOutput: "OK\n",
 	},
 	{
 		description:    "Get command",
-		command:        "redis-cli",
 		args:           []string{"GET", "name"},
 		expectedOutput: "John\n",
 	},
 	{
 		description:    "Get command",
-		command:        "redis-cli",
 		args:           []string{"GET", "unknown"},
 		expectedOutput: "\n",
 	},
@@ -55,10 +49,8 @@ var TestCases = []struct {
 
 var TestSetCases = []struct {
 	description       string
-	commandSet        string
 	argsSet           []string
 	expectedSetOutput string
-	commandGet        string
 	argsGet           []string
 	expectedGetOutput string
 	expiry            int64
@@ -66,10 +58,8 @@ var TestSetCases = []struct {
 }{
 	{
 		description:       "Set command: 1 second expiry with 0.5 second sleep",
-		commandSet:        "redis-cli",
 		argsSet:           []string{"SET", "name", "John", "EX", "1"}, // One second expiry
 		expectedSetOutput: "OK\n",
-		commandGet:        "redis-cli",
 		argsGet:           []string{"GET", "name"},
 		expectedGetOutput: "John\n",
 		expiry:            1000,
@@ -77,10 +67,8 @@ var TestSetCases = []struct {
 	},
 	{
 		description:       "Set command: 1 second expiry with 1.1 second sleep",
-		commandSet:        "redis-cli",
 		argsSet:           []string{"SET", "name", "John", "EX", "1"}, // One second expiry
 		expectedSetOutput: "OK\n",
-		commandGet:        "redis-cli",
 		argsGet:           []string{"GET", "name"},
 		expectedGetOutput: "\n",
 		expiry:            1000,
@@ -88,10 +76,8 @@ var TestSetCases = []struct {
 	},
 	{
 		description:       "Set command: 1000 ms expiry with 900 ms sleep",
-		commandSet:        "redis-cli",
 		argsSet:           []string{"SET", "color", "Purple", "PX", "1000"}, // One second expiry
 		expectedSetOutput: "OK\n",
-		commandGet:        "redis-cli",
 		argsGet:           []string{"GET", "color"},
 		expectedGetOutput: "Purple\n",
 		expiry:            1000,
@@ -99,10 +85,8 @@ var TestSetCases = []struct {
 	},
 	{
 		description:       "Set command: 1000 ms expiry with 1001 ms sleep",
-		commandSet:        "redis-cli",
 		argsSet:           []string{"SET", "color", "Purple", "PX", "1000"}, // One second expiry
 		expectedSetOutput: "OK\n",
-		commandGet:        "redis-cli",
 		argsGet:           []string{"GET", "color"},
 		expectedGetOutput: "\n",
 		expiry:            1000,
@@ -110,10 +94,8 @@ var TestSetCases = []struct {
 	},
 	{
 		description:       "Set command: NX a key that does not exist",
-		commandSet:        "redis-cli",
 		argsSet:           []string{"SET", "transport", "Car", "NX"}, // only set if key does not exist
 		expectedSetOutput: "OK\n",
-		commandGet:        "redis-cli",
 		argsGet:           []string{"GET", "transport"},
 		expectedGetOutput: "Car\n",
 		expiry:            0,
@@ -225,24 +207,48 @@ var ExistsTestCases = []struct {
 	},
 }
 
-func StartMasterTestServer() RedisServer {
-	server := NewMasterServer(map[string]string{})
-	server.Init()
-	return server
+// Starts an in-process master on a free port, closed at the end of the test, and a client connected to it
+func StartMasterTestServer(t *testing.T) *client.Client {
+	cfg := server.DefaultConfig()
+	cfg.Port = 0
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	addr, err := srv.Start(context.Background())
+	if err != nil {
+		t.Fatalf("failed to start the server: %s", err)
+	}
+	c := client.New(client.Options{Addr: addr})
+	t.Cleanup(func() {
+		c.Close()
+		srv.Close()
+	})
+	return c
 }
 
-func runCommand(command string, args ...string) (string, error) {
-	cmd := exec.Command(command, args...)
-	output, err := cmd.Output()
-	return string(output), err
+// Runs a command and formats its reply the way redis-cli prints it
+func runCommand(c *client.Client, args ...string) (string, error) {
+	v, err := c.Do(args...)
+	if _, ok := err.(client.Error); err != nil && !ok {
+		return "", err
+	}
+	switch {
+	case v.IsError():
+		return fmt.Sprintf("(error) %s\n", v.Str()), nil
+	case v.IsNull():
+		return "\n", nil
+	case v.Kind() == ':':
+		return fmt.Sprintf("%d\n", v.Int()), nil
+	}
+	return v.Str() + "\n", nil
 }
 
 func TestCommands(t *testing.T) {
-	server := StartMasterTestServer()
-	go server.Listen()
+	c := StartMasterTestServer(t)
 	for _, tc := range TestCases {
 		t.Run(tc.description, func(t *testing.T) {
-			output, err := runCommand(tc.command, tc.args...)
+			output, err := runCommand(c, tc.args...)
 			if err != nil {
 				fmt.Printf("Output: %s\n", output)
 				t.Fatalf("error while running the test: %s", err)
@@ -255,11 +261,10 @@ func TestCommands(t *testing.T) {
 }
 
 func TestSetCommand(t *testing.T) {
-	// server := StartMasterTestServer()
-	// go server.Listen()
+	c := StartMasterTestServer(t)
 	for _, tc := range TestSetCases {
 		t.Run(tc.description, func(t *testing.T) {
-			outSet, err := runCommand(tc.commandSet, tc.argsSet...)
+			outSet, err := runCommand(c, tc.argsSet...)
 			if err != nil {
 				t.Fatalf("error while running the test: %s", err)
 			} else if outSet != tc.expectedSetOutput {
@@ -270,7 +275,7 @@ func TestSetCommand(t *testing.T) {
 			fmt.Printf("sleep for: %d ms\n", tc.sleep)
 			time.Sleep(time.Millisecond * time.Duration(tc.sleep))
 
-			outGet, err := runCommand(tc.commandGet, tc.argsGet...)
+			outGet, err := runCommand(c, tc.argsGet...)
 			if err != nil {
 				t.Fatalf("error while running the test: %s", err)
 			}
@@ -282,12 +287,11 @@ func TestSetCommand(t *testing.T) {
 }
 
 func TestDelCommand(t *testing.T) {
-	// server := StartMasterTestServer()
-	// go server.Listen()
+	c := StartMasterTestServer(t)
 	for _, tc := range DelTestCases {
 		t.Run(tc.description, func(t *testing.T) {
 			for i, commands := range tc.commands {
-				out, err := runCommand("redis-cli", commands...)
+				out, err := runCommand(c, commands...)
 				if err != nil {
 					t.Fatalf("error while running the test: %s", err)
 				}
@@ -300,12 +304,11 @@ func TestDelCommand(t *testing.T) {
 }
 
 func TestCopyCommand(t *testing.T) {
-	// server := StartMasterTestServer()
-	// go server.Listen()
+	c := StartMasterTestServer(t)
 	for _, tc := range CopyTestCases {
 		t.Run(tc.description, func(t *testing.T) {
 			for i, commands := range tc.commands {
-				out, err := runCommand("redis-cli", commands...)
+				out, err := runCommand(c, commands...)
 				if err != nil {
 					t.Fatalf("error while running the test: %s", err)
 				}
@@ -318,12 +321,11 @@ func TestCopyCommand(t *testing.T) {
 }
 
 func TestExistsCommand(t *testing.T) {
-	// server := StartMasterTestServer()
-	// go server.Listen()
+	c := StartMasterTestServer(t)
 	for _, tc := range ExistsTestCases {
 		t.Run(tc.description, func(t *testing.T) {
 			for i, commands := range tc.commands {
-				out, err := runCommand("redis-cli", commands...)
+				out, err := runCommand(c, commands...)
 				if err != nil {
 					t.Fatalf("error while running the test: %s", err)
 				}
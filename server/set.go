@@ -0,0 +1,435 @@
+package server
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+/*
+Returns the set of a key, nil if the key does not exist.
+Returns the WRONGTYPE error reply if the key holds another type.
+*/
+func (r *ReqHandlerImpl) lookupSet(key string) (*Set, []byte) {
+	v, ok := r.server.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	if v.set == nil {
+		return nil, newSimpleError(errWrongType.Error())
+	}
+	return v.set, nil
+}
+
+// Returns the set of a key, created if the key does not exist, or the WRONGTYPE error reply
+func (r *ReqHandlerImpl) lookupOrCreateSet(key string) (*Set, []byte) {
+	s, reply := r.lookupSet(key)
+	if reply != nil || s != nil {
+		return s, reply
+	}
+	s = NewSet()
+	r.server.Put(key, Object{set: s})
+	return s, nil
+}
+
+// Replies the members of a set, as a set in RESP3
+func (r *ReqHandlerImpl) setReply(members []string) []byte {
+	elements := make([]string, 0, len(members))
+	for _, member := range members {
+		elements = append(elements, string(newBulkString(member)))
+	}
+	return r.encoder().Set(elements...)
+}
+
+// SADD key member [member ...], replies the number of members added
+func (r *ReqHandlerImpl) sadd(req *Request) []byte {
+	s, reply := r.lookupOrCreateSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	added := 0
+	for _, member := range req.args[1:] {
+		if s.Add(member) {
+			added++
+		}
+	}
+	return newInteger(added)
+}
+
+// SREM key member [member ...], replies the number of members removed, the set left empty is deleted
+func (r *ReqHandlerImpl) srem(req *Request) []byte {
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		return newInteger(0)
+	}
+	removed := 0
+	for _, member := range req.args[1:] {
+		if s.Remove(member) {
+			removed++
+		}
+	}
+	if s.Len() == 0 {
+		r.server.Del([]string{req.args[0]})
+	}
+	return newInteger(removed)
+}
+
+// SMEMBERS key
+func (r *ReqHandlerImpl) smembers(req *Request) []byte {
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		return r.setReply(nil)
+	}
+	return r.setReply(s.Members())
+}
+
+// SISMEMBER key member
+func (r *ReqHandlerImpl) sismember(req *Request) []byte {
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s != nil && s.Contains(req.args[1]) {
+		return newInteger(1)
+	}
+	return newInteger(0)
+}
+
+// SMISMEMBER key member [member ...], replies 1 or 0 for each member
+func (r *ReqHandlerImpl) smismember(req *Request) []byte {
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	results := make([]string, 0, len(req.args)-1)
+	for _, member := range req.args[1:] {
+		if s != nil && s.Contains(member) {
+			results = append(results, string(newInteger(1)))
+		} else {
+			results = append(results, string(newInteger(0)))
+		}
+	}
+	return newBulkArrayOfArrays(results...)
+}
+
+// SCARD key
+func (r *ReqHandlerImpl) scard(req *Request) []byte {
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		return newInteger(0)
+	}
+	return newInteger(s.Len())
+}
+
+/*
+SPOP key [count], removes random members.
+Without count the member is replied, nil if the key doesn't exist, with count a set of up to count members.
+The pop is propagated as SREM of the members popped, so that the replicas remove the same ones.
+*/
+func (r *ReqHandlerImpl) spop(req *Request) []byte {
+	if len(req.args) > 2 {
+		return newSimpleError("ERR syntax error")
+	}
+	count, withCount := 1, len(req.args) == 2
+	if withCount {
+		var reply []byte
+		if count, reply = parseInteger(req.args[1]); reply != nil {
+			return reply
+		}
+		if count < 0 {
+			return newSimpleError("ERR value is out of range, must be positive")
+		}
+	}
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		if withCount {
+			return r.setReply(nil)
+		}
+		return r.encoder().Null()
+	}
+	members := s.Members()
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	popped := members[:min(count, len(members))]
+	for _, member := range popped {
+		s.Remove(member)
+	}
+	if s.Len() == 0 {
+		r.server.Del([]string{req.args[0]})
+	}
+	r.preventPropagation = true
+	if len(popped) > 0 {
+		r.propagate(NewRequest("SREM", append([]string{req.args[0]}, popped...)...))
+	}
+	if !withCount {
+		return newBulkString(popped[0])
+	}
+	return r.setReply(popped)
+}
+
+/*
+Parses the count of SRANDMEMBER and HRANDFIELD, between -MaxInt64 and MaxInt64 like Redis,
+so that the count of a reply whose elements may repeat, -count, doesn't overflow.
+*/
+func parseRandomCount(arg string) (int, []byte) {
+	count, reply := parseInteger(arg)
+	if reply != nil {
+		return 0, reply
+	}
+	if count < -math.MaxInt64 {
+		return 0, newSimpleError("ERR value is out of range")
+	}
+	return count, nil
+}
+
+/*
+SRANDMEMBER key [count].
+Without count replies a random member, nil if the key does not exist.
+A positive count replies up to count distinct members, a negative one -count members that may repeat,
+the reply grows as they are picked instead of being allocated for -count members up front.
+*/
+func (r *ReqHandlerImpl) srandmember(req *Request) []byte {
+	if len(req.args) > 2 {
+		return newSimpleError("ERR syntax error")
+	}
+	count, withCount := 1, len(req.args) == 2
+	if withCount {
+		var reply []byte
+		if count, reply = parseRandomCount(req.args[1]); reply != nil {
+			return reply
+		}
+	}
+	s, reply := r.lookupSet(req.args[0])
+	if reply != nil {
+		return reply
+	}
+	if s == nil {
+		if withCount {
+			return newBulkArray()
+		}
+		return r.encoder().Null()
+	}
+	members := s.Members()
+	if !withCount {
+		return newBulkString(members[rand.IntN(len(members))])
+	}
+	if count >= 0 {
+		rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+		return newBulkArray(members[:min(count, len(members))]...)
+	}
+	var picked []string
+	for i := 0; i < -count; i++ {
+		picked = append(picked, members[rand.IntN(len(members))])
+	}
+	return newBulkArray(picked...)
+}
+
+// The set algebra of SINTER, SUNION, SDIFF and their STORE variants
+type setOperation int
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+/*
+Computes the intersection, the union or the difference of the sets of keys, the keys that don't exist being empty sets.
+The difference is the members of the first set that are in none of the others.
+Returns the WRONGTYPE error reply if one of the keys holds another type.
+*/
+func (r *ReqHandlerImpl) setAlgebra(op setOperation, keys []string) (*Set, []byte) {
+	sets := make([]*Set, 0, len(keys))
+	for _, key := range keys {
+		s, reply := r.lookupSet(key)
+		if reply != nil {
+			return nil, reply
+		}
+		if s == nil {
+			s = NewSet()
+		}
+		sets = append(sets, s)
+	}
+	result := NewSet()
+	switch op {
+	case setInter:
+		smallest := sets[0]
+		for _, s := range sets[1:] {
+			if s.Len() < smallest.Len() {
+				smallest = s
+			}
+		}
+		for _, member := range smallest.Members() {
+			if inAll(sets, member) {
+				result.Add(member)
+			}
+		}
+	case setUnion:
+		for _, s := range sets {
+			for _, member := range s.Members() {
+				result.Add(member)
+			}
+		}
+	case setDiff:
+		for _, member := range sets[0].Members() {
+			if !inAny(sets[1:], member) {
+				result.Add(member)
+			}
+		}
+	}
+	return result, nil
+}
+
+func inAll(sets []*Set, member string) bool {
+	for _, s := range sets {
+		if !s.Contains(member) {
+			return false
+		}
+	}
+	return true
+}
+
+func inAny(sets []*Set, member string) bool {
+	for _, s := range sets {
+		if s.Contains(member) {
+			return true
+		}
+	}
+	return false
+}
+
+// SINTER key [key ...], SUNION and SDIFF, reply the members of the resulting set
+func (r *ReqHandlerImpl) setAlgebraCommand(req *Request, op setOperation) []byte {
+	result, reply := r.setAlgebra(op, req.args)
+	if reply != nil {
+		return reply
+	}
+	return r.setReply(result.Members())
+}
+
+/*
+SINTERSTORE destination key [key ...], SUNIONSTORE and SDIFFSTORE.
+The resulting set replaces the destination whatever its type and its expiry time, an empty one deletes the destination.
+Replies the number of members of the resulting set.
+*/
+func (r *ReqHandlerImpl) setAlgebraStore(req *Request, op setOperation) []byte {
+	result, reply := r.setAlgebra(op, req.args[1:])
+	if reply != nil {
+		return reply
+	}
+	if result.Len() == 0 {
+		r.server.Del([]string{req.args[0]})
+	} else {
+		r.server.Put(req.args[0], Object{set: result})
+	}
+	return newInteger(result.Len())
+}
+
+func (r *ReqHandlerImpl) sinter(req *Request) []byte {
+	return r.setAlgebraCommand(req, setInter)
+}
+
+func (r *ReqHandlerImpl) sunion(req *Request) []byte {
+	return r.setAlgebraCommand(req, setUnion)
+}
+
+func (r *ReqHandlerImpl) sdiff(req *Request) []byte {
+	return r.setAlgebraCommand(req, setDiff)
+}
+
+func (r *ReqHandlerImpl) sinterstore(req *Request) []byte {
+	return r.setAlgebraStore(req, setInter)
+}
+
+func (r *ReqHandlerImpl) sunionstore(req *Request) []byte {
+	return r.setAlgebraStore(req, setUnion)
+}
+
+func (r *ReqHandlerImpl) sdiffstore(req *Request) []byte {
+	return r.setAlgebraStore(req, setDiff)
+}
+
+// The keys of SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sintercardKeys(args []string) []string {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 1 || numKeys+1 > len(args) {
+		return nil
+	}
+	return args[1 : 1+numKeys]
+}
+
+/*
+SINTERCARD numkeys key [key ...] [LIMIT limit], replies the number of members of the intersection of the sets.
+The count stops at limit if it isn't 0.
+*/
+func (r *ReqHandlerImpl) sintercard(req *Request) []byte {
+	numKeys, err := strconv.Atoi(req.args[0])
+	if err != nil || numKeys < 1 {
+		return newSimpleError("ERR numkeys should be greater than 0")
+	}
+	if numKeys+1 > len(req.args) {
+		return newSimpleError("ERR Number of keys can't be greater than number of args")
+	}
+	keys, rest := req.args[1:1+numKeys], req.args[1+numKeys:]
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "LIMIT") {
+			return newSimpleError("ERR syntax error")
+		}
+		var reply []byte
+		if limit, reply = parseInteger(rest[1]); reply != nil {
+			return reply
+		}
+		if limit < 0 {
+			return newSimpleError("ERR LIMIT can't be negative")
+		}
+	}
+	result, reply := r.setAlgebra(setInter, keys)
+	if reply != nil {
+		return reply
+	}
+	if limit > 0 {
+		return newInteger(min(result.Len(), limit))
+	}
+	return newInteger(result.Len())
+}
+
+// SMOVE source destination member, replies 1 if the member was moved, 0 if it isn't in the source
+func (r *ReqHandlerImpl) smove(req *Request) []byte {
+	source, destination, member := req.args[0], req.args[1], req.args[2]
+	src, reply := r.lookupSet(source)
+	if reply != nil {
+		return reply
+	}
+	if _, reply := r.lookupSet(destination); reply != nil {
+		return reply
+	}
+	if src == nil || !src.Contains(member) {
+		return newInteger(0)
+	}
+	if source == destination {
+		return newInteger(1)
+	}
+	src.Remove(member)
+	if src.Len() == 0 {
+		r.server.Del([]string{source})
+	}
+	dst, reply := r.lookupOrCreateSet(destination)
+	if reply != nil {
+		return reply
+	}
+	dst.Add(member)
+	return newInteger(1)
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	srv, addr := startTestServer(t, testConfig())
+	c := dialTestClient(t, addr)
+	defer c.Close()
+
+	steps := []struct {
+		command  string
+		expected string
+	}{
+		{"SADD flags 3 1 2 1", "3"},
+		{"SADD flags 2 4", "1"},
+		{"TYPE flags", "set"},
+		{"SMEMBERS flags", "[1 2 3 4]"},
+		{"SCARD flags", "4"},
+		{"SISMEMBER flags 2", "1"},
+		{"SISMEMBER flags 02", "0"},
+		{"SMISMEMBER flags 1 5 x", "[1 0 0]"},
+		{"SREM flags 4 5 x", "1"},
+		{"SADD beta 2 3 ada", "3"},
+		{"SADD gamma 3 ada grace", "3"},
+		{"SINTER flags beta", "[2 3]"},
+		{"SINTER flags beta gamma", "[3]"},
+		{"SINTER flags missing", "[]"},
+		{"SUNION flags gamma missing", "[1 2 3 ada grace]"},
+		{"SDIFF flags gamma", "[1 2]"},
+		{"SDIFF beta flags gamma", "[]"},
+		{"SDIFF missing flags", "[]"},
+		{"SINTERCARD 2 flags beta", "2"},
+		{"SINTERCARD 2 flags beta LIMIT 1", "1"},
+		{"SINTERCARD 3 flags beta gamma LIMIT 0", "1"},
+		{"SINTERCARD 0 flags", "ERR numkeys should be greater than 0"},
+		{"SINTERCARD 3 flags beta", "ERR Number of keys can't be greater than number of args"},
+		{"SINTERCARD 1 flags LIMIT -1", "ERR LIMIT can't be negative"},
+		{"SINTERCARD 1 flags LIMIT", "ERR syntax error"},
+		{"SINTERSTORE both flags beta", "2"},
+		{"SMEMBERS both", "[2 3]"},
+		{"SUNIONSTORE all flags beta gamma", "5"},
+		{"SMEMBERS all", "[1 2 3 ada grace]"},
+		{"SDIFFSTORE all flags beta", "1"},
+		{"SMEMBERS all", "[1]"},
+		{"SINTERSTORE all flags missing", "0"},
+		{"EXISTS all", "0"},
+		{"SMOVE gamma flags grace", "1"},
+		{"SMOVE gamma flags grace", "0"},
+		{"SMOVE gamma gamma ada", "1"},
+		{"SMOVE missing flags 1", "0"},
+		{"SISMEMBER flags grace", "1"},
+		{"SREM gamma 3 ada", "2"},
+		{"EXISTS gamma", "0"},
+		{"SCARD missing", "0"},
+		{"SMEMBERS missing", "[]"},
+		{"SPOP missing", "nil"},
+		{"SPOP missing 2", "[]"},
+		{"SPOP flags -1", "ERR value is out of range, must be positive"},
+		{"SRANDMEMBER missing", "nil"},
+		{"SRANDMEMBER missing 3", "[]"},
+		{"SRANDMEMBER flags 1 2", "ERR syntax error"},
+		{"COMMAND GETKEYS SINTERCARD 2 a b LIMIT 1", "[a b]"},
+		{"COMMAND GETKEYS SUNIONSTORE dst a b", "[dst a b]"},
+	}
+	for _, step := range steps {
+		if v := formatReply(c.do(t, strings.Fields(step.command)...)); v != step.expected {
+			t.Fatalf("%s: expected %q, got %q", step.command, step.expected, v)
+		}
+	}
+
+	encoding := func(key string) (encoding string) {
+		srv.execute(func() {
+			srv.useDB(0)
+			if v, ok := srv.Lookup(key); ok {
+				encoding = v.set.Encoding()
+			}
+		})
+		return
+	}
+	t.Run("intset encoding", func(t *testing.T) {
+		c.do(t, "SADD", "ints", "-70000", "5", "300", "9223372036854775807")
+		if e := encoding("ints"); e != "intset" {
+			t.Fatalf("expected an intset, got %s", e)
+		}
+		if v := formatReply(c.do(t, "SMEMBERS", "ints")); v != "[-70000 5 300 9223372036854775807]" {
+			t.Fatalf("expected the members in numerical order, got %s", v)
+		}
+		// Not the canonical form of an integer
+		c.do(t, "SADD", "ints", "+5")
+		if e := encoding("ints"); e != "hashtable" {
+			t.Fatalf("expected a hash table once a member isn't an integer, got %s", e)
+		}
+		if v := formatReply(c.do(t, "SMISMEMBER", "ints", "5", "+5", "300")); v != "[1 1 1]" {
+			t.Fatalf("expected the members to be kept, got %s", v)
+		}
+
+		args := []string{"SADD", "large"}
+		for i := 0; i < SET_MAX_INTSET_ENTRIES; i++ {
+			args = append(args, strconv.Itoa(i))
+		}
+		c.do(t, args...)
+		if e := encoding("large"); e != "intset" {
+			t.Fatalf("expected an intset of %d members, got %s", SET_MAX_INTSET_ENTRIES, e)
+		}
+		c.do(t, "SADD", "large", "-1")
+		if e := encoding("large"); e != "hashtable" {
+			t.Fatalf("expected a hash table past %d members, got %s", SET_MAX_INTSET_ENTRIES, e)
+		}
+		if v := c.do(t, "SCARD", "large"); v.Int() != SET_MAX_INTSET_ENTRIES+1 {
+			t.Fatalf("expected %d members, got %d", SET_MAX_INTSET_ENTRIES+1, v.Int())
+		}
+		c.do(t, "SINTERSTORE", "small", "large", "flags")
+		if e := encoding("small"); e != "intset" {
+			t.Fatalf("expected the stored set of integers to be an intset, got %s", e)
+		}
+	})
+
+	t.Run("SPOP and SRANDMEMBER", func(t *testing.T) {
+		c.do(t, "SADD", "letters", "a", "b", "c")
+		if v := c.do(t, "SRANDMEMBER", "letters").Str(); v != "a" && v != "b" && v != "c" {
+			t.Fatalf("unexpected member %q", v)
+		}
+		members := c.do(t, "SRANDMEMBER", "letters", "10").Array()
+		seen := make(map[string]bool)
+		for _, m := range members {
+			seen[m.Str()] = true
+		}
+		if len(members) != 3 || len(seen) != 3 {
+			t.Fatalf("expected the 3 distinct members, got %d", len(members))
+		}
+		if n := len(c.do(t, "SRANDMEMBER", "letters", "-7").Array()); n != 7 {
+			t.Fatalf("expected 7 members, got %d", n)
+		}
+		for command, expected := range map[string]string{
+			"SRANDMEMBER letters -9223372036854775808": "ERR value is out of range",
+			"SRANDMEMBER letters -9223372036854775809": "ERR value is not an integer or out of range",
+			"SRANDMEMBER letters 9223372036854775807":  "3",
+			"SRANDMEMBER missing -9223372036854775807": "0",
+		} {
+			v := c.do(t, strings.Fields(command)...)
+			if v.IsError() && v.Str() != expected || !v.IsError() && strconv.Itoa(len(v.Array())) != expected {
+				t.Fatalf("%s: expected %s, got %s", command, expected, formatReply(v))
+			}
+		}
+		popped := c.do(t, "SPOP", "letters").Str()
+		if v := c.do(t, "SISMEMBER", "letters", popped); v.Int() != 0 {
+			t.Fatalf("expected %s to be removed", popped)
+		}
+		if n := len(c.do(t, "SPOP", "letters", "5").Array()); n != 2 {
+			t.Fatalf("expected the 2 members left, got %d", n)
+		}
+		if v := c.do(t, "EXISTS", "letters"); v.Int() != 0 {
+			t.Fatalf("expected the set to be deleted with its last member")
+		}
+
+		resp3 := dialTestClient(t, addr)
+		defer resp3.Close()
+		resp3.do(t, "HELLO", "3")
+		if v := resp3.do(t, "SMEMBERS", "flags"); v.Kind() != '~' {
+			t.Fatalf("expected a set in RESP3, got %c", v.Kind())
+		}
+	})
+
+	t.Run("WRONGTYPE", func(t *testing.T) {
+		c.do(t, "SET", "string", "v")
+		c.do(t, "SADD", "set", "a")
+		wrongType := errWrongType.Error()
+		for _, command := range []string{
+			"SADD string a", "SREM string a", "SMEMBERS string", "SISMEMBER string a", "SMISMEMBER string a",
+			"SCARD string", "SPOP string", "SRANDMEMBER string", "SINTER set string", "SUNION string set",
+			"SDIFF set string", "SINTERSTORE dst set string", "SINTERCARD 2 set string", "SMOVE string set a",
+			"SMOVE set string a", "GET set", "HGET set f", "LLEN set",
+		} {
+			if v := formatReply(c.do(t, strings.Fields(command)...)); v != wrongType {
+				t.Fatalf("%s: expected %q, got %q", command, wrongType, v)
+			}
+		}
+		// The destination of the STORE variants is replaced whatever its type
+		if v := formatReply(c.do(t, "SUNIONSTORE", "string", "set")); v != "1" {
+			t.Fatalf("expected the string to be replaced, got %s", v)
+		}
+	})
+
+	t.Run("COPY", func(t *testing.T) {
+		c.do(t, "SADD", "source", "1")
+		c.do(t, "COPY", "source", "copy")
+		c.do(t, "SADD", "copy", "2")
+		if v := formatReply(c.do(t, "SMEMBERS", "source")); v != "[1]" {
+			t.Fatalf("expected the copy not to share the set, got %s", v)
+		}
+	})
+}
+
+func TestSetReplication(t *testing.T) {
+	_, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	for _, command := range []string{
+		"SADD cohort 1 2 3 4 5 6",
+		"SREM cohort 6",
+		"SPOP cohort 2",
+		"SPOP cohort",
+		"SADD beta 1 2 3 4 5 ada",
+		"SINTERSTORE both cohort beta",
+		"SMOVE beta cohort ada",
+	} {
+		c.do(t, strings.Fields(command)...)
+	}
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+
+	members := func(key string) (members string) {
+		replica.execute(func() {
+			replica.useDB(0)
+			if v, ok := replica.Lookup(key); ok {
+				members = strings.Join(v.set.Members(), " ")
+			}
+		})
+		return
+	}
+	// SPOP is propagated as the SREM of the members the master popped
+	for _, key := range []string{"cohort", "beta", "both"} {
+		expected := make([]string, 0)
+		for _, m := range c.do(t, "SMEMBERS", key).Array() {
+			expected = append(expected, m.Str())
+		}
+		if v := members(key); v != strings.Join(expected, " ") {
+			t.Fatalf("%s: expected %v on the replica, got %q", key, expected, v)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// The number of members up to which a set of integers is kept as an intset
+const SET_MAX_INTSET_ENTRIES = 512
+
+/*
+Set is the value of a set: distinct members.
+A set whose members are all integers is encoded as an intset, its members kept sorted in a slice of integers,
+like Redis it is converted to a hash table for good once a member isn't an integer or it grows past SET_MAX_INTSET_ENTRIES.
+*/
+type Set struct {
+	intset  []int64             // the members of an intset, sorted
+	members map[string]struct{} // the members once converted to a hash table, nil while the set is an intset
+}
+
+func NewSet() *Set {
+	return &Set{}
+}
+
+// Parses a member of an intset, it must be the canonical form of the integer so that it is replied the same way
+func intsetMember(member string) (int64, bool) {
+	i, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != member {
+		return 0, false
+	}
+	return i, true
+}
+
+func (s *Set) isIntset() bool {
+	return s.members == nil
+}
+
+// Returns the encoding of the set as OBJECT ENCODING would: intset or hashtable
+func (s *Set) Encoding() string {
+	if s.isIntset() {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+func (s *Set) Len() int {
+	if s.isIntset() {
+		return len(s.intset)
+	}
+	return len(s.members)
+}
+
+func (s *Set) Contains(member string) bool {
+	if !s.isIntset() {
+		_, ok := s.members[member]
+		return ok
+	}
+	i, ok := intsetMember(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.intset, i)
+	return found
+}
+
+// Adds a member and returns true if it wasn't in the set, the intset is converted if needed
+func (s *Set) Add(member string) bool {
+	if s.isIntset() {
+		if i, ok := intsetMember(member); ok {
+			pos, found := slices.BinarySearch(s.intset, i)
+			if found {
+				return false
+			}
+			if len(s.intset) < SET_MAX_INTSET_ENTRIES {
+				s.intset = slices.Insert(s.intset, pos, i)
+				return true
+			}
+		}
+		s.convert()
+	}
+	if _, ok := s.members[member]; ok {
+		return false
+	}
+	s.members[member] = struct{}{}
+	return true
+}
+
+// Removes a member, false if it is not in the set
+func (s *Set) Remove(member string) bool {
+	if !s.isIntset() {
+		if _, ok := s.members[member]; !ok {
+			return false
+		}
+		delete(s.members, member)
+		return true
+	}
+	i, ok := intsetMember(member)
+	if !ok {
+		return false
+	}
+	pos, found := slices.BinarySearch(s.intset, i)
+	if found {
+		s.intset = slices.Delete(s.intset, pos, pos+1)
+	}
+	return found
+}
+
+// Converts an intset to a hash table
+func (s *Set) convert() {
+	s.members = make(map[string]struct{}, len(s.intset)+1)
+	for _, i := range s.intset {
+		s.members[strconv.FormatInt(i, 10)] = struct{}{}
+	}
+	s.intset = nil
+}
+
+// Returns the members sorted, numerically for an intset, so that SMEMBERS and the set algebra reply them in a stable order
+func (s *Set) Members() []string {
+	if s.isIntset() {
+		members := make([]string, len(s.intset))
+		for i, member := range s.intset {
+			members[i] = strconv.FormatInt(member, 10)
+		}
+		return members
+	}
+	members := make([]string, 0, len(s.members))
+	for member := range s.members {
+		members = append(members, member)
+	}
+	slices.Sort(members)
+	return members
+}
+
+// Returns a copy of the set that doesn't share its members
+func (s *Set) clone() *Set {
+	return &Set{intset: slices.Clone(s.intset), members: maps.Clone(s.members)}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How long a shutdown waits for the replicas to catch up, and for the commands being run to finish
+	SHUTDOWN_TIMEOUT = 10 * time.Second
+	SHUTDOWN_ERROR   = "ERR Errors trying to SHUTDOWN. Check logs."
+)
+
+var errShutdownInProgress = errors.New("ERR Shutdown already in progress.")
+
+// Options of SHUTDOWN, a signal shuts down with none of them and Close with now and force
+type ShutdownOptions struct {
+	save   bool // saves a snapshot even if no RDB file is configured
+	noSave bool // never saves a snapshot
+	now    bool // does not wait for the replicas to catch up
+	force  bool // shuts down even if the snapshot can't be saved
+}
+
+// Parses the flags of SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]
+func parseShutdownOptions(args []string) (opts ShutdownOptions, abort bool, err error) {
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			opts.save = true
+		case "NOSAVE":
+			opts.noSave = true
+		case "NOW":
+			opts.now = true
+		case "FORCE":
+			opts.force = true
+		case "ABORT":
+			abort = true
+		default:
+			return opts, false, errors.New("ERR syntax error")
+		}
+	}
+	if (opts.save && opts.noSave) || (abort && len(args) > 1) {
+		return opts, false, errors.New("ERR syntax error")
+	}
+	return opts, abort, nil
+}
+
+// Writes the process ID to the pid file, if one is configured
+func (s *RedisServerImpl) writePidFile() {
+	if s.pidFile == "" {
+		return
+	}
+	if err := os.WriteFile(s.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		fmt.Printf("Failed to write the pid file %s: %s\n", s.pidFile, err)
+	}
+}
+
+/*
+Shuts the server down, caller is the client running SHUTDOWN, nil for a signal or Close.
+Never called on the executor, the shutdown waits for the commands being run.
+The master first gives its replicas a chance to catch up, see MasterServerImpl.Shutdown.
+The clients then stop running new commands while the ones being run finish,
+and the dataset is saved if an RDB file is configured or SAVE is given.
+A failed save aborts the shutdown unless FORCE is given, the server then resumes.
+Otherwise the listeners are closed, the socket and pid files removed,
+the connections closed and Listen returns.
+*/
+func (s *RedisServerImpl) Shutdown(opts ShutdownOptions, caller *Client) error {
+	return s.shutdown(opts, caller, nil)
+}
+
+// Cancels a shutdown waiting for the replicas
+func (s *RedisServerImpl) AbortShutdown() error {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shutdownAbort == nil {
+		return errors.New("ERR No shutdown in progress.")
+	}
+	close(s.shutdownAbort)
+	s.shutdownAbort = nil
+	return nil
+}
+
+// waitReplicas is nil for a replica, it returns false when the shutdown is aborted
+func (s *RedisServerImpl) shutdown(opts ShutdownOptions, caller *Client, waitReplicas func(abort <-chan struct{}) bool) error {
+	s.shutdownMu.Lock()
+	if s.shutdownAbort != nil || s.closing {
+		s.shutdownMu.Unlock()
+		return errShutdownInProgress
+	}
+	abort := make(chan struct{})
+	s.shutdownAbort = abort
+	s.shutdownMu.Unlock()
+	fmt.Println("User requested shutdown...")
+
+	if waitReplicas != nil && !opts.now && !waitReplicas(abort) {
+		fmt.Println("Shutdown aborted")
+		return errors.New(SHUTDOWN_ERROR)
+	}
+	s.shutdownMu.Lock()
+	if s.shutdownAbort != abort {
+		s.shutdownMu.Unlock()
+		fmt.Println("Shutdown aborted")
+		return errors.New(SHUTDOWN_ERROR)
+	}
+	s.shutdownAbort = nil
+	s.shutdownMu.Unlock()
+
+	s.pauseBatches(caller)
+	var err error
+	s.execute(func() {
+		err = s.finishShutdown(opts, caller)
+	})
+	return err
+}
+
+// Saves the dataset and closes the server, run on the executor once the clients are paused
+func (s *RedisServerImpl) finishShutdown(opts ShutdownOptions, caller *Client) error {
+	_, dbfile := s.rdb.RDBInfo()
+	if opts.save || (!opts.noSave && dbfile != "") {
+		fmt.Println("Saving the final RDB snapshot before exiting.")
+		if err := s.rdb.SaveRDB(); err != nil {
+			fmt.Printf("Error trying to save the DB: %s\n", err)
+			if !opts.force {
+				s.resumeBatches()
+				return errors.New(SHUTDOWN_ERROR)
+			}
+		}
+	}
+
+	s.shutdownMu.Lock()
+	s.closing = true
+	s.shutdownMu.Unlock()
+	s.closeListeners()
+	if s.unixSocket != "" {
+		os.Remove(s.unixSocket)
+	}
+	if s.pidFile != "" {
+		os.Remove(s.pidFile)
+	}
+	// The other clients flushed their replies at the end of their batch, the caller's batch is still running
+	if caller != nil {
+		caller.writer.Flush()
+	}
+	if s.flushReplicas != nil {
+		s.flushReplicas()
+	}
+	for _, c := range s.Clients() {
+		c.close()
+	}
+	fmt.Println("Redis is now ready to exit, bye bye...")
+	close(s.done)
+	// The clients waiting to run a batch find the server shut down
+	s.clientsMu.Lock()
+	s.batches.Broadcast()
+	s.clientsMu.Unlock()
+	return nil
+}
+
+/*
+Stops the executor and the active expire cycle of a server whose Init failed or that was never started,
+nothing else runs: the listeners are closed by Init when it fails.
+A shutdown started afterwards finds the server closing.
+*/
+func (s *RedisServerImpl) stop() {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.closing {
+		return
+	}
+	s.closing = true
+	close(s.done)
+}
+
+// Checks if the listeners are being closed by a shutdown
+func (s *RedisServerImpl) isClosing() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	return s.closing
+}
+
+/*
+Stops the clients from starting new batches of commands, and waits for the batches being run to finish.
+The caller's batch and the blocked clients are not waited for, nor any batch after the shutdown timeout.
+Called off the executor, the batches being run need it to finish.
+*/
+func (s *RedisServerImpl) pauseBatches(caller *Client) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	s.paused = true
+	timedOut := false
+	timer := time.AfterFunc(SHUTDOWN_TIMEOUT, func() {
+		s.clientsMu.Lock()
+		timedOut = true
+		s.batches.Broadcast()
+		s.clientsMu.Unlock()
+	})
+	defer timer.Stop()
+	for s.runningBatches(caller) > 0 {
+		if timedOut {
+			fmt.Println("Timeout waiting for the running commands to finish")
+			return
+		}
+		s.batches.Wait()
+	}
+}
+
+// Counts the batches being run by the clients other than caller that don't wait in a blocking command, called with clientsMu held
+func (s *RedisServerImpl) runningBatches(caller *Client) int {
+	running := 0
+	for _, c := range s.clients {
+		if c != caller && c.running && !c.waiting {
+			running++
+		}
+	}
+	return running
+}
+
+func (s *RedisServerImpl) resumeBatches() {
+	s.clientsMu.Lock()
+	s.paused = false
+	s.batches.Broadcast()
+	s.clientsMu.Unlock()
+}
+
+/*
+Marks the client as running a batch of commands, waits first while a shutdown pauses the clients.
+Once the server is shut down the batch runs without waiting, its commands find the executor stopped.
+*/
+func (s *RedisServerImpl) beginBatch(c *Client) {
+	s.clientsMu.Lock()
+	for s.paused && !s.isDone() {
+		s.batches.Wait()
+	}
+	c.running = true
+	s.clientsMu.Unlock()
+}
+
+func (s *RedisServerImpl) endBatch(c *Client) {
+	s.clientsMu.Lock()
+	c.running = false
+	s.batches.Broadcast()
+	s.clientsMu.Unlock()
+}
+
+// Marks the client as waiting off the executor in a blocking command, or done waiting
+func (s *RedisServerImpl) setWaiting(c *Client, waiting bool) {
+	s.clientsMu.Lock()
+	c.waiting = waiting
+	s.batches.Broadcast()
+	s.clientsMu.Unlock()
+}
+
+// Checks if the server is shut down
+func (s *RedisServerImpl) isDone() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// The replicas are asked for their offset and the shutdown waits for all of them to answer
+func (s *MasterServerImpl) Shutdown(opts ShutdownOptions, caller *Client) error {
+	return s.shutdown(opts, caller, s.waitReplicas)
+}
+
+/*
+Returns false if the shutdown is aborted, true once the replicas caught up or after the timeout.
+The acknowledgements of the replicas are counted on the executor, the shutdown waits off it.
+*/
+func (s *MasterServerImpl) waitReplicas(abort <-chan struct{}) bool {
+	replicas := 0
+	s.execute(func() {
+		if s.replicationOffset > 0 {
+			replicas = len(s.replicas)
+			s.RequestAcks()
+		}
+	})
+	if replicas == 0 {
+		return true
+	}
+	fmt.Printf("Waiting for %d replicas to sync before shutting down\n", replicas)
+	deadline := time.NewTimer(SHUTDOWN_TIMEOUT)
+	defer deadline.Stop()
+	for {
+		acked := false
+		var changed <-chan struct{}
+		s.execute(func() {
+			acked = s.AcksReceived() >= replicas
+			changed = s.changes()
+		})
+		if acked {
+			return true
+		}
+		select {
+		case <-abort:
+			return false
+		case <-changed:
+		case <-deadline.C:
+			fmt.Println("Timeout waiting for the replicas, shutting down anyway")
+			return true
+		}
+	}
+}
+
+// SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT], there is no reply when the server shuts down
+func (r *ReqHandlerImpl) shutdown(req *Request) []byte {
+	opts, abort, err := parseShutdownOptions(req.args)
+	if err != nil {
+		return newSimpleError(err.Error())
+	}
+	if abort {
+		if err := r.server.AbortShutdown(); err != nil {
+			return newSimpleError(err.Error())
+		}
+		return newSimpleString("OK")
+	}
+	// The shutdown waits for the replicas and for the other clients, it can't hold the executor
+	r.waitOffExecutor(func() []byte {
+		if err := r.server.Shutdown(opts, r.client); err != nil {
+			return newSimpleError(err.Error())
+		}
+		r.client.closeAfterReply = true
+		return []byte{}
+	})
+	return []byte{}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRDBEncoder(t *testing.T) {
+	if crc := crc64Jones([]byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("unexpected checksum %x", crc)
+	}
+	expiry := uint64(time.Now().Add(time.Hour).UnixMilli())
+	long := string(make([]byte, 300))
+	list := NewQuicklist()
+	for i := 0; i < 200; i++ {
+		list.PushTail(strconv.Itoa(i))
+	}
+	profile := NewHash()
+	for field, value := range map[string]string{"name": "Ada", "empty": "", "long": long} {
+		profile.Set(field, value, false)
+	}
+	// A field expiring more than 2^32 ms after the first one needs a 64-bit length
+	session := profile.clone()
+	session.SetExpiry("name", expiry)
+	session.SetExpiry("long", expiry+1<<33)
+	small, wide, tags := NewSet(), NewSet(), NewSet()
+	for _, member := range []string{"-3", "7", "32767"} {
+		small.Add(member)
+		wide.Add(member)
+	}
+	wide.Add("-9223372036854775808")
+	for _, member := range []string{"ada", "12", long} {
+		tags.Add(member)
+	}
+	objects := map[string]Object{
+		"short":   {value: "value"},
+		"long":    {value: long},
+		"expires": {value: "soon", expiry: expiry},
+		"stream":  {stream: &Stream{}},
+		"list":    {list: list},
+		"queue":   {list: list.clone(), expiry: expiry},
+		"profile": {hash: profile},
+		"session": {hash: session},
+		"small":   {set: small},
+		"wide":    {set: wide, expiry: expiry},
+		"tags":    {set: tags},
+	}
+	rdb := NewRDBManager("", "", nil)
+	databases := []map[string]Object{objects, {}, {}, {"other": {value: "db3"}}}
+	decoded, err := rdb.decodeRDB(NewRDBEncoder().Encode(databases))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(decoded) != 2 || len(decoded[0]) != 10 || len(decoded[3]) != 1 {
+		t.Fatalf("expected 10 keys in db 0 and 1 in db 3, got %v", decoded)
+	}
+	if decoded[0]["short"].value != "value" || decoded[0]["long"].value != long || decoded[3]["other"].value != "db3" {
+		t.Fatalf("unexpected values %v", decoded)
+	}
+	if decoded[0]["expires"].value != "soon" || decoded[0]["expires"].expiry != expiry {
+		t.Fatalf("unexpected expiring key %v", decoded[0]["expires"])
+	}
+	for _, key := range []string{"list", "queue"} {
+		if v := decoded[0][key]; v.list == nil || fmt.Sprint(v.list.Values()) != fmt.Sprint(list.Values()) || v.expiry != objects[key].expiry {
+			t.Fatalf("unexpected list %s %v", key, v)
+		}
+	}
+	for _, key := range []string{"profile", "session"} {
+		v, h := decoded[0][key], objects[key].hash
+		if v.hash == nil || !maps.Equal(v.hash.fields, h.fields) {
+			t.Fatalf("unexpected hash %s %v", key, v)
+		}
+		for _, field := range h.Fields() {
+			if v.hash.Expiry(field) != h.Expiry(field) {
+				t.Fatalf("%s %s: expected the expiry time %d, got %d", key, field, h.Expiry(field), v.hash.Expiry(field))
+			}
+		}
+	}
+	for _, key := range []string{"small", "wide", "tags"} {
+		v, set := decoded[0][key], objects[key].set
+		if v.set == nil || fmt.Sprint(v.set.Members()) != fmt.Sprint(set.Members()) || v.set.Encoding() != set.Encoding() || v.expiry != objects[key].expiry {
+			t.Fatalf("unexpected set %s %v", key, v)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("options", func(t *testing.T) {
+		for _, args := range [][]string{{"SAVE", "NOSAVE"}, {"ABORT", "NOW"}, {"LATER"}} {
+			if _, _, err := parseShutdownOptions(args); err == nil {
+				t.Errorf("%v: expected a syntax error", args)
+			}
+		}
+		opts, abort, err := parseShutdownOptions([]string{"nosave", "now", "force"})
+		if err != nil || abort || !opts.noSave || !opts.now || !opts.force {
+			t.Errorf("unexpected options %+v", opts)
+		}
+	})
+
+	t.Run("failed save", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing")
+		cfg := testConfig()
+		cfg.Dir, cfg.DBFilename = dir, "dump.rdb"
+		master, addr := startTestServer(t, cfg)
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		if v := c.do(t, "SHUTDOWN", "ABORT"); v.Str() != "ERR No shutdown in progress." {
+			t.Fatalf("unexpected reply %q", v.Str())
+		}
+		if v := c.do(t, "SHUTDOWN"); v.Str() != SHUTDOWN_ERROR {
+			t.Fatalf("expected the shutdown to fail, got %q", v.Str())
+		}
+		if v := c.do(t, "PING"); v.Str() != "PONG" {
+			t.Fatalf("expected the server to resume, got %q", v.Str())
+		}
+		c.Write(newBulkArray("SHUTDOWN", "NOSAVE"))
+		if _, err := c.reader.ReadValue(); err == nil {
+			t.Fatalf("expected the connection to be closed")
+		}
+		select {
+		case <-master.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("expected Listen to return")
+		}
+	})
+
+	t.Run("paused batches", func(t *testing.T) {
+		srv := NewMasterServer(testConfig())
+		defer srv.stop()
+		var running, next *Client
+		srv.execute(func() {
+			conn, _ := net.Pipe()
+			running = srv.AddClient(conn)
+			conn, _ = net.Pipe()
+			next = srv.AddClient(conn)
+		})
+		srv.beginBatch(running)
+		paused := make(chan struct{})
+		go func() {
+			srv.pauseBatches(nil)
+			close(paused)
+		}()
+		select {
+		case <-paused:
+			t.Fatalf("expected the pause to wait for the running batch")
+		case <-time.After(20 * time.Millisecond):
+		}
+		srv.endBatch(running)
+		select {
+		case <-paused:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the pause to end with the running batch")
+		}
+
+		began := make(chan struct{})
+		go func() {
+			srv.beginBatch(next)
+			close(began)
+		}()
+		select {
+		case <-began:
+			t.Fatalf("expected the batch to wait while the clients are paused")
+		case <-time.After(20 * time.Millisecond):
+		}
+		srv.resumeBatches()
+		select {
+		case <-began:
+		case <-time.After(time.Second):
+			t.Fatalf("expected the batch to run once the clients are resumed")
+		}
+	})
+
+	t.Run("snapshot and files", func(t *testing.T) {
+		dir := t.TempDir()
+		pidFile := filepath.Join(dir, "redis.pid")
+		socket := filepath.Join(dir, "redis.sock")
+		cfg := testConfig()
+		cfg.Dir, cfg.DBFilename, cfg.PidFile, cfg.UnixSocket = dir, "dump.rdb", pidFile, socket
+		master, addr := startTestServer(t, cfg)
+		if pid, _ := os.ReadFile(pidFile); string(pid) != strconv.Itoa(os.Getpid())+"\n" {
+			t.Fatalf("unexpected pid file content %q", pid)
+		}
+		c := dialTestClient(t, addr)
+		defer c.Close()
+		c.do(t, "SET", "saved", "yes")
+		c.Write(newBulkArray("SHUTDOWN"))
+		if _, err := c.reader.ReadValue(); err == nil {
+			t.Fatalf("expected the connection to be closed")
+		}
+		select {
+		case <-master.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("expected Listen to return")
+		}
+		for _, file := range []string{pidFile, socket} {
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be removed", file)
+			}
+		}
+		restarted := NewMasterServer(cfg)
+		defer restarted.stop()
+		// The active expire cycle runs on the executor
+		var loadErr, err error
+		var v string
+		restarted.execute(func() {
+			if loadErr = restarted.LoadRDBToCache(); loadErr == nil {
+				v, err = restarted.Get("saved")
+			}
+		})
+		if loadErr != nil {
+			t.Fatalf("unexpected error: %s", loadErr)
+		}
+		if err != nil || v != "yes" {
+			t.Fatalf("expected the key to be saved, got %q %v", v, err)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	errStreamIDInvalid   = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDZero      = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamIDTooSmall  = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// The largest possible ID, the end of XRANGE +
+var maxStreamID = StreamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+// StreamID identifies a stream entry: the milliseconds time of its creation and a sequence number within that millisecond
+type StreamID struct {
+	ms  uint64
+	seq uint64
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// Returns the smallest ID greater than id, fails if id is the largest possible ID
+func (id StreamID) incr() (StreamID, error) {
+	if id.seq < math.MaxUint64 {
+		return StreamID{ms: id.ms, seq: id.seq + 1}, nil
+	}
+	if id.ms < math.MaxUint64 {
+		return StreamID{ms: id.ms + 1}, nil
+	}
+	return StreamID{}, errStreamIDExhausted
+}
+
+// Parses an ID given as ms-seq, or as ms alone which is ms-0, each part is a 64-bit unsigned integer
+func parseStreamID(s string) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, errStreamIDInvalid
+	}
+	if !hasSeq {
+		return StreamID{ms: ms}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, errStreamIDInvalid
+	}
+	return StreamID{ms: ms, seq: seq}, nil
+}
+
+/*
+Parses a bound of XRANGE, - and + are the smallest and the largest IDs.
+A bound given as ms alone is ms-0 as the start and the last sequence of ms as the end, like Redis.
+*/
+func parseRangeID(s string, end bool) (StreamID, error) {
+	switch s {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return maxStreamID, nil
+	}
+	id, err := parseStreamID(s)
+	if err == nil && end && !strings.Contains(s, "-") {
+		id.seq = math.MaxUint64
+	}
+	return id, err
+}
+
+/*
+Returns the ID of the entry added by XADD to a stream whose top item is last, 0-0 for an empty stream.
+The argument is one of:
+
+	"*"       the current time now in milliseconds, with the next sequence number within that millisecond
+	"ms-*"    the given milliseconds with the next sequence number within them
+	"ms-seq"  the given ID, it must be greater than the top item
+	"ms"      the same as ms-0
+
+The auto-generated IDs are strictly increasing: when the clock goes backwards, they continue from the top item.
+*/
+func nextStreamID(arg string, last StreamID, now uint64) (StreamID, error) {
+	if arg == "*" {
+		if now > last.ms {
+			return StreamID{ms: now}, nil
+		}
+		return last.incr()
+	}
+	if msPart, seqPart, _ := strings.Cut(arg, "-"); seqPart == "*" {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return StreamID{}, errStreamIDInvalid
+		}
+		if ms < last.ms || (ms == last.ms && last.seq == math.MaxUint64) {
+			return StreamID{}, errStreamIDTooSmall
+		}
+		if ms == last.ms {
+			return StreamID{ms: ms, seq: last.seq + 1}, nil
+		}
+		return StreamID{ms: ms}, nil
+	}
+	id, err := parseStreamID(arg)
+	if err != nil {
+		return StreamID{}, err
+	}
+	if id == (StreamID{}) {
+		return StreamID{}, errStreamIDZero
+	}
+	if !last.Less(id) {
+		return StreamID{}, errStreamIDTooSmall
+	}
+	return id, nil
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const maxUint64 = "18446744073709551615"
+
+func TestParseStreamID(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected StreamID
+		err      error
+	}{
+		{id: "0-1", expected: StreamID{0, 1}},
+		{id: "1526985054069-3", expected: StreamID{1526985054069, 3}},
+		{id: "1526985054069", expected: StreamID{1526985054069, 0}},
+		{id: maxUint64 + "-" + maxUint64, expected: StreamID{1<<64 - 1, 1<<64 - 1}},
+		{id: "18446744073709551616-0", err: errStreamIDInvalid},
+		{id: "0-18446744073709551616", err: errStreamIDInvalid},
+		{id: "", err: errStreamIDInvalid},
+		{id: "-", err: errStreamIDInvalid},
+		{id: "1-", err: errStreamIDInvalid},
+		{id: "-1", err: errStreamIDInvalid},
+		{id: "1-2-3", err: errStreamIDInvalid},
+		{id: "+1-2", err: errStreamIDInvalid},
+		{id: "abc", err: errStreamIDInvalid},
+	}
+	for _, test := range tests {
+		id, err := parseStreamID(test.id)
+		if err != test.err || id != test.expected {
+			t.Errorf("%q: expected %v %v, got %v %v", test.id, test.expected, test.err, id, err)
+		}
+	}
+}
+
+func TestNextStreamID(t *testing.T) {
+	tests := []struct {
+		description string
+		arg         string
+		last        StreamID
+		now         uint64
+		expected    StreamID
+		err         error
+	}{
+		{description: "auto on an empty stream", arg: "*", now: 1000, expected: StreamID{1000, 0}},
+		{description: "auto after an older entry", arg: "*", last: StreamID{999, 5}, now: 1000, expected: StreamID{1000, 0}},
+		{description: "auto in the same millisecond", arg: "*", last: StreamID{1000, 5}, now: 1000, expected: StreamID{1000, 6}},
+		{description: "auto when the clock went backwards", arg: "*", last: StreamID{2000, 5}, now: 1000, expected: StreamID{2000, 6}},
+		{description: "auto at the last sequence", arg: "*", last: StreamID{2000, 1<<64 - 1}, now: 1000, expected: StreamID{2001, 0}},
+		{description: "auto at the last ID", arg: "*", last: StreamID{1<<64 - 1, 1<<64 - 1}, now: 1000, err: errStreamIDExhausted},
+		{description: "auto at 0 on an empty stream", arg: "*", now: 0, expected: StreamID{0, 1}},
+
+		{description: "sequence on an empty stream", arg: "5-*", expected: StreamID{5, 0}},
+		{description: "sequence 0-* on an empty stream", arg: "0-*", expected: StreamID{0, 1}},
+		{description: "sequence after an older entry", arg: "5-*", last: StreamID{4, 7}, expected: StreamID{5, 0}},
+		{description: "sequence in the same millisecond", arg: "5-*", last: StreamID{5, 7}, expected: StreamID{5, 8}},
+		{description: "sequence before the top item", arg: "4-*", last: StreamID{5, 7}, err: errStreamIDTooSmall},
+		{description: "sequence at the last sequence", arg: "5-*", last: StreamID{5, 1<<64 - 1}, err: errStreamIDTooSmall},
+		{description: "sequence with the largest milliseconds", arg: maxUint64 + "-*", expected: StreamID{1<<64 - 1, 0}},
+		{description: "sequence with overflowing milliseconds", arg: "18446744073709551616-*", err: errStreamIDInvalid},
+		{description: "sequence with invalid milliseconds", arg: "x-*", err: errStreamIDInvalid},
+		{description: "sequence without milliseconds", arg: "-*", err: errStreamIDInvalid},
+
+		{description: "explicit ID", arg: "5-3", expected: StreamID{5, 3}},
+		{description: "explicit ID with sequence 0", arg: "5-0", last: StreamID{4, 9}, expected: StreamID{5, 0}},
+		{description: "explicit milliseconds only", arg: "1526985054069", expected: StreamID{1526985054069, 0}},
+		{description: "explicit milliseconds only in the same millisecond", arg: "5", last: StreamID{5, 0}, err: errStreamIDTooSmall},
+		{description: "explicit 0-1", arg: "0-1", expected: StreamID{0, 1}},
+		{description: "explicit 0-0", arg: "0-0", err: errStreamIDZero},
+		{description: "explicit 0", arg: "0", err: errStreamIDZero},
+		{description: "explicit ID equal to the top item", arg: "5-3", last: StreamID{5, 3}, err: errStreamIDTooSmall},
+		{description: "explicit ID smaller than the top item", arg: "4-9", last: StreamID{5, 3}, err: errStreamIDTooSmall},
+		{description: "explicit largest ID", arg: maxUint64 + "-" + maxUint64, last: StreamID{5, 3}, expected: StreamID{1<<64 - 1, 1<<64 - 1}},
+		{description: "explicit ID with overflowing sequence", arg: "5-18446744073709551616", err: errStreamIDInvalid},
+		{description: "explicit negative ID", arg: "-5-1", err: errStreamIDInvalid},
+		{description: "explicit ID with a wildcard milliseconds", arg: "*-1", err: errStreamIDInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			id, err := nextStreamID(test.arg, test.last, test.now)
+			if err != test.err || id != test.expected {
+				t.Fatalf("expected %v %v, got %v %v", test.expected, test.err, id, err)
+			}
+		})
+	}
+}
+
+func TestSetStreamMonotonicIDs(t *testing.T) {
+	cache := NewCache()
+	clock := time.UnixMilli(5000)
+	cache.now = func() time.Time { return clock }
+
+	steps := []struct {
+		clock    int64
+		id       string
+		expected string
+	}{
+		{clock: 5000, id: "*", expected: "5000-0"},
+		{clock: 5000, id: "*", expected: "5000-1"},
+		{clock: 4000, id: "*", expected: "5000-2"},
+		{clock: 4000, id: "5000-*", expected: "5000-3"},
+		{clock: 4000, id: "7000", expected: "7000-0"},
+		{clock: 6000, id: "*", expected: "7000-1"},
+		{clock: 8000, id: "*", expected: "8000-0"},
+	}
+	for _, step := range steps {
+		clock = time.UnixMilli(step.clock)
+		id, err := cache.SetStream("stream", step.id, map[string]string{"f": "v"})
+		if err != nil || id != step.expected {
+			t.Fatalf("XADD %s at %d: expected %s, got %q %v", step.id, step.clock, step.expected, id, err)
+		}
+	}
+	if _, err := cache.SetStream("stream", "7999-0", map[string]string{"f": "v"}); err != errStreamIDTooSmall {
+		t.Fatalf("expected %v, got %v", errStreamIDTooSmall, err)
+	}
+	cache.Set("string", "value")
+	if _, err := cache.SetStream("string", "*", map[string]string{"f": "v"}); err == nil {
+		t.Fatalf("expected WRONGTYPE")
+	}
+}
+
+func TestXAddIDs(t *testing.T) {
+	_, masterAddr := startTestServer(t, testConfig())
+	cfg := testConfig()
+	cfg.ReplicaOf = masterAddr
+	replica, _ := startTestServer(t, cfg)
+
+	c := dialTestClient(t, masterAddr)
+	defer c.Close()
+	if v := c.do(t, "XADD", "stream", "1526985054069", "f", "v"); v.Str() != "1526985054069-0" {
+		t.Fatalf("expected 1526985054069-0, got %q", v.Str())
+	}
+	if v := c.do(t, "XADD", "stream", "1526985054069-*", "f", "v"); v.Str() != "1526985054069-1" {
+		t.Fatalf("expected 1526985054069-1, got %q", v.Str())
+	}
+	if v := c.do(t, "XADD", "stream", "1-1", "f", "v"); v.Str() != errStreamIDTooSmall.Error() {
+		t.Fatalf("expected %q, got %q", errStreamIDTooSmall, v.Str())
+	}
+	if v := c.do(t, "XADD", "stream", "1-x", "f", "v"); v.Str() != errStreamIDInvalid.Error() {
+		t.Fatalf("expected %q, got %q", errStreamIDInvalid, v.Str())
+	}
+	auto := c.do(t, "XADD", "stream", "*", "f", "v").Str()
+
+	// The replica gets the ID generated by the master
+	if v := c.do(t, "WAIT", "1", "1000"); v.Int() != 1 {
+		t.Fatalf("expected the replica to acknowledge, got %d", v.Int())
+	}
+	var last StreamEntry
+	var err error
+	replica.execute(func() {
+		replica.useDB(0)
+		last, err = replica.GetLastEntryFromStream("stream")
+	})
+	if err != nil || last.ID() != auto {
+		t.Fatalf("expected %s on the replica, got %q %v", auto, last.ID(), err)
+	}
+
+	// Several IDs are generated within the same millisecond
+	previous, _ := parseStreamID(auto)
+	for i := 0; i < 100; i++ {
+		id, err := parseStreamID(c.do(t, "XADD", "stream", "*", "f", "v").Str())
+		if err != nil || !previous.Less(id) {
+			t.Fatalf("expected an ID greater than %v, got %v %v", previous, id, err)
+		}
+		previous = id
+	}
+}
+
+func TestStreamRanges(t *testing.T) {
+	_, addr := startTestServer(t, testConfig())
+	c := dialTestClient(t, addr)
+	defer c.Close()
+	for _, id := range []string{"5-1", "5-10", "6-0", maxUint64 + "-0"} {
+		c.do(t, "XADD", "s", id, "f", "v")
+	}
+	// Replies the IDs of the entries of XRANGE, or of the single stream of XREAD
+	ids := func(v RespValue, xread bool) string {
+		if v.IsError() {
+			return v.Str()
+		}
+		entries := v.Array()
+		if xread {
+			if len(entries) == 0 {
+				return ""
+			}
+			entries = entries[0].Array()[1].Array()
+		}
+		result := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			result = append(result, entry.Array()[0].Str())
+		}
+		return strings.Join(result, " ")
+	}
+
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{command: "XRANGE s - +", expected: "5-1 5-10 6-0 " + maxUint64 + "-0"},
+		{command: "XRANGE s 5-0 5-99", expected: "5-1 5-10"},
+		{command: "XRANGE s 5-2 5-10", expected: "5-10"},
+		{command: "XRANGE s 5-10 6-0", expected: "5-10 6-0"},
+		{command: "XRANGE s 5 5", expected: "5-1 5-10"},
+		{command: "XRANGE s 6 +", expected: "6-0 " + maxUint64 + "-0"},
+		{command: "XRANGE s " + maxUint64 + " +", expected: maxUint64 + "-0"},
+		{command: "XRANGE s 7 " + maxUint64 + "-0", expected: maxUint64 + "-0"},
+		{command: "XRANGE s 6-1 5-0", expected: ""},
+		{command: "XRANGE s 5-x +", expected: errStreamIDInvalid.Error()},
+		{command: "XRANGE s - 18446744073709551616", expected: errStreamIDInvalid.Error()},
+		{command: "XREAD STREAMS s 5-1", expected: "5-10 6-0 " + maxUint64 + "-0"},
+		{command: "XREAD STREAMS s 5-9", expected: "5-10 6-0 " + maxUint64 + "-0"},
+		{command: "XREAD STREAMS s 5-10", expected: "6-0 " + maxUint64 + "-0"},
+		{command: "XREAD STREAMS s 5", expected: "5-1 5-10 6-0 " + maxUint64 + "-0"},
+		{command: "XREAD STREAMS s 6-0", expected: maxUint64 + "-0"},
+		{command: "XREAD STREAMS s " + maxUint64 + "-0", expected: ""},
+	}
+	for _, test := range tests {
+		args := strings.Fields(test.command)
+		if v := ids(c.do(t, args...), args[0] == "XREAD"); v != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.command, test.expected, v)
+		}
+	}
+}
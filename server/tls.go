@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// TLS options, set with the --tls-* arguments
+type tlsOptions struct {
+	port        string // port of the TLS listener, no TLS listener if empty or 0
+	certFile    string // certificate presented to clients, and to the master when replicating over TLS
+	keyFile     string // private key of the certificate
+	caCertFile  string // CA used to verify the client certificates and the master certificate
+	authClients string // yes, no or optional, whether clients must present a certificate
+	replication bool   // whether the link to the master uses TLS
+}
+
+// Sets the TLS options, tls-auth-clients defaults to yes as in Redis
+func (s *RedisServerImpl) setTLS(cfg Config) {
+	s.tls = tlsOptions{
+		certFile:    cfg.TLSCertFile,
+		keyFile:     cfg.TLSKeyFile,
+		caCertFile:  cfg.TLSCACertFile,
+		authClients: cfg.TLSAuthClients,
+		replication: cfg.TLSReplication,
+	}
+	if cfg.TLSPort > 0 {
+		s.tls.port = strconv.Itoa(cfg.TLSPort)
+	}
+	if s.tls.authClients == "" {
+		s.tls.authClients = "yes"
+	}
+}
+
+// Checks if a TLS listener must be opened
+func (o tlsOptions) enabled() bool {
+	return o.port != "" && o.port != "0"
+}
+
+// Loads the certificate and the CA shared by the TLS listener and the replication link
+func (o tlsOptions) config() (*tls.Config, error) {
+	if o.certFile == "" || o.keyFile == "" {
+		return nil, fmt.Errorf("TLS requires both --tls-cert-file and --tls-key-file")
+	}
+	cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the TLS certificate: %s", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if o.caCertFile != "" {
+		pem, err := os.ReadFile(o.caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the CA certificate: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.caCertFile)
+		}
+		config.ClientCAs = pool
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// Wraps a TCP listener into a TLS listener, verifying client certificates according to tls-auth-clients
+func (o tlsOptions) wrap(l net.Listener) (net.Listener, error) {
+	config, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	switch o.authClients {
+	case "no":
+		config.ClientAuth = tls.NoClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		if config.ClientCAs == nil {
+			return nil, fmt.Errorf("tls-auth-clients requires --tls-ca-cert-file")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tls.NewListener(l, config), nil
+}
+
+// Connects to the master over TLS, presenting our certificate and verifying the master's with the CA
+func (o tlsOptions) dial(masterAddress string) (net.Conn, error) {
+	config, err := o.config()
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(masterAddress)
+	if err != nil {
+		return nil, err
+	}
+	config.ServerName = host
+	return tls.Dial("tcp", masterAddress, config)
+}
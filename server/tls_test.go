@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const TLS_TEST_PORT = 16380
+
+// Writes a certificate and its key as PEM files in dir, the certificate is signed by parent or self-signed
+func writeTestCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate a key: %s", err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("unable to create the certificate: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unable to marshal the key: %s", err)
+	}
+	os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// Generates a CA, a certificate for the server and a certificate for the clients, all valid for an hour
+func generateTestCerts(t *testing.T) string {
+	dir := t.TempDir()
+	validity := func(serial int64, cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+	}
+	caTemplate := validity(1, "Test CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeTestCert(t, dir, "ca", caTemplate, nil, nil)
+
+	serverTemplate := validity(2, "127.0.0.1")
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	writeTestCert(t, dir, "server", serverTemplate, ca, caKey)
+
+	clientTemplate := validity(3, "client")
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	writeTestCert(t, dir, "client", clientTemplate, ca, caKey)
+	return dir
+}
+
+// TCP is disabled, the servers only accept TLS connections
+func tlsTestConfig(dir string) Config {
+	cfg := testConfig()
+	cfg.Port = -1
+	cfg.TLSCertFile = filepath.Join(dir, "server.crt")
+	cfg.TLSKeyFile = filepath.Join(dir, "server.key")
+	cfg.TLSCACertFile = filepath.Join(dir, "ca.crt")
+	return cfg
+}
+
+func dialTLS(t *testing.T, dir string, withClientCert bool) (*tls.Conn, error) {
+	pool := x509.NewCertPool()
+	caPem, _ := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	pool.AppendCertsFromPEM(caPem)
+	config := &tls.Config{RootCAs: pool}
+	if withClientCert {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+		if err != nil {
+			t.Fatalf("unable to load the client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := tls.Dial("tcp", "127.0.0.1:"+strconv.Itoa(TLS_TEST_PORT), config)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn, conn.Handshake()
+}
+
+func TestTLS(t *testing.T) {
+	dir := generateTestCerts(t)
+	cfg := tlsTestConfig(dir)
+	cfg.TLSPort = TLS_TEST_PORT
+	startTestServer(t, cfg)
+
+	t.Run("client with a certificate signed by the CA", func(t *testing.T) {
+		conn, err := dialTLS(t, dir, true)
+		if err != nil {
+			t.Fatalf("unable to connect: %s", err)
+		}
+		defer conn.Close()
+		conn.Write(newBulkArray("SET", "secure", "yes"))
+		reply, err := NewRespReader(conn).ReadValue()
+		if err != nil || reply.Str() != "OK" {
+			t.Fatalf("expected OK, got %v %v", reply, err)
+		}
+	})
+
+	t.Run("client without a certificate", func(t *testing.T) {
+		conn, err := dialTLS(t, dir, false)
+		if err == nil {
+			// With TLS 1.3 the client learns about the rejection on its first read
+			conn.Write(newBulkArray("PING"))
+			_, err = NewRespReader(conn).ReadValue()
+			conn.Close()
+		}
+		if err == nil {
+			t.Fatalf("expected the connection to be rejected")
+		}
+	})
+
+	t.Run("replica connected over TLS", func(t *testing.T) {
+		replicaCfg := tlsTestConfig(dir)
+		replicaCfg.ReplicaOf = "127.0.0.1:" + strconv.Itoa(TLS_TEST_PORT)
+		replicaCfg.TLSReplication = true
+		replica, _ := startTestServer(t, replicaCfg)
+
+		conn, err := dialTLS(t, dir, true)
+		if err != nil {
+			t.Fatalf("unable to connect: %s", err)
+		}
+		defer conn.Close()
+		conn.Write(newBulkArray("SET", "replicated", "over tls"))
+		NewRespReader(conn).ReadValue()
+		for i := 0; i < 100; i++ {
+			if v, err := replica.Get("replicated"); err == nil {
+				if v != "over tls" {
+					t.Fatalf("expected 'over tls', got '%s'", v)
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("the write was not propagated to the replica")
+	})
+}
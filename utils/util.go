@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -55,26 +56,57 @@ func ParseOsArgs(args []string) (map[string]string, error) {
 	argsMap := make(map[string]string)
 	for x, arg := range args {
 		switch arg {
-		case "--dir":
+		case "--dir", "--dbfilename", "--port", "--unixsocket",
+			"--tls-port", "--tls-cert-file", "--tls-key-file", "--tls-ca-cert-file",
+			"--aclfile", "--masteruser", "--pidfile", "--databases", "--hz":
 			if x+1 < len(args) {
 				argsMap[arg] = args[x+1]
-				fmt.Printf("dir: %s\n", args[x+1])
+				fmt.Printf("%s: %s\n", strings.TrimPrefix(arg, "--"), args[x+1])
 			} else {
-				return nil, fmt.Errorf("missing argument for --dir")
+				return nil, fmt.Errorf("missing argument for %s", arg)
 			}
-		case "--dbfilename":
+		case "--requirepass", "--masterauth":
+			// Passwords are not printed
 			if x+1 < len(args) {
 				argsMap[arg] = args[x+1]
-				fmt.Printf("dbfilename: %s\n", args[x+1])
 			} else {
-				return nil, fmt.Errorf("missing argument for --dbfilename")
+				return nil, fmt.Errorf("missing argument for %s", arg)
 			}
-		case "--port":
+		case "--unixsocketperm":
 			if x+1 < len(args) {
+				// The permissions are given in octal, e.g. 700
+				if _, err := strconv.ParseUint(args[x+1], 8, 32); err != nil {
+					return nil, fmt.Errorf("invalid octal permissions for %s: %s", arg, args[x+1])
+				}
 				argsMap[arg] = args[x+1]
-				fmt.Printf("port: %s\n", args[x+1])
+				fmt.Printf("unixsocketperm: %s\n", args[x+1])
+			} else {
+				return nil, fmt.Errorf("missing argument for %s", arg)
+			}
+		case "--bind":
+			// Every argument up to the next option is an address, a single argument may also hold several addresses
+			addresses := make([]string, 0)
+			for _, a := range args[x+1:] {
+				if strings.HasPrefix(a, "--") {
+					break
+				}
+				addresses = append(addresses, strings.Fields(a)...)
+			}
+			if len(addresses) == 0 {
+				return nil, fmt.Errorf("missing argument for --bind")
+			}
+			argsMap[arg] = strings.Join(addresses, " ")
+			fmt.Printf("bind: %s\n", argsMap[arg])
+		case "--tls-auth-clients", "--tls-replication", "--protected-mode", "--replica-read-only":
+			if x+1 < len(args) {
+				value := strings.ToLower(args[x+1])
+				if value != "yes" && value != "no" && (value != "optional" || arg != "--tls-auth-clients") {
+					return nil, fmt.Errorf("invalid value for %s: %s", arg, args[x+1])
+				}
+				argsMap[arg] = value
+				fmt.Printf("%s: %s\n", strings.TrimPrefix(arg, "--"), value)
 			} else {
-				return nil, fmt.Errorf("missing argument for --port")
+				return nil, fmt.Errorf("missing argument for %s", arg)
 			}
 		case "--replicaof":
 			if x+1 < len(args) {